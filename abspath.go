@@ -0,0 +1,61 @@
+package fsnotify
+
+import "path/filepath"
+
+// WithAbsolutePaths converts the paths passed to Add, Remove, AddFile, and
+// RemoveFile to absolute paths with all symlinks resolved, so that Event.Name
+// is always the canonical path regardless of how the path was added. This is
+// only used by [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// With WithFollowSymlinks(false) the symlink itself is watched, and only the directories
+// it's in are resolved. [Watcher.WatchList] returns the converted paths.
+//
+// Paths that no longer exist are made absolute, and symlinks in the part of the
+// path that still exists are resolved.
+func WithAbsolutePaths() addOpt {
+	return func(opt *withOpts) { opt.absolutePaths = true }
+}
+
+// addPath is casePath for AddWith, which doesn't resolve the last element for
+// WithFollowSymlinks(false).
+func (w *Watcher) addPath(name string, opts []addOpt) string {
+	if w.with.absolutePaths {
+		name = absPath(name, getOptions(opts...).noFollow, nil)
+	}
+	return w.foldCase(name)
+}
+
+// absPath makes path absolute and resolves the symlinks in it; the last element
+// isn't resolved if noFollow is set or if it's in watched (a WithFollowSymlinks(false) watch
+// that's being removed).
+func absPath(path string, noFollow bool, watched func() []string) string {
+	p, recurse := recursivePath(path)
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return path
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		abs = filepath.Join(dir, filepath.Base(abs))
+	}
+	if !noFollow && !isWatched(abs, watched) {
+		if r, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = r
+		}
+	}
+	if recurse {
+		return filepath.Join(abs, "...")
+	}
+	return abs
+}
+
+func isWatched(path string, watched func() []string) bool {
+	if watched == nil {
+		return false
+	}
+	for _, w := range watched() {
+		if w == path {
+			return true
+		}
+	}
+	return false
+}
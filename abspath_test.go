@@ -0,0 +1,83 @@
+package fsnotify
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWithAbsolutePaths(t *testing.T) {
+	t.Parallel()
+
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mkdir(t, tmp, "real", noWait)
+	symlink(t, join(tmp, "real"), tmp, "link", noWait)
+
+	w, err := NewWatcherWith(WithAbsolutePaths())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp, "link")
+
+	if l := w.WatchList(); len(l) != 1 || l[0] != join(tmp, "real") {
+		t.Errorf("wrong WatchList: %q", l)
+	}
+	touch(t, tmp, "link", "file")
+	if e := eventsFor(t, w, 200*time.Millisecond); len(e) == 0 || e[0].Name != join(tmp, "real", "file") {
+		t.Errorf("wrong events: %v", e)
+	}
+	if err := w.Remove(join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 0 {
+		t.Errorf("not removed: %q", l)
+	}
+
+	// The link itself.
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if err := w.AddWith(join(tmp, "link"), WithFollowSymlinks(false)); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != join(tmp, "link") {
+		t.Errorf("wrong WatchList with WithFollowSymlinks(false): %q", l)
+	}
+	if err := w.Remove(join(tmp, "link")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAbsPath(t *testing.T) {
+	t.Parallel()
+
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mkdir(t, tmp, "real", noWait)
+	symlink(t, join(tmp, "real"), tmp, "link", noWait)
+
+	tests := []struct {
+		in       string
+		noFollow bool
+		want     string
+	}{
+		{join(tmp, "real"), false, join(tmp, "real")},
+		{join(tmp, "link"), false, join(tmp, "real")},
+		{join(tmp, "link"), true, join(tmp, "link")},
+		{join(tmp, "link", "..."), false, join(tmp, "real", "...")},
+		{join(tmp, "link", "gone"), false, join(tmp, "real", "gone")},
+		{join(tmp, "link", "real", ".."), false, join(tmp, "real")},
+	}
+	for _, tt := range tests {
+		if have := absPath(tt.in, tt.noFollow, nil); have != tt.want {
+			t.Errorf("absPath(%q, %t)\nhave: %q\nwant: %q", tt.in, tt.noFollow, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package fsnotify
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AddResult is the result of adding one path with [Watcher.AddPaths].
+type AddResult struct {
+	Path string
+	Err  error // nil if the path is watched.
+}
+
+// AddPaths adds all paths with [Watcher.Add], and reports the result for every
+// path rather than stopping at the first error.
+//
+// The results are in the same order as paths. The returned error is nil if all
+// paths were added, and otherwise wraps the first error; errors.Is and errors.As
+// only check that one, so use the results to check all of them. If the watcher
+// is closed it stops there, and the remaining paths have [ErrClosed] as the
+// error.
+func (w *Watcher) AddPaths(paths []string) ([]AddResult, error) {
+	var (
+		res    = make([]AddResult, len(paths))
+		failed int
+		first  error
+	)
+	for i, p := range paths {
+		res[i].Path = p
+		if errors.Is(first, ErrClosed) {
+			res[i].Err = ErrClosed
+		} else {
+			res[i].Err = w.Add(p)
+		}
+		if res[i].Err != nil {
+			failed++
+			if first == nil {
+				first = res[i].Err
+			}
+		}
+	}
+	if failed == 0 {
+		return res, nil
+	}
+	if failed == 1 {
+		return res, first
+	}
+	return res, fmt.Errorf("%w (and %d more errors)", first, failed-1)
+}
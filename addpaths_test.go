@@ -0,0 +1,53 @@
+package fsnotify
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestAddPaths(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "a")
+	mkdir(t, tmp, "b")
+
+	w, err := NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{join(tmp, "a"), join(tmp, "missing"), join(tmp, "b")}
+	res, err := w.AddPaths(paths)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("wrong error: %v", err)
+	}
+	if len(res) != len(paths) {
+		t.Fatalf("%d results; want %d", len(res), len(paths))
+	}
+	for i, r := range res {
+		if r.Path != paths[i] {
+			t.Errorf("res[%d].Path = %q; want %q", i, r.Path, paths[i])
+		}
+		if fail := i == 1; (r.Err != nil) != fail {
+			t.Errorf("res[%d].Err = %v", i, r.Err)
+		}
+	}
+	if l := w.WatchList(); len(l) != 2 {
+		t.Errorf("WatchList() = %q; want 2 paths", l)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	res, err = w.AddPaths(paths)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("wrong error after Close: %v", err)
+	}
+	for i, r := range res {
+		if r.Err != ErrClosed {
+			t.Errorf("res[%d].Err = %v; want ErrClosed", i, r.Err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package fsnotify
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AttrChange describes which attributes changed for Chmod and [Xattr] events;
+// see [WithAttrChanges].
+type AttrChange uint8
+
+const (
+	AttrMode  AttrChange = 1 << iota // Permission bits or file type.
+	AttrOwner                        // Owner or group.
+	AttrTimes                        // Modification time (e.g. "touch").
+	AttrXattr                        // Extended attributes or ACLs.
+)
+
+func (a AttrChange) String() string {
+	var b strings.Builder
+	if a&AttrMode != 0 {
+		b.WriteString("|MODE")
+	}
+	if a&AttrOwner != 0 {
+		b.WriteString("|OWNER")
+	}
+	if a&AttrTimes != 0 {
+		b.WriteString("|TIMES")
+	}
+	if a&AttrXattr != 0 {
+		b.WriteString("|XATTR")
+	}
+	if b.Len() == 0 {
+		return "[unknown]"
+	}
+	return b.String()[1:]
+}
+
+// WithAttrChanges sets [Event.Attrs] on Chmod and Xattr events to which
+// attributes changed, so that e.g. changes to only the modification time can be
+// ignored. This is only used by [NewWatcherWith], and is a no-op for
+// [Watcher.AddWith].
+//
+// None of the platforms report this, so the mode, owner, and modification time
+// of every file in a path are read when it's added, and again on every event.
+// If none of these changed then it must have been the extended attributes.
+// Attrs is 0 if the file wasn't seen before.
+//
+// Windows doesn't send Chmod events unless [WithChmod] is enabled, and then
+// only for changes to the mode.
+func WithAttrChanges() addOpt {
+	return func(opt *withOpts) { opt.attrChanges = true }
+}
+
+// attrs keeps the attributes of files, to set Event.Attrs for WithAttrChanges
+// and to report Xattr rather than Chmod for watches added with WithOps(Xattr).
+//
+// The access time isn't compared, as reads don't send an event to update it;
+// changing only the access time is reported as an extended attribute change.
+type attrs struct {
+	all   bool // WithAttrChanges: set Event.Attrs for all watches.
+	mu    sync.Mutex
+	paths map[string]bool     // Paths passed to AddWith → WithOps(Xattr).
+	stats map[string]attrStat // Last seen attributes per file.
+}
+
+type attrStat struct {
+	attr  fileAttr
+	mtime time.Time
+}
+
+func newAttrs(with withOpts) *attrs {
+	return &attrs{
+		all:   with.attrChanges,
+		paths: make(map[string]bool),
+		stats: make(map[string]attrStat),
+	}
+}
+
+func statAttrs(path string) (attrStat, bool) {
+	st, err := os.Lstat(path)
+	if err != nil {
+		return attrStat{}, false
+	}
+	s := attrStat{attr: fileAttr{mode: st.Mode()}, mtime: st.ModTime()}
+	s.attr.uid, s.attr.gid = fileOwner(st)
+	return s, true
+}
+
+// diff gets the attributes that changed.
+func (s attrStat) diff(prev attrStat) AttrChange {
+	var c AttrChange
+	if s.attr.mode != prev.attr.mode {
+		c |= AttrMode
+	}
+	if s.attr.uid != prev.attr.uid || s.attr.gid != prev.attr.gid {
+		c |= AttrOwner
+	}
+	if !s.mtime.Equal(prev.mtime) {
+		c |= AttrTimes
+	}
+	if c == 0 {
+		c = AttrXattr
+	}
+	return c
+}
+
+// set the path added with AddWith, reading the attributes of the path and the
+// files in it if they're needed.
+func (a *attrs) set(path string, xattr bool) {
+	path = filepath.Clean(path)
+	if !a.all && !xattr {
+		a.clear(path)
+		return
+	}
+
+	root, recurse := recursivePath(path)
+	found := make(map[string]attrStat)
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if s, ok := statAttrs(p); ok {
+			found[p] = s
+		}
+		if d.IsDir() && p != root && !recurse {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.paths[path] = xattr
+	for p, s := range found {
+		a.stats[p] = s
+	}
+}
+
+// clear the path, for Remove.
+func (a *attrs) clear(path string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	root, _ := recursivePath(filepath.Clean(path))
+	for p := range a.paths {
+		if r, _ := recursivePath(p); r == root {
+			delete(a.paths, p)
+		}
+	}
+	for p := range a.stats {
+		if _, ok := a.watch(p); !ok {
+			delete(a.stats, p)
+		}
+	}
+}
+
+// watch finds the path the file is in, returning if it was added with
+// WithOps(Xattr); a.mu must be held.
+func (a *attrs) watch(name string) (xattr, ok bool) {
+	for p, x := range a.paths {
+		root, recurse := recursivePath(p)
+		if name == root || filepath.Dir(name) == root ||
+			(recurse && strings.HasPrefix(name, root+string(os.PathSeparator))) {
+			return x, true
+		}
+	}
+	return false, false
+}
+
+// filter sets Attrs and replaces Chmod with Xattr if only the extended
+// attributes changed, and records the attributes of the file for the next
+// event.
+func (a *attrs) filter(e Event) Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.paths) == 0 {
+		return e
+	}
+
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	xattr, ok := a.watch(name)
+	if !ok {
+		return e
+	}
+	if e.Op&(opRemove|opRename) != 0 {
+		delete(a.stats, name)
+		return e
+	}
+
+	s, ok := statAttrs(name)
+	if !ok {
+		return e
+	}
+	prev, seen := a.stats[name]
+	// The name may be reused by WithCallback.
+	a.stats[cloneString(name)] = s
+	if !seen || e.Op&opChmod == 0 {
+		return e
+	}
+
+	c := s.diff(prev)
+	if a.all {
+		e.Attrs = c
+	}
+	if xattr && c == AttrXattr {
+		e.Op = e.Op&^opChmod | Xattr
+	}
+	return e
+}
@@ -0,0 +1,73 @@
+package fsnotify
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWithAttrChanges(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows doesn't send Chmod events")
+	}
+	t.Parallel()
+
+	tmp := t.TempDir()
+	file := join(tmp, "file")
+	touch(t, file)
+
+	w, err := NewWatcherWith(WithAttrChanges())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp)
+
+	next := func() Event {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case e := <-w.Events:
+				if e.Op&opChmod != 0 {
+					return e
+				}
+			case err := <-w.Errors:
+				t.Fatal(err)
+			case <-timeout:
+				t.Fatal("timeout")
+			}
+		}
+	}
+
+	chmod(t, 0o600, file)
+	if e := next(); e.Attrs != AttrMode {
+		t.Errorf("chmod: %s", e.Attrs)
+	}
+
+	mtime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Attrs != AttrTimes {
+		t.Errorf("chtimes: %s", e.Attrs)
+	}
+}
+
+func TestAttrChangeString(t *testing.T) {
+	tests := []struct {
+		in   AttrChange
+		want string
+	}{
+		{0, "[unknown]"},
+		{AttrMode, "MODE"},
+		{AttrOwner | AttrTimes, "OWNER|TIMES"},
+		{AttrXattr, "XATTR"},
+	}
+	for _, tt := range tests {
+		if have := tt.in.String(); have != tt.want {
+			t.Errorf("%d: have %q, want %q", tt.in, have, tt.want)
+		}
+	}
+}
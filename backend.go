@@ -0,0 +1,54 @@
+package fsnotify
+
+// Backend is the implementation used to watch paths.
+type Backend string
+
+// The backends. BackendPoll is available on every platform, and one of the
+// others depending on the platform.
+//
+// There is no separate fanotify backend: use [Watcher.AddMount] to watch a
+// whole mount with fanotify on Linux.
+const (
+	BackendInotify Backend = "inotify" // Linux
+	BackendKqueue  Backend = "kqueue"  // macOS and the BSDs
+	BackendWindows Backend = "windows" // ReadDirectoryChangesW on Windows
+	BackendFen     Backend = "fen"     // File Event Notification on illumos and Solaris
+
+	// BackendPoll doesn't use the system's notifications, but scans the added
+	// paths every second, or every interval set with [WithReconcile]. Every
+	// change since the last scan is sent as a Create, Write, or Remove event;
+	// renames are sent as a Remove and Create, and several changes to a file
+	// within the interval as one event.
+	//
+	// This works on filesystems that don't report changes at all, such as
+	// many network filesystems, and doesn't use any watches or file
+	// descriptors, but every scan reads all watched directories. Recursive
+	// watches ("dir/...") are supported, but WithRetarget, WithMaxDepth, and
+	// WithOps aren't.
+	BackendPoll Backend = "poll"
+)
+
+// NewWatcherWithBackend is like [NewWatcherWith], but uses the given backend:
+// either [BackendPoll] or the backend for the current platform. It fails for
+// other backends rather than silently using another one; the error is an
+// [ErrUnsupported].
+//
+// This is useful to make sure that tests or programs that depend on the
+// behaviour of a backend run with it.
+func NewWatcherWithBackend(b Backend, opts ...addOpt) (*Watcher, error) {
+	switch b {
+	case BackendPoll:
+		return NewWatcherWith(append(opts, withPoll())...)
+	case Backend(defaultBackend()):
+		return NewWatcherWith(opts...)
+	}
+	return nil, ErrUnsupported{Feature: "NewWatcherWithBackend(" + string(b) + ")", Backend: defaultBackend()}
+}
+
+// Backend returns the backend the Watcher uses.
+func (w *Watcher) Backend() Backend {
+	if w.reconcile.polling() {
+		return BackendPoll
+	}
+	return Backend(defaultBackend())
+}
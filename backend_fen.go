@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"sync"
 
+	"github.com/hohodqr/fsnotify/internal"
 	"golang.org/x/sys/unix"
 )
 
@@ -53,8 +54,9 @@ import (
 //	fs.inotify.max_user_watches=124983
 //	fs.inotify.max_user_instances=128
 //
-// Reaching the limit will result in a "no space left on device" or "too many open
-// files" error.
+// Adding a path after reaching the watch limit returns [ErrTooManyWatches], and
+// reaching the instance limit results in a "too many open files" error from
+// NewWatcher.
 //
 // # kqueue notes (macOS, BSD)
 //
@@ -76,6 +78,15 @@ import (
 // to work with SMB filesystems. If you have many events in quick succession
 // this may not be enough, and you will have to use [WithBufferSize] to increase
 // the value.
+//
+// Paths longer than MAX_PATH (260 characters) can be watched; they're converted
+// to extended-length paths ("\\\\?\\C:\\path") internally. Event.Name never has
+// the "\\\\?\\" prefix, even if the path was added with it.
+//
+// All Watchers share one I/O completion port, which a few goroutines read
+// events from. A Watcher whose Events channel isn't read (with the default
+// [WithBackpressure]) holds up one of these, and can delay events for other
+// Watchers.
 type Watcher struct {
 	// Events sends the filesystem change events.
 	//
@@ -122,23 +133,144 @@ type Watcher struct {
 	//  - inotify: there are too many queued events (fs.inotify.max_queued_events sysctl)
 	//  - windows: The buffer size is too small; [WithBufferSize] can be used to increase it.
 	//  - kqueue, fen: not used.
+	//
+	// [Watcher.OnOverflow] can be used to be notified right away.
 	Errors chan error
 
-	mu      sync.Mutex
-	port    *unix.EventPort
-	done    chan struct{}       // Channel for sending a "quit message" to the reader goroutine
-	dirs    map[string]struct{} // Explicitly watched directories
-	watches map[string]struct{} // Explicitly watched non-directories
+	mu        sync.Mutex
+	port      *unix.EventPort
+	done      chan struct{}       // Channel for sending a "quit message" to the reader goroutine
+	dirs      map[string]struct{} // Explicitly watched directories
+	entries   map[string]dirList  // Last listing of watched directories
+	watches   map[string]struct{} // Explicitly watched non-directories
+	noFollow  map[string]struct{} // Explicitly watched symlinks (see WithFollowSymlinks)
+	with      withOpts            // Options passed to NewWatcherWith()
+	delivery  *delivery           // Non-blocking event delivery (see WithBackpressure)
+	stats     *stats              // Counters for Stats()
+	files     *fileWatches        // Files added with AddFile and FollowRotation
+	oneShots  *oneShots           // WithOneShot watches
+	attrs     *attrs              // Attribute changes (see WithAttrChanges)
+	pendings  *pendings           // AddPending paths
+	chmod     *chmods             // Chmod filtering and polling (see WithChmod)
+	sums      *checksums          // Write filtering (see WithChecksum)
+	diffs     *contents           // Event.Change (see WithContentDiff)
+	callback  *eventFunc          // Called instead of sending on Events (see WithCallback)
+	subs      *subscriptions      // Subscriptions from SubscribeCtx()
+	scan      *scanner            // Synthetic events from WithInitialScan and CatchUp
+	spec      *appliedSpec        // Watches added with ApplySpec()
+	reconcile *reconciler         // Rescanning (see WithReconcile)
+	contexts  *watchContexts      // Removed when done (see WithContext)
 }
 
+// The Ops that correspond to the portable operations; these are different on
+// Linux, where events have the raw inotify mask set as the Op.
+const (
+	opCreate = Create
+	opWrite  = Write
+	opRemove = Remove
+	opRename = Rename
+	opChmod  = Chmod
+)
+
+// The operations that can be enabled with WithOps.
+const supportedOps = Xattr
+
+// The operations that can be sent (see Watcher.Supports).
+const sentOps = opCreate | opWrite | opRemove | opRename | opChmod | supportedOps | Rotated | Handover | Unmount | Expired
+
 // NewWatcher creates a new Watcher.
-func NewWatcher() (*Watcher, error) {
+func NewWatcher() (*Watcher, error) { return NewWatcherWith() }
+
+// NewWatcherWith is like [NewWatcher], but allows passing options.
+//
+// Possible options are:
+//
+//   - [WithEventChannelSize] sets the capacity of the Events channel. The
+//     default is 0 (unbuffered), or 50 on Windows.
+//
+//   - [WithRootRelativeNames] makes Event.Name relative to the path passed to
+//     Add(), which is set in Event.Root.
+//
+//   - [WithRelativeTo] makes Event.Name relative to a directory, which is set
+//     in Event.Root.
+//
+//   - [WithBackpressure] sets what to do if the Events channel is full. The
+//     default is to block.
+//
+//   - [WithChmod] enables or disables Chmod events, making them consistent
+//     across platforms. The default is to send what the platform reports.
+//
+//   - [WithCallback] calls a function for every event instead of sending it
+//     on the Events channel, without allocating memory for every event.
+//
+//   - [WithJournal] appends every event to a journal file.
+//
+//   - [WithChecksum] only sends Write events if the contents of the file
+//     changed.
+//
+//   - [WithContentDiff] sets what changed in the file on Write events.
+//
+//   - [WithNameEncoding] sets how names that aren't valid UTF-8 are sent.
+//
+//   - [WithUnicodeNorm] normalizes names to a Unicode normalization form,
+//     such as NFC.
+//
+//   - [WithCaseInsensitivePaths] matches paths passed to Add and Remove
+//     regardless of case on case-insensitive volumes.
+//
+//   - [WithAbsolutePaths] makes Event.Name an absolute path with symlinks
+//     resolved.
+//
+//   - [WithAttrChanges] sets which attributes changed on Chmod events.
+//
+//   - [WithExcludeUnlinked] drops events for files that were removed but are
+//     still open.
+//
+//   - [WithSharedInstance] shares one inotify instance between Watchers on
+//     Linux.
+//
+//   - [WithIOUring] reads inotify events with io_uring on Linux; this is
+//     experimental.
+//
+//   - [WithReconcile] rescans all watched paths periodically, and sends
+//     events for changes that the system didn't report.
+//
+//   - [WithFilePolling] only opens directories on kqueue, and polls files for
+//     changes.
+//
+//   - [WithBufferSize] sets the size of the buffer inotify events are read
+//     into on Linux, and how many kevents are read at once on kqueue. The
+//     default is 64K (65536 bytes).
+func NewWatcherWith(opts ...addOpt) (*Watcher, error) {
+	with := getOptions(opts...)
+	if err := checkPlatformOpts(with, "fen", true); err != nil {
+		return nil, err
+	}
+
 	w := &Watcher{
-		Events:  make(chan Event),
-		Errors:  make(chan error),
-		dirs:    make(map[string]struct{}),
-		watches: make(map[string]struct{}),
-		done:    make(chan struct{}),
+		Events:    make(chan Event, with.eventsSize),
+		Errors:    make(chan error),
+		dirs:      make(map[string]struct{}),
+		entries:   make(map[string]dirList),
+		watches:   make(map[string]struct{}),
+		noFollow:  make(map[string]struct{}),
+		done:      make(chan struct{}),
+		with:      with,
+		delivery:  newDelivery(with),
+		stats:     newStats(),
+		files:     newFileWatches(),
+		oneShots:  newOneShots(),
+		attrs:     newAttrs(with),
+		pendings:  newPendings(),
+		chmod:     newChmods(with),
+		sums:      newChecksums(with),
+		diffs:     newContents(with),
+		callback:  newEventFunc(with),
+		subs:      newSubscriptions(with),
+		scan:      newScanner(),
+		spec:      newAppliedSpec(),
+		reconcile: newReconciler(with),
+		contexts:  newWatchContexts(),
 	}
 
 	var err error
@@ -146,25 +278,60 @@ func NewWatcher() (*Watcher, error) {
 	if err != nil {
 		return nil, fmt.Errorf("fsnotify.NewWatcher: %w", err)
 	}
+	internal.Opened("event port", w.port)
 
 	go w.readEvents()
+	w.reconcile.run(w.WatchList, w.sendSynthetic)
 	return w, nil
 }
 
 // sendEvent attempts to send an event to the user, returning true if the event
 // was put in the channel successfully and false if the watcher has been closed.
 func (w *Watcher) sendEvent(name string, op Op) (sent bool) {
-	select {
-	case w.Events <- Event{Name: name, Op: op}:
+	e := Event{Name: name, Op: op}
+	if w.with.rootRelative {
+		e = e.rootRelative(w.rootOf(name))
+	}
+	e, ok := w.filter(e)
+	if !ok {
+		return true
+	}
+	if w.subs.send(e, w.callback != nil) {
+		w.delivery.sent(e)
 		return true
-	case <-w.done:
-		return false
 	}
+	if w.callback != nil {
+		w.callback.call(e)
+		w.delivery.sent(e)
+		return true
+	}
+	if !w.delivery.blocking() {
+		return w.delivery.send(w.Events, e)
+	}
+	return w.delivery.sendBlocking(w.Events, e, w.done)
+}
+
+// rootOf gets the path passed to Add() that name belongs to, or "" if it's not
+// watched (any more).
+func (w *Watcher) rootOf(name string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.watches[name]; ok {
+		return name
+	}
+	if _, ok := w.dirs[name]; ok {
+		return name
+	}
+	if _, ok := w.dirs[filepath.Dir(name)]; ok {
+		return filepath.Dir(name)
+	}
+	return ""
 }
 
 // sendError attempts to send an error to the user, returning true if the error
 // was put in the channel successfully and false if the watcher has been closed.
 func (w *Watcher) sendError(err error) (sent bool) {
+	w.stats.error(err)
 	select {
 	case w.Errors <- err:
 		return true
@@ -183,6 +350,10 @@ func (w *Watcher) isClosed() bool {
 }
 
 // Close removes all watches and closes the events channel.
+//
+// Remove and Rename events that were already read from the system are sent
+// before the Events channel is closed, even if other events are dropped because
+// of [WithBackpressure]. Close waits up to a second for these to be read.
 func (w *Watcher) Close() error {
 	// Take the lock used by associateFile to prevent lingering events from
 	// being processed after the close
@@ -191,7 +362,9 @@ func (w *Watcher) Close() error {
 	if w.isClosed() {
 		return nil
 	}
+	w.delivery.stopping()
 	close(w.done)
+	internal.Closed("event port", w.port)
 	return w.port.Close()
 }
 
@@ -206,7 +379,10 @@ func (w *Watcher) Close() error {
 // watcher on renames.
 //
 // Notifications on network filesystems (NFS, SMB, FUSE, etc.) or special
-// filesystems (/proc, /sys, etc.) generally don't work.
+// filesystems (/proc, /sys, etc.) generally don't work. The exception is
+// network shares on Windows, which are polled if the server doesn't send
+// notifications; a [NetworkWatch] is sent on the Errors channel to tell which
+// is used.
 //
 // Returns [ErrClosed] if [Watcher.Close] was called.
 //
@@ -238,19 +414,86 @@ func (w *Watcher) Add(name string) error { return w.AddWith(name) }
 // Possible options are:
 //
 //   - [WithBufferSize] sets the buffer size for the Windows backend; no-op on
-//     other platforms. The default is 64K (65536 bytes).
-func (w *Watcher) AddWith(name string, opts ...addOpt) error {
+//     other platforms, where it's passed to NewWatcherWith instead. The
+//     default is 64K (65536 bytes).
+//   - [WithWindowsFilters] sets the ReadDirectoryChangesW notify filters for
+//     the Windows backend; no-op on other platforms.
+//   - [WithRetarget] moves the watch if the target of a symlink changes;
+//     Linux only.
+//   - [WithFollowSymlinks] sets whether to watch the target of a symlink or
+//     the symlink itself. The default is to watch the target.
+//   - [WithMaxDepth] limits how many levels of subdirectories a recursive
+//     watch descends. The default is unlimited.
+//   - [WithContinueOnError] skips subdirectories of a recursive watch that
+//     can't be read or watched, rather than failing.
+//   - [WithWalkWorkers] sets how many directories are read at the same time
+//     for a recursive watch.
+//   - [WithProgress] reports how many directories of a recursive watch were
+//     found and watched so far.
+//   - [WithInitialScan] sends a Create event for every existing file after
+//     the path is added.
+//   - [WithOneShot] removes the watch after the first event is sent.
+//   - [WithContext] removes the watch when the context is done.
+//   - [WithTTL] removes the watch after a duration.
+//   - [WithOnlyDir] returns an error if the path isn't a directory.
+//   - [WithOps] enables the Open, Read, Close, and Xattr operations.
+//   - [WithReArm] adds the path again if it's removed and recreated.
+func (w *Watcher) AddWith(name string, opts ...addOpt) (err error) {
 	if w.isClosed() {
 		return ErrClosed
 	}
+	name = w.addPath(name, opts)
+	defer func() { w.spec.addUser(name, opts, err) }()
+	w.pendings.added(name, opts)
+	if w.reconcile.polling() {
+		return w.reconcile.add(name, getOptions(opts...))
+	}
 	if w.port.PathIsWatched(name) {
 		return nil
 	}
 
-	_ = getOptions(opts...)
+	with := getOptions(opts...)
+	if with.retarget {
+		return ErrUnsupported{Feature: "WithRetarget", Backend: "fen"}
+	}
+	if with.maxDepth > 0 {
+		return ErrUnsupported{Feature: "WithMaxDepth", Backend: "fen"}
+	}
+	if with.ops&^supportedOps != 0 {
+		return ErrUnsupported{Feature: "WithOps", Backend: "fen"}
+	}
+	if err := checkPlatformOpts(with, "fen", false); err != nil {
+		return err
+	}
+	if with.onlyDir {
+		if err := checkDir(name, with.noFollow); err != nil {
+			return err
+		}
+	}
 
 	// Currently we resolve symlinks that were explicitly requested to be
-	// watched. Otherwise we would use LStat here.
+	// watched, unless WithFollowSymlinks(false) is used.
+	if with.noFollow {
+		stat, err := os.Lstat(name)
+		if err != nil {
+			return err
+		}
+		if stat.Mode()&os.ModeSymlink != 0 {
+			err = w.associateFile(name, stat, false)
+			if err != nil {
+				return err
+			}
+			w.mu.Lock()
+			w.watches[name] = struct{}{}
+			w.noFollow[name] = struct{}{}
+			w.mu.Unlock()
+			w.oneShots.set(name, with.oneShot)
+			w.contexts.set(name, with, w.Remove, w.sendExpired)
+			w.attrs.set(name, with.ops.Has(Xattr))
+			w.initialScan(name, with)
+			return nil
+		}
+	}
 	stat, err := os.Stat(name)
 	if err != nil {
 		return err
@@ -258,14 +501,25 @@ func (w *Watcher) AddWith(name string, opts ...addOpt) error {
 
 	// Associate all files in the directory.
 	if stat.IsDir() {
-		err := w.handleDirectory(name, stat, true, w.associateFile)
+		list := make(dirList)
+		err := w.handleDirectory(name, stat, true, func(path string, fi os.FileInfo, follow bool) error {
+			if path != name {
+				list[filepath.Base(path)] = inode(path, fi)
+			}
+			return w.associateFile(path, fi, follow)
+		})
 		if err != nil {
 			return err
 		}
 
 		w.mu.Lock()
 		w.dirs[name] = struct{}{}
+		w.entries[name] = list
 		w.mu.Unlock()
+		w.oneShots.set(name, with.oneShot)
+		w.contexts.set(name, with, w.Remove, w.sendExpired)
+		w.attrs.set(name, with.ops.Has(Xattr))
+		w.initialScan(name, with)
 		return nil
 	}
 
@@ -277,9 +531,22 @@ func (w *Watcher) AddWith(name string, opts ...addOpt) error {
 	w.mu.Lock()
 	w.watches[name] = struct{}{}
 	w.mu.Unlock()
+	w.oneShots.set(name, with.oneShot)
+	w.contexts.set(name, with, w.Remove, w.sendExpired)
+	w.attrs.set(name, with.ops.Has(Xattr))
+	w.initialScan(name, with)
 	return nil
 }
 
+// sendSynthetic sends an event that didn't come from the kernel, for
+// WithInitialScan, CatchUp, and WithReconcile; op is Create, Write, or Remove.
+func (w *Watcher) sendSynthetic(name string, op Op, isDir bool) bool {
+	if isDir {
+		op |= IN_ISDIR
+	}
+	return w.sendEvent(name, op)
+}
+
 // Remove stops monitoring the path for changes.
 //
 // If the path was added as a recursive watch (e.g. as "/tmp/dir/...") then the
@@ -299,6 +566,17 @@ func (w *Watcher) Remove(name string) error {
 	if w.isClosed() {
 		return nil
 	}
+	name = w.casePath(name)
+	w.oneShots.clear(name)
+	w.contexts.clear(name)
+	w.attrs.clear(name)
+	w.spec.removeUser(name)
+	if w.reconcile.polling() {
+		return w.reconcile.remove(name)
+	}
+	if w.removePending(name) {
+		return nil
+	}
 	if !w.port.PathIsWatched(name) {
 		return fmt.Errorf("%w: %s", ErrNonExistentWatch, name)
 	}
@@ -309,6 +587,8 @@ func (w *Watcher) Remove(name string) error {
 	w.mu.Lock()
 	delete(w.watches, name)
 	delete(w.dirs, name)
+	delete(w.entries, name)
+	delete(w.noFollow, name)
 	w.mu.Unlock()
 
 	stat, err := os.Stat(name)
@@ -333,11 +613,34 @@ func (w *Watcher) Remove(name string) error {
 	return nil
 }
 
+// DroppedEvents returns the number of events that were dropped because of the
+// backpressure policy set with [WithBackpressure].
+func (w *Watcher) DroppedEvents() uint64 { return w.delivery.droppedEvents() }
+
+// PathStats returns how much memory is used to store the watched paths.
+func (w *Watcher) PathStats() PathStats { return flatPathStats(w.WatchList()) }
+
+// AddMount starts monitoring all files and directories on the mount that path
+// is on; this is only supported on Linux, and returns [ErrUnsupported] on other
+// platforms.
+func (w *Watcher) AddMount(path string) error {
+	return ErrUnsupported{Feature: "AddMount", Backend: "fen"}
+}
+
+func (w *Watcher) mountPoints() []string { return nil }
+
 // readEvents contains the main loop that runs in a goroutine watching for events.
 func (w *Watcher) readEvents() {
 	// If this function returns, the watcher has been closed and we can close
 	// these channels
 	defer func() {
+		w.chmod.stop()
+		w.reconcile.stop()
+		w.contexts.stop()
+		w.scan.wait()
+		w.subs.close()
+		w.delivery.close()
+		w.delivery.sendKept(w.Events)
 		close(w.Errors)
 		close(w.Events)
 	}()
@@ -372,7 +675,8 @@ func (w *Watcher) readEvents() {
 
 			err = w.handleEvent(&pevent)
 			if err != nil {
-				if !w.sendError(err) {
+				werr := WatchError{Path: pevent.Path, Op: "add", Err: err, Alive: w.port.PathIsWatched(pevent.Path)}
+				if !w.sendError(werr) {
 					return
 				}
 			}
@@ -410,24 +714,50 @@ func (w *Watcher) handleEvent(event *unix.PortEvent) error {
 	var (
 		events     = event.Events
 		path       = event.Path
-		fmode      = event.Cookie.(os.FileMode)
+		cookie     = event.Cookie.(fenCookie)
+		fmode      = cookie.mode
 		reRegister = true
+		isDir      Op
 	)
+	if fmode.IsDir() {
+		isDir = IN_ISDIR
+	}
 
 	w.mu.Lock()
 	_, watchedDir := w.dirs[path]
 	_, watchedPath := w.watches[path]
+	_, noFollow := w.noFollow[path]
 	w.mu.Unlock()
 	isWatched := watchedDir || watchedPath
+	follow := isWatched && !noFollow
+
+	// For files in a watched directory the Remove or Rename may already have
+	// been sent by updateDirectory().
+	var (
+		parent string
+		send   = true
+	)
+	if events&(unix.FILE_DELETE|unix.FILE_RENAME_FROM|unix.FILE_RENAME_TO) != 0 {
+		parent, send = w.removeEntry(path, cookie.ino)
+	}
+
+	// The path is no longer reachable; the association is removed and can't
+	// be added again.
+	if events&(unix.UNMOUNTED|unix.MOUNTEDOVER) != 0 {
+		if !w.sendEvent(path, Unmount|isDir) {
+			return nil
+		}
+		reRegister = false
+	}
 
 	if events&unix.FILE_DELETE != 0 {
-		if !w.sendEvent(path, Remove) {
+		if send && !w.sendEvent(path, Remove|isDir) {
 			return nil
 		}
 		reRegister = false
 	}
 	if events&unix.FILE_RENAME_FROM != 0 {
-		if !w.sendEvent(path, Rename) {
+		if send && !w.sendEvent(path, Rename|isDir) {
 			return nil
 		}
 		// Don't keep watching the new file name
@@ -441,25 +771,32 @@ func (w *Watcher) handleEvent(event *unix.PortEvent) error {
 
 		// inotify reports a Remove event in this case, so we simulate this
 		// here.
-		if !w.sendEvent(path, Remove) {
+		if send && !w.sendEvent(path, Remove|isDir) {
 			return nil
 		}
 		// Don't keep watching the file that was removed
 		reRegister = false
 	}
 
-	// The file is gone, nothing left to do.
+	// The file is gone, nothing left to do; except for files in a watched
+	// directory, which may have been replaced by a new file with the same
+	// name.
 	if !reRegister {
 		if watchedDir {
 			w.mu.Lock()
 			delete(w.dirs, path)
+			delete(w.entries, path)
 			w.mu.Unlock()
 		}
 		if watchedPath {
 			w.mu.Lock()
 			delete(w.watches, path)
+			delete(w.noFollow, path)
 			w.mu.Unlock()
 		}
+		if parent != "" {
+			return w.updateDirectory(parent)
+		}
 		return nil
 	}
 
@@ -475,7 +812,7 @@ func (w *Watcher) handleEvent(event *unix.PortEvent) error {
 		// get here, the sudirectory is already gone. Clearly we were watching
 		// this path but now it is gone. Let's tell the user that it was
 		// removed.
-		if !w.sendEvent(path, Remove) {
+		if _, send := w.removeEntry(path, cookie.ino); send && !w.sendEvent(path, Remove|isDir) {
 			return nil
 		}
 		// Suppress extra write events on removed directories; they are not
@@ -485,18 +822,28 @@ func (w *Watcher) handleEvent(event *unix.PortEvent) error {
 
 	// resolve symlinks that were explicitly watched as we would have at Add()
 	// time. this helps suppress spurious Chmod events on watched symlinks
-	if isWatched {
+	if follow {
 		stat, err = os.Stat(path)
 		if err != nil {
 			// The symlink still exists, but the target is gone. Report the
 			// Remove similar to above.
-			if !w.sendEvent(path, Remove) {
+			if !w.sendEvent(path, Remove|isDir) {
 				return nil
 			}
 			// Don't return the error
 		}
 	}
 
+	// If we get here, it means we've hit an event above that requires us to
+	// continue watching the file or directory. Associate it again before
+	// reading the directory, so that changes made while it's read are sent
+	// as a new event.
+	if stat != nil {
+		if err := w.associateFile(path, stat, follow); err != nil {
+			return err
+		}
+	}
+
 	if events&unix.FILE_MODIFIED != 0 {
 		if fmode.IsDir() {
 			if watchedDir {
@@ -504,7 +851,7 @@ func (w *Watcher) handleEvent(event *unix.PortEvent) error {
 					return err
 				}
 			} else {
-				if !w.sendEvent(path, Write) {
+				if !w.sendEvent(path, Write|isDir) {
 					return nil
 				}
 			}
@@ -517,49 +864,136 @@ func (w *Watcher) handleEvent(event *unix.PortEvent) error {
 	if events&unix.FILE_ATTRIB != 0 && stat != nil {
 		// Only send Chmod if perms changed
 		if stat.Mode().Perm() != fmode.Perm() {
-			if !w.sendEvent(path, Chmod) {
+			if !w.sendEvent(path, Chmod|isDir) {
 				return nil
 			}
 		}
 	}
+	return nil
+}
 
-	if stat != nil {
-		// If we get here, it means we've hit an event above that requires us to
-		// continue watching the file or directory
-		return w.associateFile(path, stat, isWatched)
+// dirList is the listing of a watched directory: name → inode.
+type dirList map[string]uint64
+
+// fenCookie is the cookie for an association, which is returned with the event.
+type fenCookie struct {
+	mode os.FileMode
+	ino  uint64
+}
+
+func inode(path string, fi os.FileInfo) uint64 {
+	_, ino, _ := fileID(path, fi)
+	return ino
+}
+
+// removeEntry removes a file that the port reported as removed or renamed from
+// the listing of its directory. It returns the directory if it's watched, and
+// false if the event was already sent by updateDirectory().
+func (w *Watcher) removeEntry(path string, ino uint64) (dir string, send bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir = filepath.Dir(path)
+	list, ok := w.entries[dir]
+	if !ok {
+		return "", true
 	}
-	return nil
+	name := filepath.Base(path)
+	if have, ok := list[name]; !ok || have != ino {
+		return dir, false
+	}
+	delete(list, name)
+	return dir, true
 }
 
+// updateDirectory compares the directory with the last listing, sending Create
+// for new files and Remove for files that are gone.
+//
+// Files that are still associated get their own event from the port, which is
+// used instead of the listing so that a rename is sent as a Rename, and so that
+// the Remove for a file that's replaced is sent before the Create.
 func (w *Watcher) updateDirectory(path string) error {
-	// The directory was modified, so we must find unwatched entities and watch
-	// them. If something was removed from the directory, nothing will happen,
-	// as everything else should still be watched.
 	files, err := os.ReadDir(path)
 	if err != nil {
 		return err
 	}
 
+	w.mu.Lock()
+	prev := w.entries[path]
+	w.mu.Unlock()
+
+	cur := make(dirList, len(files))
 	for _, entry := range files {
-		path := filepath.Join(path, entry.Name())
-		if w.port.PathIsWatched(path) {
+		var (
+			name = entry.Name()
+			path = filepath.Join(path, name)
+		)
+		finfo, err := entry.Info()
+		if err != nil { // Removed since ReadDir.
 			continue
 		}
+		ino := inode(path, finfo)
 
-		finfo, err := entry.Info()
-		if err != nil {
-			return err
+		have, ok := prev[name]
+		switch {
+		case ok && have == ino:
+			cur[name] = ino
+			if w.port.PathIsWatched(path) {
+				continue
+			}
+			// The association failed or was lost; watch it again, but
+			// it's not new.
+			if err := w.associateFile(path, finfo, false); err != nil &&
+				!w.sendError(WatchError{Path: path, Op: "add", Err: err}) {
+				return nil
+			}
+			continue
+		case ok && w.port.PathIsWatched(path):
+			// Replaced, and the port hasn't sent the event for the old
+			// file yet.
+			cur[name] = have
+			continue
+		case ok:
+			if !w.sendEvent(path, Remove) {
+				return nil
+			}
 		}
+
+		cur[name] = ino
 		err = w.associateFile(path, finfo, false)
 		if err != nil {
-			if !w.sendError(err) {
+			if !w.sendError(WatchError{Path: path, Op: "add", Err: err}) {
 				return nil
 			}
 		}
-		if !w.sendEvent(path, Create) {
+		op := Create
+		if finfo.IsDir() {
+			op |= IN_ISDIR
+		}
+		if !w.sendEvent(path, op) {
+			return nil
+		}
+	}
+
+	for name, ino := range prev {
+		if _, ok := cur[name]; ok {
+			continue
+		}
+		path := filepath.Join(path, name)
+		if w.port.PathIsWatched(path) {
+			cur[name] = ino // The port will send an event for it.
+			continue
+		}
+		if !w.sendEvent(path, Remove) {
 			return nil
 		}
 	}
+
+	w.mu.Lock()
+	if _, ok := w.entries[path]; ok {
+		w.entries[path] = cur
+	}
+	w.mu.Unlock()
 	return nil
 }
 
@@ -594,7 +1028,7 @@ func (w *Watcher) associateFile(path string, stat os.FileInfo, follow bool) erro
 	}
 	return w.port.AssociatePath(path, stat,
 		events,
-		stat.Mode())
+		fenCookie{mode: stat.Mode(), ino: inode(path, stat)})
 }
 
 func (w *Watcher) dissociateFile(path string, stat os.FileInfo, unused bool) error {
@@ -611,6 +1045,9 @@ func (w *Watcher) WatchList() []string {
 	if w.isClosed() {
 		return nil
 	}
+	if w.reconcile.polling() {
+		return w.reconcile.list()
+	}
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
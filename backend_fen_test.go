@@ -40,6 +40,9 @@ func TestRemoveState(t *testing.T) {
 			t.Errorf("unexpected number of entries in w.dirs (have %d, want %d):\n%v",
 				len(w.dirs), wantDirs, strings.Join(d, "\n"))
 		}
+		if len(w.entries) != wantDirs {
+			t.Errorf("unexpected number of entries in w.entries (have %d, want %d)", len(w.entries), wantDirs)
+		}
 	}
 
 	check(1, 1)
@@ -54,3 +57,38 @@ func TestRemoveState(t *testing.T) {
 	}
 	check(0, 0)
 }
+
+func TestDirectoryEntries(t *testing.T) {
+	tmp := t.TempDir()
+	touch(t, tmp, "keep")
+	touch(t, tmp, "old")
+
+	w := newCollector(t, tmp)
+	w.collect(t)
+
+	touch(t, tmp, "new")
+	rm(t, tmp, "keep")
+	mv(t, join(tmp, "new"), tmp, "old") // Replaces "old".
+	touch(t, tmp, "last")
+
+	cmpEvents(t, tmp, w.stop(t), newEvents(t, `
+		create  /new
+		remove  /keep
+		rename  /new
+		remove  /old
+		create  /old
+		create  /last
+	`))
+
+	w.w.mu.Lock()
+	defer w.w.mu.Unlock()
+	have := w.w.entries[tmp]
+	if len(have) != 2 {
+		t.Errorf("wrong listing: %v", have)
+	}
+	for _, n := range []string{"old", "last"} {
+		if _, ok := have[n]; !ok {
+			t.Errorf("%q not in listing: %v", n, have)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"sync"
 	"unsafe"
 
+	"github.com/hohodqr/fsnotify/internal"
 	"golang.org/x/sys/unix"
 )
 
@@ -56,8 +57,9 @@ import (
 //	fs.inotify.max_user_watches=124983
 //	fs.inotify.max_user_instances=128
 //
-// Reaching the limit will result in a "no space left on device" or "too many open
-// files" error.
+// Adding a path after reaching the watch limit returns [ErrTooManyWatches], and
+// reaching the instance limit results in a "too many open files" error from
+// NewWatcher.
 //
 // # kqueue notes (macOS, BSD)
 //
@@ -79,6 +81,15 @@ import (
 // to work with SMB filesystems. If you have many events in quick succession
 // this may not be enough, and you will have to use [WithBufferSize] to increase
 // the value.
+//
+// Paths longer than MAX_PATH (260 characters) can be watched; they're converted
+// to extended-length paths ("\\\\?\\C:\\path") internally. Event.Name never has
+// the "\\\\?\\" prefix, even if the path was added with it.
+//
+// All Watchers share one I/O completion port, which a few goroutines read
+// events from. A Watcher whose Events channel isn't read (with the default
+// [WithBackpressure]) holds up one of these, and can delay events for other
+// Watchers.
 type Watcher struct {
 	// Events sends the filesystem change events.
 	//
@@ -125,35 +136,100 @@ type Watcher struct {
 	//  - inotify: there are too many queued events (fs.inotify.max_queued_events sysctl)
 	//  - windows: The buffer size is too small; [WithBufferSize] can be used to increase it.
 	//  - kqueue, fen: not used.
+	//
+	// [Watcher.OnOverflow] can be used to be notified right away.
 	Errors chan error
 
 	// Store fd here as os.File.Read() will no longer return on close after
 	// calling Fd(). See: https://github.com/golang/go/issues/26439
 	fd          int
 	inotifyFile *os.File
+	shared      *sharedInotify     // Shared inotify instance (see WithSharedInstance)
+	records     chan inotifyRecord // Events for this Watcher from the shared instance
+	uring       *uring             // Reads with io_uring (see WithIOUring)
+	fan         *fanMounts         // Mounts added with AddMount
 	watches     *watches
-	done        chan struct{} // Channel for sending a "quit message" to the reader goroutine
+	with        withOpts       // Options passed to NewWatcherWith()
+	delivery    *delivery      // Non-blocking event delivery (see WithBackpressure)
+	stats       *stats         // Counters for Stats()
+	files       *fileWatches   // Files added with AddFile and FollowRotation
+	oneShots    *oneShots      // WithOneShot watches
+	attrs       *attrs         // Attribute changes (see WithAttrChanges)
+	pendings    *pendings      // AddPending paths
+	chmod       *chmods        // Chmod filtering and polling (see WithChmod)
+	sums        *checksums     // Write filtering (see WithChecksum)
+	diffs       *contents      // Event.Change (see WithContentDiff)
+	callback    *eventFunc     // Called instead of sending on Events (see WithCallback)
+	subs        *subscriptions // Subscriptions from SubscribeCtx()
+	scan        *scanner       // Synthetic events from WithInitialScan and CatchUp
+	spec        *appliedSpec   // Watches added with ApplySpec()
+	reconcile   *reconciler    // Rescanning (see WithReconcile)
+	contexts    *watchContexts // Removed when done (see WithContext)
+	move        dirMove        // Directory that's being moved; only used by readEvents
+	done        chan struct{}  // Channel for sending a "quit message" to the reader goroutine
 	closeMu     sync.Mutex
 	doneResp    chan struct{} // Channel to respond to Close
 }
 
+// The inotify flags that correspond to the portable operations, as events have
+// the raw inotify mask set as the Op.
+const (
+	opCreate = IN_CREATE | IN_MOVED_TO
+	opWrite  = IN_MODIFY | IN_CLOSE_WRITE
+	opRemove = IN_DELETE | IN_DELETE_SELF
+	opRename = IN_MOVED_FROM | IN_MOVE_SELF
+	opChmod  = IN_ATTRIB
+)
+
+// The operations that can be enabled with WithOps.
+const supportedOps = Open | Read | Close | Xattr
+
+// The operations that can be sent (see Watcher.Supports).
+const sentOps = opCreate | opWrite | opRemove | opRename | opChmod | IN_OPEN | IN_ACCESS | IN_CLOSE | IN_UNMOUNT |
+	supportedOps | Retargeted | Rotated | Handover | Unmount | Expired | WatchRemoved
+
 type (
 	watches struct {
-		mu   sync.RWMutex
-		wd   map[uint32]*watch // wd → watch
-		path map[string]uint32 // pathname → wd
+		mu    sync.RWMutex
+		wd    map[uint32]*watch      // wd → watch
+		path  map[*pathNode]uint32   // path → wd
+		paths *pathTree              // Paths of all watches
+		links map[string]watchedLink // symlink → target, for WithRetarget and WithFollowSymlinks(false)
+		depth map[string]int         // root → WithMaxDepth, for recursive watches
 	}
 	watch struct {
-		wd    uint32 // Watch descriptor (as returned by the inotify_add_watch() syscall)
-		flags uint32 // inotify flags of this watch (see inotify(7) for the list of valid flags)
-		path  string // Watch path.
+		wd    uint32    // Watch descriptor (as returned by the inotify_add_watch() syscall)
+		flags uint32    // inotify flags of this watch (see inotify(7) for the list of valid flags)
+		path  *pathNode // Watch path.
+		root  string    // Path passed to Add() this watch belongs to.
+
+		// Only added to detect symlink changes for WithRetarget and
+		// WithFollowSymlinks(false); events are not sent. Cleared if the
+		// user adds the path as well.
+		internal bool
+	}
+	watchedLink struct {
+		target string
+		flags  uint32 // Extra flags to re-add the watch with (IN_DONT_FOLLOW).
+	}
+	// dirMove is a directory in a recursive watch that's being moved: the
+	// kernel sends IN_MOVED_FROM, IN_MOVED_TO with the same cookie, and then
+	// IN_MOVE_SELF for the directory itself.
+	dirMove struct {
+		cookie uint32
+		from   string // Path from IN_MOVED_FROM, or "" if there is none.
+		root   string
+		moved  uint32 // Watch that was moved, for IN_MOVE_SELF.
 	}
 )
 
 func newWatches() *watches {
 	return &watches{
-		wd:   make(map[uint32]*watch),
-		path: make(map[string]uint32),
+		wd:    make(map[uint32]*watch),
+		path:  make(map[*pathNode]uint32),
+		paths: newPathTree(),
+		links: make(map[string]watchedLink),
+		depth: make(map[string]int),
 	}
 }
 
@@ -163,30 +239,74 @@ func (w *watches) len() int {
 	return len(w.wd)
 }
 
-func (w *watches) add(ww *watch) {
+func (w *watches) remove(wd uint32) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	w.wd[ww.wd] = ww
-	w.path[ww.path] = ww.wd
+	if ww, ok := w.wd[wd]; ok {
+		delete(w.path, ww.path)
+		w.paths.release(ww.path)
+		delete(w.wd, wd)
+	}
 }
 
-func (w *watches) remove(wd uint32) {
+// move changes the path of the watches for the directory old and all
+// directories below it that belong to root to new, as if they had been added
+// with the new path. It returns the watch for old, or nil if there isn't one.
+func (w *watches) move(old, new, root string) *watch {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	delete(w.path, w.wd[wd].path)
-	delete(w.wd, wd)
+
+	// The kernel removes a watch for an empty directory that's replaced, but
+	// the IN_DELETE_SELF for it may come later.
+	if wd, ok := w.path[w.paths.lookup(new)]; ok {
+		delete(w.path, w.wd[wd].path)
+		w.paths.release(w.wd[wd].path)
+		delete(w.wd, wd)
+	}
+
+	moved := w.get(old)
+	for _, ww := range w.wd {
+		if ww.root != root || ww.internal {
+			continue
+		}
+		p := ww.path.String()
+		if p != old && !strings.HasPrefix(p, old+"/") {
+			continue
+		}
+		delete(w.path, ww.path)
+		w.paths.release(ww.path)
+		ww.path = w.paths.add(new + p[len(old):])
+		w.path[ww.path] = ww.wd
+	}
+	return moved
+}
+
+// below returns the paths of the watches for root that are for dir or below it.
+func (w *watches) below(dir, root string) []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var paths []string
+	for _, ww := range w.wd {
+		if p := ww.path.String(); ww.root == root && !ww.internal &&
+			(p == dir || strings.HasPrefix(p, dir+"/")) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
 }
 
 func (w *watches) removePath(path string) (uint32, bool) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	wd, ok := w.path[path]
+	node := w.paths.lookup(path)
+	wd, ok := w.path[node]
 	if !ok {
 		return 0, false
 	}
 
-	delete(w.path, path)
+	delete(w.path, node)
+	w.paths.release(node)
 	delete(w.wd, wd)
 
 	return wd, true
@@ -195,7 +315,16 @@ func (w *watches) removePath(path string) (uint32, bool) {
 func (w *watches) byPath(path string) *watch {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
-	return w.wd[w.path[path]]
+	return w.get(path)
+}
+
+// get the watch for the path; w.mu must be held.
+func (w *watches) get(path string) *watch {
+	wd, ok := w.path[w.paths.lookup(path)]
+	if !ok {
+		return nil
+	}
+	return w.wd[wd]
 }
 
 func (w *watches) byWd(wd uint32) *watch {
@@ -207,9 +336,13 @@ func (w *watches) byWd(wd uint32) *watch {
 func (w *watches) updatePath(path string, f func(*watch) (*watch, error)) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return w.updatePathLocked(path, f)
+}
 
+// updatePathLocked is like updatePath; w.mu must be held.
+func (w *watches) updatePathLocked(path string, f func(*watch) (*watch, error)) error {
 	var existing *watch
-	wd, ok := w.path[path]
+	wd, ok := w.path[w.paths.lookup(path)]
 	if ok {
 		existing = w.wd[wd]
 	}
@@ -219,6 +352,9 @@ func (w *watches) updatePath(path string, f func(*watch) (*watch, error)) error
 		return err
 	}
 	if upd != nil {
+		if upd.path == nil {
+			upd.path = w.paths.add(path)
+		}
 		w.wd[upd.wd] = upd
 		w.path[upd.path] = upd.wd
 
@@ -231,66 +367,183 @@ func (w *watches) updatePath(path string, f func(*watch) (*watch, error)) error
 }
 
 func WatcherRecursivelyWithExclude() (*Watcher, error) {
-	fd, errno := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
-	if fd == -1 {
-		return nil, errno
-	}
-	w := &Watcher{
-		fd:          fd,
-		inotifyFile: os.NewFile(uintptr(fd), ""),
-		watches:     newWatches(),
-		Events:      make(chan Event),
-		Errors:      make(chan error),
-		done:        make(chan struct{}),
-		doneResp:    make(chan struct{}),
+	w, err := NewWatcherWith()
+	if err != nil {
+		return nil, err
 	}
 
-	wdirs, err := GetDirNames(w.WatchList())
+	wdirs, err := GetDirs(w.WatchList())
 	if err != nil {
+		w.Close()
 		return nil, err
 	}
 	for _, v := range wdirs {
 		w.Add(v)
 	}
-	go w.readEvents()
 	return w, nil
 }
 
 // NewWatcher creates a new Watcher.
-func NewWatcher() (*Watcher, error) {
-	// Need to set nonblocking mode for SetDeadline to work, otherwise blocking
-	// I/O operations won't terminate on close.
-	fd, errno := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
-	if fd == -1 {
-		return nil, errno
+func NewWatcher() (*Watcher, error) { return NewWatcherWith() }
+
+// NewWatcherWith is like [NewWatcher], but allows passing options.
+//
+// Possible options are:
+//
+//   - [WithEventChannelSize] sets the capacity of the Events channel. The
+//     default is 0 (unbuffered), or 50 on Windows.
+//
+//   - [WithRootRelativeNames] makes Event.Name relative to the path passed to
+//     Add(), which is set in Event.Root.
+//
+//   - [WithRelativeTo] makes Event.Name relative to a directory, which is set
+//     in Event.Root.
+//
+//   - [WithBackpressure] sets what to do if the Events channel is full. The
+//     default is to block.
+//
+//   - [WithChmod] enables or disables Chmod events, making them consistent
+//     across platforms. The default is to send what the platform reports.
+//
+//   - [WithCallback] calls a function for every event instead of sending it
+//     on the Events channel, without allocating memory for every event.
+//
+//   - [WithJournal] appends every event to a journal file.
+//
+//   - [WithChecksum] only sends Write events if the contents of the file
+//     changed.
+//
+//   - [WithContentDiff] sets what changed in the file on Write events.
+//
+//   - [WithNameEncoding] sets how names that aren't valid UTF-8 are sent.
+//
+//   - [WithUnicodeNorm] normalizes names to a Unicode normalization form,
+//     such as NFC.
+//
+//   - [WithCaseInsensitivePaths] matches paths passed to Add and Remove
+//     regardless of case on case-insensitive volumes.
+//
+//   - [WithAbsolutePaths] makes Event.Name an absolute path with symlinks
+//     resolved.
+//
+//   - [WithAttrChanges] sets which attributes changed on Chmod events.
+//
+//   - [WithExcludeUnlinked] drops events for files that were removed but are
+//     still open.
+//
+//   - [WithSharedInstance] shares one inotify instance between Watchers on
+//     Linux.
+//
+//   - [WithIOUring] reads inotify events with io_uring on Linux; this is
+//     experimental.
+//
+//   - [WithReconcile] rescans all watched paths periodically, and sends
+//     events for changes that the system didn't report.
+//
+//   - [WithFilePolling] only opens directories on kqueue, and polls files for
+//     changes.
+//
+//   - [WithBufferSize] sets the size of the buffer inotify events are read
+//     into on Linux, and how many kevents are read at once on kqueue. The
+//     default is 64K (65536 bytes).
+func NewWatcherWith(opts ...addOpt) (*Watcher, error) {
+	with := getOptions(opts...)
+	if with.bufsize < 4096 {
+		return nil, fmt.Errorf("fsnotify.WithBufferSize: buffer size cannot be smaller than 4096 bytes")
+	}
+	if with.ioUring && with.shared {
+		return nil, fmt.Errorf("fsnotify.WithIOUring: can't be used with WithSharedInstance")
+	}
+	if err := checkPlatformOpts(with, "inotify", true); err != nil {
+		return nil, err
 	}
 
 	w := &Watcher{
-		fd:          fd,
-		inotifyFile: os.NewFile(uintptr(fd), ""),
-		watches:     newWatches(),
-		Events:      make(chan Event),
-		Errors:      make(chan error),
-		done:        make(chan struct{}),
-		doneResp:    make(chan struct{}),
+		watches:   newWatches(),
+		with:      with,
+		delivery:  newDelivery(with),
+		stats:     newStats(),
+		fan:       newFanMounts(),
+		files:     newFileWatches(),
+		oneShots:  newOneShots(),
+		attrs:     newAttrs(with),
+		pendings:  newPendings(),
+		chmod:     newChmods(with),
+		sums:      newChecksums(with),
+		diffs:     newContents(with),
+		callback:  newEventFunc(with),
+		subs:      newSubscriptions(with),
+		scan:      newScanner(),
+		spec:      newAppliedSpec(),
+		reconcile: newReconciler(with),
+		contexts:  newWatchContexts(),
+		Events:    make(chan Event, with.eventsSize),
+		Errors:    make(chan error),
+		done:      make(chan struct{}),
+		doneResp:  make(chan struct{}),
+	}
+
+	if with.shared {
+		s, records, err := joinShared(w)
+		if err != nil {
+			return nil, err
+		}
+		w.fd, w.shared, w.records = s.fd, s, records
+	} else {
+		// Need to set nonblocking mode for SetDeadline to work, otherwise
+		// blocking I/O operations won't terminate on close. io_uring would
+		// return EAGAIN rather than wait for events, and is woken up on close.
+		flags := unix.IN_CLOEXEC | unix.IN_NONBLOCK
+		if with.ioUring {
+			flags = unix.IN_CLOEXEC
+		}
+		fd, errno := unix.InotifyInit1(flags)
+		if fd == -1 {
+			return nil, errno
+		}
+		internal.Opened("inotify", fd)
+		w.fd, w.inotifyFile = fd, os.NewFile(uintptr(fd), "")
+
+		if with.ioUring {
+			u, err := newURing(fd, with.bufsize)
+			if err != nil {
+				w.inotifyFile.Close()
+				internal.Closed("inotify", fd)
+				return nil, fmt.Errorf("fsnotify.WithIOUring: %w", err)
+			}
+			w.uring = u
+		}
 	}
 
 	go w.readEvents()
+	w.reconcile.run(w.WatchList, w.sendSynthetic)
 	return w, nil
 }
 
 // Returns true if the event was sent, or false if watcher is closed.
 func (w *Watcher) sendEvent(e Event) bool {
-	select {
-	case w.Events <- e:
+	e, ok := w.filter(e)
+	if !ok {
 		return true
-	case <-w.done:
-		return false
 	}
+	if w.subs.send(e, w.callback != nil) {
+		w.delivery.sent(e)
+		return true
+	}
+	if w.callback != nil {
+		w.callback.call(e)
+		w.delivery.sent(e)
+		return true
+	}
+	if !w.delivery.blocking() {
+		return w.delivery.send(w.Events, e)
+	}
+	return w.delivery.sendBlocking(w.Events, e, w.done)
 }
 
 // Returns true if the error was sent, or false if watcher is closed.
 func (w *Watcher) sendError(err error) bool {
+	w.stats.error(err)
 	select {
 	case w.Errors <- err:
 		return true
@@ -309,20 +562,35 @@ func (w *Watcher) isClosed() bool {
 }
 
 // Close removes all watches and closes the events channel.
+//
+// Remove and Rename events that were already read from the system are sent
+// before the Events channel is closed, even if other events are dropped because
+// of [WithBackpressure]. Close waits up to a second for these to be read.
 func (w *Watcher) Close() error {
 	w.closeMu.Lock()
 	if w.isClosed() {
 		w.closeMu.Unlock()
 		return nil
 	}
+	w.delivery.stopping()
 	close(w.done)
 	w.closeMu.Unlock()
 
-	// Causes any blocking reads to return with an error, provided the file
-	// still supports deadline operations.
-	err := w.inotifyFile.Close()
-	if err != nil {
-		return err
+	if w.shared != nil {
+		if err := w.shared.leave(w); err != nil {
+			return err
+		}
+	} else {
+		if w.uring != nil {
+			w.uring.wakeup()
+		}
+		// Causes any blocking reads to return with an error, provided the
+		// file still supports deadline operations.
+		err := w.inotifyFile.Close()
+		if err != nil {
+			return err
+		}
+		internal.Closed("inotify", w.fd)
 	}
 
 	// Wait for goroutine to close
@@ -342,7 +610,10 @@ func (w *Watcher) Close() error {
 // watcher on renames.
 //
 // Notifications on network filesystems (NFS, SMB, FUSE, etc.) or special
-// filesystems (/proc, /sys, etc.) generally don't work.
+// filesystems (/proc, /sys, etc.) generally don't work. The exception is
+// network shares on Windows, which are polled if the server doesn't send
+// notifications; a [NetworkWatch] is sent on the Errors channel to tell which
+// is used.
 //
 // Returns [ErrClosed] if [Watcher.Close] was called.
 //
@@ -374,26 +645,336 @@ func (w *Watcher) Add(name string) error { return w.AddWith(name) }
 // Possible options are:
 //
 //   - [WithBufferSize] sets the buffer size for the Windows backend; no-op on
-//     other platforms. The default is 64K (65536 bytes).
-func (w *Watcher) AddWith(name string, opts ...addOpt) error {
+//     other platforms, where it's passed to NewWatcherWith instead. The
+//     default is 64K (65536 bytes).
+//   - [WithWindowsFilters] sets the ReadDirectoryChangesW notify filters for
+//     the Windows backend; no-op on other platforms.
+//   - [WithRetarget] moves the watch if the target of a symlink changes;
+//     Linux only.
+//   - [WithFollowSymlinks] sets whether to watch the target of a symlink or
+//     the symlink itself. The default is to watch the target.
+//   - [WithMaxDepth] limits how many levels of subdirectories a recursive
+//     watch descends. The default is unlimited.
+//   - [WithContinueOnError] skips subdirectories of a recursive watch that
+//     can't be read or watched, rather than failing.
+//   - [WithWalkWorkers] sets how many directories are read at the same time
+//     for a recursive watch.
+//   - [WithProgress] reports how many directories of a recursive watch were
+//     found and watched so far.
+//   - [WithInitialScan] sends a Create event for every existing file after
+//     the path is added.
+//   - [WithOneShot] removes the watch after the first event is sent.
+//   - [WithContext] removes the watch when the context is done.
+//   - [WithTTL] removes the watch after a duration.
+//   - [WithOnlyDir] returns an error if the path isn't a directory.
+//   - [WithOps] enables the Open, Read, Close, and Xattr operations.
+//   - [WithReArm] adds the path again if it's removed and recreated.
+func (w *Watcher) AddWith(name string, opts ...addOpt) (err error) {
 	if w.isClosed() {
 		return ErrClosed
 	}
 
-	name = filepath.Clean(name)
-	_ = getOptions(opts...)
+	name = filepath.Clean(w.addPath(name, opts))
+	defer func() { w.spec.addUser(name, opts, err) }()
+	w.pendings.added(name, opts)
+	with := getOptions(opts...)
+	if w.reconcile.polling() {
+		return w.reconcile.add(name, with)
+	}
+	if err := checkPlatformOpts(with, "inotify", false); err != nil {
+		return err
+	}
+
+	if root, recurse := recursivePath(name); recurse {
+		if with.onlyDir {
+			if err := checkDir(root, with.noFollow); err != nil {
+				return err
+			}
+		}
+		err := w.addRecursive(root, with)
+		if _, partial := err.(*WalkError); err != nil && !partial {
+			return err
+		}
+		w.oneShots.set(name, with.oneShot)
+		w.contexts.set(name, with, w.Remove, w.sendExpired)
+		w.attrs.set(name, with.ops.Has(Xattr))
+		w.initialScan(name, with)
+		return err
+	}
+
+	var flags uint32
+	if with.noFollow {
+		flags |= unix.IN_DONT_FOLLOW
+	}
+	// A shared instance always uses IN_MASK_ADD, which would keep IN_ONESHOT
+	// for the other Watchers.
+	kernelOneShot := with.oneShot && w.shared == nil
+	if kernelOneShot {
+		flags |= unix.IN_ONESHOT
+	}
+	if with.onlyDir {
+		flags |= unix.IN_ONLYDIR
+	}
+	flags |= opFlags(with.ops)
+	if with.retarget || with.noFollow {
+		if err := w.addLink(name, flags); err != nil {
+			return err
+		}
+	}
+	if err := w.add(name, name, flags); err != nil {
+		if with.onlyDir && errors.Is(err, unix.ENOTDIR) {
+			return ErrNotDirectory{Path: name}
+		}
+		return err
+	}
+	w.oneShots.set(name, with.oneShot && !kernelOneShot)
+	w.contexts.set(name, with, w.Remove, w.sendExpired)
+	w.attrs.set(name, with.ops.Has(Xattr))
+	w.initialScan(name, with)
+	return nil
+}
+
+// sendSynthetic sends an event that didn't come from the kernel, for
+// WithInitialScan, CatchUp, and WithReconcile; op is Create, Write, or Remove.
+func (w *Watcher) sendSynthetic(name string, op Op, isDir bool) bool {
+	e := Event{Name: name}
+	switch op {
+	case Create:
+		e.Op = IN_CREATE
+	case Write:
+		e.Op = IN_MODIFY
+	case Remove:
+		e.Op = IN_DELETE
+	}
+	if isDir {
+		e.Op |= IN_ISDIR
+	}
+	if w.with.rootRelative {
+		watch := w.watches.byPath(name)
+		if watch == nil {
+			watch = w.watches.byPath(filepath.Dir(name))
+		}
+		if watch != nil {
+			e = e.rootRelative(watch.root)
+		}
+	}
+	return w.sendEvent(e)
+}
+
+// How many watches addRecursive adds while holding the lock.
+const addBatchSize = 256
+
+// addRecursive adds a watch for the directory and all its subdirectories, up to
+// WithMaxDepth levels deep. With WithContinueOnError the directories that can't
+// be read or watched are skipped, and returned in a *WalkError.
+func (w *Watcher) addRecursive(root string, with withOpts) error {
+	walk := with
+	walk.loopPolicy = LoopSkip
+	dirs, err := getDirs([]string{root}, walk)
+	var errs []error
+	if werr, ok := err.(*WalkError); ok {
+		errs = werr.Errs
+	} else if err != nil {
+		return err
+	}
+	if with.maxDepth > 0 {
+		w.watches.mu.Lock()
+		w.watches.depth[root] = with.maxDepth
+		w.watches.mu.Unlock()
+	}
+	var watched, reported int
+	for i := 0; i < len(dirs); {
+		n, err := w.addBatch(dirs[i:], root, opFlags(with.ops))
+		i, watched = i+n, watched+n
+		if err != nil {
+			if !with.continueOnError || dirs[i] == root {
+				return err
+			}
+			errs = append(errs, err)
+			i++
+		}
+		if with.progress != nil && (watched-reported >= progressEvery || i == len(dirs)) {
+			with.progress(len(dirs), watched)
+			reported = watched
+		}
+	}
+	return walkError(errs)
+}
+
+// inDepth reports if a new directory should be watched according to the
+// WithMaxDepth of the root.
+func (w *watches) inDepth(name, root string) bool {
+	w.mu.RLock()
+	max := w.depth[root]
+	w.mu.RUnlock()
+	return max <= 0 || strings.Count(strings.TrimPrefix(name, root), "/") <= max
+}
+
+// linkTarget gets the target of a symlink; for IN_DONT_FOLLOW watches this is
+// the content of the link, as the target doesn't need to exist.
+func linkTarget(name string, flags uint32) (string, error) {
+	if flags&unix.IN_DONT_FOLLOW != 0 {
+		return os.Readlink(name)
+	}
+	return filepath.EvalSymlinks(name)
+}
+
+// opFlags gets the inotify flags for the operations enabled with WithOps.
+func opFlags(ops Op) uint32 {
+	var flags uint32
+	if ops.Has(Open) {
+		flags |= unix.IN_OPEN
+	}
+	if ops.Has(Read) {
+		flags |= unix.IN_ACCESS
+	}
+	if ops.Has(Close) {
+		flags |= unix.IN_CLOSE_WRITE | unix.IN_CLOSE_NOWRITE
+	}
+	return flags
+}
+
+// addLink keeps track of the target of the symlink, and watches the parent
+// directory for changes to it. It's not an error if name isn't a symlink.
+func (w *Watcher) addLink(name string, flags uint32) error {
+	st, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if st.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	target, err := linkTarget(name, flags)
+	if err != nil {
+		return err
+	}
+
+	// The parent directory watch is internal unless the user also adds it; the
+	// links map records that it's needed for the link.
+	dir := filepath.Dir(name)
+	err = w.watches.updatePath(dir, func(existing *watch) (*watch, error) {
+		if existing != nil {
+			return nil, nil
+		}
+		ww, err := w.newWatch(dir, dir, 0)(nil)
+		if ww != nil {
+			ww.internal = true
+		}
+		return ww, err
+	})
+	if err != nil {
+		return w.watchErr(dir, err)
+	}
+
+	w.watches.mu.Lock()
+	w.watches.links[name] = watchedLink{target: target, flags: flags}
+	w.watches.mu.Unlock()
+	return nil
+}
+
+// retarget moves the watches for the symlink and everything below it if the
+// target changed, returning true if it did.
+func (w *Watcher) retarget(link string) (bool, error) {
+	w.watches.mu.RLock()
+	old, ok := w.watches.links[link]
+	watched := w.watches.get(link) != nil
+	w.watches.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	target, err := linkTarget(link, old.flags)
+	if err != nil {
+		return false, nil // Removed; nothing to do (yet).
+	}
+	if target == old.target {
+		if watched {
+			return false, nil
+		}
+		// Replaced by a link to the same target; with IN_DONT_FOLLOW the watch
+		// on the old link is gone, so add it again.
+		return false, w.add(link, link, old.flags)
+	}
+
+	type moved struct{ path, root string }
+	w.watches.mu.Lock()
+	w.watches.links[cloneString(link)] = watchedLink{target: target, flags: old.flags}
+	var move []moved
+	if node := w.watches.paths.lookup(link); node != nil {
+		for _, ww := range w.watches.wd {
+			if ww.path.in(node) {
+				move = append(move, moved{ww.path.String(), ww.root})
+			}
+		}
+	}
+	w.watches.mu.Unlock()
+
+	if !watched {
+		move = append(move, moved{link, link})
+	}
+	for _, ww := range move {
+		// The path stays the same, but it now refers to a different inode and
+		// thus a different watch descriptor.
+		_ = w.rmWatch(ww.path)
+		flags := uint32(0)
+		if ww.path == link {
+			flags = old.flags
+		} else if _, err := os.Stat(ww.path); err != nil {
+			continue // Doesn't exist in the new target.
+		}
+		if err := w.add(ww.path, ww.root, flags); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
 
-	var flags uint32 = unix.IN_MOVED_TO | unix.IN_MOVED_FROM |
+// add a watch for name, which belongs to the watch root (the path that was
+// passed to Add(); this is different from name for directories that are added
+// automatically).
+func (w *Watcher) add(name, root string, flags uint32) error {
+	return w.watchErr(name, w.watches.updatePath(name, w.newWatch(name, root, flags)))
+}
+
+// addBatch adds watches for up to addBatchSize names like add, but only locks
+// the watches once. It returns the number of names that were added before the
+// first error.
+func (w *Watcher) addBatch(names []string, root string, flags uint32) (int, error) {
+	if len(names) > addBatchSize {
+		names = names[:addBatchSize]
+	}
+	w.watches.mu.Lock()
+	for i, name := range names {
+		if err := w.watches.updatePathLocked(name, w.newWatch(name, root, flags)); err != nil {
+			w.watches.mu.Unlock()
+			return i, w.watchErr(name, err)
+		}
+	}
+	w.watches.mu.Unlock()
+	return len(names), nil
+}
+
+// newWatch returns the function for updatePath to add a watch for name.
+func (w *Watcher) newWatch(name, root string, flags uint32) func(*watch) (*watch, error) {
+	flags |= unix.IN_MOVED_TO | unix.IN_MOVED_FROM |
 		unix.IN_CREATE | unix.IN_ATTRIB | unix.IN_MODIFY |
 		unix.IN_MOVE_SELF | unix.IN_DELETE | unix.IN_DELETE_SELF
 	// var flags uint32 = unix.IN_ALL_EVENTS
+	if w.with.excludeUnlinked {
+		flags |= unix.IN_EXCL_UNLINK
+	}
 
-	return w.watches.updatePath(name, func(existing *watch) (*watch, error) {
+	return func(existing *watch) (*watch, error) {
 		if existing != nil {
-			flags |= existing.flags | unix.IN_MASK_ADD
+			// IN_MASK_ADD would keep IN_ONESHOT, so replace the mask if the
+			// watch is no longer one-shot.
+			if existing.flags&unix.IN_ONESHOT != 0 && flags&unix.IN_ONESHOT == 0 {
+				flags |= existing.flags &^ (unix.IN_ONESHOT | unix.IN_MASK_ADD)
+			} else {
+				flags |= existing.flags | unix.IN_MASK_ADD
+			}
 		}
 
-		wd, err := unix.InotifyAddWatch(w.fd, name, flags)
+		wd, err := w.addWatch(name, flags)
 		if wd == -1 {
 			return nil, err
 		}
@@ -401,15 +982,43 @@ func (w *Watcher) AddWith(name string, opts ...addOpt) error {
 		if existing == nil {
 			return &watch{
 				wd:    uint32(wd),
-				path:  name,
+				root:  root,
 				flags: flags,
 			}, nil
 		}
 
 		existing.wd = uint32(wd)
 		existing.flags = flags
+		if name == root || existing.internal {
+			existing.root = root
+		}
+		existing.internal = false // Now also added by the user.
 		return existing, nil
-	})
+	}
+}
+
+// watchErr converts the error from adding a watch for name.
+func (w *Watcher) watchErr(name string, err error) error {
+	// ENOSPC means max_user_watches was reached, which is a rather confusing
+	// error ("no space left on device").
+	if errors.Is(err, unix.ENOSPC) {
+		err = ErrTooManyWatches{
+			Path:    name,
+			Watches: w.watches.len(),
+			Limit:   readProcInt("/proc/sys/fs/inotify/max_user_watches"),
+			Setting: "fs.inotify.max_user_watches",
+			Err:     err,
+		}
+	}
+	return err
+}
+
+// addWatch calls inotify_add_watch, or adds the watch to the shared instance.
+func (w *Watcher) addWatch(name string, flags uint32) (int, error) {
+	if w.shared != nil {
+		return w.shared.addWatch(w, name, flags)
+	}
+	return unix.InotifyAddWatch(w.fd, name, flags)
 }
 
 // Remove stops monitoring the path for changes.
@@ -431,14 +1040,83 @@ func (w *Watcher) Remove(name string) error {
 	if w.isClosed() {
 		return nil
 	}
-	return w.remove(filepath.Clean(name))
+	name = filepath.Clean(w.casePath(name))
+	w.oneShots.clear(name)
+	w.contexts.clear(name)
+	w.attrs.clear(name)
+	w.spec.removeUser(name)
+	if w.reconcile.polling() {
+		return w.reconcile.remove(name)
+	}
+	if w.removePending(name) {
+		return nil
+	}
+	if ok, err := w.fan.remove(name); ok {
+		return err
+	}
+	name, recurse := recursivePath(name)
+	if recurse {
+		return w.removeRecursive(name)
+	}
+	return w.removeOwned(name)
+}
+
+// removeOwned removes the watch for a path that was added by the user. The
+// watch is kept as an internal watch if it's the parent directory of a symlink
+// that's still tracked by addLink.
+func (w *Watcher) removeOwned(name string) error {
+	w.removeLink(name)
+	w.watches.mu.Lock()
+	if ww := w.watches.get(name); ww != nil && (ww.internal || w.watches.hasLinkIn(name)) {
+		internal := ww.internal
+		ww.internal, ww.root = true, name
+		w.watches.mu.Unlock()
+		if internal {
+			return fmt.Errorf("%w: %s", ErrNonExistentWatch, name)
+		}
+		return nil
+	}
+	w.watches.mu.Unlock()
+	return w.rmWatch(name)
+}
+
+// removeRecursive removes all watches that were added for the root.
+func (w *Watcher) removeRecursive(root string) error {
+	w.watches.mu.Lock()
+	delete(w.watches.depth, root)
+	var paths []string
+	for _, ww := range w.watches.wd {
+		if ww.root == root && !ww.internal {
+			paths = append(paths, ww.path.String())
+		}
+	}
+	w.watches.mu.Unlock()
+
+	if len(paths) == 0 {
+		return fmt.Errorf("%w: %s", ErrNonExistentWatch, root)
+	}
+	for _, p := range paths {
+		if err := w.removeOwned(p); err != nil && !errors.Is(err, ErrNonExistentWatch) {
+			return err
+		}
+	}
+	return nil
 }
 
 func (w *Watcher) remove(name string) error {
+	w.removeLink(name)
+	return w.rmWatch(name)
+}
+
+// rmWatch removes the inotify watch for the path.
+func (w *Watcher) rmWatch(name string) error {
 	wd, ok := w.watches.removePath(name)
 	if !ok {
 		return fmt.Errorf("%w: %s", ErrNonExistentWatch, name)
 	}
+	if w.shared != nil && !w.shared.release(w, wd) {
+		return nil // Still used by another Watcher.
+	}
 
 	success, errno := unix.InotifyRmWatch(w.fd, wd)
 	if success == -1 {
@@ -457,6 +1135,38 @@ func (w *Watcher) remove(name string) error {
 	return nil
 }
 
+// removeLink stops tracking the symlink target for a WithRetarget or
+// WithFollowSymlinks(false) watch, and removes the internal watch on the parent
+// directory if it's no longer needed.
+func (w *Watcher) removeLink(name string) {
+	w.watches.mu.Lock()
+	if _, ok := w.watches.links[name]; !ok {
+		w.watches.mu.Unlock()
+		return
+	}
+	delete(w.watches.links, name)
+
+	dir := filepath.Dir(name)
+	parent := w.watches.get(dir)
+	if parent == nil || !parent.internal || w.watches.hasLinkIn(dir) {
+		w.watches.mu.Unlock()
+		return
+	}
+	w.watches.mu.Unlock()
+	_ = w.remove(dir)
+}
+
+// hasLinkIn reports if a symlink in dir is tracked by addLink; w.mu must be
+// held.
+func (w *watches) hasLinkIn(dir string) bool {
+	for l := range w.links {
+		if filepath.Dir(l) == dir {
+			return true
+		}
+	}
+	return false
+}
+
 // WatchList returns all paths added with [Add] (and are not yet removed).
 //
 // Returns nil if [Watcher.Close] was called.
@@ -464,127 +1174,317 @@ func (w *Watcher) WatchList() []string {
 	if w.isClosed() {
 		return nil
 	}
+	if w.reconcile.polling() {
+		return w.reconcile.list()
+	}
 
 	entries := make([]string, 0, w.watches.len())
 	w.watches.mu.RLock()
-	for pathname := range w.watches.path {
-		entries = append(entries, pathname)
+	for path, wd := range w.watches.path {
+		if w.watches.wd[wd].internal {
+			continue
+		}
+		entries = append(entries, path.String())
 	}
 	w.watches.mu.RUnlock()
 
-	return entries
+	return append(entries, w.fan.points()...)
+}
+
+// DroppedEvents returns the number of events that were dropped because of the
+// backpressure policy set with [WithBackpressure].
+func (w *Watcher) DroppedEvents() uint64 { return w.delivery.droppedEvents() }
+
+// PathStats returns how much memory is used to store the watched paths.
+func (w *Watcher) PathStats() PathStats {
+	w.watches.mu.RLock()
+	defer w.watches.mu.RUnlock()
+	return w.watches.paths.stats()
 }
 
 // readEvents reads from the inotify file descriptor, converts the
 // received events into Event objects and sends them via the Events channel
 func (w *Watcher) readEvents() {
 	defer func() {
+		w.fan.stop()
+		w.chmod.stop()
+		w.reconcile.stop()
+		w.contexts.stop()
+		w.scan.wait()
+		w.subs.close()
+		w.delivery.close()
+		w.delivery.sendKept(w.Events)
 		close(w.doneResp)
 		close(w.Errors)
 		close(w.Events)
 	}()
 
-	var (
-		buf   [unix.SizeofInotifyEvent * 4096]byte // Buffer for a maximum of 4096 raw events
-		errno error                                // Syscall errno
-	)
+	var nameBuf []byte // Reused for names with WithCallback
+	if w.shared != nil {
+		for {
+			select {
+			case raw := <-w.records:
+				if !w.handleRecord(raw, &nameBuf) {
+					return
+				}
+			case <-w.done:
+				return
+			}
+		}
+	}
+
+	if w.uring != nil {
+		defer w.uring.close()
+		w.uring.run(func(buf []byte, err error) bool {
+			return w.handleRead(buf, err, &nameBuf)
+		})
+		return
+	}
+
+	buf := make([]byte, w.with.bufsize) // Buffer for the raw events (see WithBufferSize)
 	for {
 		// See if we have been closed.
 		if w.isClosed() {
 			return
 		}
 
-		n, err := w.inotifyFile.Read(buf[:])
-		switch {
-		case errors.Unwrap(err) == os.ErrClosed:
+		n, err := w.inotifyFile.Read(buf)
+		if errors.Unwrap(err) == os.ErrClosed {
+			return
+		}
+		if !w.handleRead(buf[:n], err, &nameBuf) {
 			return
-		case err != nil:
-			if !w.sendError(err) {
-				return
-			}
-			continue
 		}
+	}
+}
 
-		if n < unix.SizeofInotifyEvent {
-			var err error
-			if n == 0 {
-				err = io.EOF // If EOF is received. This should really never happen.
-			} else if n < 0 {
-				err = errno // If an error occurred while reading.
-			} else {
-				err = errors.New("notify: short read in readEvents()") // Read was too short.
-			}
-			if !w.sendError(err) {
-				return
-			}
-			continue
+// handleRead sends the events in buf, which was read from the inotify file
+// descriptor, or the error from reading it. It returns false if the watcher
+// was closed.
+func (w *Watcher) handleRead(buf []byte, err error, nameBuf *[]byte) bool {
+	switch {
+	case err != nil:
+		return w.sendError(err)
+	case len(buf) == 0:
+		return w.sendError(io.EOF) // If EOF is received. This should really never happen.
+	case len(buf) < unix.SizeofInotifyEvent:
+		return w.sendError(errors.New("notify: short read in readEvents()")) // Read was too short.
+	}
+
+	// We don't know how many events we just read into the buffer
+	for offset := 0; offset < len(buf); {
+		raw, size, err := readInotifyRecord(buf[offset:])
+		if err != nil {
+			return w.sendError(err)
 		}
+		// Move to the next event in the buffer
+		offset += size
 
-		var offset uint32
-		// We don't know how many events we just read into the buffer
-		// While the offset points to at least one whole event...
-		for offset <= uint32(n-unix.SizeofInotifyEvent) {
-			var (
-				// Point "raw" to the event in the buffer
-				raw     = (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
-				mask    = uint32(raw.Mask)
-				nameLen = uint32(raw.Len)
-			)
+		if !w.handleRecord(raw, nameBuf) {
+			return false
+		}
+	}
+	return true
+}
 
-			if mask&unix.IN_Q_OVERFLOW != 0 {
-				if !w.sendError(ErrEventOverflow) {
-					return
-				}
-			}
+// handleRecord converts a raw inotify event and sends it, returning false if
+// the watcher was closed while sending an error.
+func (w *Watcher) handleRecord(raw inotifyRecord, nameBuf *[]byte) bool {
+	var (
+		mask    = raw.mask
+		nameLen = len(raw.name)
+	)
 
-			// If the event happened to the watched directory or the watched file, the kernel
-			// doesn't append the filename to the event, but we would like to always fill the
-			// the "Name" field with a valid filename. We retrieve the path of the watch from
-			// the "paths" map.
-			watch := w.watches.byWd(uint32(raw.Wd))
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		if !w.sendError(ErrEventOverflow) {
+			return false
+		}
+	}
 
-			// inotify will automatically remove the watch on deletes; just need
-			// to clean our state here.
-			if watch != nil && mask&unix.IN_DELETE_SELF == unix.IN_DELETE_SELF {
-				w.watches.remove(watch.wd)
-			}
+	// If the event happened to the watched directory or the watched file, the kernel
+	// doesn't append the filename to the event, but we would like to always fill the
+	// the "Name" field with a valid filename. We retrieve the path of the watch from
+	// the "paths" map.
+	watch := w.watches.byWd(uint32(raw.wd))
 
-			// We can't really update the state when a watched path is moved;
-			// only IN_MOVE_SELF is sent and not IN_MOVED_{FROM,TO}. So remove
-			// the watch.
-			if watch != nil && mask&unix.IN_MOVE_SELF == unix.IN_MOVE_SELF {
-				err := w.remove(watch.path)
-				if err != nil && !errors.Is(err, ErrNonExistentWatch) {
-					if !w.sendError(err) {
-						return
-					}
-				}
-			}
+	// inotify will automatically remove the watch on deletes and after the
+	// first event for IN_ONESHOT; just need to clean our state here.
+	if watch != nil && mask&unix.IN_DELETE_SELF == unix.IN_DELETE_SELF {
+		w.watches.remove(watch.wd)
+	}
+	// The filesystem was unmounted; the kernel removes the watch and sends
+	// IN_IGNORED after this.
+	if watch != nil && mask&unix.IN_UNMOUNT != 0 {
+		w.watches.remove(watch.wd)
+		w.removeLink(watch.path.String())
+	}
+	if watch != nil && mask&unix.IN_IGNORED != 0 && watch.flags&unix.IN_ONESHOT != 0 {
+		w.watches.remove(watch.wd)
+		w.removeLink(watch.path.String())
+	} else if watch != nil && mask&unix.IN_IGNORED != 0 {
+		return w.ignored(watch)
+	}
 
-			var name string
-			if watch != nil {
-				name = watch.path
-			}
-			if nameLen > 0 {
-				// Point "bytes" at the first byte of the filename
-				bytes := (*[unix.PathMax]byte)(unsafe.Pointer(&buf[offset+unix.SizeofInotifyEvent]))[:nameLen:nameLen]
-				// The filename is padded with NULL bytes. TrimRight() gets rid of those.
-				name += "/" + strings.TrimRight(string(bytes[0:nameLen]), "\000")
+	// A directory in a recursive watch that's moved to somewhere else in the
+	// same watch keeps its watches, with the new paths. The events are sent
+	// as usual with the old and new path, except for IN_MOVE_SELF.
+	if watch != nil && nameLen > 0 && mask&unix.IN_ISDIR != 0 && !watch.internal {
+		switch {
+		case mask&unix.IN_MOVED_FROM != 0:
+			w.move = dirMove{cookie: raw.cookie, from: watch.path.join(raw.name), root: watch.root}
+		case mask&unix.IN_MOVED_TO != 0 && w.move.from != "" && w.move.cookie == raw.cookie:
+			to := watch.path.join(raw.name)
+			if w.move.root == watch.root && w.watches.inDepth(to, watch.root) {
+				if moved := w.watches.move(w.move.from, to, watch.root); moved != nil {
+					w.move.moved = moved.wd
+				}
 			}
+			w.move.from = ""
+		}
+	}
+	if watch != nil && mask&unix.IN_MOVE_SELF != 0 && w.move.moved != 0 && w.move.moved == watch.wd {
+		w.move = dirMove{}
+		return true
+	}
 
-			event := w.newEvent(name, mask)
-			if watch != nil && mask&unix.IN_CREATE == unix.IN_CREATE && mask&unix.IN_ISDIR == unix.IN_ISDIR {
-				w.Add(event.Name)
-			}
-			// Send the events that are not ignored on the events channel
-			if mask&unix.IN_IGNORED == 0 {
-				if !w.sendEvent(event) {
-					return
+	// For other moves only IN_MOVE_SELF is sent and not IN_MOVED_{FROM,TO},
+	// so the new path isn't known; remove the watch, and the watches below
+	// it for recursive watches.
+	if watch != nil && mask&unix.IN_MOVE_SELF == unix.IN_MOVE_SELF {
+		paths := []string{watch.path.String()}
+		if !watch.internal {
+			paths = w.watches.below(paths[0], watch.root)
+		}
+		for _, p := range paths {
+			err := w.remove(p)
+			if err != nil && !errors.Is(err, ErrNonExistentWatch) {
+				if !w.sendError(WatchError{Path: p, Op: "remove", Err: err}) {
+					return false
 				}
 			}
+		}
+	}
 
-			// Move to the next event in the buffer
-			offset += unix.SizeofInotifyEvent + nameLen
+	var (
+		name string
+		path *pathNode
+	)
+	if watch != nil {
+		path = watch.path
+	}
+	switch {
+	case w.callback != nil:
+		// Build the name in a reused buffer to avoid allocating; the Event
+		// is only valid during the callback.
+		*nameBuf = path.appendTo((*nameBuf)[:0])
+		if nameLen > 0 {
+			*nameBuf = append(append(*nameBuf, '/'), raw.name...)
+		}
+		name = *(*string)(unsafe.Pointer(nameBuf))
+	case nameLen > 0:
+		name = path.join(raw.name)
+	default:
+		name = path.String()
+	}
+
+	event := w.newEvent(name, mask)
+	if watch != nil && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		changed, err := w.retarget(name)
+		if err != nil && !w.sendError(WatchError{Path: name, Op: "retarget", Err: err, Alive: true}) {
+			return false
+		}
+		if changed && !w.sendEvent(Event{Name: name, Op: Retargeted}) {
+			return false
+		}
+	}
+	if watch == nil && nameLen == 0 {
+		// Event for a watch that was already removed (e.g. the old
+		// link after a retarget); there's no name to report.
+		return true
+	}
+	if watch != nil && watch.internal {
+		return true
+	}
+	var newDir string
+	if watch != nil && mask&unix.IN_CREATE == unix.IN_CREATE && mask&unix.IN_ISDIR == unix.IN_ISDIR &&
+		w.watches.inDepth(event.Name, watch.root) && !w.pendings.owns(watch.path.String()) {
+		newDir = cloneString(event.Name)
+		if w.add(newDir, watch.root, watch.flags&opFlags(supportedOps)) != nil {
+			newDir = "" // Already removed.
+		}
+	}
+	if w.with.rootRelative && watch != nil {
+		event = event.rootRelative(watch.root)
+	}
+	// Send the events that are not ignored on the events channel. Keep
+	// going through the buffer if we're closed, so that Remove and
+	// Rename events are still sent before the channel is closed.
+	if mask&unix.IN_IGNORED == 0 {
+		w.sendEvent(event)
+	}
+	if newDir != "" {
+		w.scanNewDir(newDir, watch)
+	}
+	return true
+}
+
+// ignored handles IN_IGNORED for a watch that's still in use: the kernel
+// removed it without IN_DELETE_SELF or IN_UNMOUNT, or after we missed them. The
+// watch is added again if the path still exists, or else a WatchRemoved event
+// is sent, preceded by a WatchError with the reason if it's not that the path
+// no longer exists.
+//
+// Returns false if the Watcher was closed.
+func (w *Watcher) ignored(watch *watch) bool {
+	name := watch.path.String()
+	w.watches.remove(watch.wd)
+	if watch.internal {
+		return true
+	}
+	err := w.add(name, watch.root, watch.flags&^unix.IN_MASK_ADD)
+	if err == nil {
+		return true
+	}
+	w.removeLink(name)
+	if !errors.Is(err, unix.ENOENT) && !w.sendError(WatchError{Path: name, Op: "add", Err: err}) {
+		return false
+	}
+	e := Event{Name: name, Op: WatchRemoved}
+	if w.with.rootRelative {
+		e = e.rootRelative(watch.root)
+	}
+	return w.sendEvent(e)
+}
+
+// scanNewDir sends Create events for the contents of a directory that was
+// added to a recursive watch, as files can be created in it before the watch
+// is added. New subdirectories are added and scanned too.
+//
+// Files created after the watch was added but before the directory was read
+// will be sent twice.
+func (w *Watcher) scanNewDir(dir string, watch *watch) {
+	ls, err := os.ReadDir(dir)
+	if err != nil {
+		return // Already removed.
+	}
+	for _, f := range ls {
+		var (
+			path = filepath.Join(dir, f.Name())
+			e    = Event{Name: path, Op: IN_CREATE}
+			sub  = f.IsDir() && w.watches.inDepth(path, watch.root)
+		)
+		if f.IsDir() {
+			e.Op |= IN_ISDIR
+		}
+		if sub && w.add(path, watch.root, watch.flags&opFlags(supportedOps)) != nil {
+			sub = false
+		}
+		if w.with.rootRelative {
+			e = e.rootRelative(watch.root)
+		}
+		w.sendEvent(e)
+		if sub {
+			w.scanNewDir(path, watch)
 		}
 	}
 }
@@ -645,7 +1545,16 @@ func (w *Watcher) newEvent(name string, mask uint32) Event {
 		e.Op |= IN_ONLYDIR
 	}
 	if mask&unix.IN_OPEN == unix.IN_OPEN {
-		e.Op |= IN_OPEN
+		e.Op |= IN_OPEN | Open
+	}
+	if mask&unix.IN_UNMOUNT == unix.IN_UNMOUNT {
+		e.Op |= IN_UNMOUNT | Unmount
+	}
+	if mask&unix.IN_ACCESS == unix.IN_ACCESS {
+		e.Op |= Read
+	}
+	if mask&(unix.IN_CLOSE_WRITE|unix.IN_CLOSE_NOWRITE) != 0 {
+		e.Op |= Close
 	}
 
 	// if mask&unix.IN_DELETE_SELF == unix.IN_DELETE_SELF {
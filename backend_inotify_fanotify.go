@@ -0,0 +1,333 @@
+//go:build linux && !appengine
+// +build linux,!appengine
+
+package fsnotify
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/hohodqr/fsnotify/internal"
+	"golang.org/x/sys/unix"
+)
+
+// Events for AddMount; these have the same values as the IN_* flags, so the
+// mask can be used as the Op.
+const fanMask = unix.FAN_CREATE | unix.FAN_DELETE | unix.FAN_MOVED_FROM | unix.FAN_MOVED_TO |
+	unix.FAN_MODIFY | unix.FAN_ATTRIB | unix.FAN_ONDIR
+
+const sizeofFanotifyEvent = uint32(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+
+// fanMounts are the mounts added with AddMount, which are watched with a
+// fanotify mark on the filesystem rather than an inotify watch for every
+// directory.
+//
+// The kernel reports events with FAN_REPORT_DFID_NAME: the file handle of the
+// directory and the name in it, rather than a path. The path of the directory
+// is looked up by opening the handle with open_by_handle_at() relative to the
+// mount, and reading the /proc/self/fd link.
+type fanMounts struct {
+	mu     sync.Mutex
+	fd     int      // Stored separately, as calling file.Fd() makes it blocking.
+	file   *os.File // fanotify instance; nil until the first AddMount.
+	mounts map[string]*fanMount
+	done   chan struct{} // Closed when read() returns.
+}
+
+type fanMount struct {
+	point string   // Mount point.
+	fd    int      // Mount point, for open_by_handle_at().
+	fsid  [2]int32 // Filesystem ID, as reported in events.
+}
+
+func newFanMounts() *fanMounts {
+	return &fanMounts{mounts: make(map[string]*fanMount), done: make(chan struct{})}
+}
+
+// AddMount starts monitoring all files and directories on the mount that path
+// is on, with one fanotify mark instead of an inotify watch for every
+// directory. Changes anywhere on the mount are sent, including in directories
+// that are created later, without the limits of fs.inotify.max_user_watches.
+//
+// The mount point is used for WatchList and Remove; for example
+// AddMount("/data/dir") watches "/data" if that's where the filesystem is
+// mounted. Changes made through other mounts of the same filesystem (such as
+// bind mounts) are also sent, if the path is visible on this mount.
+//
+// The Op is IN_CREATE, IN_DELETE, IN_MOVED_FROM, IN_MOVED_TO, IN_MODIFY, or
+// IN_ATTRIB, with IN_ISDIR for directories. Unlike inotify, the kernel merges
+// events for the same name that haven't been read yet, so one event can have
+// several of these; e.g. IN_CREATE|IN_MODIFY|IN_MOVED_FROM for a file that was
+// written and renamed right after it was created. Events for files in
+// directories that were removed before the event was read are dropped, as their
+// path can no longer be found.
+//
+// This needs Linux 5.9 or newer and CAP_SYS_ADMIN, and a filesystem that
+// supports file handles (most local filesystems, but not /proc or FUSE). Other
+// platforms return [ErrUnsupported].
+func (w *Watcher) AddMount(path string) error {
+	if w.isClosed() {
+		return ErrClosed
+	}
+	return w.fan.add(w, path)
+}
+
+// mountPoints returns the mounts added with AddMount.
+func (w *Watcher) mountPoints() []string { return w.fan.points() }
+
+func (m *fanMounts) add(w *Watcher, path string) error {
+	point, err := mountPoint(path)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mounts[point]; ok {
+		return nil
+	}
+	if m.file == nil {
+		fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK|unix.FAN_REPORT_DFID_NAME,
+			unix.O_RDONLY|unix.O_CLOEXEC)
+		if err != nil {
+			return os.NewSyscallError("fanotify_init", err)
+		}
+		internal.Opened("fanotify", fd)
+		m.fd, m.file = fd, os.NewFile(uintptr(fd), "fanotify")
+		go m.read(w)
+	}
+
+	fd, err := unix.Open(point, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: point, Err: err}
+	}
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(fd, &st); err != nil {
+		unix.Close(fd)
+		return &os.PathError{Op: "statfs", Path: point, Err: err}
+	}
+	err = unix.FanotifyMark(m.fd, unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, fanMask, unix.AT_FDCWD, point)
+	if err != nil {
+		unix.Close(fd)
+		return &os.PathError{Op: "fanotify_mark", Path: point, Err: err}
+	}
+	m.mounts[point] = &fanMount{point: point, fd: fd, fsid: st.Fsid.Val}
+	return nil
+}
+
+// remove the mount, returning false if it wasn't added. The filesystem mark is
+// only removed if no other mount of the filesystem was added.
+func (m *fanMounts) remove(point string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mnt, ok := m.mounts[point]
+	if !ok {
+		return false, nil
+	}
+	delete(m.mounts, point)
+	unix.Close(mnt.fd)
+	for _, other := range m.mounts {
+		if other.fsid == mnt.fsid {
+			return true, nil
+		}
+	}
+	err := unix.FanotifyMark(m.fd, unix.FAN_MARK_REMOVE|unix.FAN_MARK_FILESYSTEM, fanMask, unix.AT_FDCWD, point)
+	if err != nil && err != unix.ENOENT {
+		return true, &os.PathError{Op: "fanotify_mark", Path: point, Err: err}
+	}
+	return true, nil
+}
+
+func (m *fanMounts) points() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := make([]string, 0, len(m.mounts))
+	for p := range m.mounts {
+		l = append(l, p)
+	}
+	return l
+}
+
+// stop closes the fanotify instance and waits for read() to return; the
+// watcher must be closed.
+func (m *fanMounts) stop() {
+	m.mu.Lock()
+	file := m.file
+	for p, mnt := range m.mounts {
+		unix.Close(mnt.fd)
+		delete(m.mounts, p)
+	}
+	m.mu.Unlock()
+	if file == nil {
+		return
+	}
+	file.Close()
+	internal.Closed("fanotify", m.fd)
+	<-m.done
+}
+
+func (m *fanMounts) read(w *Watcher) {
+	defer close(m.done)
+
+	buf := make([]byte, w.with.bufsize) // Buffer for the raw events (see WithBufferSize)
+	for {
+		n, err := m.file.Read(buf)
+		if errors.Is(err, os.ErrClosed) || w.isClosed() {
+			return
+		}
+		if err != nil {
+			if !w.sendError(err) {
+				return
+			}
+			continue
+		}
+		if !m.handle(w, buf[:n]) {
+			return
+		}
+	}
+}
+
+// handle sends the events in buf; it returns false if the watcher was closed.
+func (m *fanMounts) handle(w *Watcher, buf []byte) bool {
+	for uint32(len(buf)) >= sizeofFanotifyEvent {
+		meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[0]))
+		if meta.Event_len < sizeofFanotifyEvent || int(meta.Event_len) > len(buf) ||
+			meta.Metadata_len > uint16(meta.Event_len) {
+			return w.sendError(errors.New("fsnotify: invalid fanotify event"))
+		}
+		var (
+			mask = uint32(meta.Mask)
+			info = buf[meta.Metadata_len:meta.Event_len]
+		)
+		buf = buf[meta.Event_len:]
+		if meta.Fd >= 0 {
+			unix.Close(int(meta.Fd)) // Never set with FAN_REPORT_DFID_NAME.
+		}
+
+		if mask&unix.FAN_Q_OVERFLOW != 0 {
+			if !w.sendError(ErrEventOverflow) {
+				return false
+			}
+			continue
+		}
+		name, point, ok := m.path(info)
+		if !ok {
+			continue
+		}
+		e := w.newEvent(name, mask&fanMask)
+		if w.with.rootRelative {
+			e = e.rootRelative(point)
+		}
+		if !w.sendEvent(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// path finds the path for the directory file handle and name in the
+// information records of an event, and the mount point it's on.
+func (m *fanMounts) path(info []byte) (string, string, bool) {
+	for len(info) >= 4 {
+		var (
+			typ = info[0]
+			l   = int(*(*uint16)(unsafe.Pointer(&info[2])))
+		)
+		if l < 4 || l > len(info) {
+			return "", "", false
+		}
+		rec := info[:l]
+		info = info[l:]
+		if (typ != unix.FAN_EVENT_INFO_TYPE_DFID_NAME && typ != unix.FAN_EVENT_INFO_TYPE_DFID) || len(rec) < 20 {
+			continue
+		}
+
+		var (
+			fsid   = *(*[2]int32)(unsafe.Pointer(&rec[4]))
+			hBytes = int(*(*uint32)(unsafe.Pointer(&rec[12])))
+			hType  = *(*int32)(unsafe.Pointer(&rec[16]))
+		)
+		if 20+hBytes > len(rec) {
+			return "", "", false
+		}
+		var name string
+		if typ == unix.FAN_EVENT_INFO_TYPE_DFID_NAME {
+			name = cstring(rec[20+hBytes:])
+		}
+		dir, point, ok := m.resolve(fsid, unix.NewFileHandle(hType, rec[20:20+hBytes]))
+		if !ok {
+			return "", "", false
+		}
+		if name == "" || name == "." { // Event for the directory itself.
+			return dir, point, true
+		}
+		return filepath.Join(dir, name), point, true
+	}
+	return "", "", false
+}
+
+// resolve the path of a directory file handle.
+func (m *fanMounts) resolve(fsid [2]int32, h unix.FileHandle) (string, string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mnt := range m.mounts {
+		if mnt.fsid != fsid {
+			continue
+		}
+		fd, err := unix.OpenByHandleAt(mnt.fd, h, unix.O_PATH|unix.O_CLOEXEC)
+		if err != nil {
+			continue // ESTALE if it was removed.
+		}
+		dir, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+		unix.Close(fd)
+		if err != nil || strings.HasSuffix(dir, " (deleted)") || !inMount(dir, mnt.point) {
+			continue
+		}
+		return dir, mnt.point, true
+	}
+	return "", "", false
+}
+
+// mountPoint returns where the filesystem that path is on is mounted, by going
+// up the directory tree until the mount ID changes.
+func mountPoint(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	_, id, err := unix.NameToHandleAt(unix.AT_FDCWD, path, 0)
+	if err != nil {
+		return "", &os.PathError{Op: "name_to_handle_at", Path: path, Err: err}
+	}
+	for path != "/" {
+		parent := filepath.Dir(path)
+		_, pid, err := unix.NameToHandleAt(unix.AT_FDCWD, parent, 0)
+		if err != nil || pid != id {
+			break
+		}
+		path = parent
+	}
+	return path, nil
+}
+
+func inMount(path, point string) bool {
+	return point == "/" || path == point || strings.HasPrefix(path, point+"/")
+}
+
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,222 @@
+//go:build linux && !appengine
+// +build linux,!appengine
+
+package fsnotify
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/hohodqr/fsnotify/internal"
+	"golang.org/x/sys/unix"
+)
+
+// sharedInotify is an inotify instance that's shared by all Watchers created
+// with WithSharedInstance. One goroutine reads from it, and routes the events to
+// the Watchers that added the watch descriptor.
+type sharedInotify struct {
+	fd       int
+	file     *os.File
+	watchers map[*Watcher]chan inotifyRecord // Watcher → channel its readEvents() reads from.
+	wds      map[uint32]map[*Watcher]uint32  // wd → Watchers that added it, with their flags.
+	done     chan struct{}                   // Closed when readEvents() returns.
+}
+
+// sharedInstance is the current shared instance; it's set to nil once the last
+// Watcher left, and a new one is created for the next Watcher.
+var sharedInstance struct {
+	// Protects sharedInstance.in and the state of all sharedInotify
+	// instances.
+	mu sync.Mutex
+	in *sharedInotify
+}
+
+// joinShared adds the Watcher to the shared instance, creating it if needed.
+func joinShared(w *Watcher) (*sharedInotify, chan inotifyRecord, error) {
+	sharedInstance.mu.Lock()
+	defer sharedInstance.mu.Unlock()
+
+	s := sharedInstance.in
+	if s == nil {
+		fd, errno := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+		if fd == -1 {
+			return nil, nil, errno
+		}
+		internal.Opened("inotify", fd)
+		s = &sharedInotify{
+			fd:       fd,
+			file:     os.NewFile(uintptr(fd), ""),
+			watchers: make(map[*Watcher]chan inotifyRecord),
+			wds:      make(map[uint32]map[*Watcher]uint32),
+			done:     make(chan struct{}),
+		}
+		sharedInstance.in = s
+		go s.readEvents()
+	}
+
+	records := make(chan inotifyRecord, 64)
+	s.watchers[w] = records
+	return s, records, nil
+}
+
+// leave removes the Watcher and all watches that no other Watcher uses, and
+// closes the instance if this was the last Watcher.
+func (s *sharedInotify) leave(w *Watcher) error {
+	sharedInstance.mu.Lock()
+	delete(s.watchers, w)
+	for wd, ws := range s.wds {
+		if _, ok := ws[w]; !ok {
+			continue
+		}
+		delete(ws, w)
+		if len(ws) == 0 {
+			delete(s.wds, wd)
+			_, _ = unix.InotifyRmWatch(s.fd, wd)
+		}
+	}
+	last := len(s.watchers) == 0
+	if last && sharedInstance.in == s {
+		sharedInstance.in = nil
+	}
+	sharedInstance.mu.Unlock()
+
+	if !last {
+		return nil
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	internal.Closed("inotify", s.fd)
+	<-s.done
+	return nil
+}
+
+// addWatch adds a watch for the Watcher.
+func (s *sharedInotify) addWatch(w *Watcher, name string, flags uint32) (int, error) {
+	sharedInstance.mu.Lock()
+	defer sharedInstance.mu.Unlock()
+	if _, ok := s.watchers[w]; !ok {
+		return -1, ErrClosed
+	}
+
+	// Always use IN_MASK_ADD, so that the flags of other Watchers watching
+	// the same inode aren't replaced. IN_EXCL_UNLINK would apply to the other
+	// Watchers too; WithExcludeUnlinked is filtered in userspace instead.
+	flags &^= unix.IN_EXCL_UNLINK
+	wd, err := unix.InotifyAddWatch(s.fd, name, flags|unix.IN_MASK_ADD)
+	if wd == -1 {
+		return wd, err
+	}
+	ws := s.wds[uint32(wd)]
+	if ws == nil {
+		ws = make(map[*Watcher]uint32)
+		s.wds[uint32(wd)] = ws
+	}
+	ws[w] |= flags
+	return wd, nil
+}
+
+// release the watch for the Watcher, returning true if no other Watchers use it
+// and it should be removed with inotify_rm_watch.
+func (s *sharedInotify) release(w *Watcher, wd uint32) bool {
+	sharedInstance.mu.Lock()
+	defer sharedInstance.mu.Unlock()
+	ws, ok := s.wds[wd]
+	if !ok {
+		return true
+	}
+	delete(ws, w)
+	if len(ws) > 0 {
+		return false
+	}
+	delete(s.wds, wd)
+	return true
+}
+
+func (s *sharedInotify) readEvents() {
+	defer close(s.done)
+
+	var buf [unix.SizeofInotifyEvent * 4096]byte // Buffer for a maximum of 4096 raw events
+	for {
+		n, err := s.file.Read(buf[:])
+		switch {
+		case errors.Unwrap(err) == os.ErrClosed:
+			return
+		case err != nil:
+			s.sendError(err)
+			continue
+		case n == 0:
+			s.sendError(io.EOF) // This should really never happen.
+			continue
+		case n < unix.SizeofInotifyEvent:
+			s.sendError(errors.New("notify: short read in readEvents()"))
+			continue
+		}
+
+		for offset := 0; offset < n; {
+			raw, size, err := readInotifyRecord(buf[offset:n])
+			if err != nil {
+				s.sendError(err)
+				break
+			}
+			offset += size
+			if len(raw.name) > 0 {
+				raw.name = append([]byte(nil), raw.name...)
+			}
+			s.dispatch(raw)
+		}
+	}
+}
+
+type sharedTarget struct {
+	w       *Watcher
+	records chan inotifyRecord
+}
+
+// dispatch sends the record to all Watchers that use the watch descriptor, or
+// to all Watchers for IN_Q_OVERFLOW.
+func (s *sharedInotify) dispatch(raw inotifyRecord) {
+	var to []sharedTarget
+	sharedInstance.mu.Lock()
+	if raw.mask&unix.IN_Q_OVERFLOW != 0 {
+		for w, records := range s.watchers {
+			to = append(to, sharedTarget{w, records})
+		}
+	} else {
+		wd := uint32(raw.wd)
+		for w, flags := range s.wds[wd] {
+			// Another Watcher may have added the watch with more flags.
+			if raw.mask&(flags|unix.IN_IGNORED|unix.IN_UNMOUNT) != 0 {
+				to = append(to, sharedTarget{w, s.watchers[w]})
+			}
+		}
+		if raw.mask&unix.IN_IGNORED != 0 {
+			delete(s.wds, wd)
+		}
+	}
+	sharedInstance.mu.Unlock()
+
+	// Send without holding the lock, as the Watchers may add watches while
+	// handling events.
+	for _, t := range to {
+		select {
+		case t.records <- raw:
+		case <-t.w.done:
+		}
+	}
+}
+
+// sendError sends the error to all Watchers.
+func (s *sharedInotify) sendError(err error) {
+	sharedInstance.mu.Lock()
+	ws := make([]*Watcher, 0, len(s.watchers))
+	for w := range s.watchers {
+		ws = append(ws, w)
+	}
+	sharedInstance.mu.Unlock()
+	for _, w := range ws {
+		w.sendError(err)
+	}
+}
@@ -5,12 +5,16 @@ package fsnotify
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 // Ensure that the correct error is returned on overflows.
@@ -134,3 +138,773 @@ func TestRemoveState(t *testing.T) {
 	}
 	check(0)
 }
+
+func TestInotifyRetarget(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "a", noWait)
+	mkdir(t, tmp, "b", noWait)
+	symlink(t, join(tmp, "a"), tmp, "cur", noWait)
+
+	w := newWatcher(t)
+	defer w.Close()
+	cur := join(tmp, "cur")
+	if err := w.AddWith(cur, WithRetarget()); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != cur {
+		t.Fatalf("wrong WatchList: %v", l)
+	}
+
+	// Switch the symlink atomically, like most deploy tools do.
+	symlink(t, join(tmp, "b"), tmp, "new", noWait)
+	mv(t, join(tmp, "new"), tmp, "cur")
+
+	wait := func(want Event) {
+		t.Helper()
+		for {
+			select {
+			case e := <-w.Events:
+				if e == want {
+					return
+				}
+			case err := <-w.Errors:
+				t.Fatal(err)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timeout waiting for %s", want)
+			}
+		}
+	}
+	wait(Event{Name: cur, Op: Retargeted})
+
+	touch(t, tmp, "b", "file", noWait)
+	wait(Event{Name: join(cur, "file"), Op: IN_CREATE})
+
+	// And back again.
+	symlink(t, join(tmp, "a"), tmp, "new", noWait)
+	mv(t, join(tmp, "new"), tmp, "cur")
+	wait(Event{Name: cur, Op: Retargeted})
+	touch(t, tmp, "a", "file2", noWait)
+	wait(Event{Name: join(cur, "file2"), Op: IN_CREATE})
+
+	// Events for the old target should no longer be sent, and events for the
+	// parent directory are never sent.
+	touch(t, tmp, "b", "file3", noWait)
+	touch(t, tmp, "other", noWait)
+	select {
+	case e := <-w.Events:
+		t.Errorf("unexpected event: %s", e)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := w.Remove(cur); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 0 {
+		t.Errorf("WatchList not empty: %v", l)
+	}
+}
+
+func TestInotifyNoFollow(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "a", noWait)
+	link := join(tmp, "link")
+	symlink(t, join(tmp, "a"), link, noWait)
+
+	w := newWatcher(t)
+	defer w.Close()
+	if err := w.AddWith(link, WithFollowSymlinks(false)); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func(want Op) {
+		t.Helper()
+		for {
+			select {
+			case e := <-w.Events:
+				if e.Name != link {
+					t.Fatalf("wrong event: %s", e)
+				}
+				if e.Op&want != 0 {
+					return
+				}
+			case err := <-w.Errors:
+				t.Fatal(err)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timeout waiting for %s", want)
+			}
+		}
+	}
+
+	touch(t, tmp, "a", "file", noWait) // Not sent, as the target isn't watched.
+	symlink(t, join(tmp, "b"), tmp, "new", noWait)
+	mv(t, join(tmp, "new"), link)
+	wait(Retargeted)
+
+	rm(t, link, noWait)
+	wait(opRemove)
+}
+
+// The parent directory of a symlink is watched internally, but can still be
+// added and removed by the user.
+func TestInotifyLinkParent(t *testing.T) {
+	t.Parallel()
+
+	for _, opt := range []addOpt{WithRetarget(), WithFollowSymlinks(false)} {
+		opt := opt
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			tmp := t.TempDir()
+			mkdir(t, tmp, "a", noWait)
+			mkdir(t, tmp, "b", noWait)
+			link := join(tmp, "link")
+			symlink(t, join(tmp, "a"), link, noWait)
+
+			w := newWatcher(t)
+			defer w.Close()
+			if err := w.AddWith(link, opt); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Remove(tmp); !errors.Is(err, ErrNonExistentWatch) {
+				t.Fatalf("wrong error removing internal watch: %v", err)
+			}
+
+			wait := func(want Event) {
+				t.Helper()
+				for {
+					select {
+					case e := <-w.Events:
+						if e == want {
+							return
+						}
+					case err := <-w.Errors:
+						t.Fatal(err)
+					case <-time.After(2 * time.Second):
+						t.Fatalf("timeout waiting for %s", want)
+					}
+				}
+			}
+
+			addWatch(t, w, tmp)
+			if l := w.WatchList(); len(l) != 2 {
+				t.Fatalf("wrong WatchList: %q", l)
+			}
+			touch(t, tmp, "file", noWait)
+			wait(Event{Name: join(tmp, "file"), Op: IN_CREATE})
+
+			// The link is still tracked after the parent is removed.
+			if err := w.Remove(tmp); err != nil {
+				t.Fatal(err)
+			}
+			if l := w.WatchList(); len(l) != 1 || l[0] != link {
+				t.Fatalf("wrong WatchList: %q", l)
+			}
+			symlink(t, join(tmp, "b"), tmp, "new", noWait)
+			mv(t, join(tmp, "new"), link)
+			wait(Event{Name: link, Op: Retargeted})
+		})
+	}
+}
+
+func TestInotifyMaxDepth(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdirAll(t, tmp, "a", "b")
+
+	w := newWatcher(t)
+	defer w.Close()
+	if err := w.AddWith(join(tmp, "..."), WithMaxDepth(1)); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 2 {
+		t.Fatalf("wrong WatchList: %v", l)
+	}
+
+	// new is one level deep and should be watched; new/deep isn't.
+	mkdir(t, tmp, "new", noWait)
+	mkdir(t, tmp, "new", "deep", noWait)
+	touch(t, tmp, "a", "b", "file", noWait)
+	time.Sleep(100 * time.Millisecond)
+	if l := w.WatchList(); len(l) != 3 {
+		t.Fatalf("wrong WatchList: %v", l)
+	}
+
+	if err := w.Remove(join(tmp, "...")); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 0 {
+		t.Errorf("WatchList not empty: %v", l)
+	}
+}
+
+func TestInotifyAddProgress(t *testing.T) {
+	t.Parallel()
+
+	// More than addBatchSize directories.
+	tmp := t.TempDir()
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 20; j++ {
+			mkdirAll(t, tmp, fmt.Sprintf("d%d", i), fmt.Sprintf("e%d", j), noWait)
+		}
+	}
+
+	w := newWatcher(t)
+	defer w.Close()
+	var calls [][2]int
+	err := w.AddWith(join(tmp, "..."), WithProgress(func(dirs, watched int) {
+		calls = append(calls, [2]int{dirs, watched})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 421 {
+		t.Fatalf("wrong WatchList: %d", len(l))
+	}
+	if len(calls) < 2 || calls[len(calls)-1] != [2]int{421, 421} {
+		t.Errorf("wrong progress: %v", calls)
+	}
+}
+
+func TestInotifySharedInstance(t *testing.T) {
+	tmp := t.TempDir()
+	mkdir(t, tmp, "a")
+	mkdir(t, tmp, "b")
+
+	newShared := func() *eventCollector {
+		w, err := NewWatcherWith(WithSharedInstance())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &eventCollector{w: w, done: make(chan struct{})}
+		c.collect(t)
+		return c
+	}
+	names := func(events Events) string {
+		seen := make(map[string]bool)
+		var l []string
+		for _, e := range events {
+			if n := strings.TrimPrefix(e.Name, tmp); !seen[n] {
+				seen[n] = true
+				l = append(l, n)
+			}
+		}
+		return strings.Join(l, " ")
+	}
+
+	w1, w2 := newShared(), newShared()
+	if w1.w.fd != w2.w.fd {
+		t.Fatalf("not using the same inotify instance: %d and %d", w1.w.fd, w2.w.fd)
+	}
+	addWatch(t, w1.w, tmp, "a")
+	addWatch(t, w2.w, tmp, "b")
+	addWatch(t, w1.w, tmp)
+	addWatch(t, w2.w, tmp)
+
+	touch(t, tmp, "a", "file")
+	touch(t, tmp, "b", "file")
+
+	// Removing the watch from one Watcher shouldn't affect the other.
+	if err := w1.w.Remove(tmp); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, tmp, "file")
+
+	if have := names(w1.stop(t)); have != "/a/file" {
+		t.Errorf("wrong events for first watcher: %s", have)
+	}
+	touch(t, tmp, "file2")
+	if have := names(w2.stop(t)); have != "/b/file /file /file2" {
+		t.Errorf("wrong events for second watcher: %s", have)
+	}
+
+	sharedInstance.mu.Lock()
+	defer sharedInstance.mu.Unlock()
+	if sharedInstance.in != nil {
+		t.Error("shared instance not closed after closing all watchers")
+	}
+}
+
+// BenchmarkInotifyEvents measures converting and sending an event that was
+// read from the kernel, without the syscalls.
+func BenchmarkInotifyEvents(b *testing.B) {
+	for _, tt := range []struct {
+		name string
+		mask uint32
+		opts []addOpt
+	}{
+		{"modify", unix.IN_MODIFY, nil},
+		{"create", unix.IN_CREATE, nil},
+		{"modify callback", unix.IN_MODIFY, []addOpt{WithCallback(func(*Event) {})}},
+		{"modify root-relative", unix.IN_MODIFY, []addOpt{WithRootRelativeNames()}},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			tmp := b.TempDir()
+			w, err := NewWatcherWith(tt.opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer w.Close()
+			if err := w.Add(tmp); err != nil {
+				b.Fatal(err)
+			}
+			go func() {
+				for range w.Events {
+				}
+			}()
+
+			var (
+				buf     = inotifyRecordBuf(int32(w.watches.byPath(tmp).wd), tt.mask, "file.txt")
+				nameBuf []byte
+				start   = time.Now()
+			)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				raw, _, err := readInotifyRecord(buf)
+				if err != nil {
+					b.Fatal(err)
+				}
+				w.handleRecord(raw, &nameBuf)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "events/s")
+		})
+	}
+}
+
+// inotifyRecordBuf creates a raw inotify_event, with the name padded like the
+// kernel does.
+func inotifyRecordBuf(wd int32, mask uint32, name string) []byte {
+	n := (len(name) + 1 + 15) &^ 15
+	b := make([]byte, unix.SizeofInotifyEvent+n)
+	nativeEndian.PutUint32(b[0:], uint32(wd))
+	nativeEndian.PutUint32(b[4:], mask)
+	nativeEndian.PutUint32(b[12:], uint32(n))
+	copy(b[unix.SizeofInotifyEvent:], name)
+	return b
+}
+
+func TestInotifyExcludeUnlinked(t *testing.T) {
+	t.Parallel()
+
+	for _, shared := range []bool{false, true} {
+		shared := shared
+		t.Run(strconv.FormatBool(shared), func(t *testing.T) {
+			t.Parallel()
+
+			tmp := t.TempDir()
+			file := join(tmp, "file")
+			touch(t, file)
+			fp, err := os.OpenFile(file, os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close()
+
+			opts := []addOpt{WithExcludeUnlinked()}
+			if shared {
+				opts = append(opts, WithSharedInstance())
+			}
+			w, err := NewWatcherWith(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			addWatch(t, w, tmp)
+
+			rm(t, file)
+			if _, err := fp.WriteString("data"); err != nil {
+				t.Fatal(err)
+			}
+			fp.Close()
+			touch(t, join(tmp, "done"))
+
+			var (
+				have    []string
+				removed bool
+				timeout = time.After(2 * time.Second)
+			)
+			for {
+				select {
+				case e := <-w.Events:
+					have = append(have, e.String())
+					if e.Name == file && e.Op&opWrite != 0 {
+						t.Errorf("event for unlinked file: %s", e)
+					}
+					if e.Name == file && e.Op&opRemove != 0 {
+						removed = true
+					}
+					if e.Name == join(tmp, "done") {
+						if !removed {
+							t.Errorf("no Remove: %q", have)
+						}
+						return
+					}
+				case err := <-w.Errors:
+					t.Fatal(err)
+				case <-timeout:
+					t.Fatalf("timeout: %q", have)
+				}
+			}
+		})
+	}
+}
+
+func TestInotifyXattr(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	file := join(tmp, "file")
+	touch(t, file)
+	if err := unix.Setxattr(file, "user.fsnotify", []byte("1"), 0); err != nil {
+		t.Skipf("xattrs not supported: %s", err)
+	}
+
+	w := newWatcher(t)
+	defer w.Close()
+	if err := w.AddWith(tmp, WithOps(Xattr)); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() Event {
+		t.Helper()
+		select {
+		case e := <-w.Events:
+			return e
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout")
+		}
+		return Event{}
+	}
+
+	if err := unix.Setxattr(file, "user.fsnotify", []byte("2"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); !e.Has(Xattr) || e.Op&opChmod != 0 {
+		t.Errorf("setxattr: %s", e)
+	}
+
+	chmod(t, 0o600, file)
+	if e := next(); e.Has(Xattr) || e.Op&opChmod == 0 {
+		t.Errorf("chmod: %s", e)
+	}
+}
+
+// Files created in a new directory before the watch for it is added should
+// still be reported.
+func TestInotifyRecursiveNewDir(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t)
+	defer w.Close()
+	addWatch(t, w, tmp, "...")
+
+	deep := join(tmp, "a", "b", "c", "d", "e")
+	if err := os.MkdirAll(deep, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(join(deep, "file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	have := make(map[string]bool)
+	for _, e := range eventsFor(t, w, 500*time.Millisecond) {
+		if e.Has(IN_CREATE) {
+			have[e.Name] = true
+		}
+	}
+	for _, p := range []string{"a", "a/b", "a/b/c", "a/b/c/d", "a/b/c/d/e", "a/b/c/d/e/file"} {
+		if !have[join(tmp, p)] {
+			t.Errorf("no create for %q; have: %v", p, have)
+		}
+	}
+
+	// Subdirectories are watched.
+	touch(t, deep, "new")
+	if e := eventsFor(t, w, 200*time.Millisecond); len(e) == 0 || e[0].Name != join(deep, "new") {
+		t.Errorf("wrong events: %v", e)
+	}
+}
+
+func TestInotifyRecursiveMove(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	out := t.TempDir()
+	mkdirAll(t, tmp, "a", "b", "c", noWait)
+	mkdir(t, tmp, "dst", noWait)
+
+	w := newWatcher(t)
+	defer w.Close()
+	addWatch(t, w, tmp, "...")
+
+	// Moved in the watch: the watches are kept with the new paths.
+	mv(t, join(tmp, "a"), tmp, "dst", "z")
+	eventsFor(t, w, 100*time.Millisecond)
+	touch(t, tmp, "dst", "z", "b", "c", "file")
+	if e := eventsFor(t, w, 200*time.Millisecond); len(e) == 0 || e[0].Name != join(tmp, "dst", "z", "b", "c", "file") {
+		t.Errorf("wrong events: %v", e)
+	}
+	want := []string{tmp, join(tmp, "dst"), join(tmp, "dst", "z"), join(tmp, "dst", "z", "b"), join(tmp, "dst", "z", "b", "c")}
+	have := w.WatchList()
+	sort.Strings(have)
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+
+	// Moved out of the watch: the watches are removed.
+	mv(t, join(tmp, "dst", "z"), out, "z")
+	eventsFor(t, w, 100*time.Millisecond)
+	want = want[:2]
+	have = w.WatchList()
+	sort.Strings(have)
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestInotifyBufferSize(t *testing.T) {
+	t.Parallel()
+
+	if _, err := NewWatcherWith(WithBufferSize(100)); err == nil {
+		t.Fatal("no error for a buffer smaller than 4096 bytes")
+	}
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithBufferSize(4096), WithEventChannelSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp)
+
+	// More events than fit in the buffer at once.
+	for i := 0; i < 200; i++ {
+		if err := os.WriteFile(join(tmp, "file-with-a-long-name-"+strconv.Itoa(i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := 0
+	for _, e := range eventsFor(t, w, 500*time.Millisecond) {
+		if e.Has(IN_CREATE) {
+			n++
+		}
+	}
+	if n != 200 {
+		t.Errorf("%d Create events; want 200", n)
+	}
+}
+
+func TestInotifyIOUring(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithIOUring(), WithEventChannelSize(1000))
+	if err != nil {
+		t.Skipf("io_uring not available: %s", err)
+	}
+	addWatch(t, w, tmp)
+
+	for i := 0; i < 200; i++ {
+		if err := os.WriteFile(join(tmp, "file-"+strconv.Itoa(i)), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := 0
+	for _, e := range eventsFor(t, w, 500*time.Millisecond) {
+		if e.Has(IN_CREATE) {
+			n++
+		}
+	}
+	if n != 200 {
+		t.Errorf("%d Create events; want 200", n)
+	}
+
+	// Close doesn't block on the read in flight.
+	done := make(chan error)
+	go func() { done <- w.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close() timed out")
+	}
+
+	if _, err := NewWatcherWith(WithIOUring(), WithSharedInstance()); err == nil {
+		t.Error("no error with WithSharedInstance")
+	}
+}
+
+func TestInotifyAddMount(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithEventChannelSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.AddMount(tmp); err != nil {
+		t.Skipf("fanotify not available: %s", err)
+	}
+	point, err := mountPoint(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != point {
+		t.Errorf("WatchList: %q; want %q", l, point)
+	}
+
+	// Events in directories created after AddMount are sent too.
+	var (
+		dir  = join(tmp, "dir")
+		file = join(dir, "file")
+		mv   = join(dir, "mv")
+	)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(file, mv); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(mv); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events for the same name can be merged by the kernel.
+	want := map[string]Op{
+		dir:  IN_CREATE | IN_ISDIR,
+		file: IN_CREATE | IN_MODIFY | IN_MOVED_FROM,
+		mv:   IN_MOVED_TO | IN_DELETE,
+	}
+	have := make(map[string]Op)
+	for _, e := range eventsFor(t, w, 500*time.Millisecond) {
+		// The whole filesystem is watched; ignore changes made by others.
+		if strings.HasPrefix(e.Name, tmp+"/") {
+			have[e.Name] |= e.Op
+		}
+	}
+	for name, op := range want {
+		if have[name] != op {
+			t.Errorf("%s\nhave: %s\nwant: %s", name, have[name], op)
+		}
+	}
+	if len(have) != len(want) {
+		t.Errorf("\nhave: %v\nwant: %v", have, want)
+	}
+
+	if err := w.Remove(point); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 0 {
+		t.Errorf("WatchList after Remove: %q", l)
+	}
+	if err := w.Remove(point); !errors.Is(err, ErrNonExistentWatch) {
+		t.Errorf("second Remove: %v", err)
+	}
+}
+
+func TestInotifyUnmount(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mnt := join(tmp, "mnt")
+	mkdir(t, mnt)
+	if err := unix.Mount("tmpfs", mnt, "tmpfs", 0, ""); err != nil {
+		t.Skipf("can't mount tmpfs: %s", err)
+	}
+	t.Cleanup(func() { unix.Unmount(mnt, unix.MNT_DETACH) })
+	mkdir(t, mnt, "dir")
+
+	w := newCollector(t, mnt, join(mnt, "dir"))
+	w.collect(t)
+	if err := unix.Unmount(mnt, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForEvents()
+	have := w.events(t)
+	for _, p := range []string{mnt, join(mnt, "dir")} {
+		var found bool
+		for _, e := range have {
+			if e.Name == p && e.Has(Unmount) && e.Has(IN_UNMOUNT) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no Unmount event for %q\nhave: %v", p, have)
+		}
+	}
+	if l := w.w.WatchList(); len(l) != 0 {
+		t.Errorf("WatchList not empty: %q", l)
+	}
+	w.stop(t)
+}
+
+func TestInotifyIgnored(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "readd", noWait)
+	mkdir(t, tmp, "gone", noWait)
+
+	w := newWatcher(t, join(tmp, "readd"), join(tmp, "gone"))
+	defer w.Close()
+
+	// Remove the watches behind the Watcher's back, as the kernel would; the
+	// lock keeps the events from being read until "gone" is removed.
+	w.watches.mu.Lock()
+	for _, p := range []string{"readd", "gone"} {
+		wd := w.watches.path[w.watches.paths.lookup(join(tmp, p))]
+		if _, err := unix.InotifyRmWatch(w.fd, wd); err != nil {
+			w.watches.mu.Unlock()
+			t.Fatal(err)
+		}
+	}
+	err := os.Remove(join(tmp, "gone"))
+	w.watches.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(2 * time.Second)
+	select {
+	case e := <-w.Events:
+		if e.Name != join(tmp, "gone") || e.Op != WatchRemoved {
+			t.Errorf("wrong event: %s", e)
+		}
+	case err := <-w.Errors:
+		t.Fatal(err)
+	case <-timeout:
+		t.Fatal("no WatchRemoved event")
+	}
+
+	// The other watch still works.
+	touch(t, tmp, "readd", "file")
+	select {
+	case e := <-w.Events:
+		if e.Name != join(tmp, "readd", "file") {
+			t.Errorf("wrong event: %s", e)
+		}
+	case err := <-w.Errors:
+		t.Fatal(err)
+	case <-timeout:
+		t.Fatal("no event after the watch was added again")
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != join(tmp, "readd") {
+		t.Errorf("WatchList() = %q", l)
+	}
+}
@@ -0,0 +1,249 @@
+//go:build linux && !appengine
+// +build linux,!appengine
+
+package fsnotify
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// io_uring constants from linux/io_uring.h; x/sys/unix only has the syscall
+// numbers.
+const (
+	uringOffSQRing       = 0
+	uringOffCQRing       = 0x8000000
+	uringOffSQEs         = 0x10000000
+	uringOpReadFixed     = 4
+	uringRegisterBuffers = 0
+	uringEnterGetEvents  = 1
+
+	uringEntries = 4 // Only two reads are ever in flight.
+	uringSQESize = int(unsafe.Sizeof(uringSQE{}))
+	uringCQESize = int(unsafe.Sizeof(uringCQE{}))
+)
+
+// User data for the reads, to tell the completions apart.
+const (
+	uringRead = 1 // Read from the inotify file descriptor.
+	uringWake = 2 // Read from the eventfd, for Close.
+)
+
+type (
+	uringParams struct {
+		sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+		resv                                                                   [3]uint32
+		sqOff                                                                  uringSQOffsets
+		cqOff                                                                  uringCQOffsets
+	}
+	uringSQOffsets struct {
+		head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+		userAddr                                                        uint64
+	}
+	uringCQOffsets struct {
+		head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+		userAddr                                                        uint64
+	}
+	uringSQE struct {
+		opcode      uint8
+		flags       uint8
+		ioprio      uint16
+		fd          int32
+		off         uint64
+		addr        uint64
+		len         uint32
+		rwFlags     uint32
+		userData    uint64
+		bufIndex    uint16
+		personality uint16
+		spliceFdIn  int32
+		addr3       uint64
+		_           uint64
+	}
+	uringCQE struct {
+		userData uint64
+		res      int32
+		flags    uint32
+	}
+)
+
+// uring reads from the inotify file descriptor with io_uring, into a buffer
+// that's registered with the kernel once (see WithIOUring).
+type uring struct {
+	fd      int    // io_uring instance.
+	inotify int    // inotify file descriptor to read from.
+	wake    int    // eventfd that wakeup() writes to.
+	buf     []byte // Registered buffers: the inotify buffer, followed by 8 bytes for the eventfd.
+	size    int    // Size of the inotify buffer.
+
+	sqRing, cqRing, sqes []byte
+	sqHead, sqTail       *uint32
+	sqMask               uint32
+	sqArray              []byte
+	cqHead, cqTail       *uint32
+	cqMask               uint32
+	cqes                 []byte
+
+	mu     sync.Mutex // Held to write to wake, which may be closed.
+	closed bool
+}
+
+func newURing(inotify, size int) (*uring, error) {
+	var p uringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	u := &uring{fd: int(fd), inotify: inotify, wake: -1, size: size}
+
+	err := u.mmap(&p)
+	if err == nil {
+		u.buf, err = unix.Mmap(-1, 0, size+8, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	}
+	if err == nil {
+		iov := make([]unix.Iovec, 2)
+		iov[0].Base, iov[1].Base = &u.buf[0], &u.buf[size]
+		iov[0].SetLen(size)
+		iov[1].SetLen(8)
+		_, _, errno = unix.Syscall6(unix.SYS_IO_URING_REGISTER, uintptr(u.fd), uringRegisterBuffers,
+			uintptr(unsafe.Pointer(&iov[0])), uintptr(len(iov)), 0, 0)
+		if errno != 0 {
+			err = errno
+		}
+	}
+	if err == nil {
+		u.wake, err = unix.Eventfd(0, unix.EFD_CLOEXEC)
+	}
+	if err != nil {
+		u.close()
+		return nil, err
+	}
+	return u, nil
+}
+
+// mmap maps the submission and completion queues.
+func (u *uring) mmap(p *uringParams) error {
+	var err error
+	mmap := func(off int64, size uint32) []byte {
+		if err != nil {
+			return nil
+		}
+		var b []byte
+		b, err = unix.Mmap(u.fd, off, int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+		return b
+	}
+	u.sqRing = mmap(uringOffSQRing, p.sqOff.array+p.sqEntries*4)
+	u.cqRing = mmap(uringOffCQRing, p.cqOff.cqes+p.cqEntries*uint32(uringCQESize))
+	u.sqes = mmap(uringOffSQEs, p.sqEntries*uint32(uringSQESize))
+	if err != nil {
+		return err
+	}
+
+	u.sqHead = (*uint32)(unsafe.Pointer(&u.sqRing[p.sqOff.head]))
+	u.sqTail = (*uint32)(unsafe.Pointer(&u.sqRing[p.sqOff.tail]))
+	u.sqMask = *(*uint32)(unsafe.Pointer(&u.sqRing[p.sqOff.ringMask]))
+	u.sqArray = u.sqRing[p.sqOff.array:]
+	u.cqHead = (*uint32)(unsafe.Pointer(&u.cqRing[p.cqOff.head]))
+	u.cqTail = (*uint32)(unsafe.Pointer(&u.cqRing[p.cqOff.tail]))
+	u.cqMask = *(*uint32)(unsafe.Pointer(&u.cqRing[p.cqOff.ringMask]))
+	u.cqes = u.cqRing[p.cqOff.cqes:]
+	return nil
+}
+
+// run reads from the inotify file descriptor until wakeup() is called or fn
+// returns false. fn is called with the data that was read or an error, and the
+// buffer is only valid until it returns.
+func (u *uring) run(fn func(buf []byte, err error) bool) {
+	u.read(uringRead, u.inotify, 0, u.buf[:u.size])
+	u.read(uringWake, u.wake, 1, u.buf[u.size:])
+	for {
+		if err := u.enter(); err != nil {
+			if !fn(nil, err) {
+				return
+			}
+			continue
+		}
+
+		head, tail := atomic.LoadUint32(u.cqHead), atomic.LoadUint32(u.cqTail)
+		for ; head != tail; head++ {
+			cqe := (*uringCQE)(unsafe.Pointer(&u.cqes[int(head&u.cqMask)*uringCQESize]))
+			if cqe.userData == uringWake {
+				return
+			}
+
+			switch res := cqe.res; {
+			case res == -int32(unix.EINTR) || res == -int32(unix.EAGAIN):
+			case res < 0:
+				if !fn(nil, unix.Errno(-res)) {
+					return
+				}
+			default:
+				if !fn(u.buf[:res], nil) {
+					return
+				}
+			}
+			u.read(uringRead, u.inotify, 0, u.buf[:u.size])
+		}
+		atomic.StoreUint32(u.cqHead, head)
+	}
+}
+
+// read queues a read into the registered buffer buf.
+func (u *uring) read(data uint64, fd int, index uint16, buf []byte) {
+	tail := atomic.LoadUint32(u.sqTail)
+	i := tail & u.sqMask
+	*(*uringSQE)(unsafe.Pointer(&u.sqes[int(i)*uringSQESize])) = uringSQE{
+		opcode:   uringOpReadFixed,
+		fd:       int32(fd),
+		addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:      uint32(len(buf)),
+		userData: data,
+		bufIndex: index,
+	}
+	*(*uint32)(unsafe.Pointer(&u.sqArray[i*4])) = i
+	atomic.StoreUint32(u.sqTail, tail+1)
+}
+
+// enter submits the queued reads, and waits for at least one to complete.
+func (u *uring) enter() error {
+	for {
+		submit := atomic.LoadUint32(u.sqTail) - atomic.LoadUint32(u.sqHead)
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(u.fd), uintptr(submit), 1,
+			uringEnterGetEvents, 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+// wakeup makes run() return.
+func (u *uring) wakeup() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.closed {
+		_, _ = unix.Write(u.wake, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	}
+}
+
+// close the io_uring instance, cancelling any reads in flight.
+func (u *uring) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closed = true
+	unix.Close(u.fd)
+	if u.wake != -1 {
+		unix.Close(u.wake)
+	}
+	for _, b := range [][]byte{u.sqRing, u.cqRing, u.sqes, u.buf} {
+		if b != nil {
+			_ = unix.Munmap(b)
+		}
+	}
+}
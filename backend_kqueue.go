@@ -12,7 +12,9 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"unsafe"
 
+	"github.com/hohodqr/fsnotify/internal"
 	"golang.org/x/sys/unix"
 )
 
@@ -53,8 +55,9 @@ import (
 //	fs.inotify.max_user_watches=124983
 //	fs.inotify.max_user_instances=128
 //
-// Reaching the limit will result in a "no space left on device" or "too many open
-// files" error.
+// Adding a path after reaching the watch limit returns [ErrTooManyWatches], and
+// reaching the instance limit results in a "too many open files" error from
+// NewWatcher.
 //
 // # kqueue notes (macOS, BSD)
 //
@@ -76,6 +79,15 @@ import (
 // to work with SMB filesystems. If you have many events in quick succession
 // this may not be enough, and you will have to use [WithBufferSize] to increase
 // the value.
+//
+// Paths longer than MAX_PATH (260 characters) can be watched; they're converted
+// to extended-length paths ("\\\\?\\C:\\path") internally. Event.Name never has
+// the "\\\\?\\" prefix, even if the path was added with it.
+//
+// All Watchers share one I/O completion port, which a few goroutines read
+// events from. A Watcher whose Events channel isn't read (with the default
+// [WithBackpressure]) holds up one of these, and can delay events for other
+// Watchers.
 type Watcher struct {
 	// Events sends the filesystem change events.
 	//
@@ -122,6 +134,8 @@ type Watcher struct {
 	//  - inotify: there are too many queued events (fs.inotify.max_queued_events sysctl)
 	//  - windows: The buffer size is too small; [WithBufferSize] can be used to increase it.
 	//  - kqueue, fen: not used.
+	//
+	// [Watcher.OnOverflow] can be used to be notified right away.
 	Errors chan error
 
 	done         chan struct{}
@@ -134,16 +148,116 @@ type Watcher struct {
 	dirFlags     map[string]uint32           // Watched directories to fflags used in kqueue.
 	paths        map[int]pathInfo            // File descriptors to path names for processing kqueue events.
 	fileExists   map[string]struct{}         // Keep track of if we know this file exists (to stop duplicate create events).
+	polled       map[string]polledFile       // Files in watched directories that aren't opened (see WithFilePolling).
 	isClosed     bool                        // Set to true when Close() is first called
+	with         withOpts                    // Options passed to NewWatcherWith()
+	delivery     *delivery                   // Non-blocking event delivery (see WithBackpressure)
+	stats        *stats                      // Counters for Stats()
+	files        *fileWatches                // Files added with AddFile and FollowRotation
+	oneShots     *oneShots                   // WithOneShot watches
+	attrs        *attrs                      // Attribute changes (see WithAttrChanges)
+	pendings     *pendings                   // AddPending paths
+	chmod        *chmods                     // Chmod filtering and polling (see WithChmod)
+	sums         *checksums                  // Write filtering (see WithChecksum)
+	diffs        *contents                   // Event.Change (see WithContentDiff)
+	callback     *eventFunc                  // Called instead of sending on Events (see WithCallback)
+	subs         *subscriptions              // Subscriptions from SubscribeCtx()
+	scan         *scanner                    // Synthetic events from WithInitialScan and CatchUp
+	spec         *appliedSpec                // Watches added with ApplySpec()
+	reconcile    *reconciler                 // Rescanning (see WithReconcile)
+	contexts     *watchContexts              // Removed when done (see WithContext)
 }
 
+// The Ops that correspond to the portable operations; these are different on
+// Linux, where events have the raw inotify mask set as the Op.
+const (
+	opCreate = Create
+	opWrite  = Write
+	opRemove = Remove
+	opRename = Rename
+	opChmod  = Chmod
+)
+
+// The operations that can be sent (see Watcher.Supports).
+const sentOps = opCreate | opWrite | opRemove | opRename | opChmod | supportedOps | Rotated | Handover | Unmount | Expired
+
 type pathInfo struct {
 	name  string
 	isDir bool
 }
 
 // NewWatcher creates a new Watcher.
-func NewWatcher() (*Watcher, error) {
+func NewWatcher() (*Watcher, error) { return NewWatcherWith() }
+
+// NewWatcherWith is like [NewWatcher], but allows passing options.
+//
+// Possible options are:
+//
+//   - [WithEventChannelSize] sets the capacity of the Events channel. The
+//     default is 0 (unbuffered), or 50 on Windows.
+//
+//   - [WithRootRelativeNames] makes Event.Name relative to the path passed to
+//     Add(), which is set in Event.Root.
+//
+//   - [WithRelativeTo] makes Event.Name relative to a directory, which is set
+//     in Event.Root.
+//
+//   - [WithBackpressure] sets what to do if the Events channel is full. The
+//     default is to block.
+//
+//   - [WithChmod] enables or disables Chmod events, making them consistent
+//     across platforms. The default is to send what the platform reports.
+//
+//   - [WithCallback] calls a function for every event instead of sending it
+//     on the Events channel, without allocating memory for every event.
+//
+//   - [WithJournal] appends every event to a journal file.
+//
+//   - [WithChecksum] only sends Write events if the contents of the file
+//     changed.
+//
+//   - [WithContentDiff] sets what changed in the file on Write events.
+//
+//   - [WithNameEncoding] sets how names that aren't valid UTF-8 are sent.
+//
+//   - [WithUnicodeNorm] normalizes names to a Unicode normalization form,
+//     such as NFC.
+//
+//   - [WithCaseInsensitivePaths] matches paths passed to Add and Remove
+//     regardless of case on case-insensitive volumes.
+//
+//   - [WithAbsolutePaths] makes Event.Name an absolute path with symlinks
+//     resolved.
+//
+//   - [WithAttrChanges] sets which attributes changed on Chmod events.
+//
+//   - [WithExcludeUnlinked] drops events for files that were removed but are
+//     still open.
+//
+//   - [WithSharedInstance] shares one inotify instance between Watchers on
+//     Linux.
+//
+//   - [WithIOUring] reads inotify events with io_uring on Linux; this is
+//     experimental.
+//
+//   - [WithReconcile] rescans all watched paths periodically, and sends
+//     events for changes that the system didn't report.
+//
+//   - [WithFilePolling] only opens directories on kqueue, and polls files for
+//     changes.
+//
+//   - [WithBufferSize] sets the size of the buffer inotify events are read
+//     into on Linux, and how many kevents are read at once on kqueue. The
+//     default is 64K (65536 bytes).
+func NewWatcherWith(opts ...addOpt) (*Watcher, error) {
+	with := getOptions(opts...)
+	if with.bufsize < 4096 {
+		return nil, fmt.Errorf("fsnotify.WithBufferSize: buffer size cannot be smaller than 4096 bytes")
+	}
+	if err := checkPlatformOpts(with, "kqueue", true); err != nil {
+		return nil, err
+	}
+
 	kq, closepipe, err := newKqueue()
 	if err != nil {
 		return nil, err
@@ -157,13 +271,34 @@ func NewWatcher() (*Watcher, error) {
 		dirFlags:     make(map[string]uint32),
 		paths:        make(map[int]pathInfo),
 		fileExists:   make(map[string]struct{}),
+		polled:       make(map[string]polledFile),
 		userWatches:  make(map[string]struct{}),
-		Events:       make(chan Event),
+		with:         with,
+		delivery:     newDelivery(with),
+		stats:        newStats(),
+		files:        newFileWatches(),
+		oneShots:     newOneShots(),
+		attrs:        newAttrs(with),
+		pendings:     newPendings(),
+		chmod:        newChmods(with),
+		sums:         newChecksums(with),
+		diffs:        newContents(with),
+		callback:     newEventFunc(with),
+		subs:         newSubscriptions(with),
+		scan:         newScanner(),
+		spec:         newAppliedSpec(),
+		reconcile:    newReconciler(with),
+		contexts:     newWatchContexts(),
+		Events:       make(chan Event, with.eventsSize),
 		Errors:       make(chan error),
 		done:         make(chan struct{}),
 	}
 
+	if with.filePolling > 0 {
+		w.scan.run(w.pollFiles)
+	}
 	go w.readEvents()
+	w.reconcile.run(w.WatchList, w.sendSynthetic)
 	return w, nil
 }
 
@@ -199,21 +334,53 @@ func newKqueue() (kq int, closepipe [2]int, err error) {
 		unix.Close(closepipe[1])
 		return kq, closepipe, err
 	}
+	internal.Opened("kqueue", kq)
+	internal.Opened("pipe", closepipe[0])
+	internal.Opened("pipe", closepipe[1])
 	return kq, closepipe, nil
 }
 
 // Returns true if the event was sent, or false if watcher is closed.
 func (w *Watcher) sendEvent(e Event) bool {
-	select {
-	case w.Events <- e:
+	if w.with.rootRelative {
+		e = e.rootRelative(w.rootOf(e.Name))
+	}
+	e, ok := w.filter(e)
+	if !ok {
 		return true
-	case <-w.done:
-		return false
 	}
+	if w.subs.send(e, w.callback != nil) {
+		w.delivery.sent(e)
+		return true
+	}
+	if w.callback != nil {
+		w.callback.call(e)
+		w.delivery.sent(e)
+		return true
+	}
+	if !w.delivery.blocking() {
+		return w.delivery.send(w.Events, e)
+	}
+	return w.delivery.sendBlocking(w.Events, e, w.done)
+}
+
+// rootOf gets the path passed to Add() that name belongs to, or "" if it's not
+// watched (any more).
+func (w *Watcher) rootOf(name string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.userWatches[name]; ok {
+		return name
+	}
+	if _, ok := w.userWatches[filepath.Dir(name)]; ok {
+		return filepath.Dir(name)
+	}
+	return ""
 }
 
 // Returns true if the error was sent, or false if watcher is closed.
 func (w *Watcher) sendError(err error) bool {
+	w.stats.error(err)
 	select {
 	case w.Errors <- err:
 		return true
@@ -223,6 +390,10 @@ func (w *Watcher) sendError(err error) bool {
 }
 
 // Close removes all watches and closes the events channel.
+//
+// Remove and Rename events that were already read from the system are sent
+// before the Events channel is closed, even if other events are dropped because
+// of [WithBackpressure]. Close waits up to a second for these to be read.
 func (w *Watcher) Close() error {
 	w.mu.Lock()
 	if w.isClosed {
@@ -243,6 +414,8 @@ func (w *Watcher) Close() error {
 
 	// Send "quit" message to the reader goroutine.
 	unix.Close(w.closepipe[1])
+	internal.Closed("pipe", w.closepipe[1])
+	w.delivery.stopping()
 	close(w.done)
 
 	return nil
@@ -259,7 +432,10 @@ func (w *Watcher) Close() error {
 // watcher on renames.
 //
 // Notifications on network filesystems (NFS, SMB, FUSE, etc.) or special
-// filesystems (/proc, /sys, etc.) generally don't work.
+// filesystems (/proc, /sys, etc.) generally don't work. The exception is
+// network shares on Windows, which are polled if the server doesn't send
+// notifications; a [NetworkWatch] is sent on the Errors channel to tell which
+// is used.
 //
 // Returns [ErrClosed] if [Watcher.Close] was called.
 //
@@ -291,15 +467,77 @@ func (w *Watcher) Add(name string) error { return w.AddWith(name) }
 // Possible options are:
 //
 //   - [WithBufferSize] sets the buffer size for the Windows backend; no-op on
-//     other platforms. The default is 64K (65536 bytes).
-func (w *Watcher) AddWith(name string, opts ...addOpt) error {
-	_ = getOptions(opts...)
+//     other platforms, where it's passed to NewWatcherWith instead. The
+//     default is 64K (65536 bytes).
+//   - [WithWindowsFilters] sets the ReadDirectoryChangesW notify filters for
+//     the Windows backend; no-op on other platforms.
+//   - [WithRetarget] moves the watch if the target of a symlink changes;
+//     Linux only.
+//   - [WithFollowSymlinks] sets whether to watch the target of a symlink or
+//     the symlink itself. The default is to watch the target.
+//   - [WithMaxDepth] limits how many levels of subdirectories a recursive
+//     watch descends. The default is unlimited.
+//   - [WithContinueOnError] skips subdirectories of a recursive watch that
+//     can't be read or watched, rather than failing.
+//   - [WithWalkWorkers] sets how many directories are read at the same time
+//     for a recursive watch.
+//   - [WithProgress] reports how many directories of a recursive watch were
+//     found and watched so far.
+//   - [WithInitialScan] sends a Create event for every existing file after
+//     the path is added.
+//   - [WithOneShot] removes the watch after the first event is sent.
+//   - [WithContext] removes the watch when the context is done.
+//   - [WithTTL] removes the watch after a duration.
+//   - [WithOnlyDir] returns an error if the path isn't a directory.
+//   - [WithOps] enables the Open, Read, Close, and Xattr operations.
+//   - [WithReArm] adds the path again if it's removed and recreated.
+func (w *Watcher) AddWith(name string, opts ...addOpt) (err error) {
+	name = w.addPath(name, opts)
+	defer func() { w.spec.addUser(name, opts, err) }()
+	w.pendings.added(name, opts)
+	with := getOptions(opts...)
+	if w.reconcile.polling() {
+		return w.reconcile.add(name, with)
+	}
+	if with.retarget {
+		return ErrUnsupported{Feature: "WithRetarget", Backend: "kqueue"}
+	}
+	if with.maxDepth > 0 {
+		return ErrUnsupported{Feature: "WithMaxDepth", Backend: "kqueue"}
+	}
+	if with.ops&^supportedOps != 0 {
+		return ErrUnsupported{Feature: "WithOps", Backend: "kqueue"}
+	}
+	if err := checkPlatformOpts(with, "kqueue", false); err != nil {
+		return err
+	}
+	if with.onlyDir {
+		if err := checkDir(name, with.noFollow); err != nil {
+			return err
+		}
+	}
 
 	w.mu.Lock()
 	w.userWatches[name] = struct{}{}
 	w.mu.Unlock()
-	_, err := w.addWatch(name, noteAllEvents)
-	return err
+	_, err = w.addWatch(name, noteAllEvents|opNotes(with.ops), !with.noFollow)
+	if err != nil {
+		return err
+	}
+	w.oneShots.set(name, with.oneShot)
+	w.contexts.set(name, with, w.Remove, w.sendExpired)
+	w.attrs.set(name, with.ops.Has(Xattr))
+	w.initialScan(name, with)
+	return nil
+}
+
+// sendSynthetic sends an event that didn't come from the kernel, for
+// WithInitialScan, CatchUp, and WithReconcile; op is Create, Write, or Remove.
+func (w *Watcher) sendSynthetic(name string, op Op, isDir bool) bool {
+	if isDir {
+		op |= IN_ISDIR
+	}
+	return w.sendEvent(Event{Name: name, Op: op})
 }
 
 // Remove stops monitoring the path for changes.
@@ -318,6 +556,17 @@ func (w *Watcher) AddWith(name string, opts ...addOpt) error {
 //
 // Returns nil if [Watcher.Close] was called.
 func (w *Watcher) Remove(name string) error {
+	name = w.casePath(name)
+	w.oneShots.clear(name)
+	w.contexts.clear(name)
+	w.attrs.clear(name)
+	w.spec.removeUser(name)
+	if w.reconcile.polling() {
+		return w.reconcile.remove(name)
+	}
+	if w.removePending(name) {
+		return nil
+	}
 	return w.remove(name, true)
 }
 
@@ -340,6 +589,7 @@ func (w *Watcher) remove(name string, unwatchFiles bool) error {
 	}
 
 	unix.Close(watchfd)
+	internal.Closed("watch", watchfd)
 
 	w.mu.Lock()
 	isDir := w.paths[watchfd].isDir
@@ -356,6 +606,9 @@ func (w *Watcher) remove(name string, unwatchFiles bool) error {
 	delete(w.paths, watchfd)
 	delete(w.dirFlags, name)
 	delete(w.fileExists, name)
+	if unwatchFiles && isDir {
+		w.unpollDir(name)
+	}
 	w.mu.Unlock()
 
 	// Find all watched paths that are in this directory that are not external.
@@ -388,6 +641,9 @@ func (w *Watcher) WatchList() []string {
 	if w.isClosed {
 		return nil
 	}
+	if w.reconcile.polling() {
+		return w.reconcile.list()
+	}
 
 	entries := make([]string, 0, len(w.userWatches))
 	for pathname := range w.userWatches {
@@ -397,13 +653,32 @@ func (w *Watcher) WatchList() []string {
 	return entries
 }
 
-// Watch all events (except NOTE_EXTEND, NOTE_LINK, NOTE_REVOKE)
-const noteAllEvents = unix.NOTE_DELETE | unix.NOTE_WRITE | unix.NOTE_ATTRIB | unix.NOTE_RENAME
+// Watch all events (except NOTE_EXTEND, NOTE_LINK). NOTE_REVOKE is sent if the
+// filesystem is unmounted.
+const noteAllEvents = unix.NOTE_DELETE | unix.NOTE_WRITE | unix.NOTE_ATTRIB | unix.NOTE_RENAME | unix.NOTE_REVOKE
+
+// opNotes gets the notes for the operations enabled with WithOps.
+func opNotes(ops Op) uint32 {
+	var notes uint32
+	if ops.Has(Open) {
+		notes |= noteOpen
+	}
+	if ops.Has(Read) {
+		notes |= noteRead
+	}
+	if ops.Has(Close) {
+		notes |= noteClose
+	}
+	return notes
+}
 
 // addWatch adds name to the watched file set.
 // The flags are interpreted as described in kevent(2).
 // Returns the real path to the file which was added, if any, which may be different from the one passed in the case of symlinks.
-func (w *Watcher) addWatch(name string, flags uint32) (string, error) {
+//
+// Symlinks are resolved if follow is true, or else the symlink itself is
+// watched.
+func (w *Watcher) addWatch(name string, flags uint32, follow bool) (string, error) {
 	var isDir bool
 	name = filepath.Clean(name)
 
@@ -437,7 +712,13 @@ func (w *Watcher) addWatch(name string, flags uint32) (string, error) {
 		// will act like everything is fine if the link can't be resolved.
 		// There will simply be no file events for broken symlinks. Hence the
 		// returns of nil on errors.
-		if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
+		mode := openMode
+		if fi.Mode()&os.ModeSymlink == os.ModeSymlink && !follow {
+			if openSymlink == 0 {
+				return "", ErrUnsupported{Feature: "WithFollowSymlinks(false)", Backend: "kqueue"}
+			}
+			mode |= openSymlink
+		} else if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
 			name, err = filepath.EvalSymlinks(name)
 			if err != nil {
 				return "", nil
@@ -460,13 +741,21 @@ func (w *Watcher) addWatch(name string, flags uint32) (string, error) {
 		// Retry on EINTR; open() can return EINTR in practice on macOS.
 		// See #354, and go issues 11180 and 39237.
 		for {
-			watchfd, err = unix.Open(name, openMode, 0)
+			watchfd, err = unix.Open(name, mode, 0)
 			if err == nil {
+				internal.Opened("watch", watchfd)
 				break
 			}
 			if errors.Is(err, unix.EINTR) {
 				continue
 			}
+			if errors.Is(err, unix.EMFILE) {
+				w.mu.Lock()
+				n := len(w.watches)
+				w.mu.Unlock()
+				err = ErrTooManyWatches{Path: name, Watches: n, Limit: fdLimit(),
+					Setting: "the open files limit (ulimit -n, kern.maxfilesperproc)", Err: err}
+			}
 
 			return "", err
 		}
@@ -477,6 +766,7 @@ func (w *Watcher) addWatch(name string, flags uint32) (string, error) {
 	err := w.register([]int{watchfd}, unix.EV_ADD|unix.EV_CLEAR|unix.EV_ENABLE, flags)
 	if err != nil {
 		unix.Close(watchfd)
+		internal.Closed("watch", watchfd)
 		return "", err
 	}
 
@@ -516,20 +806,46 @@ func (w *Watcher) addWatch(name string, flags uint32) (string, error) {
 	return name, nil
 }
 
+// DroppedEvents returns the number of events that were dropped because of the
+// backpressure policy set with [WithBackpressure].
+func (w *Watcher) DroppedEvents() uint64 { return w.delivery.droppedEvents() }
+
+// PathStats returns how much memory is used to store the watched paths.
+func (w *Watcher) PathStats() PathStats { return flatPathStats(w.WatchList()) }
+
+// AddMount starts monitoring all files and directories on the mount that path
+// is on; this is only supported on Linux, and returns [ErrUnsupported] on other
+// platforms.
+func (w *Watcher) AddMount(path string) error {
+	return ErrUnsupported{Feature: "AddMount", Backend: "kqueue"}
+}
+
+func (w *Watcher) mountPoints() []string { return nil }
+
 // readEvents reads from kqueue and converts the received kevents into
 // Event values that it sends down the Events channel.
 func (w *Watcher) readEvents() {
 	defer func() {
 		err := unix.Close(w.kq)
+		internal.Closed("kqueue", w.kq)
 		if err != nil {
 			w.Errors <- err
 		}
 		unix.Close(w.closepipe[0])
+		internal.Closed("pipe", w.closepipe[0])
+		w.chmod.stop()
+		w.reconcile.stop()
+		w.contexts.stop()
+		w.scan.wait()
+		w.subs.close()
+		w.delivery.close()
+		w.delivery.sendKept(w.Events)
 		close(w.Events)
 		close(w.Errors)
 	}()
 
-	eventBuffer := make([]unix.Kevent_t, 10)
+	// Read as many kevents at once as fit in the buffer size (see WithBufferSize).
+	eventBuffer := make([]unix.Kevent_t, w.with.bufsize/int(unsafe.Sizeof(unix.Kevent_t{})))
 	for closed := false; !closed; {
 		kevents, err := w.read(eventBuffer)
 		// EINTR is okay, the syscall was interrupted before timeout expired.
@@ -559,8 +875,11 @@ func (w *Watcher) readEvents() {
 			w.mu.Unlock()
 
 			event := w.newEvent(path.name, mask)
+			if path.isDir {
+				event.Op |= IN_ISDIR
+			}
 
-			if event.Has(Rename) || event.Has(Remove) {
+			if event.Has(Rename) || event.Has(Remove) || event.Has(Unmount) {
 				w.remove(event.Name, false)
 				w.mu.Lock()
 				delete(w.fileExists, event.Name)
@@ -587,7 +906,7 @@ func (w *Watcher) readEvents() {
 					if found {
 						err := w.sendDirectoryChangeEvents(fileDir)
 						if err != nil {
-							if !w.sendError(err) {
+							if !w.sendError(WatchError{Path: fileDir, Op: "read", Err: err, Alive: true}) {
 								closed = true
 							}
 						}
@@ -597,7 +916,7 @@ func (w *Watcher) readEvents() {
 					if fi, err := os.Lstat(filePath); err == nil {
 						err := w.sendFileCreatedEventIfNew(filePath, fi)
 						if err != nil {
-							if !w.sendError(err) {
+							if !w.sendError(WatchError{Path: filePath, Op: "add", Err: err}) {
 								closed = true
 							}
 						}
@@ -623,6 +942,18 @@ func (w *Watcher) newEvent(name string, mask uint32) Event {
 	if mask&unix.NOTE_ATTRIB == unix.NOTE_ATTRIB {
 		e.Op |= Chmod
 	}
+	if mask&unix.NOTE_REVOKE == unix.NOTE_REVOKE {
+		e.Op |= Unmount
+	}
+	if mask&noteOpen != 0 {
+		e.Op |= Open
+	}
+	if mask&noteRead != 0 {
+		e.Op |= Read
+	}
+	if mask&noteClose != 0 {
+		e.Op |= Close
+	}
 	// No point sending a write and delete event at the same time: if it's gone,
 	// then it's gone.
 	if e.Op.Has(Write) && e.Op.Has(Remove) {
@@ -684,13 +1015,30 @@ func (w *Watcher) sendDirectoryChangeEvents(dir string) error {
 		return fmt.Errorf("fsnotify.sendDirectoryChangeEvents: %w", err)
 	}
 
+	names := make([]string, 0, len(files))
 	for _, f := range files {
-		fi, err := f.Info()
+		names = append(names, f.Name())
+	}
+	w.mu.Lock()
+	created := dirDiff(dir, names, w.fileExists)
+	w.mu.Unlock()
+
+	// Files aren't opened with WithFilePolling, so there are no events when
+	// they're removed.
+	if w.with.filePolling > 0 && !w.sendPolledRemoves(dir, names, created) {
+		return nil
+	}
+
+	for _, path := range created {
+		fi, err := os.Lstat(path)
 		if err != nil {
+			if errors.Is(err, os.ErrNotExist) { // Already removed again.
+				continue
+			}
 			return fmt.Errorf("fsnotify.sendDirectoryChangeEvents: %w", err)
 		}
 
-		err = w.sendFileCreatedEventIfNew(filepath.Join(dir, fi.Name()), fi)
+		err = w.sendFileCreatedEventIfNew(path, fi)
 		if err != nil {
 			// Don't need to send an error if this file isn't readable.
 			if errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM) {
@@ -708,7 +1056,11 @@ func (w *Watcher) sendFileCreatedEventIfNew(filePath string, fi os.FileInfo) (er
 	_, doesExist := w.fileExists[filePath]
 	w.mu.Unlock()
 	if !doesExist {
-		if !w.sendEvent(Event{Name: filePath, Op: Create}) {
+		op := Create
+		if fi.IsDir() {
+			op |= IN_ISDIR
+		}
+		if !w.sendEvent(Event{Name: filePath, Op: op}) {
 			return
 		}
 	}
@@ -727,6 +1079,12 @@ func (w *Watcher) sendFileCreatedEventIfNew(filePath string, fi os.FileInfo) (er
 }
 
 func (w *Watcher) internalWatch(name string, fi os.FileInfo) (string, error) {
+	// Entries get the WithOps notes of the directory, like inotify sends
+	// these for all entries in the directory.
+	w.mu.Lock()
+	ops := w.dirFlags[filepath.Dir(name)] & (noteOpen | noteRead | noteClose)
+	w.mu.Unlock()
+
 	if fi.IsDir() {
 		// mimic Linux providing delete events for subdirectories, but preserve
 		// the flags used if currently watching subdirectory
@@ -734,12 +1092,16 @@ func (w *Watcher) internalWatch(name string, fi os.FileInfo) (string, error) {
 		flags := w.dirFlags[name]
 		w.mu.Unlock()
 
-		flags |= unix.NOTE_DELETE | unix.NOTE_RENAME
-		return w.addWatch(name, flags)
+		flags |= unix.NOTE_DELETE | unix.NOTE_RENAME | ops
+		return w.addWatch(name, flags, true)
+	}
+
+	if w.with.filePolling > 0 {
+		return w.pollFile(name, fi), nil
 	}
 
 	// watch file to mimic Linux inotify
-	return w.addWatch(name, noteAllEvents)
+	return w.addWatch(name, noteAllEvents|ops, true)
 }
 
 // Register events with the queue.
@@ -0,0 +1,14 @@
+//go:build darwin || dragonfly || netbsd || openbsd
+// +build darwin dragonfly netbsd openbsd
+
+package fsnotify
+
+// The notes for WithOps; only FreeBSD has these.
+const (
+	noteOpen  = 0
+	noteRead  = 0
+	noteClose = 0
+)
+
+// The operations that can be enabled with WithOps.
+const supportedOps = Xattr
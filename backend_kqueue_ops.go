@@ -0,0 +1,16 @@
+//go:build freebsd
+// +build freebsd
+
+package fsnotify
+
+import "golang.org/x/sys/unix"
+
+// The notes for WithOps; only FreeBSD has these.
+const (
+	noteOpen  = unix.NOTE_OPEN
+	noteRead  = unix.NOTE_READ
+	noteClose = unix.NOTE_CLOSE | unix.NOTE_CLOSE_WRITE
+)
+
+// The operations that can be enabled with WithOps.
+const supportedOps = Open | Read | Close | Xattr
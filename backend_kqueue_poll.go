@@ -0,0 +1,161 @@
+//go:build freebsd || openbsd || netbsd || dragonfly || darwin
+// +build freebsd openbsd netbsd dragonfly darwin
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// polledFile is the state of a file in a watched directory with
+// WithFilePolling, which is used instead of opening the file.
+type polledFile struct {
+	ino      uint64
+	size     int64
+	mtime    int64
+	mode     os.FileMode
+	uid, gid uint32
+}
+
+func newPolledFile(path string, fi os.FileInfo) polledFile {
+	_, ino, _ := fileID(path, fi)
+	uid, gid := fileOwner(fi)
+	return polledFile{
+		ino:   ino,
+		size:  fi.Size(),
+		mtime: fi.ModTime().UnixNano(),
+		mode:  fi.Mode(),
+		uid:   uid,
+		gid:   gid,
+	}
+}
+
+// op gets the event for the changes since p, if any.
+func (p polledFile) op(n polledFile) Op {
+	var op Op
+	if n.ino != p.ino || n.size != p.size || n.mtime != p.mtime {
+		op |= Write
+	}
+	if n.mode != p.mode || n.uid != p.uid || n.gid != p.gid {
+		op |= Chmod
+	}
+	return op
+}
+
+// pollFile starts tracking a file in a watched directory.
+func (w *Watcher) pollFile(name string, fi os.FileInfo) string {
+	name = filepath.Clean(name)
+	w.mu.Lock()
+	w.polled[name] = newPolledFile(name, fi)
+	w.mu.Unlock()
+	return name
+}
+
+// unpollDir stops tracking all files in the directory; this must be called
+// with w.mu held.
+func (w *Watcher) unpollDir(dir string) {
+	for p := range w.polled {
+		if filepath.Dir(p) == dir {
+			delete(w.polled, p)
+			delete(w.fileExists, p)
+		}
+	}
+}
+
+// sendPolledRemoves sends a Remove or Rename event for files in the directory
+// that are no longer in names; it's a Rename if one of the created paths is
+// the same file.
+func (w *Watcher) sendPolledRemoves(dir string, names, created []string) bool {
+	exists := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		exists[filepath.Join(dir, n)] = struct{}{}
+	}
+
+	type gone struct {
+		path string
+		ino  uint64
+	}
+	var removed []gone
+	w.mu.Lock()
+	for p, f := range w.polled {
+		if _, ok := exists[p]; !ok && filepath.Dir(p) == dir {
+			removed = append(removed, gone{p, f.ino})
+			delete(w.polled, p)
+			delete(w.fileExists, p)
+		}
+	}
+	w.mu.Unlock()
+	if len(removed) == 0 {
+		return true
+	}
+
+	inos := make(map[uint64]struct{}, len(created))
+	for _, c := range created {
+		if fi, err := os.Lstat(c); err == nil {
+			if _, ino, err := fileID(c, fi); err == nil {
+				inos[ino] = struct{}{}
+			}
+		}
+	}
+	for _, r := range removed {
+		op := Remove
+		if _, ok := inos[r.ino]; ok {
+			op = Rename
+		}
+		if !w.sendEvent(Event{Name: r.path, Op: op}) {
+			return false
+		}
+	}
+	return true
+}
+
+// pollFiles checks all polled files every interval until the watcher is
+// closed, sending Write and Chmod events for changes, and Remove for files that
+// are gone without the directory reporting it (e.g. because the directory was
+// removed).
+func (w *Watcher) pollFiles() {
+	t := time.NewTicker(w.with.filePolling)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-t.C:
+		}
+
+		w.mu.Lock()
+		paths := make([]string, 0, len(w.polled))
+		for p := range w.polled {
+			if _, ok := w.watches[p]; !ok { // Also added with Add(); kqueue sends the events.
+				paths = append(paths, p)
+			}
+		}
+		w.mu.Unlock()
+
+		for _, p := range paths {
+			fi, err := os.Lstat(p)
+
+			var op Op
+			w.mu.Lock()
+			prev, ok := w.polled[p]
+			switch {
+			case !ok: // Removed since we got the list.
+			case err != nil:
+				delete(w.polled, p)
+				delete(w.fileExists, p)
+				op = Remove
+			default:
+				cur := newPolledFile(p, fi)
+				op = prev.op(cur)
+				w.polled[p] = cur
+			}
+			w.mu.Unlock()
+
+			if op != 0 && !w.sendEvent(Event{Name: p, Op: op}) {
+				return
+			}
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestRemoveState(t *testing.T) {
@@ -94,3 +95,37 @@ func TestRemoveState(t *testing.T) {
 		}
 	}
 }
+
+func TestFilePolling(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	touch(t, tmp, "file")
+
+	w, err := NewWatcherWith(WithFilePolling(10 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &eventCollector{w: w, done: make(chan struct{})}
+	c.collect(t)
+	addWatch(t, w, tmp)
+
+	w.mu.Lock()
+	if len(w.watches) != 1 {
+		t.Errorf("files in the directory are opened: %v", w.watches)
+	}
+	w.mu.Unlock()
+
+	cat(t, "data", tmp, "file")
+	mv(t, join(tmp, "file"), tmp, "renamed")
+	touch(t, tmp, "new")
+	rm(t, tmp, "new")
+
+	cmpEvents(t, tmp, c.stop(t), newEvents(t, `
+		write    /file
+		rename   /file
+		create   /renamed
+		create   /new
+		remove   /new
+	`))
+}
@@ -45,8 +45,9 @@ import "errors"
 //	fs.inotify.max_user_watches=124983
 //	fs.inotify.max_user_instances=128
 //
-// Reaching the limit will result in a "no space left on device" or "too many open
-// files" error.
+// Adding a path after reaching the watch limit returns [ErrTooManyWatches], and
+// reaching the instance limit results in a "too many open files" error from
+// NewWatcher.
 //
 // # kqueue notes (macOS, BSD)
 //
@@ -68,6 +69,15 @@ import "errors"
 // to work with SMB filesystems. If you have many events in quick succession
 // this may not be enough, and you will have to use [WithBufferSize] to increase
 // the value.
+//
+// Paths longer than MAX_PATH (260 characters) can be watched; they're converted
+// to extended-length paths ("\\\\?\\C:\\path") internally. Event.Name never has
+// the "\\\\?\\" prefix, even if the path was added with it.
+//
+// All Watchers share one I/O completion port, which a few goroutines read
+// events from. A Watcher whose Events channel isn't read (with the default
+// [WithBackpressure]) holds up one of these, and can delay events for other
+// Watchers.
 type Watcher struct {
 	// Events sends the filesystem change events.
 	//
@@ -114,17 +124,132 @@ type Watcher struct {
 	//  - inotify: there are too many queued events (fs.inotify.max_queued_events sysctl)
 	//  - windows: The buffer size is too small; [WithBufferSize] can be used to increase it.
 	//  - kqueue, fen: not used.
+	//
+	// [Watcher.OnOverflow] can be used to be notified right away.
 	Errors chan error
+
+	with      withOpts
+	delivery  *delivery
+	stats     *stats
+	files     *fileWatches
+	oneShots  *oneShots
+	pendings  *pendings
+	attrs     *attrs
+	chmod     *chmods
+	sums      *checksums
+	diffs     *contents
+	callback  *eventFunc
+	subs      *subscriptions
+	scan      *scanner
+	spec      *appliedSpec
+	reconcile *reconciler
+	contexts  *watchContexts
 }
 
+// The Ops that correspond to the portable operations; these are different on
+// Linux, where events have the raw inotify mask set as the Op.
+const (
+	opCreate = Create
+	opWrite  = Write
+	opRemove = Remove
+	opRename = Rename
+	opChmod  = Chmod
+)
+
+// The operations that can be enabled with WithOps.
+const supportedOps Op = 0
+
+// The operations that can be sent (see Watcher.Supports).
+const sentOps Op = 0
+
 // NewWatcher creates a new Watcher.
 func NewWatcher() (*Watcher, error) {
 	return nil, errors.New("fsnotify not supported on the current platform")
 }
 
+// NewWatcherWith is like [NewWatcher], but allows passing options.
+//
+// Possible options are:
+//
+//   - [WithEventChannelSize] sets the capacity of the Events channel. The
+//     default is 0 (unbuffered), or 50 on Windows.
+//
+//   - [WithRootRelativeNames] makes Event.Name relative to the path passed to
+//     Add(), which is set in Event.Root.
+//
+//   - [WithRelativeTo] makes Event.Name relative to a directory, which is set
+//     in Event.Root.
+//
+//   - [WithBackpressure] sets what to do if the Events channel is full. The
+//     default is to block.
+//
+//   - [WithChmod] enables or disables Chmod events, making them consistent
+//     across platforms. The default is to send what the platform reports.
+//
+//   - [WithCallback] calls a function for every event instead of sending it
+//     on the Events channel, without allocating memory for every event.
+//
+//   - [WithJournal] appends every event to a journal file.
+//
+//   - [WithChecksum] only sends Write events if the contents of the file
+//     changed.
+//
+//   - [WithContentDiff] sets what changed in the file on Write events.
+//
+//   - [WithNameEncoding] sets how names that aren't valid UTF-8 are sent.
+//
+//   - [WithUnicodeNorm] normalizes names to a Unicode normalization form,
+//     such as NFC.
+//
+//   - [WithCaseInsensitivePaths] matches paths passed to Add and Remove
+//     regardless of case on case-insensitive volumes.
+//
+//   - [WithAbsolutePaths] makes Event.Name an absolute path with symlinks
+//     resolved.
+//
+//   - [WithAttrChanges] sets which attributes changed on Chmod events.
+//
+//   - [WithExcludeUnlinked] drops events for files that were removed but are
+//     still open.
+//
+//   - [WithSharedInstance] shares one inotify instance between Watchers on
+//     Linux.
+//
+//   - [WithIOUring] reads inotify events with io_uring on Linux; this is
+//     experimental.
+//
+//   - [WithReconcile] rescans all watched paths periodically, and sends
+//     events for changes that the system didn't report.
+//
+//   - [WithFilePolling] only opens directories on kqueue, and polls files for
+//     changes.
+//
+//   - [WithBufferSize] sets the size of the buffer inotify events are read
+//     into on Linux, and how many kevents are read at once on kqueue. The
+//     default is 64K (65536 bytes).
+func NewWatcherWith(opts ...addOpt) (*Watcher, error) { return NewWatcher() }
+
 // Close removes all watches and closes the events channel.
+//
+// Remove and Rename events that were already read from the system are sent
+// before the Events channel is closed, even if other events are dropped because
+// of [WithBackpressure]. Close waits up to a second for these to be read.
 func (w *Watcher) Close() error { return nil }
 
+// DroppedEvents returns the number of events that were dropped because of the
+// backpressure policy set with [WithBackpressure].
+func (w *Watcher) DroppedEvents() uint64 { return 0 }
+
+// PathStats returns how much memory is used to store the watched paths.
+func (w *Watcher) PathStats() PathStats { return flatPathStats(w.WatchList()) }
+
+// AddMount starts monitoring all files and directories on the mount that path
+// is on; this is only supported on Linux, and returns [ErrUnsupported] on other
+// platforms.
+func (w *Watcher) AddMount(path string) error { return nil }
+
+func (w *Watcher) mountPoints() []string { return nil }
+
 // WatchList returns all paths added with [Add] (and are not yet removed).
 //
 // Returns nil if [Watcher.Close] was called.
@@ -141,7 +266,10 @@ func (w *Watcher) WatchList() []string { return nil }
 // watcher on renames.
 //
 // Notifications on network filesystems (NFS, SMB, FUSE, etc.) or special
-// filesystems (/proc, /sys, etc.) generally don't work.
+// filesystems (/proc, /sys, etc.) generally don't work. The exception is
+// network shares on Windows, which are polled if the server doesn't send
+// notifications; a [NetworkWatch] is sent on the Errors channel to tell which
+// is used.
 //
 // Returns [ErrClosed] if [Watcher.Close] was called.
 //
@@ -173,9 +301,35 @@ func (w *Watcher) Add(name string) error { return nil }
 // Possible options are:
 //
 //   - [WithBufferSize] sets the buffer size for the Windows backend; no-op on
-//     other platforms. The default is 64K (65536 bytes).
+//     other platforms, where it's passed to NewWatcherWith instead. The
+//     default is 64K (65536 bytes).
+//   - [WithWindowsFilters] sets the ReadDirectoryChangesW notify filters for
+//     the Windows backend; no-op on other platforms.
+//   - [WithRetarget] moves the watch if the target of a symlink changes;
+//     Linux only.
+//   - [WithFollowSymlinks] sets whether to watch the target of a symlink or
+//     the symlink itself. The default is to watch the target.
+//   - [WithMaxDepth] limits how many levels of subdirectories a recursive
+//     watch descends. The default is unlimited.
+//   - [WithContinueOnError] skips subdirectories of a recursive watch that
+//     can't be read or watched, rather than failing.
+//   - [WithWalkWorkers] sets how many directories are read at the same time
+//     for a recursive watch.
+//   - [WithProgress] reports how many directories of a recursive watch were
+//     found and watched so far.
+//   - [WithInitialScan] sends a Create event for every existing file after
+//     the path is added.
+//   - [WithOneShot] removes the watch after the first event is sent.
+//   - [WithContext] removes the watch when the context is done.
+//   - [WithTTL] removes the watch after a duration.
+//   - [WithOnlyDir] returns an error if the path isn't a directory.
+//   - [WithOps] enables the Open, Read, Close, and Xattr operations.
+//   - [WithReArm] adds the path again if it's removed and recreated.
 func (w *Watcher) AddWith(name string, opts ...addOpt) error { return nil }
 
+func (w *Watcher) sendSynthetic(name string, op Op, isDir bool) bool { return false }
+func (w *Watcher) sendError(err error) bool                          { return false }
+
 // Remove stops monitoring the path for changes.
 //
 // If the path was added as a recursive watch (e.g. as "/tmp/dir/...") then the
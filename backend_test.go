@@ -0,0 +1,27 @@
+package fsnotify
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewWatcherWithBackend(t *testing.T) {
+	w, err := NewWatcherWithBackend(Backend(defaultBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Backend() != Backend(defaultBackend()) {
+		t.Errorf("Backend() = %q; want %q", w.Backend(), defaultBackend())
+	}
+
+	other := BackendInotify
+	if defaultBackend() == "inotify" {
+		other = BackendKqueue
+	}
+	_, err = NewWatcherWithBackend(other)
+	var unsup ErrUnsupported
+	if !errors.As(err, &unsup) || unsup.Backend != defaultBackend() {
+		t.Errorf("wrong error for %q: %v", other, err)
+	}
+}
@@ -16,11 +16,12 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
-	"runtime"
 	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
+	"github.com/hohodqr/fsnotify/internal"
 	"golang.org/x/sys/windows"
 )
 
@@ -61,8 +62,9 @@ import (
 //	fs.inotify.max_user_watches=124983
 //	fs.inotify.max_user_instances=128
 //
-// Reaching the limit will result in a "no space left on device" or "too many open
-// files" error.
+// Adding a path after reaching the watch limit returns [ErrTooManyWatches], and
+// reaching the instance limit results in a "too many open files" error from
+// NewWatcher.
 //
 // # kqueue notes (macOS, BSD)
 //
@@ -84,6 +86,15 @@ import (
 // to work with SMB filesystems. If you have many events in quick succession
 // this may not be enough, and you will have to use [WithBufferSize] to increase
 // the value.
+//
+// Paths longer than MAX_PATH (260 characters) can be watched; they're converted
+// to extended-length paths ("\\\\?\\C:\\path") internally. Event.Name never has
+// the "\\\\?\\" prefix, even if the path was added with it.
+//
+// All Watchers share one I/O completion port, which a few goroutines read
+// events from. A Watcher whose Events channel isn't read (with the default
+// [WithBackpressure]) holds up one of these, and can delay events for other
+// Watchers.
 type Watcher struct {
 	// Events sends the filesystem change events.
 	//
@@ -130,32 +141,160 @@ type Watcher struct {
 	//  - inotify: there are too many queued events (fs.inotify.max_queued_events sysctl)
 	//  - windows: The buffer size is too small; [WithBufferSize] can be used to increase it.
 	//  - kqueue, fen: not used.
+	//
+	// [Watcher.OnOverflow] can be used to be notified right away.
 	Errors chan error
 
-	port  windows.Handle // Handle to completion port
-	input chan *input    // Inputs to the reader are sent on this channel
-	quit  chan chan<- error
-
-	mu      sync.Mutex // Protects access to watches, closed
-	watches watchMap   // Map of watches (key: i-number)
-	closed  bool       // Set to true when Close() is first called
+	port *completionPort // Completion port shared by all Watchers
+	done chan struct{}   // Closed when Close() is called, to stop WithInitialScan scans
+
+	// Held while adding or removing watches and handling their events; the
+	// completion port workers handle events for many Watchers, but only one
+	// at a time for every Watcher.
+	io sync.Mutex
+
+	mu        sync.Mutex          // Protects access to watches, closed
+	watches   watchMap            // Map of watches (key: i-number)
+	noFollow  map[string]struct{} // Symlinks to directories watched as the symlink (see WithFollowSymlinks)
+	closed    bool                // Set to true when Close() is first called
+	with      withOpts            // Options passed to NewWatcherWith()
+	delivery  *delivery           // Non-blocking event delivery (see WithBackpressure)
+	stats     *stats              // Counters for Stats()
+	files     *fileWatches        // Files added with AddFile and FollowRotation
+	oneShots  *oneShots           // WithOneShot watches
+	attrs     *attrs              // Attribute changes (see WithAttrChanges)
+	pendings  *pendings           // AddPending paths
+	chmod     *chmods             // Chmod filtering and polling (see WithChmod)
+	sums      *checksums          // Write filtering (see WithChecksum)
+	diffs     *contents           // Event.Change (see WithContentDiff)
+	callback  *eventFunc          // Called instead of sending on Events (see WithCallback)
+	subs      *subscriptions      // Subscriptions from SubscribeCtx()
+	scan      *scanner            // Synthetic events from WithInitialScan and CatchUp
+	spec      *appliedSpec        // Watches added with ApplySpec()
+	reconcile *reconciler         // Rescanning (see WithReconcile)
+	contexts  *watchContexts      // Removed when done (see WithContext)
+	netPoll   *netPoller          // Network shares without notifications (see NetworkWatch)
 }
 
 // NewWatcher creates a new Watcher.
-func NewWatcher() (*Watcher, error) {
-	port, err := windows.CreateIoCompletionPort(windows.InvalidHandle, 0, 0, 0)
+func NewWatcher() (*Watcher, error) { return NewWatcherWith() }
+
+// NewWatcherWith is like [NewWatcher], but allows passing options.
+//
+// Possible options are:
+//
+//   - [WithEventChannelSize] sets the capacity of the Events channel. The
+//     default is 0 (unbuffered), or 50 on Windows.
+//
+//   - [WithRootRelativeNames] makes Event.Name relative to the path passed to
+//     Add(), which is set in Event.Root.
+//
+//   - [WithRelativeTo] makes Event.Name relative to a directory, which is set
+//     in Event.Root.
+//
+//   - [WithBackpressure] sets what to do if the Events channel is full. The
+//     default is to block.
+//
+//   - [WithChmod] enables or disables Chmod events, making them consistent
+//     across platforms. The default is to send what the platform reports.
+//
+//   - [WithCallback] calls a function for every event instead of sending it
+//     on the Events channel, without allocating memory for every event.
+//
+//   - [WithJournal] appends every event to a journal file.
+//
+//   - [WithChecksum] only sends Write events if the contents of the file
+//     changed.
+//
+//   - [WithContentDiff] sets what changed in the file on Write events.
+//
+//   - [WithNameEncoding] sets how names that aren't valid UTF-8 are sent.
+//
+//   - [WithUnicodeNorm] normalizes names to a Unicode normalization form,
+//     such as NFC.
+//
+//   - [WithCaseInsensitivePaths] matches paths passed to Add and Remove
+//     regardless of case on case-insensitive volumes.
+//
+//   - [WithAbsolutePaths] makes Event.Name an absolute path with symlinks
+//     resolved.
+//
+//   - [WithAttrChanges] sets which attributes changed on Chmod events.
+//
+//   - [WithExcludeUnlinked] drops events for files that were removed but are
+//     still open.
+//
+//   - [WithSharedInstance] shares one inotify instance between Watchers on
+//     Linux.
+//
+//   - [WithIOUring] reads inotify events with io_uring on Linux; this is
+//     experimental.
+//
+//   - [WithReconcile] rescans all watched paths periodically, and sends
+//     events for changes that the system didn't report.
+//
+//   - [WithFilePolling] only opens directories on kqueue, and polls files for
+//     changes.
+//
+//   - [WithBufferSize] sets the size of the buffer inotify events are read
+//     into on Linux, and how many kevents are read at once on kqueue. The
+//     default is 64K (65536 bytes).
+func NewWatcherWith(opts ...addOpt) (*Watcher, error) {
+	with := getOptions(opts...)
+	if with.eventsSize == 0 {
+		with.eventsSize = 50
+	}
+	if err := checkPlatformOpts(with, "windows", true); err != nil {
+		return nil, err
+	}
+
+	port, err := acquirePort()
 	if err != nil {
-		return nil, os.NewSyscallError("CreateIoCompletionPort", err)
+		return nil, err
 	}
 	w := &Watcher{
-		port:    port,
-		watches: make(watchMap),
-		input:   make(chan *input, 1),
-		Events:  make(chan Event, 50),
-		Errors:  make(chan error),
-		quit:    make(chan chan<- error, 1),
-	}
-	go w.readEvents()
+		port:      port,
+		watches:   make(watchMap),
+		noFollow:  make(map[string]struct{}),
+		Events:    make(chan Event, with.eventsSize),
+		Errors:    make(chan error),
+		done:      make(chan struct{}),
+		with:      with,
+		delivery:  newDelivery(with),
+		stats:     newStats(),
+		files:     newFileWatches(),
+		oneShots:  newOneShots(),
+		attrs:     newAttrs(with),
+		pendings:  newPendings(),
+		chmod:     newChmods(with),
+		sums:      newChecksums(with),
+		diffs:     newContents(with),
+		callback:  newEventFunc(with),
+		subs:      newSubscriptions(with),
+		scan:      newScanner(),
+		spec:      newAppliedSpec(),
+		reconcile: newReconciler(with),
+		contexts:  newWatchContexts(),
+		netPoll:   newNetPoller(),
+	}
+	// Attribute changes aren't reported reliably; poll for them.
+	w.chmod.poll(w.WatchList, func(e Event) bool {
+		if w.with.rootRelative {
+			e = e.rootRelative(w.rootOf(e.Name))
+		}
+		e, ok := w.filter(e)
+		if ok && w.subs.send(e, false) {
+			w.delivery.sent(e)
+			return true
+		}
+		if ok && w.callback != nil {
+			w.callback.call(e)
+			w.delivery.sent(e)
+			return true
+		}
+		return !ok || w.chmod.send(w.Events, w.delivery, e)
+	})
+	w.reconcile.run(w.WatchList, w.sendSynthetic)
 	return w, nil
 }
 
@@ -171,41 +310,113 @@ func (w *Watcher) sendEvent(name string, mask uint64) bool {
 	}
 
 	event := w.newEvent(name, uint32(mask))
-	select {
-	case ch := <-w.quit:
-		w.quit <- ch
-	case w.Events <- event:
+	if w.with.rootRelative {
+		event = event.rootRelative(w.rootOf(name))
 	}
-	return true
+	event, ok := w.filter(event)
+	if !ok {
+		return true
+	}
+	if w.subs.send(event, w.callback != nil) {
+		w.delivery.sent(event)
+		return true
+	}
+	if w.callback != nil {
+		w.callback.call(event)
+		w.delivery.sent(event)
+		return true
+	}
+	if !w.delivery.blocking() {
+		return w.delivery.send(w.Events, event)
+	}
+	return w.delivery.sendBlocking(w.Events, event, w.done)
+}
+
+// rootOf gets the path passed to Add() that name belongs to: the file itself if
+// it's watched directly, or else the closest watched (recursive) directory.
+func (w *Watcher) rootOf(name string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var root string
+	for _, index := range w.watches {
+		for _, watch := range index {
+			if _, ok := watch.names[filepath.Base(name)]; ok && filepath.Join(watch.path, filepath.Base(name)) == name {
+				return name
+			}
+			if watch.mask == 0 || len(watch.path) <= len(root) {
+				continue
+			}
+			if name == watch.path ||
+				filepath.Dir(name) == watch.path ||
+				(watch.recurse && strings.HasPrefix(name, watch.path+string(filepath.Separator))) {
+				root = watch.path
+			}
+		}
+	}
+	if root == "" {
+		return w.netPoll.rootOf(name)
+	}
+	return root
 }
 
 // Returns true if the error was sent, or false if watcher is closed.
 func (w *Watcher) sendError(err error) bool {
+	w.stats.error(err)
 	select {
 	case w.Errors <- err:
 		return true
-	case <-w.quit:
+	case <-w.done:
 	}
 	return false
 }
 
 // Close removes all watches and closes the events channel.
+//
+// Remove and Rename events that were already read from the system are sent
+// before the Events channel is closed, even if other events are dropped because
+// of [WithBackpressure]. Close waits up to a second for these to be read.
 func (w *Watcher) Close() error {
 	if w.isClosed() {
 		return nil
 	}
 
 	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
 	w.closed = true
+	w.delivery.stopping()
+	close(w.done)
 	w.mu.Unlock()
 
-	// Send "quit" message to the reader goroutine
-	ch := make(chan error)
-	w.quit <- ch
-	if err := w.wakeupReader(); err != nil {
-		return err
+	w.io.Lock()
+	w.mu.Lock()
+	var indexes []indexMap
+	for _, index := range w.watches {
+		indexes = append(indexes, index)
+	}
+	w.mu.Unlock()
+	for _, index := range indexes {
+		for _, watch := range index {
+			w.deleteWatch(watch)
+			w.startRead(watch)
+		}
 	}
-	return <-ch
+	w.chmod.stop()
+	w.reconcile.stop()
+	w.contexts.stop()
+	w.netPoll.stop()
+	w.scan.wait()
+	w.subs.close()
+	w.delivery.close()
+	w.delivery.sendKept(w.Events)
+	close(w.Events)
+	close(w.Errors)
+	w.io.Unlock()
+
+	return w.port.release()
 }
 
 // Add starts monitoring the path for changes.
@@ -219,7 +430,10 @@ func (w *Watcher) Close() error {
 // watcher on renames.
 //
 // Notifications on network filesystems (NFS, SMB, FUSE, etc.) or special
-// filesystems (/proc, /sys, etc.) generally don't work.
+// filesystems (/proc, /sys, etc.) generally don't work. The exception is
+// network shares on Windows, which are polled if the server doesn't send
+// notifications; a [NetworkWatch] is sent on the Errors channel to tell which
+// is used.
 //
 // Returns [ErrClosed] if [Watcher.Close] was called.
 //
@@ -251,29 +465,98 @@ func (w *Watcher) Add(name string) error { return w.AddWith(name) }
 // Possible options are:
 //
 //   - [WithBufferSize] sets the buffer size for the Windows backend; no-op on
-//     other platforms. The default is 64K (65536 bytes).
-func (w *Watcher) AddWith(name string, opts ...addOpt) error {
+//     other platforms, where it's passed to NewWatcherWith instead. The
+//     default is 64K (65536 bytes).
+//   - [WithWindowsFilters] sets the ReadDirectoryChangesW notify filters for
+//     the Windows backend; no-op on other platforms.
+//   - [WithRetarget] moves the watch if the target of a symlink changes;
+//     Linux only.
+//   - [WithFollowSymlinks] sets whether to watch the target of a symlink or
+//     the symlink itself. The default is to watch the target.
+//   - [WithMaxDepth] limits how many levels of subdirectories a recursive
+//     watch descends. The default is unlimited.
+//   - [WithContinueOnError] skips subdirectories of a recursive watch that
+//     can't be read or watched, rather than failing.
+//   - [WithWalkWorkers] sets how many directories are read at the same time
+//     for a recursive watch.
+//   - [WithProgress] reports how many directories of a recursive watch were
+//     found and watched so far.
+//   - [WithInitialScan] sends a Create event for every existing file after
+//     the path is added.
+//   - [WithOneShot] removes the watch after the first event is sent.
+//   - [WithContext] removes the watch when the context is done.
+//   - [WithTTL] removes the watch after a duration.
+//   - [WithOnlyDir] returns an error if the path isn't a directory.
+//   - [WithOps] enables the Open, Read, Close, and Xattr operations.
+//   - [WithReArm] adds the path again if it's removed and recreated.
+func (w *Watcher) AddWith(name string, opts ...addOpt) (err error) {
 	if w.isClosed() {
 		return ErrClosed
 	}
 
+	name = w.addPath(name, opts)
+	defer func() { w.spec.addUser(name, opts, err) }()
+	w.pendings.added(name, opts)
 	with := getOptions(opts...)
+	if w.reconcile.polling() {
+		return w.reconcile.add(name, with)
+	}
 	if with.bufsize < 4096 {
 		return fmt.Errorf("fsnotify.WithBufferSize: buffer size cannot be smaller than 4096 bytes")
 	}
+	if with.retarget {
+		return ErrUnsupported{Feature: "WithRetarget", Backend: "windows"}
+	}
+	if with.ops != 0 {
+		return ErrUnsupported{Feature: "WithOps", Backend: "windows"}
+	}
+	if with.windowsFilters&^notifyFilterAll != 0 {
+		return fmt.Errorf("fsnotify.WithWindowsFilters: unknown filters: 0x%x", with.windowsFilters&^notifyFilterAll)
+	}
+	if with.onlyDir {
+		root, _ := recursivePath(name)
+		if err := checkDir(root, with.noFollow); err != nil {
+			return err
+		}
+	}
 
 	in := &input{
-		op:      opAddWatch,
-		path:    filepath.Clean(name),
-		flags:   sysFSALLEVENTS,
-		reply:   make(chan error),
-		bufsize: with.bufsize,
-	}
-	w.input <- in
-	if err := w.wakeupReader(); err != nil {
+		path:     filepath.Clean(windowsShortPath(name)),
+		flags:    sysFSALLEVENTS,
+		bufsize:  with.bufsize,
+		noFollow: with.noFollow,
+		maxDepth: with.maxDepth,
+		filters:  with.windowsFilters,
+	}
+	w.io.Lock()
+	if w.isClosed() {
+		w.io.Unlock()
+		return ErrClosed
+	}
+	err = w.addWatch(in)
+	w.io.Unlock()
+	if err != nil {
 		return err
 	}
-	return <-in.reply
+	w.oneShots.set(in.path, with.oneShot)
+	w.contexts.set(in.path, with, w.Remove, w.sendExpired)
+	w.attrs.set(in.path, with.ops.Has(Xattr))
+	w.initialScan(in.path, with)
+	return nil
+}
+
+// sendSynthetic sends an event that didn't come from the kernel, for
+// WithInitialScan, CatchUp, and WithReconcile; op is Create, Write, or Remove.
+func (w *Watcher) sendSynthetic(name string, op Op, isDir bool) bool {
+	switch op {
+	case Create:
+		return w.sendEvent(name, sysFSCREATE)
+	case Write:
+		return w.sendEvent(name, sysFSMODIFY)
+	case Remove:
+		return w.sendEvent(name, sysFSDELETE)
+	}
+	return false
 }
 
 // Remove stops monitoring the path for changes.
@@ -296,16 +579,26 @@ func (w *Watcher) Remove(name string) error {
 		return nil
 	}
 
-	in := &input{
-		op:    opRemoveWatch,
-		path:  filepath.Clean(name),
-		reply: make(chan error),
+	name = w.casePath(name)
+	w.oneShots.clear(filepath.Clean(windowsShortPath(name)))
+	w.contexts.clear(filepath.Clean(windowsShortPath(name)))
+	w.attrs.clear(filepath.Clean(windowsShortPath(name)))
+	w.spec.removeUser(name)
+	if w.reconcile.polling() {
+		return w.reconcile.remove(name)
 	}
-	w.input <- in
-	if err := w.wakeupReader(); err != nil {
-		return err
+	if w.removePending(windowsShortPath(name)) {
+		return nil
+	}
+	if w.netPoll.remove(filepath.Clean(windowsShortPath(name))) {
+		return nil
+	}
+	w.io.Lock()
+	defer w.io.Unlock()
+	if w.isClosed() {
+		return nil
 	}
-	return <-in.reply
+	return w.remWatch(filepath.Clean(windowsShortPath(name)))
 }
 
 // WatchList returns all paths added with [Add] (and are not yet removed).
@@ -315,6 +608,9 @@ func (w *Watcher) WatchList() []string {
 	if w.isClosed() {
 		return nil
 	}
+	if w.reconcile.polling() {
+		return w.reconcile.list()
+	}
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -326,7 +622,7 @@ func (w *Watcher) WatchList() []string {
 		}
 	}
 
-	return entries
+	return append(entries, w.netPoll.list()...)
 }
 
 // These options are from the old golang.org/x/exp/winfsnotify, where you could
@@ -346,8 +642,15 @@ const (
 	sysFSMOVEDTO    = 0x80
 	sysFSMOVESELF   = 0x800
 	sysFSIGNORED    = 0x8000
+	sysFSUNMOUNT    = 0x2000
 )
 
+// notifyFilterAll is all the filters that can be set with WithWindowsFilters.
+const notifyFilterAll = windows.FILE_NOTIFY_CHANGE_FILE_NAME | windows.FILE_NOTIFY_CHANGE_DIR_NAME |
+	windows.FILE_NOTIFY_CHANGE_ATTRIBUTES | windows.FILE_NOTIFY_CHANGE_SIZE |
+	windows.FILE_NOTIFY_CHANGE_LAST_WRITE | windows.FILE_NOTIFY_CHANGE_LAST_ACCESS |
+	windows.FILE_NOTIFY_CHANGE_CREATION | windows.FILE_NOTIFY_CHANGE_SECURITY
+
 func (w *Watcher) newEvent(name string, mask uint32) Event {
 	e := Event{Name: name}
 	if mask&sysFSCREATE == sysFSCREATE || mask&sysFSMOVEDTO == sysFSMOVEDTO {
@@ -362,24 +665,45 @@ func (w *Watcher) newEvent(name string, mask uint32) Event {
 	if mask&sysFSMOVE == sysFSMOVE || mask&sysFSMOVESELF == sysFSMOVESELF || mask&sysFSMOVEDFROM == sysFSMOVEDFROM {
 		e.Op |= Rename
 	}
+	if mask&sysFSUNMOUNT == sysFSUNMOUNT {
+		e.Op |= Unmount
+	}
+	// ReadDirectoryChangesW doesn't say if it's a directory; check new paths,
+	// and use the known paths for everything else (see reconciler.markDir).
+	if e.Op&Create != 0 {
+		e = statDir(e)
+	}
 	return e
 }
 
+// The Ops that correspond to the portable operations; these are different on
+// Linux, where events have the raw inotify mask set as the Op.
 const (
-	opAddWatch = iota
-	opRemoveWatch
+	opCreate = Create
+	opWrite  = Write
+	opRemove = Remove
+	opRename = Rename
+	opChmod  = Chmod
 )
 
+// The operations that can be enabled with WithOps.
+const supportedOps Op = 0
+
+// The operations that can be sent (see Watcher.Supports).
+const sentOps = opCreate | opWrite | opRemove | opRename | opChmod | supportedOps | Rotated | Handover | Unmount | Expired
+
 const (
 	provisional uint64 = 1 << (32 + iota)
 )
 
+// input is a path to add with the options for it.
 type input struct {
-	op      int
-	path    string
-	flags   uint32
-	bufsize int
-	reply   chan error
+	path     string
+	flags    uint32
+	bufsize  int
+	noFollow bool
+	maxDepth int
+	filters  uint32
 }
 
 type inode struct {
@@ -389,14 +713,33 @@ type inode struct {
 }
 
 type watch struct {
-	ov      windows.Overlapped
-	ino     *inode            // i-number
-	recurse bool              // Recursive watch?
-	path    string            // Directory path
-	mask    uint64            // Directory itself is being watched with these notify flags
-	names   map[string]uint64 // Map of names being watched and their notify flags
-	rename  string            // Remembers the old name while renaming a file
-	buf     []byte            // buffer, allocated later
+	// Must be first, as the Overlapped from a completion is cast to the watch.
+	ov       windows.Overlapped
+	w        *Watcher          // Watcher the watch belongs to
+	ino      *inode            // i-number
+	recurse  bool              // Recursive watch?
+	maxDepth int               // WithMaxDepth for recursive watches.
+	filters  uint32            // WithWindowsFilters; 0 for the default.
+	path     string            // Directory path
+	mask     uint64            // Directory itself is being watched with these notify flags
+	names    map[string]uint64 // Map of names being watched and their notify flags
+	rename   string            // Remembers the old name while renaming a file
+	buf      []byte            // buffer, allocated later
+	network  bool              // On a network share.
+	retries  int               // Attempts to reconnect since the last completed read.
+}
+
+// isProvisional reports if the watch is still being added.
+func (watch *watch) isProvisional() bool {
+	if watch.mask&provisional != 0 {
+		return true
+	}
+	for _, m := range watch.names {
+		if m&provisional != 0 {
+			return true
+		}
+	}
+	return false
 }
 
 type (
@@ -404,20 +747,18 @@ type (
 	watchMap map[uint32]indexMap
 )
 
-func (w *Watcher) wakeupReader() error {
-	err := windows.PostQueuedCompletionStatus(w.port, 0, 0, nil)
-	if err != nil {
-		return os.NewSyscallError("PostQueuedCompletionStatus", err)
-	}
-	return nil
-}
-
 func (w *Watcher) getDir(pathname string) (dir string, err error) {
-	attr, err := windows.GetFileAttributes(windows.StringToUTF16Ptr(pathname))
+	attr, err := windows.GetFileAttributes(windows.StringToUTF16Ptr(longPath(pathname)))
 	if err != nil {
 		return "", os.NewSyscallError("GetFileAttributes", err)
 	}
-	if attr&windows.FILE_ATTRIBUTE_DIRECTORY != 0 {
+	w.mu.Lock()
+	_, noFollow := w.noFollow[pathname]
+	w.mu.Unlock()
+	if noFollow && attr&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0 {
+		// Watch the symlink itself in the parent directory, like a file.
+		dir = filepath.Dir(pathname)
+	} else if attr&windows.FILE_ATTRIBUTE_DIRECTORY != 0 {
 		dir = pathname
 	} else {
 		dir, _ = filepath.Split(pathname)
@@ -426,8 +767,14 @@ func (w *Watcher) getDir(pathname string) (dir string, err error) {
 	return
 }
 
+// closeDir closes a directory handle from getIno().
+func closeDir(h windows.Handle) error {
+	internal.Closed("directory", h)
+	return windows.CloseHandle(h)
+}
+
 func (w *Watcher) getIno(path string) (ino *inode, err error) {
-	h, err := windows.CreateFile(windows.StringToUTF16Ptr(path),
+	h, err := windows.CreateFile(windows.StringToUTF16Ptr(longPath(path)),
 		windows.FILE_LIST_DIRECTORY,
 		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
 		nil, windows.OPEN_EXISTING,
@@ -435,11 +782,12 @@ func (w *Watcher) getIno(path string) (ino *inode, err error) {
 	if err != nil {
 		return nil, os.NewSyscallError("CreateFile", err)
 	}
+	internal.Opened("directory", h)
 
 	var fi windows.ByHandleFileInformation
 	err = windows.GetFileInformationByHandle(h, &fi)
 	if err != nil {
-		windows.CloseHandle(h)
+		closeDir(h)
 		return nil, os.NewSyscallError("GetFileInformationByHandle", err)
 	}
 	ino = &inode{
@@ -450,7 +798,7 @@ func (w *Watcher) getIno(path string) (ino *inode, err error) {
 	return ino, nil
 }
 
-// Must run within the I/O thread.
+// Must be called with Watcher.io held.
 func (m watchMap) get(ino *inode) *watch {
 	if i := m[ino.volume]; i != nil {
 		return i[ino.index]
@@ -458,7 +806,7 @@ func (m watchMap) get(ino *inode) *watch {
 	return nil
 }
 
-// Must run within the I/O thread.
+// Must be called with Watcher.io held.
 func (m watchMap) set(ino *inode, watch *watch) {
 	i := m[ino.volume]
 	if i == nil {
@@ -468,11 +816,22 @@ func (m watchMap) set(ino *inode, watch *watch) {
 	i[ino.index] = watch
 }
 
-// Must run within the I/O thread.
-func (w *Watcher) addWatch(pathname string, flags uint64, bufsize int) error {
-	pathname, recurse := recursivePath(pathname)
+// Must be called with w.io held.
+func (w *Watcher) addWatch(in *input) error {
+	var (
+		pathname, recurse = recursivePath(in.path)
+		flags             = uint64(in.flags)
+	)
+	if in.noFollow {
+		w.mu.Lock()
+		w.noFollow[pathname] = struct{}{}
+		w.mu.Unlock()
+	}
 	dir, err := w.getDir(pathname)
 	if err != nil {
+		w.mu.Lock()
+		delete(w.noFollow, pathname)
+		w.mu.Unlock()
 		return err
 	}
 
@@ -484,24 +843,32 @@ func (w *Watcher) addWatch(pathname string, flags uint64, bufsize int) error {
 	watchEntry := w.watches.get(ino)
 	w.mu.Unlock()
 	if watchEntry == nil {
-		_, err := windows.CreateIoCompletionPort(ino.handle, w.port, 0, 0)
+		_, err := windows.CreateIoCompletionPort(ino.handle, w.port.handle, 0, 0)
 		if err != nil {
-			windows.CloseHandle(ino.handle)
+			closeDir(ino.handle)
 			return os.NewSyscallError("CreateIoCompletionPort", err)
 		}
 		watchEntry = &watch{
+			w:       w,
 			ino:     ino,
 			path:    dir,
 			names:   make(map[string]uint64),
 			recurse: recurse,
-			buf:     make([]byte, bufsize),
+			buf:     make([]byte, in.bufsize),
+			network: isNetworkPath(dir),
+		}
+		if recurse {
+			watchEntry.maxDepth = in.maxDepth
 		}
 		w.mu.Lock()
 		w.watches.set(ino, watchEntry)
 		w.mu.Unlock()
 		flags |= provisional
 	} else {
-		windows.CloseHandle(ino.handle)
+		closeDir(ino.handle)
+	}
+	if in.filters != 0 {
+		watchEntry.filters = in.filters
 	}
 	if pathname == dir {
 		watchEntry.mask |= flags
@@ -511,6 +878,9 @@ func (w *Watcher) addWatch(pathname string, flags uint64, bufsize int) error {
 
 	err = w.startRead(watchEntry)
 	if err != nil {
+		if watchEntry.network && flags&provisional != 0 && notifyUnsupported(err) {
+			return w.addPoll(in, err)
+		}
 		return err
 	}
 
@@ -519,10 +889,132 @@ func (w *Watcher) addWatch(pathname string, flags uint64, bufsize int) error {
 	} else {
 		watchEntry.names[filepath.Base(pathname)] &= ^provisional
 	}
+	if watchEntry.network && flags&provisional != 0 {
+		w.warnNetwork(NetworkWatch{Path: in.path, Mode: "notify"})
+	}
+	return nil
+}
+
+// isNetworkPath reports if the path is on a network share.
+func isNetworkPath(path string) bool {
+	if isUNC(path) {
+		return true
+	}
+	_, network, _ := fsType(path)
+	return network
+}
+
+// notifyUnsupported reports if the error from ReadDirectoryChangesW means the
+// network share doesn't support it. Some servers also fail with
+// ERROR_INVALID_PARAMETER if the buffer is larger than 64K.
+func notifyUnsupported(err error) bool {
+	return errors.Is(err, windows.ERROR_INVALID_FUNCTION) ||
+		errors.Is(err, windows.ERROR_NOT_SUPPORTED) ||
+		errors.Is(err, windows.ERROR_INVALID_PARAMETER)
+}
+
+// isNetworkError reports if the error means the connection to a network share
+// was lost, which may be temporary.
+func isNetworkError(err error) bool {
+	switch err {
+	case windows.ERROR_NETNAME_DELETED, windows.ERROR_UNEXP_NET_ERR, windows.ERROR_BAD_NETPATH,
+		windows.ERROR_NETWORK_UNREACHABLE, windows.ERROR_SEM_TIMEOUT:
+		return true
+	}
+	return false
+}
+
+// warnNetwork sends a NetworkWatch warning in the background, as adding a watch
+// shouldn't block until it's read from the Errors channel. Must be called with
+// w.io held.
+func (w *Watcher) warnNetwork(warn NetworkWatch) {
+	w.scan.run(func() { w.sendError(warn) })
+}
+
+// addPoll polls the path, after the network share it's on didn't support
+// notifications. Must be called with w.io held.
+func (w *Watcher) addPoll(in *input, reason error) error {
+	if err := w.netPoll.add(in.path, w.sendSynthetic); err != nil {
+		return err
+	}
+	root, _ := recursivePath(in.path)
+	w.warnNetwork(NetworkWatch{Path: root, Mode: "poll", Err: reason})
 	return nil
 }
 
-// Must run within the I/O thread.
+// reconnect closes the directory of a watch on a network share after the
+// connection was lost, and tries to open it again in the background, with
+// netRetryDelay between attempts. Unmount events are sent if it can't be
+// opened after netRetries attempts. Must be called with w.io held.
+func (w *Watcher) reconnect(watch *watch, reason error) {
+	closeDir(watch.ino.handle)
+	w.mu.Lock()
+	delete(w.watches[watch.ino.volume], watch.ino.index)
+	w.mu.Unlock()
+	w.warnNetwork(NetworkWatch{Path: watch.path, Mode: "retry", Err: reason})
+
+	attempt := watch.retries
+	go func() {
+		for ; attempt < netRetries; attempt++ {
+			select {
+			case <-w.done:
+				return
+			case <-time.After(netRetryDelay(attempt)):
+			}
+
+			w.io.Lock()
+			if w.isClosed() {
+				w.io.Unlock()
+				return
+			}
+			watch.retries = attempt + 1
+			ok := w.reopen(watch)
+			w.io.Unlock()
+			if ok {
+				return
+			}
+		}
+
+		w.io.Lock()
+		defer w.io.Unlock()
+		if !w.isClosed() {
+			w.sendUnmount(watch)
+			w.deleteWatch(watch)
+		}
+	}()
+}
+
+// reopen opens the directory of a watch again after reconnecting, reporting if
+// it succeeded. Must be called with w.io held.
+func (w *Watcher) reopen(watch *watch) bool {
+	ino, err := w.getIno(watch.path)
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	exists := w.watches.get(ino) != nil
+	w.mu.Unlock()
+	if exists { // Added again in the meantime.
+		closeDir(ino.handle)
+		return true
+	}
+	if _, err := windows.CreateIoCompletionPort(ino.handle, w.port.handle, 0, 0); err != nil {
+		closeDir(ino.handle)
+		return false
+	}
+
+	watch.ino = ino
+	w.mu.Lock()
+	w.watches.set(ino, watch)
+	w.mu.Unlock()
+	w.warnNetwork(NetworkWatch{Path: watch.path, Mode: "notify"})
+	if err := w.startRead(watch); err != nil {
+		w.sendError(WatchError{Path: watch.path, Op: "read", Err: err})
+	}
+	return true
+}
+
+// Must be called with w.io held.
 func (w *Watcher) remWatch(pathname string) error {
 	pathname, recurse := recursivePath(pathname)
 
@@ -543,9 +1035,9 @@ func (w *Watcher) remWatch(pathname string) error {
 		return fmt.Errorf("can't use \\... with non-recursive watch %q", pathname)
 	}
 
-	err = windows.CloseHandle(ino.handle)
+	err = closeDir(ino.handle)
 	if err != nil {
-		w.sendError(os.NewSyscallError("CloseHandle", err))
+		w.sendError(WatchError{Path: pathname, Op: "remove", Err: os.NewSyscallError("CloseHandle", err)})
 	}
 	if watch == nil {
 		return fmt.Errorf("%w: %s", ErrNonExistentWatch, pathname)
@@ -558,11 +1050,14 @@ func (w *Watcher) remWatch(pathname string) error {
 		w.sendEvent(filepath.Join(watch.path, name), watch.names[name]&sysFSIGNORED)
 		delete(watch.names, name)
 	}
+	w.mu.Lock()
+	delete(w.noFollow, pathname)
+	w.mu.Unlock()
 
 	return w.startRead(watch)
 }
 
-// Must run within the I/O thread.
+// Must be called with w.io held.
 func (w *Watcher) deleteWatch(watch *watch) {
 	for name, mask := range watch.names {
 		if mask&provisional == 0 {
@@ -578,11 +1073,96 @@ func (w *Watcher) deleteWatch(watch *watch) {
 	}
 }
 
-// Must run within the I/O thread.
+// isUnmounted reports if the error means the volume was dismounted or removed,
+// or the network share was disconnected.
+func isUnmounted(err error) bool {
+	switch err {
+	case windows.ERROR_NETNAME_DELETED, windows.ERROR_DEV_NOT_EXIST, windows.ERROR_NOT_READY,
+		windows.ERROR_DEVICE_NOT_CONNECTED, windows.ERROR_UNRECOGNIZED_VOLUME:
+		return true
+	}
+	return false
+}
+
+// fileStandardInfo is FILE_STANDARD_INFO, which isn't in x/sys/windows.
+type fileStandardInfo struct {
+	AllocationSize int64
+	EndOfFile      int64
+	NumberOfLinks  uint32
+	DeletePending  bool
+	Directory      bool
+}
+
+// deletePending reports if the directory of the handle was removed. It's not
+// actually removed until all handles for it are closed, and a new directory
+// can't be created with the same name until then.
+func deletePending(h windows.Handle) bool {
+	var fi fileStandardInfo
+	err := windows.GetFileInformationByHandleEx(h, windows.FileStandardInfo,
+		(*byte)(unsafe.Pointer(&fi)), uint32(unsafe.Sizeof(fi)))
+	return err == nil && fi.DeletePending
+}
+
+// watchFor gets the watch for the directory path, or nil if it's not watched.
+func (w *Watcher) watchFor(path string) *watch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, index := range w.watches {
+		for _, watch := range index {
+			if watch.path == path {
+				return watch
+			}
+		}
+	}
+	return nil
+}
+
+// released closes the handle of a watch after its directory was removed, and
+// then sends the Remove events for it. The handle is closed first so that the
+// directory can be recreated right away, e.g. by WithReArm. Must be called with
+// w.io held.
+func (w *Watcher) released(watch *watch) {
+	windows.CancelIoEx(watch.ino.handle, nil)
+	if err := closeDir(watch.ino.handle); err != nil {
+		w.sendError(WatchError{Path: watch.path, Op: "remove", Err: os.NewSyscallError("CloseHandle", err)})
+	}
+	w.mu.Lock()
+	if w.watches.get(watch.ino) == watch {
+		delete(w.watches[watch.ino.volume], watch.ino.index)
+	}
+	w.mu.Unlock()
+
+	w.sendEvent(watch.path, watch.mask&sysFSDELETESELF)
+	w.deleteWatch(watch)
+}
+
+// unmounted sends Unmount for the paths of the watch and removes it, after its
+// volume was removed. Must be called with w.io held.
+func (w *Watcher) unmounted(watch *watch) {
+	w.sendUnmount(watch)
+	w.deleteWatch(watch)
+	w.startRead(watch)
+}
+
+// sendUnmount sends Unmount for the paths of the watch.
+func (w *Watcher) sendUnmount(watch *watch) {
+	for name, mask := range watch.names {
+		if mask&provisional == 0 {
+			w.sendEvent(filepath.Join(watch.path, name), sysFSUNMOUNT)
+		}
+	}
+	if watch.mask != 0 && watch.mask&provisional == 0 {
+		w.sendEvent(watch.path, sysFSUNMOUNT)
+	}
+}
+
+// Must be called with w.io held.
 func (w *Watcher) startRead(watch *watch) error {
-	err := windows.CancelIo(watch.ino.handle)
-	if err != nil {
-		w.sendError(os.NewSyscallError("CancelIo", err))
+	// CancelIoEx rather than CancelIo, as the read may have been started by
+	// another worker.
+	err := windows.CancelIoEx(watch.ino.handle, nil)
+	if err != nil && err != windows.ERROR_NOT_FOUND {
+		w.sendError(WatchError{Path: watch.path, Op: "read", Err: os.NewSyscallError("CancelIoEx", err)})
 		w.deleteWatch(watch)
 	}
 	mask := w.toWindowsFlags(watch.mask)
@@ -590,15 +1170,18 @@ func (w *Watcher) startRead(watch *watch) error {
 		mask |= w.toWindowsFlags(m)
 	}
 	if mask == 0 {
-		err := windows.CloseHandle(watch.ino.handle)
+		err := closeDir(watch.ino.handle)
 		if err != nil {
-			w.sendError(os.NewSyscallError("CloseHandle", err))
+			w.sendError(WatchError{Path: watch.path, Op: "remove", Err: os.NewSyscallError("CloseHandle", err)})
 		}
 		w.mu.Lock()
 		delete(w.watches[watch.ino.volume], watch.ino.index)
 		w.mu.Unlock()
 		return nil
 	}
+	if watch.filters != 0 {
+		mask = watch.filters
+	}
 
 	// We need to pass the array, rather than the slice.
 	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&watch.buf))
@@ -609,8 +1192,16 @@ func (w *Watcher) startRead(watch *watch) error {
 		err := os.NewSyscallError("ReadDirectoryChanges", rdErr)
 		if rdErr == windows.ERROR_ACCESS_DENIED && watch.mask&provisional == 0 {
 			// Watched directory was probably removed
-			w.sendEvent(watch.path, watch.mask&sysFSDELETESELF)
-			err = nil
+			w.released(watch)
+			return nil
+		}
+		if watch.network && isNetworkError(rdErr) && !watch.isProvisional() {
+			w.reconnect(watch, err)
+			return nil
+		}
+		if isUnmounted(rdErr) {
+			w.unmounted(watch)
+			return nil
 		}
 		w.deleteWatch(watch)
 		w.startRead(watch)
@@ -619,168 +1210,155 @@ func (w *Watcher) startRead(watch *watch) error {
 	return nil
 }
 
-// readEvents reads from the I/O completion port, converts the
-// received events into Event objects and sends them via the Events channel.
-// Entry point to the I/O thread.
-func (w *Watcher) readEvents() {
-	var (
-		n   uint32
-		key uintptr
-		ov  *windows.Overlapped
-	)
-	runtime.LockOSThread()
+// DroppedEvents returns the number of events that were dropped because of the
+// backpressure policy set with [WithBackpressure].
+func (w *Watcher) DroppedEvents() uint64 { return w.delivery.droppedEvents() }
 
-	for {
-		// This error is handled after the watch == nil check below.
-		qErr := windows.GetQueuedCompletionStatus(w.port, &n, &key, &ov, windows.INFINITE)
+// PathStats returns how much memory is used to store the watched paths.
+func (w *Watcher) PathStats() PathStats { return flatPathStats(w.WatchList()) }
 
-		watch := (*watch)(unsafe.Pointer(ov))
-		if watch == nil {
-			select {
-			case ch := <-w.quit:
-				w.mu.Lock()
-				var indexes []indexMap
-				for _, index := range w.watches {
-					indexes = append(indexes, index)
-				}
-				w.mu.Unlock()
-				for _, index := range indexes {
-					for _, watch := range index {
-						w.deleteWatch(watch)
-						w.startRead(watch)
-					}
-				}
+// AddMount starts monitoring all files and directories on the mount that path
+// is on; this is only supported on Linux, and returns [ErrUnsupported] on other
+// platforms.
+func (w *Watcher) AddMount(path string) error {
+	return ErrUnsupported{Feature: "AddMount", Backend: "windows"}
+}
 
-				err := windows.CloseHandle(w.port)
-				if err != nil {
-					err = os.NewSyscallError("CloseHandle", err)
-				}
-				close(w.Events)
-				close(w.Errors)
-				ch <- err
-				return
-			case in := <-w.input:
-				switch in.op {
-				case opAddWatch:
-					in.reply <- w.addWatch(in.path, uint64(in.flags), in.bufsize)
-				case opRemoveWatch:
-					in.reply <- w.remWatch(in.path)
-				}
-			default:
-			}
-			continue
-		}
-
-		switch qErr {
-		case nil:
-			// No error
-		case windows.ERROR_MORE_DATA:
-			if watch == nil {
-				w.sendError(errors.New("ERROR_MORE_DATA has unexpectedly null lpOverlapped buffer"))
-			} else {
-				// The i/o succeeded but the buffer is full.
-				// In theory we should be building up a full packet.
-				// In practice we can get away with just carrying on.
-				n = uint32(unsafe.Sizeof(watch.buf))
-			}
-		case windows.ERROR_ACCESS_DENIED:
-			// Watched directory was probably removed
-			w.sendEvent(watch.path, watch.mask&sysFSDELETESELF)
-			w.deleteWatch(watch)
-			w.startRead(watch)
-			continue
-		case windows.ERROR_OPERATION_ABORTED:
-			// CancelIo was called on this handle
-			continue
-		default:
-			w.sendError(os.NewSyscallError("GetQueuedCompletionPort", qErr))
-			continue
-		}
-
-		var offset uint32
-		for {
-			if n == 0 {
-				w.sendError(ErrEventOverflow)
-				break
-			}
+func (w *Watcher) mountPoints() []string { return nil }
+
+// handleCompletion converts the events from a completed read into Event
+// objects and sends them via the Events channel, and starts the next read. This
+// is called by the completion port workers.
+func (w *Watcher) handleCompletion(watch *watch, n uint32, qErr error) {
+	w.io.Lock()
+	defer w.io.Unlock()
+	if w.isClosed() {
+		return
+	}
 
-			// Point "raw" to the event in the buffer
-			raw := (*windows.FileNotifyInformation)(unsafe.Pointer(&watch.buf[offset]))
-
-			// Create a buf that is the size of the path name
-			size := int(raw.FileNameLength / 2)
-			var buf []uint16
-			// TODO: Use unsafe.Slice in Go 1.17; https://stackoverflow.com/questions/51187973
-			sh := (*reflect.SliceHeader)(unsafe.Pointer(&buf))
-			sh.Data = uintptr(unsafe.Pointer(&raw.FileName))
-			sh.Len = size
-			sh.Cap = size
-			name := windows.UTF16ToString(buf)
-			fullname := filepath.Join(watch.path, name)
-
-			var mask uint64
-			switch raw.Action {
-			case windows.FILE_ACTION_REMOVED:
-				mask = sysFSDELETESELF
-			case windows.FILE_ACTION_MODIFIED:
-				mask = sysFSMODIFY
-			case windows.FILE_ACTION_RENAMED_OLD_NAME:
-				watch.rename = name
-			case windows.FILE_ACTION_RENAMED_NEW_NAME:
-				// Update saved path of all sub-watches.
-				old := filepath.Join(watch.path, watch.rename)
-				w.mu.Lock()
-				for _, watchMap := range w.watches {
-					for _, ww := range watchMap {
-						if strings.HasPrefix(ww.path, old) {
-							ww.path = filepath.Join(fullname, strings.TrimPrefix(ww.path, old))
-						}
+	switch qErr {
+	case nil:
+		watch.retries = 0
+	case windows.ERROR_MORE_DATA:
+		// The i/o succeeded but the buffer is full.
+		// In theory we should be building up a full packet.
+		// In practice we can get away with just carrying on.
+		n = uint32(unsafe.Sizeof(watch.buf))
+	case windows.ERROR_ACCESS_DENIED:
+		// Watched directory was probably removed
+		w.released(watch)
+		return
+	case windows.ERROR_OPERATION_ABORTED:
+		// CancelIoEx was called on this handle
+		return
+	default:
+		if watch.network && isNetworkError(qErr) {
+			w.reconnect(watch, os.NewSyscallError("GetQueuedCompletionPort", qErr))
+			return
+		}
+		if isUnmounted(qErr) {
+			w.unmounted(watch)
+			return
+		}
+		w.sendError(WatchError{Path: watch.path, Op: "read", Err: os.NewSyscallError("GetQueuedCompletionPort", qErr)})
+		return
+	}
+
+	var offset uint32
+	for {
+		if n == 0 {
+			w.sendError(WatchError{Path: watch.path, Op: "read", Err: ErrEventOverflow, Alive: true})
+			break
+		}
+
+		raw, err := readWindowsRecord(watch.buf[offset:n])
+		if err != nil {
+			w.sendError(WatchError{Path: watch.path, Op: "read", Err: err, Alive: true})
+			break
+		}
+		name := raw.name
+		fullname := filepath.Join(watch.path, name)
+
+		// Events below WithMaxDepth still need to be processed to keep the
+		// state up to date, but aren't sent.
+		deep := watch.maxDepth > 0 && strings.Count(name, string(filepath.Separator)) > watch.maxDepth
+
+		var mask uint64
+		switch raw.action {
+		case windows.FILE_ACTION_REMOVED:
+			mask = sysFSDELETESELF
+		case windows.FILE_ACTION_MODIFIED:
+			mask = sysFSMODIFY
+		case windows.FILE_ACTION_RENAMED_OLD_NAME:
+			watch.rename = name
+		case windows.FILE_ACTION_RENAMED_NEW_NAME:
+			// Update saved path of all sub-watches.
+			old := filepath.Join(watch.path, watch.rename)
+			w.mu.Lock()
+			for _, watchMap := range w.watches {
+				for _, ww := range watchMap {
+					if strings.HasPrefix(ww.path, old) {
+						ww.path = filepath.Join(fullname, strings.TrimPrefix(ww.path, old))
 					}
 				}
-				w.mu.Unlock()
+			}
+			w.mu.Unlock()
 
-				if watch.names[watch.rename] != 0 {
-					watch.names[name] |= watch.names[watch.rename]
-					delete(watch.names, watch.rename)
-					mask = sysFSMOVESELF
-				}
+			if watch.names[watch.rename] != 0 {
+				watch.names[name] |= watch.names[watch.rename]
+				delete(watch.names, watch.rename)
+				mask = sysFSMOVESELF
 			}
+		}
 
-			sendNameEvent := func() {
+		sendNameEvent := func() {
+			if !deep {
 				w.sendEvent(fullname, watch.names[name]&mask)
 			}
-			if raw.Action != windows.FILE_ACTION_RENAMED_NEW_NAME {
-				sendNameEvent()
-			}
-			if raw.Action == windows.FILE_ACTION_REMOVED {
-				w.sendEvent(fullname, watch.names[name]&sysFSIGNORED)
-				delete(watch.names, name)
+		}
+		if raw.action != windows.FILE_ACTION_RENAMED_NEW_NAME {
+			sendNameEvent()
+		}
+		if raw.action == windows.FILE_ACTION_REMOVED {
+			w.sendEvent(fullname, watch.names[name]&sysFSIGNORED)
+			delete(watch.names, name)
+
+			// The handle for a watched subdirectory keeps it from being
+			// removed until it's closed; don't wait for its read to fail.
+			if sub := w.watchFor(fullname); sub != nil && sub != watch && deletePending(sub.ino.handle) {
+				w.released(sub)
 			}
+		}
 
-			w.sendEvent(fullname, watch.mask&w.toFSnotifyFlags(raw.Action))
-			if raw.Action == windows.FILE_ACTION_RENAMED_NEW_NAME {
-				fullname = filepath.Join(watch.path, watch.rename)
-				sendNameEvent()
-			}
+		if !deep {
+			w.sendEvent(fullname, watch.mask&w.toFSnotifyFlags(raw.action))
+		}
+		if raw.action == windows.FILE_ACTION_RENAMED_NEW_NAME {
+			fullname = filepath.Join(watch.path, watch.rename)
+			sendNameEvent()
+		}
 
-			// Move to the next event in the buffer
-			if raw.NextEntryOffset == 0 {
-				break
-			}
-			offset += raw.NextEntryOffset
-
-			// Error!
-			if offset >= n {
-				//lint:ignore ST1005 Windows should be capitalized
-				w.sendError(errors.New(
-					"Windows system assumed buffer larger than it is, events have likely been missed"))
-				break
-			}
+		// Move to the next event in the buffer
+		if raw.next == 0 {
+			break
 		}
 
-		if err := w.startRead(watch); err != nil {
-			w.sendError(err)
+		// Error!
+		if raw.next >= n-offset {
+			//lint:ignore ST1005 Windows should be capitalized
+			w.sendError(WatchError{Path: watch.path, Op: "read", Alive: true, Err: errors.New(
+				"Windows system assumed buffer larger than it is, events have likely been missed")})
+			break
 		}
+		offset += raw.next
+	}
+
+	if deletePending(watch.ino.handle) {
+		w.released(watch)
+		return
+	}
+	if err := w.startRead(watch); err != nil {
+		w.sendError(WatchError{Path: watch.path, Op: "read", Err: err})
 	}
 }
 
@@ -0,0 +1,110 @@
+//go:build windows
+// +build windows
+
+package fsnotify
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/hohodqr/fsnotify/internal"
+	"golang.org/x/sys/windows"
+)
+
+// completionPort is the I/O completion port that's shared by all watches of all
+// Watchers. A few workers read completions from it and handle them for the
+// Watcher the watch belongs to, so the number of threads doesn't grow with the
+// number of watches or Watchers.
+type completionPort struct {
+	handle windows.Handle
+	refs   int            // Number of Watchers using the port.
+	wg     sync.WaitGroup // Running workers.
+}
+
+// sharedPort is the current completion port; it's set to nil once the last
+// Watcher released it, and a new one is created for the next Watcher.
+var sharedPort struct {
+	mu   sync.Mutex
+	port *completionPort
+}
+
+// portWorkers is the number of workers reading from the completion port.
+func portWorkers() int {
+	n := runtime.NumCPU()
+	if n > 4 {
+		n = 4
+	}
+	return n
+}
+
+// acquirePort gets the shared completion port, creating it if needed.
+func acquirePort() (*completionPort, error) {
+	sharedPort.mu.Lock()
+	defer sharedPort.mu.Unlock()
+
+	p := sharedPort.port
+	if p == nil {
+		h, err := windows.CreateIoCompletionPort(windows.InvalidHandle, 0, 0, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("CreateIoCompletionPort", err)
+		}
+		internal.Opened("port", h)
+		p = &completionPort{handle: h}
+		for i := 0; i < portWorkers(); i++ {
+			p.wg.Add(1)
+			go p.work()
+		}
+		sharedPort.port = p
+	}
+	p.refs++
+	return p, nil
+}
+
+// release the port, closing it and waiting for the workers to stop if this was
+// the last Watcher using it.
+func (p *completionPort) release() error {
+	sharedPort.mu.Lock()
+	p.refs--
+	last := p.refs == 0
+	if last && sharedPort.port == p {
+		sharedPort.port = nil
+	}
+	sharedPort.mu.Unlock()
+
+	if !last {
+		return nil
+	}
+	err := windows.CloseHandle(p.handle)
+	internal.Closed("port", p.handle)
+	if err != nil {
+		return os.NewSyscallError("CloseHandle", err)
+	}
+	p.wg.Wait()
+	return nil
+}
+
+// work reads completions until the port is closed.
+func (p *completionPort) work() {
+	defer p.wg.Done()
+	var (
+		n   uint32
+		key uintptr
+		ov  *windows.Overlapped
+	)
+	for {
+		// This error is handled in handleCompletion(), after the watch == nil
+		// check below.
+		qErr := windows.GetQueuedCompletionStatus(p.handle, &n, &key, &ov, windows.INFINITE)
+
+		watch := (*watch)(unsafe.Pointer(ov))
+		if watch == nil {
+			if qErr != nil { // Port was closed.
+				return
+			}
+			continue
+		}
+		watch.w.handleCompletion(watch, n, qErr)
+	}
+}
@@ -5,8 +5,12 @@ package fsnotify
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
 )
 
 func TestRemoveState(t *testing.T) {
@@ -49,3 +53,128 @@ func TestRemoveState(t *testing.T) {
 	}
 	check(0)
 }
+
+func TestLongPath(t *testing.T) {
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, strings.Repeat(`node_modules\pkg\`, 20))
+	mkdirAll(t, dir)
+	if len(dir) < 260 {
+		t.Fatalf("path not long enough: %d", len(dir))
+	}
+
+	for _, add := range []string{dir, `\\?\` + dir} {
+		t.Run("", func(t *testing.T) {
+			w := newCollector(t, add)
+			w.collect(t)
+
+			file := filepath.Join(dir, "file")
+			touch(t, file)
+			rm(t, file)
+
+			events := w.stop(t)
+			if len(events) == 0 {
+				t.Fatal("no events")
+			}
+			for _, e := range events {
+				if e.Name != file {
+					t.Errorf("wrong name: %s", e)
+				}
+			}
+		})
+	}
+}
+
+func TestWindowsFilters(t *testing.T) {
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "file")
+	touch(t, file)
+
+	w := newCollector(t)
+	err := w.w.AddWith(tmp, WithWindowsFilters(0x10000))
+	if err == nil || !strings.Contains(err.Error(), "unknown filters") {
+		t.Fatalf("wrong error for unknown filters: %v", err)
+	}
+	err = w.w.AddWith(tmp, WithWindowsFilters(windows.FILE_NOTIFY_CHANGE_FILE_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.collect(t)
+
+	cat(t, "data", file) // No LAST_WRITE, so no event.
+	touch(t, tmp, "new")
+
+	cmpEvents(t, tmp, w.stop(t), newEvents(t, `
+		create  /new
+	`))
+}
+
+func TestSharedPort(t *testing.T) {
+	refs := func() int {
+		sharedPort.mu.Lock()
+		defer sharedPort.mu.Unlock()
+		if sharedPort.port == nil {
+			return 0
+		}
+		return sharedPort.port.refs
+	}
+	tmp := t.TempDir()
+	before := refs()
+
+	ws := make([]*eventCollector, 3)
+	for i := range ws {
+		dir := filepath.Join(tmp, fmt.Sprintf("dir%d", i))
+		mkdir(t, dir)
+		ws[i] = newCollector(t, dir)
+		ws[i].collect(t)
+		if ws[i].w.port != ws[0].w.port {
+			t.Fatal("Watchers don't share the completion port")
+		}
+	}
+
+	for i := range ws {
+		touch(t, tmp, fmt.Sprintf("dir%d", i), "file")
+	}
+	for i, w := range ws {
+		cmpEvents(t, tmp, w.stop(t), newEvents(t, fmt.Sprintf(`
+			create  /dir%d/file
+		`, i)))
+	}
+
+	if have := refs(); have != before {
+		t.Errorf("port not released after closing the Watchers: %d refs; want %d", have, before)
+	}
+}
+
+func TestRecreateDir(t *testing.T) {
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "dir")
+	mkdir(t, dir)
+
+	w := newWatcher(t)
+	defer w.Close()
+	addWatch(t, w, tmp)
+	if err := w.AddWith(dir, WithReArm()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The handle is closed when the directory is removed, so it can be created
+	// again right away.
+	rmAll(t, dir)
+	eventsFor(t, w, 200*time.Millisecond)
+	if sub := w.watchFor(dir); sub != nil {
+		t.Fatalf("handle for removed directory not released: %#v", sub)
+	}
+	mkdir(t, dir)
+	eventsFor(t, w, 200*time.Millisecond)
+
+	touch(t, dir, "file")
+	var found bool
+	for _, e := range eventsFor(t, w, 200*time.Millisecond) {
+		if e.Name == filepath.Join(dir, "file") && e.Has(Create) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no Create for file in recreated directory; WatchList: %q", w.WatchList())
+	}
+}
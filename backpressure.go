@@ -0,0 +1,402 @@
+package fsnotify
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backpressure describes what a Watcher does when the consumer doesn't read
+// from the Events channel fast enough; see [WithBackpressure].
+type Backpressure uint8
+
+const (
+	// Block until the consumer reads the event. This is the default. The
+	// kernel will keep queueing events in the meanwhile, and may overflow.
+	BackpressureBlock Backpressure = iota
+
+	// Drop the oldest event in the Events channel to make room for the new
+	// one. This is the same as BackpressureDropNewest if the Events channel is
+	// unbuffered, as there's no older event to drop.
+	BackpressureDropOldest
+
+	// Drop the new event if the Events channel is full.
+	BackpressureDropNewest
+
+	// Queue events internally if the Events channel is full, merging the Op of
+	// events for a path that's already queued. The queue is unbounded, but
+	// never holds more than one event per path.
+	BackpressureCoalesce
+)
+
+func (b Backpressure) String() string {
+	switch b {
+	case BackpressureBlock:
+		return "block"
+	case BackpressureDropOldest:
+		return "drop-oldest"
+	case BackpressureDropNewest:
+		return "drop-newest"
+	case BackpressureCoalesce:
+		return "coalesce"
+	default:
+		return "unknown"
+	}
+}
+
+// WithBackpressure sets what to do when the Events channel is full. This is
+// only used by [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// The default is [BackpressureBlock]. The other policies never block the
+// backend, and only make sense if the Events channel has a buffer (see
+// [WithEventChannelSize]); with an unbuffered channel every event the consumer
+// isn't waiting for at that exact moment is dropped or queued.
+//
+// The number of dropped events is available with [Watcher.DroppedEvents].
+func WithBackpressure(b Backpressure) addOpt {
+	return func(opt *withOpts) { opt.backpressure = b }
+}
+
+// delivery sends events for the non-blocking backpressure policies.
+type delivery struct {
+	dropped uint64 // Keep first for 64-bit alignment on 32-bit platforms.
+	policy  Backpressure
+
+	mu       sync.Mutex
+	pending  []*Event          // Queue for BackpressureCoalesce, in order.
+	byName   map[string]*Event // Queued events by path.
+	flushing bool              // flush() goroutine is running.
+	holding  uint32            // Set with hold(); accessed atomically.
+	held     []Event           // Events held until release().
+	closing  uint32            // Set with stopping(); accessed atomically.
+	kept     []Event           // Remove and Rename events for sendKept().
+	wg       sync.WaitGroup
+	done     chan struct{}
+	overflow overflowFunc // Called for dropped events; see OnOverflow.
+	journal  *Journal     // Delivered events are written here; see WithJournal.
+}
+
+func newDelivery(with withOpts) *delivery {
+	return &delivery{
+		policy:  with.backpressure,
+		byName:  make(map[string]*Event),
+		journal: with.journal,
+		done:    make(chan struct{}),
+	}
+}
+
+// sent is called for every event that's delivered to the consumer, on the
+// Events channel or otherwise.
+func (d *delivery) sent(e Event) {
+	if d.journal != nil {
+		d.journal.Write(e)
+	}
+}
+
+func (d *delivery) blocking() bool {
+	return d.policy == BackpressureBlock && atomic.LoadUint32(&d.holding) == 0
+}
+
+func (d *delivery) droppedEvents() uint64 { return atomic.LoadUint64(&d.dropped) }
+
+// queued returns the number of events that are waiting to be sent.
+func (d *delivery) queued() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending) + len(d.held)
+}
+
+// send e on ch according to the policy, or queue it if events are held with
+// hold(). This never blocks, except for BackpressureBlock right after the held
+// events were released. Returns false if the delivery was closed.
+func (d *delivery) send(ch chan Event, e Event) bool {
+	select {
+	case <-d.done:
+		d.keep(e)
+		return false
+	default:
+	}
+
+	if atomic.LoadUint32(&d.holding) == 1 {
+		d.mu.Lock()
+		if atomic.LoadUint32(&d.holding) == 1 {
+			d.held = append(d.held, e)
+			d.mu.Unlock()
+			return true
+		}
+		d.mu.Unlock()
+	}
+	return d.deliver(ch, e)
+}
+
+// deliver e on ch according to the policy, without checking hold().
+func (d *delivery) deliver(ch chan Event, e Event) bool {
+	if (d.policy == BackpressureDropNewest || d.policy == BackpressureDropOldest) &&
+		atomic.LoadUint32(&d.closing) == 1 {
+		return d.deliverClosing(ch, e)
+	}
+
+	switch d.policy {
+	case BackpressureBlock: // Only for events held by hold().
+		select {
+		case ch <- e:
+			d.sent(e)
+		case <-d.done:
+			d.keep(e)
+			return false
+		}
+	case BackpressureDropNewest:
+		select {
+		case ch <- e:
+			d.sent(e)
+		default:
+			atomic.AddUint64(&d.dropped, 1)
+			d.overflow.call(1)
+		}
+	case BackpressureDropOldest:
+		for {
+			select {
+			case ch <- e:
+				d.sent(e)
+				return true
+			case <-d.done:
+				d.keep(e)
+				return false
+			default:
+			}
+			// There's nothing to drop from an unbuffered channel; drop the
+			// new event instead.
+			if cap(ch) == 0 {
+				atomic.AddUint64(&d.dropped, 1)
+				d.overflow.call(1)
+				return true
+			}
+			select {
+			case <-ch:
+				atomic.AddUint64(&d.dropped, 1)
+				d.overflow.call(1)
+			default:
+			}
+		}
+	case BackpressureCoalesce:
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		// Keep the order if there's already something in the queue.
+		if len(d.pending) == 0 {
+			select {
+			case ch <- e:
+				d.sent(e)
+				return true
+			default:
+			}
+		}
+		if p, ok := d.byName[e.Name]; ok {
+			p.Op |= e.Op
+			return true
+		}
+		d.pending = append(d.pending, &e)
+		d.byName[e.Name] = &e
+		if !d.flushing {
+			d.flushing = true
+			d.wg.Add(1)
+			go d.flush(ch)
+		}
+	}
+	return true
+}
+
+// flush the coalesce queue to ch.
+func (d *delivery) flush(ch chan Event) {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		if len(d.pending) == 0 {
+			d.flushing = false
+			d.mu.Unlock()
+			return
+		}
+		e := d.pending[0]
+		d.pending[0] = nil
+		d.pending = d.pending[1:]
+		delete(d.byName, e.Name)
+		d.mu.Unlock()
+
+		select {
+		case ch <- *e:
+			d.sent(*e)
+		case <-d.done:
+			d.mu.Lock()
+			for _, p := range append([]*Event{e}, d.pending...) {
+				d.keepLocked(*p)
+			}
+			d.pending, d.flushing = nil, false
+			d.mu.Unlock()
+			return
+		}
+	}
+}
+
+// hold all events until release() is called, for Standby. The held events are
+// kept in memory without limit.
+func (d *delivery) hold() { atomic.StoreUint32(&d.holding, 1) }
+
+// sendWait sends e on ch, blocking until it's read. Returns false if the
+// delivery was closed first. Unlike send() this can be called from any
+// goroutine, as close() waits for it to return.
+func (d *delivery) sendWait(ch chan Event, e Event) bool {
+	d.mu.Lock()
+	select {
+	case <-d.done:
+		d.mu.Unlock()
+		return false
+	default:
+	}
+	d.wg.Add(1)
+	d.mu.Unlock()
+	defer d.wg.Done()
+
+	select {
+	case ch <- e:
+		d.sent(e)
+		return true
+	case <-d.done:
+		return false
+	}
+}
+
+// release the events held since hold() to ch, in order and before any new
+// events.
+func (d *delivery) release(ch chan Event) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for {
+			d.mu.Lock()
+			if len(d.held) == 0 {
+				atomic.StoreUint32(&d.holding, 0)
+				d.mu.Unlock()
+				return
+			}
+			e := d.held[0]
+			d.held = d.held[1:]
+			d.mu.Unlock()
+
+			if !d.deliver(ch, e) {
+				return
+			}
+		}
+	}()
+}
+
+// close the delivery, waiting for the queue to stop. This must be called before
+// the Events channel is closed.
+func (d *delivery) close() {
+	d.mu.Lock()
+	select {
+	case <-d.done:
+	default:
+		close(d.done)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+
+	// Events that were never released from hold().
+	d.mu.Lock()
+	for _, e := range d.held {
+		d.keepLocked(e)
+	}
+	d.held = nil
+	d.mu.Unlock()
+}
+
+// Remove and Rename events that were already read from the system are never
+// lost when the Watcher is closed, so the consumer sees the final state of the
+// files. Once the Watcher is closing these events are kept instead of being
+// dropped (or instead of giving up on a blocking send), and sent with
+// sendKept() before the Events channel is closed. To keep the order, no events
+// are sent on the channel once one was kept, and other events are dropped.
+
+// closeTimeout is how long sendKept() waits for the consumer to read the kept
+// events.
+var closeTimeout = time.Second
+
+// stopping marks the Watcher as closing; this must be called from Close().
+func (d *delivery) stopping() { atomic.StoreUint32(&d.closing, 1) }
+
+// keep e for sendKept() if it's a Remove or Rename.
+func (d *delivery) keep(e Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keepLocked(e)
+}
+
+func (d *delivery) keepLocked(e Event) {
+	if e.Op&(opRemove|opRename) != 0 {
+		d.kept = append(d.kept, e)
+	} else if d.policy != BackpressureBlock {
+		atomic.AddUint64(&d.dropped, 1)
+	}
+}
+
+// deliverClosing is deliver() for the drop policies once the Watcher is
+// closing. DropOldest doesn't remove anything from ch any more, as that may be
+// a Remove or Rename.
+func (d *delivery) deliverClosing(ch chan Event, e Event) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.kept) == 0 {
+		select {
+		case ch <- e:
+			d.sent(e)
+			return true
+		default:
+		}
+	}
+	d.keepLocked(e)
+	return true
+}
+
+// sendBlocking sends e on ch for BackpressureBlock, keeping it if done is
+// closed first. Once done is closed nothing is sent on ch any more.
+func (d *delivery) sendBlocking(ch chan Event, e Event, done chan struct{}) bool {
+	select {
+	case <-done:
+		d.keep(e)
+		return false
+	default:
+	}
+	select {
+	case ch <- e:
+		d.sent(e)
+		return true
+	case <-done:
+		d.keep(e)
+		return false
+	}
+}
+
+// sendKept sends the kept events on ch, waiting at most closeTimeout for the
+// consumer to read them. This must be called after close(), right before the
+// Events channel is closed.
+func (d *delivery) sendKept(ch chan Event) {
+	d.mu.Lock()
+	kept := d.kept
+	d.kept = nil
+	d.mu.Unlock()
+	if len(kept) == 0 {
+		return
+	}
+
+	t := time.NewTimer(closeTimeout)
+	defer t.Stop()
+	for i, e := range kept {
+		select {
+		case ch <- e:
+			d.sent(e)
+		case <-t.C:
+			atomic.AddUint64(&d.dropped, uint64(len(kept)-i))
+			return
+		}
+	}
+}
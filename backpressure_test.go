@@ -0,0 +1,170 @@
+package fsnotify
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Remove and Rename events are sent before the Events channel is closed.
+func TestDeliveryKept(t *testing.T) {
+	t.Parallel()
+
+	recv := func(t *testing.T, d *delivery, ch chan Event) []Event {
+		t.Helper()
+		d.close()
+		go func() {
+			d.sendKept(ch)
+			close(ch)
+		}()
+		var have []Event
+		for e := range ch {
+			have = append(have, e)
+		}
+		return have
+	}
+	check := func(t *testing.T, have []Event, want ...string) {
+		t.Helper()
+		if len(have) != len(want) {
+			t.Fatalf("have %d events, want %d: %v", len(have), len(want), have)
+		}
+		for i := range want {
+			if have[i].Name != want[i] {
+				t.Errorf("event %d: have %q, want %q", i, have[i].Name, want[i])
+			}
+		}
+	}
+
+	for _, b := range []Backpressure{BackpressureDropOldest, BackpressureDropNewest} {
+		b := b
+		t.Run(b.String(), func(t *testing.T) {
+			t.Parallel()
+
+			d := newDelivery(withOpts{backpressure: b})
+			ch := make(chan Event, 1)
+			d.send(ch, Event{Name: "a", Op: opWrite})
+			d.stopping()
+			d.send(ch, Event{Name: "b", Op: opRemove})
+			d.send(ch, Event{Name: "c", Op: opWrite})
+			d.send(ch, Event{Name: "d", Op: opRename})
+
+			check(t, recv(t, d, ch), "a", "b", "d")
+			if have := d.droppedEvents(); have != 1 {
+				t.Errorf("DroppedEvents: %d", have)
+			}
+		})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			d    = newDelivery(withOpts{})
+			ch   = make(chan Event)
+			done = make(chan struct{})
+		)
+		d.stopping()
+		close(done)
+		if d.sendBlocking(ch, Event{Name: "a", Op: opRemove}, done) {
+			t.Error("sendBlocking returned true after done was closed")
+		}
+		d.sendBlocking(ch, Event{Name: "b", Op: opWrite}, done)
+		d.sendBlocking(ch, Event{Name: "c", Op: opRename}, done)
+
+		check(t, recv(t, d, ch), "a", "c")
+	})
+}
+
+// DropOldest with an unbuffered channel drops the new event, rather than
+// spinning until the consumer reads.
+func TestDropOldestUnbuffered(t *testing.T) {
+	t.Parallel()
+
+	d := newDelivery(withOpts{backpressure: BackpressureDropOldest})
+	ch := make(chan Event)
+	sent := make(chan bool)
+	go func() { sent <- d.send(ch, Event{Name: "a", Op: opWrite}) }()
+	select {
+	case ok := <-sent:
+		if !ok {
+			t.Error("send returned false")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked")
+	}
+	if have := d.droppedEvents(); have != 1 {
+		t.Errorf("DroppedEvents: %d", have)
+	}
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithBackpressure(BackpressureDropOldest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addWatch(t, w, tmp)
+	touch(t, tmp, "file", noWait)
+	waitForEvents()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- w.Close()
+		for range w.Events {
+		}
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked")
+	}
+}
+
+func TestCloseCoalesce(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithEventChannelSize(1), WithBackpressure(BackpressureCoalesce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addWatch(t, w, tmp)
+
+	// Nothing is reading, so the events are queued.
+	for i := 0; i < 10; i++ {
+		touch(t, tmp, fmt.Sprintf("file%d", i), noWait)
+	}
+	for i := 0; i < 10; i++ {
+		rm(t, tmp, fmt.Sprintf("file%d", i), noWait)
+	}
+	waitForEvents()
+
+	errc := make(chan error, 1)
+	go func() { errc <- w.Close() }()
+
+	removed := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+loop:
+	for {
+		select {
+		case e, ok := <-w.Events:
+			if !ok {
+				break loop
+			}
+			if e.Op&opRemove != 0 {
+				removed[e.Name] = true
+			}
+		case <-timeout:
+			t.Fatal("timeout")
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// The first event went in the channel before the Remove was read.
+	if len(removed) < 9 {
+		t.Errorf("have Remove for %d paths, want at least 9: %v", len(removed), removed)
+	}
+}
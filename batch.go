@@ -0,0 +1,78 @@
+package fsnotify
+
+import "time"
+
+// ReadBatch reads up to max events from the Events channel, waiting at most
+// wait for them to arrive. This can be used instead of receiving from Events
+// directly to handle high event volumes in batches.
+//
+// It returns when max events have been read, when the wait time has passed, or
+// when an error is received from the Errors channel; in that case the events
+// read so far are returned along with the error. If wait is 0 or negative it
+// only returns events that are immediately available.
+//
+// The returned slice may be empty if no events arrived in time. Returns
+// [ErrClosed] if the watcher was closed and there are no more events.
+func (w *Watcher) ReadBatch(max int, wait time.Duration) ([]Event, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var (
+		batch = make([]Event, 0, max)
+		errs  = w.Errors
+	)
+	for len(batch) < max {
+		select {
+		case e, ok := <-w.Events:
+			if !ok {
+				return batch, closedIfEmpty(batch)
+			}
+			batch = append(batch, e)
+			continue
+		case err, ok := <-errs:
+			if !ok {
+				// Errors is closed at the same time as Events; keep reading
+				// until Events is drained.
+				errs = nil
+				continue
+			}
+			return batch, err
+		default:
+		}
+		if timeout == nil {
+			return batch, nil
+		}
+
+		select {
+		case e, ok := <-w.Events:
+			if !ok {
+				return batch, closedIfEmpty(batch)
+			}
+			batch = append(batch, e)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			return batch, err
+		case <-timeout:
+			return batch, nil
+		}
+	}
+	return batch, nil
+}
+
+func closedIfEmpty(batch []Event) error {
+	if len(batch) == 0 {
+		return ErrClosed
+	}
+	return nil
+}
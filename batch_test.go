@@ -0,0 +1,54 @@
+package fsnotify
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReadBatch(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithEventChannelSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addWatch(t, w, tmp)
+
+	for i := 0; i < 5; i++ {
+		touch(t, tmp, fmt.Sprintf("file%d", i), noWait)
+	}
+
+	batch, err := w.ReadBatch(3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 3 {
+		t.Errorf("len(batch) = %d; want 3: %v", len(batch), batch)
+	}
+
+	// Get the rest.
+	waitForEvents()
+	if _, err := w.ReadBatch(1000, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	batch, err = w.ReadBatch(10, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 0 {
+		t.Errorf("len(batch) = %d; want 0: %v", len(batch), batch)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("returned before the wait time")
+	}
+
+	w.Close()
+	if _, err := w.ReadBatch(10, time.Second); !errors.Is(err, ErrClosed) {
+		t.Errorf("wrong error after close: %v", err)
+	}
+}
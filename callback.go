@@ -0,0 +1,64 @@
+package fsnotify
+
+import "sync"
+
+// WithCallback calls fn for every event instead of sending it on the Events
+// channel. This is only used by [NewWatcherWith], and is a no-op for
+// [Watcher.AddWith].
+//
+// This is intended for very high event rates where the per-event allocations
+// and channel operations matter. The *Event and the memory backing the Name are
+// reused for the next event, so they're only valid until fn returns; use
+// [Event.Clone] to keep an event around.
+//
+// fn is called from the goroutine that reads from the kernel, so new events
+// are queued by the kernel while it runs; it should return quickly, and must
+// not call [Watcher.Close] or any other Watcher method. Calls are never
+// concurrent.
+//
+// Errors are still sent on the Errors channel, which must be read. Nothing is
+// sent on the Events channel, and [WithBackpressure] is ignored.
+func WithCallback(fn func(*Event)) addOpt {
+	return func(opt *withOpts) { opt.callback = fn }
+}
+
+// Clone returns a copy of the event that's safe to keep after the function
+// passed to [WithCallback] returned.
+func (e Event) Clone() Event {
+	c := Event{Name: cloneString(e.Name), Op: e.Op, Root: cloneString(e.Root), RawName: cloneString(e.RawName), Attrs: e.Attrs}
+	if e.Change != nil {
+		ch := *e.Change
+		ch.Ranges = append([]ChangedRange(nil), e.Change.Ranges...)
+		c.Change = &ch
+	}
+	return c
+}
+
+func cloneString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return string(append([]byte(nil), s...))
+}
+
+// eventFunc calls the WithCallback function, reusing the Event.
+type eventFunc struct {
+	mu sync.Mutex
+	fn func(*Event)
+	ev Event
+}
+
+func newEventFunc(with withOpts) *eventFunc {
+	if with.callback == nil {
+		return nil
+	}
+	return &eventFunc{fn: with.callback}
+}
+
+func (f *eventFunc) call(e Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ev = e
+	f.fn(&f.ev)
+	f.ev = Event{}
+}
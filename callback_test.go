@@ -0,0 +1,77 @@
+package fsnotify
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithCallback(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu   sync.Mutex
+		have = make(map[string]bool)
+		ptr  *Event
+	)
+	w, err := NewWatcherWith(WithCallback(func(e *Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if ptr != nil && ptr != e {
+			t.Error("*Event not reused")
+		}
+		ptr = e
+		have[e.Clone().Name] = true
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	tmp := t.TempDir()
+	addWatch(t, w, tmp)
+	for i := 0; i < 10; i++ {
+		touch(t, tmp, fmt.Sprintf("file%d", i), noWait)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(have)
+		mu.Unlock()
+		if n == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("have %d names, want 10", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 0; i < 10; i++ {
+		if n := join(tmp, fmt.Sprintf("file%d", i)); !have[n] {
+			t.Errorf("no event for %q", n)
+		}
+	}
+	select {
+	case e := <-w.Events:
+		t.Errorf("event sent on channel: %s", e)
+	default:
+	}
+}
+
+func TestEventClone(t *testing.T) {
+	b := []byte("/tmp/file")
+	for _, e := range []Event{
+		{Name: string(b), Op: Create, Root: "/tmp"},
+		{Name: string(b), Op: Chmod | Xattr, Attrs: AttrMode | AttrXattr},
+	} {
+		c := e.Clone()
+		if c != e {
+			t.Errorf("have %#v, want %#v", c, e)
+		}
+	}
+}
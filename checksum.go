@@ -0,0 +1,94 @@
+package fsnotify
+
+import (
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WithChecksum only sends Write events if the contents of the file actually
+// changed, according to a hash created with newHash (e.g. [crypto/sha256.New]
+// or [hash/crc32.NewIEEE]). This is only used by [NewWatcherWith], and is a
+// no-op for [Watcher.AddWith].
+//
+// Many programs write files without changing them, or write them in several
+// steps that each trigger an event; build tools and the like usually only care
+// if the contents changed. The file is read and hashed on every Write (and
+// Create) event, so this is best used for small files.
+//
+// The first Write for a file that existed before it was watched is always sent,
+// as the previous contents are unknown.
+func WithChecksum(newHash func() hash.Hash) addOpt {
+	return func(opt *withOpts) { opt.newHash = newHash }
+}
+
+// checksums filters Write events according to WithChecksum.
+type checksums struct {
+	newHash func() hash.Hash
+	mu      sync.Mutex
+	sums    map[string]string // Last seen hash per path.
+}
+
+func newChecksums(with withOpts) *checksums {
+	return &checksums{newHash: with.newHash, sums: make(map[string]string)}
+}
+
+// filter the Write from the event if the contents didn't change, returning
+// false if nothing is left.
+func (c *checksums) filter(e Event) (Event, bool) {
+	if c.newHash == nil {
+		return e, true
+	}
+
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	if e.Op&(opRemove|opRename) != 0 {
+		c.mu.Lock()
+		delete(c.sums, name)
+		c.mu.Unlock()
+	}
+	if e.Op&(opCreate|opWrite) == 0 {
+		return e, true
+	}
+
+	sum, ok := c.sum(name)
+	if !ok {
+		return e, true
+	}
+	c.mu.Lock()
+	prev, seen := c.sums[name]
+	if !seen || prev != sum {
+		// The name may be reused by WithCallback.
+		c.sums[cloneString(name)] = sum
+		c.mu.Unlock()
+		return e, true
+	}
+	c.mu.Unlock()
+
+	// Only drop the Write bits, and drop the event if there's nothing left
+	// except for the IN_ISDIR flag on Linux.
+	e.Op &^= opWrite
+	return e, e.Op&^IN_ISDIR != 0
+}
+
+// sum gets the hash of a regular file.
+func (c *checksums) sum(path string) (string, bool) {
+	// Check first, as opening a FIFO would block.
+	if st, err := os.Lstat(path); err != nil || !st.Mode().IsRegular() {
+		return "", false
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer fp.Close()
+	h := c.newHash()
+	if _, err := io.Copy(h, fp); err != nil {
+		return "", false
+	}
+	return string(h.Sum(nil)), true
+}
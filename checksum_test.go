@@ -0,0 +1,65 @@
+package fsnotify
+
+import (
+	"crypto/sha256"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWithChecksum(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	file := join(tmp, "file")
+	cat(t, "hello", file, noWait)
+
+	w, err := NewWatcherWith(WithChecksum(sha256.New))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp)
+
+	// Overwrite the file with the same data.
+	rewrite := func() {
+		t.Helper()
+		fp, err := os.OpenFile(file, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fp.Close()
+		if _, err := fp.WriteAt([]byte("hello"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hasWrite := func() bool {
+		t.Helper()
+		for {
+			select {
+			case e := <-w.Events:
+				if e.Op&opWrite != 0 {
+					return true
+				}
+			case err := <-w.Errors:
+				t.Fatal(err)
+			case <-time.After(300 * time.Millisecond):
+				return false
+			}
+		}
+	}
+
+	// The first Write is always sent, as there's nothing to compare with.
+	rewrite()
+	if !hasWrite() {
+		t.Error("no Write sent for first write")
+	}
+	rewrite()
+	if hasWrite() {
+		t.Error("Write sent without changing the contents")
+	}
+	cat(t, " world", file, noWait)
+	if !hasWrite() {
+		t.Error("no Write sent after changing the contents")
+	}
+}
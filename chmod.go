@@ -0,0 +1,208 @@
+package fsnotify
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WithChmod sets whether Chmod events are sent. This is only used by
+// [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// By default Chmod events are sent as the platform reports them, which differs
+// a lot: macOS and Linux also send them when only the timestamps change (e.g.
+// "touch", or Spotlight indexing on macOS), and Windows only sends them for
+// some attributes and not at all on some filesystems.
+//
+// With WithChmod(false) Chmod is never sent. With WithChmod(true) a Chmod is
+// only sent if the mode or owner of the file actually changed, and on Windows
+// the mode of the watched paths is polled every second to detect changes the
+// filesystem doesn't report.
+func WithChmod(enable bool) addOpt {
+	return func(opt *withOpts) {
+		if enable {
+			opt.chmod = chmodOn
+		} else {
+			opt.chmod = chmodOff
+		}
+	}
+}
+
+type chmodMode uint8
+
+const (
+	chmodDefault chmodMode = iota // Send whatever the backend sends.
+	chmodOff
+	chmodOn
+)
+
+var chmodPollInterval = time.Second
+
+// chmods filters Chmod events according to WithChmod, and polls for changes
+// if the backend doesn't report them reliably.
+type chmods struct {
+	mode  chmodMode
+	mu    sync.Mutex
+	attrs map[string]fileAttr // Last seen attributes per path.
+	wg    sync.WaitGroup
+	done  chan struct{}
+}
+
+type fileAttr struct {
+	mode     fs.FileMode
+	uid, gid uint32
+}
+
+func statAttr(path string) (fileAttr, bool) {
+	st, err := os.Lstat(path)
+	if err != nil {
+		return fileAttr{}, false
+	}
+	a := fileAttr{mode: st.Mode()}
+	a.uid, a.gid = fileOwner(st)
+	return a, true
+}
+
+func newChmods(with withOpts) *chmods {
+	return &chmods{
+		mode:  with.chmod,
+		attrs: make(map[string]fileAttr),
+		done:  make(chan struct{}),
+	}
+}
+
+// filter the Chmod from the event, returning false if nothing is left.
+func (c *chmods) filter(e Event) (Event, bool) {
+	if c.mode == chmodDefault {
+		return e, true
+	}
+
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	if e.Op&(opRemove|opRename) != 0 {
+		c.mu.Lock()
+		delete(c.attrs, name)
+		c.mu.Unlock()
+	}
+	if e.Op&opChmod == 0 {
+		return e, true
+	}
+
+	if c.mode == chmodOn {
+		a, ok := statAttr(name)
+		if !ok {
+			return e, true
+		}
+		c.mu.Lock()
+		prev, seen := c.attrs[name]
+		if !seen || prev != a {
+			// The name may be reused by WithCallback.
+			c.attrs[cloneString(name)] = a
+			c.mu.Unlock()
+			return e, true
+		}
+		c.mu.Unlock()
+	}
+
+	// Only the Chmod bits; also drop events that have nothing else left except
+	// for the IN_ISDIR flag on Linux.
+	e.Op &^= opChmod
+	return e, e.Op&^IN_ISDIR != 0
+}
+
+// poll the mode of the paths returned by list (and the contents of
+// directories) until stopped, sending a Chmod event for every change.
+//
+// The events are sent through filter() by send, which records the new
+// attributes; that way a change is only sent once if the backend also reported
+// it.
+func (c *chmods) poll(list func() []string, send func(Event) bool) {
+	if c.mode != chmodOn {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		t := time.NewTicker(chmodPollInterval)
+		defer t.Stop()
+		for {
+			c.check(list(), send)
+			select {
+			case <-c.done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+func (c *chmods) check(paths []string, send func(Event) bool) {
+	var names []string
+	for _, p := range paths {
+		names = append(names, p)
+		if ls, err := os.ReadDir(p); err == nil {
+			for _, f := range ls {
+				names = append(names, filepath.Join(p, f.Name()))
+			}
+		}
+	}
+
+	seen := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		a, ok := statAttr(n)
+		if !ok {
+			continue
+		}
+		seen[n] = struct{}{}
+
+		c.mu.Lock()
+		prev, ok := c.attrs[n]
+		if !ok {
+			c.attrs[n] = a
+		}
+		c.mu.Unlock()
+		if ok && prev != a {
+			if !send(Event{Name: n, Op: opChmod}) {
+				return
+			}
+		}
+	}
+
+	c.mu.Lock()
+	for n := range c.attrs {
+		if _, ok := seen[n]; !ok {
+			delete(c.attrs, n)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// send an event from poll(); the backend's sendEvent may block on a channel
+// that's already gone by the time we're stopped.
+func (c *chmods) send(ch chan Event, d *delivery, e Event) bool {
+	if !d.blocking() {
+		return d.send(ch, e)
+	}
+	select {
+	case ch <- e:
+		d.sent(e)
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// stop polling and wait for it to finish; this must be called before closing
+// the Events channel.
+func (c *chmods) stop() {
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.wg.Wait()
+}
@@ -0,0 +1,115 @@
+package fsnotify
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWithChmod(t *testing.T) {
+	t.Parallel()
+
+	collect := func(t *testing.T, w *Watcher, f func()) Events {
+		t.Helper()
+		f()
+		var have Events
+		for {
+			select {
+			case e := <-w.Events:
+				have = append(have, e)
+			case err := <-w.Errors:
+				t.Fatal(err)
+			case <-time.After(300 * time.Millisecond):
+				return have
+			}
+		}
+	}
+	hasChmod := func(ev Events) bool {
+		for _, e := range ev {
+			if e.Op&opChmod != 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("off", func(t *testing.T) {
+		t.Parallel()
+
+		tmp := t.TempDir()
+		file := join(tmp, "file")
+		touch(t, file, noWait)
+		w, err := NewWatcherWith(WithChmod(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		addWatch(t, w, tmp)
+
+		if ev := collect(t, w, func() { chmod(t, 0o600, file, noWait) }); hasChmod(ev) {
+			t.Errorf("Chmod sent: %s", ev)
+		}
+	})
+
+	t.Run("on", func(t *testing.T) {
+		t.Parallel()
+		if runtime.GOOS == "windows" {
+			t.Skip("Windows only has a read-only attribute; see TestChmodPoll")
+		}
+
+		tmp := t.TempDir()
+		file := join(tmp, "file")
+		touch(t, file, noWait)
+		w, err := NewWatcherWith(WithChmod(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		addWatch(t, w, tmp)
+
+		// The first Chmod for a path is always sent, as there's nothing to
+		// compare with.
+		if ev := collect(t, w, func() { chmod(t, 0o600, file, noWait) }); !hasChmod(ev) {
+			t.Errorf("no Chmod sent: %s", ev)
+		}
+		if ev := collect(t, w, func() { chmod(t, 0o600, file, noWait) }); hasChmod(ev) {
+			t.Errorf("Chmod sent without changing the mode: %s", ev)
+		}
+		if ev := collect(t, w, func() { chmod(t, 0o640, file, noWait) }); !hasChmod(ev) {
+			t.Errorf("no Chmod sent: %s", ev)
+		}
+	})
+}
+
+func TestChmodPoll(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	file := join(tmp, "file")
+	touch(t, file, noWait)
+
+	c := newChmods(withOpts{chmod: chmodOn})
+	var have Events
+	send := func(e Event) bool {
+		e, ok := c.filter(e)
+		if ok {
+			have = append(have, e)
+		}
+		return true
+	}
+
+	c.check([]string{tmp}, send)
+	if len(have) != 0 {
+		t.Fatalf("events on first check: %s", have)
+	}
+
+	if err := os.Chmod(file, 0o400); err != nil {
+		t.Fatal(err)
+	}
+	c.check([]string{tmp}, send)
+	c.check([]string{tmp}, send)
+	if len(have) != 1 || have[0].Name != file || have[0].Op&opChmod == 0 {
+		t.Fatalf("wrong events: %s", have)
+	}
+}
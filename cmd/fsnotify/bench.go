@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Generate a storm of changes in a temporary directory while watching it, and
+// report how fast the events arrive:
+//
+//	fsnotify bench -n 10000 -ops create,write,rename -backpressure block,coalesce
+//
+// For every file the selected ops are done in order: it's created, written to,
+// and then renamed. The latency of an event is the time since the last change
+// to that path, so it includes time spent in the kernel queue and the Events
+// channel.
+
+type benchResult struct {
+	Backend      string        `json:"backend"`
+	Backpressure string        `json:"backpressure"`
+	Changes      int           `json:"changes"` // Number of syscalls done.
+	Events       int           `json:"events"`
+	Duration     time.Duration `json:"duration"`
+	PerSecond    float64       `json:"per_second"`
+	P50          time.Duration `json:"p50"`
+	P90          time.Duration `json:"p90"`
+	P99          time.Duration `json:"p99"`
+	Max          time.Duration `json:"max"`
+	Dropped      uint64        `json:"dropped"`
+	Overflows    int           `json:"overflows"`
+}
+
+type benchOpts struct {
+	files, dirs, workers int
+	ops                  []string
+	chanSize             uint
+	settle               time.Duration
+}
+
+var benchPolicies = map[string]fsnotify.Backpressure{
+	"block":       fsnotify.BackpressureBlock,
+	"drop-oldest": fsnotify.BackpressureDropOldest,
+	"drop-newest": fsnotify.BackpressureDropNewest,
+	"coalesce":    fsnotify.BackpressureCoalesce,
+}
+
+func bench(args ...string) {
+	var (
+		o        benchOpts
+		ops      string
+		policies string
+		chanSize uint
+		flags    = flag.NewFlagSet("bench", flag.ExitOnError)
+	)
+	flags.IntVar(&o.files, "n", 10000, "Number of files to change.")
+	flags.IntVar(&o.dirs, "dirs", 10, "Number of directories to spread the files over.")
+	flags.IntVar(&o.workers, "workers", runtime.NumCPU(), "Number of goroutines making changes.")
+	flags.StringVar(&ops, "ops", "create,write,rename", "Comma-separated list of changes to make to every file.")
+	flags.StringVar(&policies, "backpressure", "block", "Comma-separated list of backpressure policies to benchmark.")
+	flags.UintVar(&chanSize, "buffer", 0, "Size of the Events channel.")
+	flags.DurationVar(&o.settle, "settle", 500*time.Millisecond, "Stop after no events were received for this long.")
+	flags.Parse(args)
+	o.chanSize = chanSize
+
+	if o.files < 1 || o.dirs < 1 || o.workers < 1 {
+		exit("-n, -dirs, and -workers must be at least 1")
+	}
+	for _, op := range strings.Split(ops, ",") {
+		switch op = strings.TrimSpace(op); op {
+		case "create", "write", "rename":
+			o.ops = append(o.ops, op)
+		default:
+			exit("unknown op for -ops: %q", op)
+		}
+	}
+	if len(o.ops) == 0 || o.ops[0] != "create" {
+		exit("-ops must start with create")
+	}
+
+	var results []benchResult
+	for _, p := range strings.Split(policies, ",") {
+		policy, ok := benchPolicies[strings.TrimSpace(p)]
+		if !ok {
+			exit("unknown policy for -backpressure: %q", p)
+		}
+		r, err := benchRun(o, policy)
+		if err != nil {
+			exit("%s", err)
+		}
+		results = append(results, r)
+	}
+
+	if jsonOutput {
+		for _, r := range results {
+			j, _ := json.Marshal(r)
+			fmt.Println(string(j))
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "BACKEND\tBACKPRESSURE\tCHANGES\tEVENTS\tEVENTS/S\tP50\tP90\tP99\tMAX\tDROPPED\tOVERFLOWS\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.0f\t%s\t%s\t%s\t%s\t%d\t%d\t\n",
+			r.Backend, r.Backpressure, r.Changes, r.Events, r.PerSecond,
+			r.P50.Round(time.Microsecond), r.P90.Round(time.Microsecond),
+			r.P99.Round(time.Microsecond), r.Max.Round(time.Microsecond),
+			r.Dropped, r.Overflows)
+	}
+	tw.Flush()
+}
+
+func benchRun(o benchOpts, policy fsnotify.Backpressure) (benchResult, error) {
+	r := benchResult{Backpressure: policy.String()}
+	tmp, err := os.MkdirTemp("", "fsnotify-bench-")
+	if err != nil {
+		return r, err
+	}
+	defer os.RemoveAll(tmp)
+	if rep, err := fsnotify.Simulate(tmp, fsnotify.SimModel{}); err == nil {
+		r.Backend = rep.Backend
+	}
+	dirs := make([]string, o.dirs)
+	for i := range dirs {
+		dirs[i] = filepath.Join(tmp, fmt.Sprintf("d%d", i))
+		if err := os.Mkdir(dirs[i], 0o755); err != nil {
+			return r, err
+		}
+	}
+
+	w, err := fsnotify.NewWatcherWith(fsnotify.WithBackpressure(policy), fsnotify.WithEventChannelSize(o.chanSize))
+	if err != nil {
+		return r, err
+	}
+	defer w.Close()
+	for _, d := range dirs {
+		if err := w.Add(d); err != nil {
+			return r, fmt.Errorf("%q: %w", d, err)
+		}
+	}
+
+	// Time of the last change to every path.
+	var (
+		mu      sync.Mutex
+		changed = make(map[string]time.Time)
+	)
+	touch := func(paths ...string) {
+		now := time.Now()
+		mu.Lock()
+		for _, p := range paths {
+			changed[p] = now
+		}
+		r.Changes++
+		mu.Unlock()
+	}
+
+	var (
+		latencies []time.Duration
+		last      time.Time
+		done      = make(chan struct{})
+	)
+	go func() {
+		for err := range w.Errors {
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				mu.Lock()
+				r.Overflows++
+				mu.Unlock()
+			}
+		}
+	}()
+	go func() {
+		defer close(done)
+		timer := time.NewTimer(time.Hour)
+		for {
+			select {
+			case e := <-w.Events:
+				last = time.Now()
+				mu.Lock()
+				if t, ok := changed[e.Name]; ok {
+					latencies = append(latencies, last.Sub(t))
+				}
+				mu.Unlock()
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(o.settle)
+			case <-timer.C:
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	var (
+		wg    sync.WaitGroup
+		errMu sync.Mutex
+		first error
+	)
+	next := make(chan int)
+	for i := 0; i < o.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range next {
+				if err := benchFile(dirs[n%len(dirs)], n, o.ops, touch); err != nil {
+					errMu.Lock()
+					if first == nil {
+						first = err
+					}
+					errMu.Unlock()
+				}
+			}
+		}()
+	}
+	for n := 0; n < o.files; n++ {
+		next <- n
+	}
+	close(next)
+	wg.Wait()
+	if first != nil {
+		return r, first
+	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	r.Events = len(latencies)
+	r.Dropped = w.DroppedEvents()
+	if !last.IsZero() {
+		r.Duration = last.Sub(start)
+		r.PerSecond = float64(r.Events) / r.Duration.Seconds()
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		pct := func(p float64) time.Duration { return latencies[int(p*float64(len(latencies)-1))] }
+		r.P50, r.P90, r.P99, r.Max = pct(.5), pct(.9), pct(.99), latencies[len(latencies)-1]
+	}
+	return r, nil
+}
+
+// benchFile makes the changes to a single file.
+func benchFile(dir string, n int, ops []string, touch func(...string)) error {
+	path := filepath.Join(dir, fmt.Sprintf("f%d", n))
+	for _, op := range ops {
+		switch op {
+		case "create":
+			touch(path)
+			fp, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			fp.Close()
+		case "write":
+			touch(path)
+			if err := os.WriteFile(path, []byte("data\n"), 0o644); err != nil {
+				return err
+			}
+		case "rename":
+			touch(path, path+".renamed")
+			if err := os.Rename(path, path+".renamed"); err != nil {
+				return err
+			}
+			path += ".renamed"
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+	"github.com/hohodqr/fsnotify/decor"
+)
+
+// Run long-term with the paths and actions from a config file, which is
+// reloaded when it changes:
+//
+//	fsnotify daemon --config watch.yaml
+//
+// For example:
+//
+//	watches:
+//	  - path: /srv/uploads
+//	    recursive: true
+//	    exclude: ["*.tmp", .git]
+//	    ops: [create, write]     # Only these ops; the default is all.
+//	    debounce: 500ms          # Wait for changes to a path to stop.
+//	    actions:
+//	      - log: true
+//	      - exec: [rsync, "{path}", "backup:/srv/uploads/"]
+//	      - post: http://localhost:8080/hook
+//
+// The exec action works like the exec command, and post sends the event as JSON
+// (like --json prints it).
+//
+// If the new config is invalid or a path can't be watched the error is logged
+// and the previous config is kept.
+
+type (
+	daemonConfig struct {
+		Watches []daemonWatch `json:"watches"`
+	}
+	daemonWatch struct {
+		Path      string         `json:"path"`
+		Recursive bool           `json:"recursive"`
+		Exclude   []string       `json:"exclude"`
+		MaxDepth  int            `json:"max_depth"`
+		Ops       []string       `json:"ops"`
+		Debounce  duration       `json:"debounce"`
+		Actions   []daemonAction `json:"actions"`
+	}
+	daemonAction struct {
+		Log  bool     `json:"log"`
+		Exec []string `json:"exec"`
+		Post string   `json:"post"`
+	}
+	duration time.Duration
+)
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %s; use e.g. \"500ms\"", b)
+	}
+	v, err := time.ParseDuration(s)
+	*d = duration(v)
+	return err
+}
+
+// Op names in the config, and the names that Op.String() uses for them on the
+// various platforms.
+var daemonOps = map[string][]string{
+	"create": {"CREATE", "IN_CREATE", "IN_MOVED_TO"},
+	"write":  {"WRITE", "IN_MODIFY", "IN_CLOSE_WRITE"},
+	"remove": {"REMOVE", "IN_DELETE", "IN_DELETE_SELF"},
+	"rename": {"RENAME", "IN_MOVED_FROM", "IN_MOVE_SELF"},
+	"chmod":  {"CHMOD", "IN_ATTRIB"},
+}
+
+func readConfig(path string) (daemonConfig, error) {
+	var c daemonConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	tree, err := parseYAML(data)
+	if err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	j, err := json.Marshal(tree)
+	if err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, w := range c.Watches {
+		if w.Path == "" {
+			return c, fmt.Errorf("%s: watch %d: no path", path, i+1)
+		}
+		for _, op := range w.Ops {
+			if _, ok := daemonOps[op]; !ok {
+				return c, fmt.Errorf("%s: %s: unknown op %q", path, w.Path, op)
+			}
+		}
+		for _, a := range w.Actions {
+			if n := btoi(a.Log) + btoi(len(a.Exec) > 0) + btoi(a.Post != ""); n != 1 {
+				return c, fmt.Errorf("%s: %s: every action must have exactly one of log, exec, or post", path, w.Path)
+			}
+		}
+		for _, g := range w.Exclude {
+			if _, err := filepath.Match(g, ""); err != nil {
+				return c, fmt.Errorf("%s: %s: exclude %q: %w", path, w.Path, g, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func daemon(args ...string) {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	config := flags.String("config", "", "Path to the config file.")
+	flags.Parse(args)
+	if *config == "" {
+		exit("must specify --config")
+	}
+
+	cfg, err := readConfig(*config)
+	if err != nil {
+		exit("%s", err)
+	}
+	running, err := startWatches(cfg)
+	if err != nil {
+		exit("%s", err)
+	}
+
+	// Reload the config when it changes; FollowRotation keeps working if an
+	// editor replaces the file.
+	cw, err := fsnotify.NewWatcher()
+	if err != nil {
+		exit("creating a new watcher: %s", err)
+	}
+	if err := cw.AddFile(*config, fsnotify.FollowRotation()); err != nil {
+		exit("%q: %s", *config, err)
+	}
+	src := decor.Debounce(decor.Watch(cw), 200*time.Millisecond)
+	defer src.Close()
+	go func() {
+		for err := range src.Errors() {
+			printTime("ERROR: %s: %s", *config, err)
+		}
+	}()
+
+	printTime("ready; press ^C to exit")
+	for range src.Events() {
+		cfg, err := readConfig(*config)
+		if err != nil {
+			printTime("ERROR: not reloading: %s", err)
+			continue
+		}
+		next, err := startWatches(cfg)
+		if err != nil {
+			printTime("ERROR: not reloading: %s", err)
+			continue
+		}
+		for _, s := range running {
+			s.Close()
+		}
+		running = next
+		printTime("reloaded %s", *config)
+	}
+}
+
+// startWatches starts a Watcher for every watch in the config; either all are
+// started, or none are.
+func startWatches(cfg daemonConfig) ([]decor.Source, error) {
+	var started []decor.Source
+	for _, dw := range cfg.Watches {
+		s, err := startWatch(dw)
+		if err != nil {
+			for _, s := range started {
+				s.Close()
+			}
+			return nil, err
+		}
+		started = append(started, s)
+	}
+	return started, nil
+}
+
+func startWatch(dw daemonWatch) (decor.Source, error) {
+	t := tree{
+		roots:     []string{filepath.Clean(dw.Path)},
+		recursive: dw.Recursive,
+		exclude:   dw.Exclude,
+		maxDepth:  dw.MaxDepth,
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := t.dirs()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	for _, d := range dirs {
+		if err := w.Add(d); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("%q: %w", d, err)
+		}
+	}
+
+	src := decor.Filter(decor.Watch(w), func(e fsnotify.Event) bool {
+		if t.excluded(e.Name) {
+			return false
+		}
+		if e.IsDir() && e.Op&(fsnotify.IN_CREATE|fsnotify.IN_MOVED_TO) != 0 && t.watchDir(e.Name) {
+			w.Add(e.Name)
+		}
+		return hasOp(e, dw.Ops)
+	})
+	if dw.Debounce > 0 {
+		src = decor.Debounce(src, time.Duration(dw.Debounce))
+	}
+
+	go func() {
+		for err := range src.Errors() {
+			printTime("ERROR: %s: %s", dw.Path, err)
+		}
+	}()
+	go func() {
+		for e := range src.Events() {
+			eventHistory.add(e.Op.String(), e.Name)
+			for _, a := range dw.Actions {
+				a.run(e)
+			}
+		}
+	}()
+	return src, nil
+}
+
+// hasOp reports if the event has one of the ops, or true if there are none.
+func hasOp(e fsnotify.Event, ops []string) bool {
+	if len(ops) == 0 {
+		return true
+	}
+	have := strings.Split(e.Op.String(), "|")
+	for _, op := range ops {
+		for _, name := range daemonOps[op] {
+			for _, h := range have {
+				if h == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+var postClient = &http.Client{Timeout: 10 * time.Second}
+
+func (a daemonAction) run(e fsnotify.Event) {
+	switch {
+	case a.Log:
+		if jsonOutput {
+			printJSON(e.Op.String(), e.Name, nil)
+		} else {
+			printTime("%s", e)
+		}
+	case len(a.Exec) > 0:
+		run(a.Exec, e)
+	case a.Post != "":
+		body, _ := json.Marshal(jsonEvent{Time: time.Now(), Op: strings.Split(e.Op.String(), "|"), Path: e.Name})
+		resp, err := postClient.Post(a.Post, "application/json", bytes.NewReader(body))
+		if err != nil {
+			printTime("ERROR: %s", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			printTime("ERROR: POST %s: %s", a.Post, resp.Status)
+		}
+	}
+}
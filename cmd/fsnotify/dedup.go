@@ -1,11 +1,9 @@
 package main
 
 import (
-	"math"
-	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/hohodqr/fsnotify"
 )
 
 // Depending on the system, a single "write" can generate many Write events; for
@@ -13,7 +11,8 @@ import (
 // the binary.
 //
 // The general strategy to deal with this is to wait a short time for more write
-// events, resetting the wait period for every new event.
+// events, resetting the wait period for every new event; fsnotify.Deduper does
+// this.
 func dedup(paths ...string) {
 	if len(paths) < 1 {
 		exit("must specify at least one path to watch")
@@ -42,24 +41,16 @@ func dedup(paths ...string) {
 }
 
 func dedupLoop(w *fsnotify.Watcher) {
-	var (
-		// Wait 100ms for new events; each new event resets the timer.
-		waitFor = 100 * time.Millisecond
-
-		// Keep track of the timers, as path → timer.
-		mu     sync.Mutex
-		timers = make(map[string]*time.Timer)
-
-		// Callback we run.
-		printEvent = func(e fsnotify.Event) {
+	// Wait 100ms for new events; each new event resets the timer.
+	d := fsnotify.NewDeduper(100*time.Millisecond, fsnotify.DedupPath, func(e fsnotify.Event) {
+		if jsonOutput {
+			printJSON(e.Op.String(), e.Name, nil)
+		} else {
 			printTime(e.String())
-
-			// Don't need to remove the timer if you don't have a lot of files.
-			mu.Lock()
-			delete(timers, e.Name)
-			mu.Unlock()
 		}
-	)
+		eventHistory.add(e.Op.String(), e.Name)
+	})
+	defer d.Stop()
 
 	for {
 		select {
@@ -68,6 +59,10 @@ func dedupLoop(w *fsnotify.Watcher) {
 			if !ok { // Channel was closed (i.e. Watcher.Close() was called).
 				return
 			}
+			if jsonOutput {
+				printJSON("", "", err)
+				continue
+			}
 			printTime("ERROR: %s", err)
 		// Read from Events.
 		case e, ok := <-w.Events:
@@ -80,24 +75,7 @@ func dedupLoop(w *fsnotify.Watcher) {
 			if !e.Has(fsnotify.Create) && !e.Has(fsnotify.Write) {
 				continue
 			}
-
-			// Get timer.
-			mu.Lock()
-			t, ok := timers[e.Name]
-			mu.Unlock()
-
-			// No timer yet, so create one.
-			if !ok {
-				t = time.AfterFunc(math.MaxInt64, func() { printEvent(e) })
-				t.Stop()
-
-				mu.Lock()
-				timers[e.Name] = t
-				mu.Unlock()
-			}
-
-			// Reset the timer for this path, so it will start from 100ms again.
-			t.Reset(waitFor)
+			d.Add(e)
 		}
 	}
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Report the limits of the platform, how much watching the paths would use,
+// and what to do about it if that's too much or if the paths can't be watched
+// reliably:
+//
+//	fsnotify doctor -r ~/src
+//
+// The exit status is 1 if a limit would be exceeded.
+
+func doctor(args ...string) {
+	var (
+		recursive bool
+		flags     = flag.NewFlagSet("doctor", flag.ExitOnError)
+	)
+	flags.BoolVar(&recursive, "r", false, "Check watching the directories recursively.")
+	flags.BoolVar(&recursive, "recursive", false, "Check watching the directories recursively.")
+	flags.Parse(args)
+	paths := flags.Args()
+
+	// Simulate reads the limits for us; with no paths the current directory
+	// is used just for that.
+	var (
+		reports []fsnotify.SimReport
+		total   fsnotify.SimReport
+	)
+	for i, p := range append([]string{"."}, paths...) {
+		r, err := fsnotify.Simulate(filepath.Clean(p), fsnotify.SimModel{Recursive: recursive})
+		if err != nil {
+			exit("%s", err)
+		}
+		reports = append(reports, r)
+		if i == 0 {
+			continue
+		}
+		total.Watches += r.Watches
+		total.FileDescriptors += r.FileDescriptors
+		total.Memory += r.Memory
+	}
+	limits := reports[0]
+	if limits.Backend == "inotify" {
+		total.FileDescriptors = 1 // One inotify instance for all paths.
+	}
+
+	fmt.Println("Platform")
+	fmt.Printf("    %s/%s, %s backend\n", runtime.GOOS, runtime.GOARCH, limits.Backend)
+
+	fmt.Println("\nLimits")
+	var inotifyWatches, inotifyInstances, maxInstances int
+	switch limits.Backend {
+	case "inotify":
+		maxInstances = readInt("/proc/sys/fs/inotify/max_user_instances")
+		inotifyInstances, inotifyWatches = inotifyUse()
+		fmt.Printf("    max_user_watches     %s (%d in use by this user)\n", limit(limits.WatchLimit), inotifyWatches)
+		fmt.Printf("    max_user_instances   %s (%d in use by this user)\n", limit(maxInstances), inotifyInstances)
+		fmt.Printf("    max_queued_events    %s\n", limit(limits.QueueSize))
+	case "windows":
+		fmt.Printf("    buffer size          %d bytes per watch (about %d events)\n", 65536, limits.QueueSize)
+	}
+	fmt.Printf("    open files           %s\n", limit(limits.FDLimit))
+
+	var problems []string
+	if len(paths) > 0 {
+		fmt.Println("\nThis invocation")
+		for i, r := range reports[1:] {
+			fmt.Printf("    %s: %d directories, %d files; %d watches, %d file descriptors, ~%s memory\n",
+				paths[i], r.Dirs, r.Files, r.Watches, r.FileDescriptors, size(r.Memory))
+		}
+		if len(paths) > 1 {
+			fmt.Printf("    total: %d watches, %d file descriptors, ~%s memory\n",
+				total.Watches, total.FileDescriptors, size(total.Memory))
+		}
+
+		switch limits.Backend {
+		case "inotify":
+			if need := inotifyWatches + total.Watches; limits.WatchLimit > 0 && need > limits.WatchLimit {
+				problems = append(problems, fmt.Sprintf(
+					"Not enough inotify watches: need %d (%d for this and %d already in use), but the limit is %d.\n"+
+						"Raise the limit with:\n\n"+
+						"        sysctl fs.inotify.max_user_watches=%d\n\n"+
+						"    And add this to /etc/sysctl.d/fsnotify.conf to keep it after a reboot:\n\n"+
+						"        fs.inotify.max_user_watches=%[5]d\n\n"+
+						"    Each watch uses about 1K of kernel memory.",
+					need, total.Watches, inotifyWatches, limits.WatchLimit, roundUp(need*2)))
+			}
+			if maxInstances > 0 && inotifyInstances+1 > maxInstances {
+				problems = append(problems, fmt.Sprintf(
+					"Not enough inotify instances: %d of %d are in use. Raise the limit with:\n\n"+
+						"        sysctl fs.inotify.max_user_instances=%d\n\n"+
+						"    Or use fewer Watchers; a single Watcher can watch any number of paths.",
+					inotifyInstances, maxInstances, roundUp(maxInstances*2)))
+			}
+		case "kqueue":
+			if limits.FDLimit > 0 && total.FileDescriptors > limits.FDLimit {
+				fix := "        ulimit -n %d\n\n    For a service set LimitNOFILE= (systemd) or the equivalent."
+				if runtime.GOOS == "darwin" {
+					fix = "        ulimit -n %d\n\n    The system-wide limits are the kern.maxfiles and kern.maxfilesperproc sysctls."
+				}
+				problems = append(problems, fmt.Sprintf(
+					"Not enough file descriptors: kqueue needs one for every file and directory (%d), but the limit is %d.\n"+
+						"Raise the limit with:\n\n"+fix+"\n\n"+
+						"    Or watch fewer files: exclude large directories such as .git and node_modules, or use\n"+
+						"    fsnotify.WithFilePolling, which only needs one for every directory.",
+					total.FileDescriptors, limits.FDLimit, roundUp(total.FileDescriptors*2)))
+			}
+		case "windows":
+			if !recursive && total.Watches > 1000 {
+				problems = append(problems, fmt.Sprintf(
+					"Watching %d directories uses a handle and a %dK buffer for each. Watch the root with a\n"+
+						"    recursive watch instead (Add(\"dir/...\")), which uses one for the entire tree.",
+					total.Watches, 64))
+			}
+		}
+		for _, p := range paths {
+			c, err := fsnotify.Probe(p)
+			if err != nil || c.Native {
+				continue
+			}
+			why := "it's a pseudo filesystem"
+			if c.Network {
+				why = "changes made on other machines aren't reported"
+			} else if strings.Contains(strings.ToLower(c.Filesystem), "fuse") {
+				why = "it's a FUSE filesystem"
+			}
+			problems = append(problems, fmt.Sprintf(
+				"%s is on %s, which can't be watched reliably: %s. Poll it for changes instead.",
+				p, c.Filesystem, why))
+		}
+		if limits.Backend != "inotify" && limits.Backend != "kqueue" && limits.FDLimit > 0 && total.FileDescriptors > limits.FDLimit {
+			problems = append(problems, fmt.Sprintf(
+				"Not enough file descriptors: need %d, but the limit is %d. Raise it with \"ulimit -n %d\".",
+				total.FileDescriptors, limits.FDLimit, roundUp(total.FileDescriptors*2)))
+		}
+	}
+
+	switch {
+	case len(problems) > 0:
+		fmt.Println("\nProblems")
+		for _, p := range problems {
+			fmt.Printf("  - %s\n", p)
+		}
+	case len(paths) > 0:
+		fmt.Println("\nNo problems found.")
+	}
+
+	// General advice for things we can't check in advance.
+	fmt.Println("\nIf events are being lost")
+	switch limits.Backend {
+	case "inotify":
+		fmt.Println("    Raise fs.inotify.max_queued_events, or read events faster.")
+	case "windows":
+		fmt.Println("    Increase the buffer with WithBufferSize (up to 64K for network shares), or read events faster.")
+	default:
+		fmt.Println("    Read events faster, or use WithBackpressure to choose what to drop.")
+	}
+
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// inotifyUse counts the inotify instances and watches of the processes we can
+// see in /proc, which are usually those of the same user; the limits are per
+// user.
+func inotifyUse() (instances, watches int) {
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if l, err := os.Readlink(fd); err != nil || l != "anon_inode:inotify" {
+			continue
+		}
+		instances++
+		info, err := os.ReadFile(strings.Replace(fd, "/fd/", "/fdinfo/", 1))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(info), "\n") {
+			if strings.HasPrefix(line, "inotify wd:") {
+				watches++
+			}
+		}
+	}
+	return instances, watches
+}
+
+func readInt(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return n
+}
+
+func limit(n int) string {
+	if n <= 0 {
+		return "unknown or unlimited"
+	}
+	return strconv.Itoa(n)
+}
+
+// roundUp rounds n up to a "nice" number to suggest as a limit.
+func roundUp(n int) int {
+	for _, v := range []int{1024, 8192, 65536, 131072, 524288, 1048576} {
+		if n <= v {
+			return v
+		}
+	}
+	return (n/1048576 + 1) * 1048576
+}
+
+func size(n int) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
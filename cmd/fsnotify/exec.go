@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+	"github.com/hohodqr/fsnotify/decor"
+)
+
+// Run a command for every change, like "inotifywait -m | while read ..." but
+// cross-platform:
+//
+//	fsnotify exec [paths] -- cmd [args]
+//
+// Events are debounced per path, and the command is run once for every path
+// after it stopped changing. In the arguments {path} and {op} are replaced with
+// the path and the operations, and they're also set in the FSNOTIFY_PATH and
+// FSNOTIFY_OP environment variables. Commands are run one at a time, in the
+// order of the events.
+func execCmd(args ...string) {
+	var (
+		paths []string
+		cmd   []string
+	)
+	for i, a := range args {
+		if a == "--" {
+			paths, cmd = args[:i], args[i+1:]
+			break
+		}
+	}
+	if len(paths) < 1 {
+		exit("must specify at least one path to watch")
+	}
+	if len(cmd) < 1 {
+		exit("must specify a command after --")
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		exit("creating a new watcher: %s", err)
+	}
+	src := decor.Debounce(decor.Watch(w), 100*time.Millisecond)
+	defer src.Close()
+
+	for _, p := range paths {
+		err = w.Add(p)
+		if err != nil {
+			exit("%q: %s", p, err)
+		}
+	}
+
+	go func() {
+		for err := range src.Errors() {
+			if jsonOutput {
+				printJSON("", "", err)
+				continue
+			}
+			printTime("ERROR: %s", err)
+		}
+	}()
+
+	printTime("ready; press ^C to exit")
+	for e := range src.Events() {
+		eventHistory.add(e.Op.String(), e.Name)
+		if jsonOutput {
+			printJSON(e.Op.String(), e.Name, nil)
+		}
+		run(cmd, e)
+	}
+}
+
+// run the command for the event.
+func run(cmd []string, e fsnotify.Event) {
+	r := strings.NewReplacer("{path}", e.Name, "{op}", e.Op.String())
+	args := make([]string, len(cmd))
+	for i, a := range cmd {
+		args[i] = r.Replace(a)
+	}
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = nil, os.Stdout, os.Stderr
+	c.Env = append(os.Environ(), "FSNOTIFY_PATH="+e.Name, "FSNOTIFY_OP="+e.Op.String())
+	if err := c.Run(); err != nil {
+		printTime("%s: %s", strings.Join(args, " "), err)
+	}
+}
@@ -1,15 +1,11 @@
 package main
 
-import (
-	"os"
-	"path/filepath"
+import "github.com/hohodqr/fsnotify"
 
-	"github.com/fsnotify/fsnotify"
-)
-
-// Watch one or more files, but instead of watching the file directly it watches
-// the parent directory. This solves various issues where files are frequently
-// renamed, such as editors saving them.
+// Watch one or more files with FollowRotation, which watches the parent
+// directory instead of the file itself. This solves various issues where files
+// are frequently renamed, such as editors saving them or logrotate, and sends a
+// Rotated event when the file is replaced.
 func file(files ...string) {
 	if len(files) < 1 {
 		exit("must specify at least one file to watch")
@@ -23,21 +19,11 @@ func file(files ...string) {
 	defer w.Close()
 
 	// Start listening for events.
-	go fileLoop(w, files)
+	go fileLoop(w)
 
 	// Add all files from the commandline.
 	for _, p := range files {
-		st, err := os.Lstat(p)
-		if err != nil {
-			exit("%s", err)
-		}
-
-		if st.IsDir() {
-			exit("%q is a directory, not a file", p)
-		}
-
-		// Watch the directory, not the file itself.
-		err = w.Add(filepath.Dir(p))
+		err = w.AddFile(p, fsnotify.FollowRotation())
 		if err != nil {
 			exit("%q: %s", p, err)
 		}
@@ -47,7 +33,7 @@ func file(files ...string) {
 	<-make(chan struct{}) // Block forever
 }
 
-func fileLoop(w *fsnotify.Watcher, files []string) {
+func fileLoop(w *fsnotify.Watcher) {
 	i := 0
 	for {
 		select {
@@ -56,6 +42,10 @@ func fileLoop(w *fsnotify.Watcher, files []string) {
 			if !ok { // Channel was closed (i.e. Watcher.Close() was called).
 				return
 			}
+			if jsonOutput {
+				printJSON("", "", err)
+				continue
+			}
 			printTime("ERROR: %s", err)
 		// Read from Events.
 		case e, ok := <-w.Events:
@@ -63,23 +53,15 @@ func fileLoop(w *fsnotify.Watcher, files []string) {
 				return
 			}
 
-			// Ignore files we're not interested in. Can use a
-			// map[string]struct{} if you have a lot of files, but for just a
-			// few files simply looping over a slice is faster.
-			var found bool
-			for _, f := range files {
-				if f == e.Name {
-					found = true
-				}
-			}
-			if !found {
-				continue
-			}
-
 			// Just print the event nicely aligned, and keep track how many
 			// events we've seen.
 			i++
-			printTime("%3d %s", i, e)
+			if jsonOutput {
+				printJSON(e.Op.String(), e.Name, nil)
+			} else {
+				printTime("%3d %s", i, e)
+			}
+			eventHistory.add(e.Op.String(), e.Name)
 		}
 	}
 }
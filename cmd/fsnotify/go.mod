@@ -0,0 +1,35 @@
+// The fsnotify command is a separate module, so that importing fsnotify doesn't
+// pull in its dependencies (gRPC and Prometheus).
+module github.com/hohodqr/fsnotify/cmd/fsnotify
+
+go 1.17
+
+require (
+	github.com/hohodqr/fsnotify v0.0.0-00010101000000-000000000000
+	github.com/hohodqr/fsnotify/rpc v0.0.0-00010101000000-000000000000
+	github.com/prometheus/client_golang v1.14.0
+	github.com/segmentio/kafka-go v0.4.47
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.42.0 // indirect
+	github.com/prometheus/procfs v0.8.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+	google.golang.org/grpc v1.56.3 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+)
+
+replace (
+	github.com/hohodqr/fsnotify => ../../
+	github.com/hohodqr/fsnotify/rpc => ../../rpc
+)
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Keep the last few thousand events in memory so that scripts can poll for
+// them over HTTP, if the listener is enabled with --events-addr:
+//
+//	GET /events?since=cursor&path=glob&timeout=30s
+//
+// Every event gets an increasing ID; "since" is the last ID the client has seen
+// (the "cursor" from the previous response, or 0 to start). If there are no
+// newer events the request blocks until there are, or until the timeout
+// expires. "path" is an optional glob matched against the event path with
+// filepath.Match.
+var eventHistory = newHistory(4096)
+
+type (
+	history struct {
+		mu      sync.Mutex
+		buf     []historyEvent // Ring buffer.
+		start   int            // Index of the oldest event in buf.
+		last    uint64         // ID of the newest event.
+		changed chan struct{}  // Closed (and replaced) when an event is added.
+	}
+	historyEvent struct {
+		ID   uint64    `json:"id"`
+		Time time.Time `json:"time"`
+		Op   string    `json:"op"`
+		Path string    `json:"path"`
+	}
+	historyResponse struct {
+		Cursor    uint64         `json:"cursor"`
+		Truncated bool           `json:"truncated"` // Some events after "since" were already discarded.
+		Events    []historyEvent `json:"events"`
+	}
+)
+
+func newHistory(size int) *history {
+	return &history{
+		buf:     make([]historyEvent, 0, size),
+		changed: make(chan struct{}),
+	}
+}
+
+// add records an event.
+func (h *history) add(op, path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.last++
+	e := historyEvent{ID: h.last, Time: time.Now(), Op: op, Path: path}
+	if len(h.buf) < cap(h.buf) {
+		h.buf = append(h.buf, e)
+	} else {
+		h.buf[h.start] = e
+		h.start = (h.start + 1) % len(h.buf)
+	}
+
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// since returns all events newer than the cursor that match the glob, the new
+// cursor, and a channel that's closed when the next event is added.
+func (h *history) since(cursor uint64, glob string) (historyResponse, <-chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resp := historyResponse{Cursor: h.last, Events: []historyEvent{}}
+	if cursor > h.last { // Cursor from before a restart; start over.
+		cursor = 0
+	}
+	for i := range h.buf {
+		e := h.buf[(h.start+i)%len(h.buf)]
+		if i == 0 && e.ID > cursor+1 && cursor > 0 {
+			resp.Truncated = true
+		}
+		if e.ID <= cursor {
+			continue
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, e.Path); !ok {
+				continue
+			}
+		}
+		resp.Events = append(resp.Events, e)
+	}
+	return resp, h.changed
+}
+
+func (h *history) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	var cursor uint64
+	if s := q.Get("since"); s != "" {
+		var err error
+		cursor, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	glob := q.Get("path")
+	if _, err := filepath.Match(glob, ""); err != nil {
+		http.Error(w, "invalid path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	timeout := 30 * time.Second
+	if s := q.Get("timeout"); s != "" {
+		var err error
+		timeout, err = time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, "invalid timeout: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		resp, changed := h.since(cursor, glob)
+		if len(resp.Events) > 0 || resp.Truncated {
+			writeJSON(w, resp)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			writeJSON(w, resp)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With --json events and errors are printed to stdout as newline-delimited
+// JSON, one object per line, instead of as log lines:
+//
+//	{"time":"2006-01-02T15:04:05.999999999Z","op":["CREATE"],"path":"/tmp/file"}
+//	{"time":"2006-01-02T15:04:05.999999999Z","error":"..."}
+//
+// So it can be piped to jq and the like.
+var jsonOutput bool
+
+type jsonEvent struct {
+	Time  time.Time `json:"time"`
+	Op    []string  `json:"op,omitempty"`
+	Path  string    `json:"path,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+var (
+	jsonMu  sync.Mutex // dedup prints from timers.
+	jsonEnc = json.NewEncoder(os.Stdout)
+)
+
+// printJSON prints an event with the op as returned by Op.String(), or an
+// error if err is set.
+func printJSON(op, path string, err error) {
+	e := jsonEvent{Time: time.Now(), Path: path}
+	if op != "" {
+		e.Op = strings.Split(op, "|")
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	jsonEnc.Encode(e)
+}
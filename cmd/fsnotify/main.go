@@ -4,10 +4,12 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -21,11 +23,150 @@ https://github.com/fsnotify/fsnotify
 
 Commands:
 
-    watch [paths]  Watch the paths for changes and print the events.
+    watch [flags] [paths]
+                   Watch the paths for changes and print the events.
+
+                   -r, -recursive   Watch directories recursively.
+                   -exclude glob    Don't watch or print paths matching the
+                                    glob, matched against the full path and
+                                    every directory name below the watched
+                                    path. Can be repeated.
+                   -max-depth n     Only watch n levels of subdirectories.
+                   -backend name    Use the backend name: poll to scan the
+                                    paths every second, or inotify, kqueue,
+                                    windows, or fen to fail if that's not
+                                    the backend for the platform.
     file  [file]   Watch a single file for changes.
     dedup [paths]  Watch the paths for changes, suppressing duplicate events.
+    exec  [paths] -- cmd [args]
+                   Run a command for every changed path, with {path} and {op}
+                   in the arguments (and $FSNOTIFY_PATH and $FSNOTIFY_OP)
+                   replaced with the path and operations.
+    daemon --config file
+                   Run with the paths, filters, and actions from a YAML config
+                   file, which is reloaded when it changes. See daemon.go for
+                   the format.
+    stats [flags] [paths]
+                   Show the events per second by op and the busiest paths,
+                   refreshed every interval, and the number of watches and
+                   dropped events. Takes the same flags as watch, and:
+
+                   -interval d      How often to refresh; the default is 1s.
+                   -top n           Number of paths to show; the default is 10.
+    doctor [-r] [paths]
+                   Show the limits of the platform, how many watches and file
+                   descriptors watching the paths would use, and how to raise
+                   the limits if they're too low. Paths on network or pseudo
+                   filesystems, which should be polled, are reported too.
+    bench [flags]  Create, write, and rename files in a temporary directory
+                   while watching it, and report the throughput, latency
+                   percentiles, and dropped events.
+
+                   -n n             Number of files; the default is 10000.
+                   -ops list        Changes to make to every file; the default
+                                    is create,write,rename.
+                   -backpressure list
+                                    Backpressure policies to run with; the
+                                    default is block.
+                   -buffer n        Size of the Events channel.
+    replay [-speed n] journal [-- cmd [args]]
+                   Print the events from a journal written with WithJournal
+                   with the recorded delays between them, or run the command
+                   for every event like exec. -speed 2x plays it twice as fast,
+                   and -speed max doesn't wait.
+    serve [flags] [paths]
+                   Serve a watcher over gRPC for clients of the rpc package,
+                   which can add and remove watches and stream the events.
+
+                   -grpc addr       Address; the default is localhost:7070.
+                   -token-file f    Require clients to send the token in f.
+                   -cert f -key f   Use TLS with this certificate and key.
+
+                   Use -token-file and -cert when listening on anything other
+                   than localhost, as clients can watch any readable path.
+    publish [flags] [paths]
+                   Publish the events as JSON to a message bus. Takes the same
+                   flags as watch, and:
+
+                   -nats url        NATS server, e.g. nats://localhost:4222.
+                   -mqtt url        MQTT broker, e.g. mqtt://localhost:1883.
+                   -kafka url       Kafka brokers, e.g. kafka://host1,host2:9092.
+                   -subject s       Subject or topic; the default is fs.events.
+
+                   NATS waits for the server to acknowledge every event, so
+                   it's limited to one event per round trip; Kafka sends them
+                   in batches.
+
+Flags:
+
+    --json         Print events as newline-delimited JSON on stdout, one
+                   object per event with the time, op names, and path.
+    --metrics-addr addr
+                   Address for the HTTP listener with the Prometheus metrics
+                   on /metrics; the default is localhost:6061. Use e.g.
+                   :6061 to listen on all interfaces.
+    --no-metrics   Don't start the metrics listener.
+    --events-addr addr
+                   Start an HTTP listener with the events on /events, e.g.
+                   localhost:6062. This is off by default, as it publishes
+                   every watched path without authentication; only use an
+                   address other than localhost on trusted networks.
+
+The listeners are only started for the commands that keep running: watch,
+file, dedup, exec, daemon, stats, serve, and publish. It's an error if the
+address is in use; use --no-metrics or another --metrics-addr to run more than
+one of these at the same time.
+
+With --events-addr scripts can long-poll for the events with:
+
+    GET /events?since=cursor&path=glob&timeout=30s
+
+This returns a JSON object with all events after the cursor (0 to start) that
+match the optional path glob, and the cursor to use for the next request.
 `[1:]
 
+var (
+	metricsAddr = "localhost:6061"
+	noMetrics   bool
+	eventsAddr  string
+)
+
+// globalFlags removes the flags that apply to all commands from args. Any
+// arguments after "--" are left alone.
+func globalFlags(args []string) []string {
+	out := args[:0]
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			return append(out, args[i:]...)
+		case a == "--json" || a == "-json":
+			jsonOutput = true
+		case a == "--no-metrics" || a == "-no-metrics":
+			noMetrics = true
+		case a == "--metrics-addr" || a == "-metrics-addr":
+			if i+1 == len(args) {
+				exit("%s needs an address", a)
+			}
+			i++
+			metricsAddr = args[i]
+		case strings.HasPrefix(a, "--metrics-addr=") || strings.HasPrefix(a, "-metrics-addr="):
+			metricsAddr = a[strings.IndexByte(a, '=')+1:]
+		case a == "--events-addr" || a == "-events-addr":
+			if i+1 == len(args) {
+				exit("%s needs an address", a)
+			}
+			i++
+			eventsAddr = args[i]
+		case strings.HasPrefix(a, "--events-addr=") || strings.HasPrefix(a, "-events-addr="):
+			eventsAddr = a[strings.IndexByte(a, '=')+1:]
+		default:
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
 func exit(format string, a ...interface{}) {
 	fmt.Fprintf(os.Stderr, filepath.Base(os.Args[0])+": "+format+"\n", a...)
 	fmt.Print("\n" + usage)
@@ -52,19 +193,47 @@ func main() {
 	if len(os.Args) == 1 {
 		help()
 	}
-	// Always show help if -h[elp] appears anywhere before we do anything else.
+	// Always show help if -h[elp] appears anywhere before we do anything else;
+	// but not in the command for exec.
 	for _, f := range os.Args[1:] {
+		if f == "--" {
+			break
+		}
 		switch f {
 		case "help", "-h", "-help", "--help":
 			help()
 		}
 	}
-	// pro()
-	server := http.NewServeMux()
-	server.Handle("/metrics", promhttp.Handler())
-	go http.ListenAndServe("0.0.0.0:6061", server)
+	args := globalFlags(os.Args[1:])
+	if len(args) == 0 {
+		help()
+	}
+	cmd, args := args[0], args[1:]
+
+	// Short commands such as doctor shouldn't fail because another fsnotify
+	// is already listening.
+	listen := map[string]bool{"watch": true, "file": true, "dedup": true, "exec": true,
+		"daemon": true, "stats": true, "serve": true, "publish": true}[cmd]
 
-	cmd, args := os.Args[1], os.Args[2:]
+	// pro()
+	if listen && !noMetrics {
+		server := http.NewServeMux()
+		server.Handle("/metrics", promhttp.Handler())
+		l, err := net.Listen("tcp", metricsAddr)
+		if err != nil {
+			exit("metrics listener: %s", err)
+		}
+		go func() { log.Fatalf("metrics listener: %s", http.Serve(l, server)) }()
+	}
+	if listen && eventsAddr != "" {
+		server := http.NewServeMux()
+		server.Handle("/events", eventHistory)
+		l, err := net.Listen("tcp", eventsAddr)
+		if err != nil {
+			exit("events listener: %s", err)
+		}
+		go func() { log.Fatalf("events listener: %s", http.Serve(l, server)) }()
+	}
 	switch cmd {
 	default:
 		exit("unknown command: %q", cmd)
@@ -74,6 +243,22 @@ func main() {
 		file(args...)
 	case "dedup":
 		dedup(args...)
+	case "exec":
+		execCmd(args...)
+	case "daemon":
+		daemon(args...)
+	case "stats":
+		statsCmd(args...)
+	case "doctor":
+		doctor(args...)
+	case "bench":
+		bench(args...)
+	case "replay":
+		replay(args...)
+	case "serve":
+		serve(args...)
+	case "publish":
+		publish(args...)
 	}
 
 }
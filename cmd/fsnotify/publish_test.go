@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// listen starts a fake broker that runs serve for the first connection.
+func listen(t *testing.T, serve func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+	return l.Addr().String()
+}
+
+func TestNATS(t *testing.T) {
+	type msg struct{ subject, data string }
+	var (
+		connect = make(chan map[string]interface{}, 1)
+		msgs    = make(chan msg, 10)
+	)
+	addr := listen(t, func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		io.WriteString(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		for {
+			l, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			l = strings.TrimSpace(l)
+			switch {
+			case strings.HasPrefix(l, "CONNECT "):
+				var opts map[string]interface{}
+				json.Unmarshal([]byte(l[8:]), &opts)
+				connect <- opts
+				io.WriteString(conn, "+OK\r\n")
+			case l == "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case strings.HasPrefix(l, "PUB "):
+				f := strings.Fields(l)
+				n, _ := strconv.Atoi(f[2])
+				data := make([]byte, n+2)
+				io.ReadFull(br, data)
+
+				io.WriteString(conn, "PING\r\n") // Must be answered.
+				if l, _ := br.ReadString('\n'); l != "PONG\r\n" {
+					io.WriteString(conn, "-ERR 'Expected PONG'\r\n")
+					return
+				}
+				if f[1] == "denied" {
+					io.WriteString(conn, "-ERR 'Permissions Violation for Publish to \"denied\"'\r\n")
+					continue
+				}
+				msgs <- msg{f[1], string(data[:n])}
+				io.WriteString(conn, "+OK\r\n")
+			}
+		}
+	})
+
+	s, err := dialNATS("nats://user:pass@" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	opts := <-connect
+	if opts["verbose"] != true || opts["user"] != "user" || opts["pass"] != "pass" {
+		t.Errorf("wrong CONNECT options: %v", opts)
+	}
+
+	// The first rejected PUB should be an error, not only the one after it.
+	if err := s.publish("denied", "", []byte("x")); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("wrong error for denied subject: %v", err)
+	}
+	for _, data := range []string{"hello", "", "a\r\nb"} {
+		if err := s.publish("fs.events", "", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if m := <-msgs; m != (msg{"fs.events", data}) {
+			t.Errorf("have %q, want %q", m, msg{"fs.events", data})
+		}
+	}
+}
+
+func TestNATSConnectError(t *testing.T) {
+	addr := listen(t, func(conn net.Conn) {
+		io.WriteString(conn, "INFO {}\r\n")
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+	})
+	_, err := dialNATS("nats://token@" + addr)
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestNATSClosed(t *testing.T) {
+	addr := listen(t, func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		io.WriteString(conn, "INFO {}\r\n")
+		br.ReadString('\n')
+		br.ReadString('\n')
+		io.WriteString(conn, "+OK\r\nPONG\r\n")
+		br.ReadString('\n') // PUB; close without a reply.
+	})
+	s, err := dialNATS("nats://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if err := s.publish("fs.events", "", []byte("x")); err == nil {
+		t.Error("no error when the connection is closed")
+	}
+}
+
+// byteReader reads one byte at a time, so nothing after the packet is read.
+type byteReader struct{ io.Reader }
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r, b[:])
+	return b[0], err
+}
+
+// readMQTT reads a packet, and returns the type and body.
+func readMQTT(r io.Reader) (byte, []byte, error) {
+	br := byteReader{r}
+	typ, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return typ, body, err
+}
+
+func TestMQTTPacket(t *testing.T) {
+	// The remaining length is a varint with the same encoding as
+	// encoding/binary.
+	for _, n := range []int{0, 1, 127, 128, 16383, 16384, 2097151, 2097152} {
+		p := mqttPacket(0x30, make([]byte, n))
+		typ, body, err := readMQTT(bytes.NewReader(p))
+		if err != nil {
+			t.Fatalf("%d: %s", n, err)
+		}
+		if typ != 0x30 || len(body) != n {
+			t.Errorf("%d: have type %#x and length %d", n, typ, len(body))
+		}
+	}
+
+	if have := mqttString("abc"); !bytes.Equal(have, []byte{0, 3, 'a', 'b', 'c'}) {
+		t.Errorf("mqttString: %v", have)
+	}
+}
+
+func TestMQTT(t *testing.T) {
+	type packet struct {
+		typ  byte
+		body []byte
+	}
+	packets := make(chan packet, 10)
+	addr := listen(t, func(conn net.Conn) {
+		for {
+			typ, body, err := readMQTT(conn)
+			if err != nil {
+				close(packets)
+				return
+			}
+			packets <- packet{typ, body}
+			if typ == 0x10 {
+				conn.Write([]byte{0x20, 2, 0, 0}) // CONNACK
+			}
+		}
+	})
+
+	s, err := dialMQTT("mqtt://user:pass@" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := <-packets
+	// Protocol name, level 4, clean session with username and password, the
+	// keep-alive, and the payload with the client ID, username and password.
+	want := append(mqttString("MQTT"), 4, 0xc2, 0, 60)
+	if p.typ != 0x10 || !bytes.HasPrefix(p.body, want) {
+		t.Fatalf("wrong CONNECT: %#x %v", p.typ, p.body)
+	}
+	if !bytes.HasSuffix(p.body, append(mqttString("user"), mqttString("pass")...)) {
+		t.Errorf("no username and password in CONNECT: %v", p.body)
+	}
+
+	if err := s.publish("fs/events", "", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	p = <-packets
+	if want := append(mqttString("fs/events"), "hello"...); p.typ != 0x30 || !bytes.Equal(p.body, want) {
+		t.Errorf("wrong PUBLISH: %#x %q", p.typ, p.body)
+	}
+
+	s.close()
+	if p := <-packets; p.typ != 0xe0 || len(p.body) != 0 {
+		t.Errorf("wrong DISCONNECT: %#x %v", p.typ, p.body)
+	}
+}
+
+func TestMQTTRefused(t *testing.T) {
+	addr := listen(t, func(conn net.Conn) {
+		readMQTT(conn)
+		conn.Write([]byte{0x20, 2, 0, 5}) // Not authorized.
+	})
+	_, err := dialMQTT("mqtt://" + addr)
+	if err == nil || !strings.Contains(err.Error(), "refused with code 5") {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	if _, err := dialMQTT("mqtts://" + addr); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Errorf("wrong error for mqtts: %v", err)
+	}
+}
+
+func TestKafkaBrokers(t *testing.T) {
+	tests := []struct {
+		in, user, pass string
+		want           []string
+		wantErr        string
+	}{
+		{"kafka://localhost", "", "", []string{"localhost:9092"}, ""},
+		{"kafka://localhost:9093/", "", "", []string{"localhost:9093"}, ""},
+		{"kafka://a,b:9093,[::1]", "", "", []string{"a:9092", "b:9093", "[::1]:9092"}, ""},
+		{"kafka://user:p@ss@a", "user", "p@ss", []string{"a:9092"}, ""},
+		{"kafka://user@a", "user", "", []string{"a:9092"}, ""},
+		{"kafka://", "", "", nil, "no broker"},
+		{"kafka://a,,b", "", "", nil, "no broker"},
+		{"kafkas://a", "", "", nil, "unsupported URL"},
+		{"localhost:9092", "", "", nil, "unsupported URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have, user, err := kafkaBrokers(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("\nin:         %q\nhave error: %v\nwant error: %s", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("\nin: %q\nerror: %s", tt.in, err)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nin:   %q\nhave: %q\nwant: %q", tt.in, have, tt.want)
+			}
+			var name, pass string
+			if user != nil {
+				name = user.Username()
+				pass, _ = user.Password()
+			}
+			if name != tt.user || pass != tt.pass {
+				t.Errorf("\nin:   %q\nhave user %q:%q\nwant user %q:%q", tt.in, name, pass, tt.user, tt.pass)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Print the events from a journal written with WithJournal, with the same
+// delays between them as when they were recorded, to reproduce bug reports
+// without the original filesystem:
+//
+//	fsnotify replay -speed 2x journal.ndjson
+//	fsnotify replay journal.ndjson.gz -- cmd [args]
+//
+// With a command it's run for every event, like the exec command (but without
+// debouncing). The journal is read from stdin if the path is "-".
+
+// speed is a flag for the playback speed: "2x" or "2" is twice as fast, "max"
+// or "0" doesn't wait at all.
+type speed float64
+
+func (s *speed) String() string {
+	if *s == 0 {
+		return "max"
+	}
+	return strconv.FormatFloat(float64(*s), 'g', -1, 64) + "x"
+}
+
+func (s *speed) Set(v string) error {
+	if v == "max" {
+		*s = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSuffix(v, "x"), 64)
+	if err != nil || f < 0 {
+		return fmt.Errorf("invalid speed %q; use e.g. 2x, 0.5x, or max", v)
+	}
+	*s = speed(f)
+	return nil
+}
+
+func replay(args ...string) {
+	var (
+		sp    = speed(1)
+		cmd   []string
+		flags = flag.NewFlagSet("replay", flag.ExitOnError)
+	)
+	flags.Var(&sp, "speed", "Playback speed: 2x is twice as fast, max doesn't wait.")
+	for i, a := range args {
+		if a == "--" {
+			args, cmd = args[:i], args[i+1:]
+			if len(cmd) == 0 {
+				exit("must specify a command after --")
+			}
+			break
+		}
+	}
+
+	// Allow flags after the path.
+	var paths []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		paths = append(paths, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(paths) != 1 {
+		exit("must specify one journal")
+	}
+
+	var in io.Reader = os.Stdin
+	if paths[0] != "-" {
+		fp, err := os.Open(paths[0])
+		if err != nil {
+			exit("%s", err)
+		}
+		defer fp.Close()
+		in = fp
+	}
+	r, err := fsnotify.NewJournalReader(in)
+	if err != nil {
+		exit("%s: %s", paths[0], err)
+	}
+	defer r.Close()
+
+	var prev time.Time
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			exit("%s: %s", paths[0], err)
+		}
+		if sp > 0 && !prev.IsZero() && rec.Time.After(prev) {
+			time.Sleep(time.Duration(float64(rec.Time.Sub(prev)) / float64(sp)))
+		}
+		prev = rec.Time
+
+		e := rec.Event()
+		eventHistory.add(e.Op.String(), e.Name)
+		switch {
+		case jsonOutput:
+			printJSON(e.Op.String(), e.Name, nil)
+		case len(cmd) == 0:
+			log.Printf("Op:%s Name: %s (recorded at %s)", e.Op, e.Name, rec.Time.Format("15:04:05.000"))
+		}
+		if len(cmd) > 0 {
+			run(cmd, e)
+		}
+	}
+}
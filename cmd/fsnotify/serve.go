@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/hohodqr/fsnotify"
+	"github.com/hohodqr/fsnotify/rpc"
+)
+
+// Serve a Watcher over gRPC to clients of the rpc package, which can then add
+// and remove their own watches and stream the events:
+//
+//	fsnotify serve -grpc :7070 -token-file token -cert cert.pem -key key.pem [paths]
+//
+// The paths are watched from the start; clients receive events for all watches,
+// including those added by other clients.
+func serve(args ...string) {
+	var (
+		addr, tokenFile, cert, key string
+		flags                      = flag.NewFlagSet("serve", flag.ExitOnError)
+	)
+	flags.StringVar(&addr, "grpc", "localhost:7070", "Address to listen on.")
+	flags.StringVar(&tokenFile, "token-file", "", "Require clients to send the token in this file.")
+	flags.StringVar(&cert, "cert", "", "TLS certificate file; requires -key.")
+	flags.StringVar(&key, "key", "", "TLS key file; requires -cert.")
+	flags.Parse(args)
+
+	var opts []rpc.Option
+	if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			exit("%s", err)
+		}
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			exit("%q: token is empty", tokenFile)
+		}
+		opts = append(opts, rpc.WithToken(token))
+	}
+	if (cert == "") != (key == "") {
+		exit("-cert and -key must be used together")
+	}
+	if cert != "" {
+		c, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			exit("%s", err)
+		}
+		opts = append(opts, rpc.WithTLS(&tls.Config{Certificates: []tls.Certificate{c}}))
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		exit("creating a new watcher: %s", err)
+	}
+	defer w.Close()
+	for _, p := range flags.Args() {
+		if err := w.Add(p); err != nil {
+			exit("%q: %s", p, err)
+		}
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		exit("%s", err)
+	}
+	printTime("listening on %s; press ^C to exit", l.Addr())
+	if err := rpc.NewServer(w, opts...).Serve(l); err != nil {
+		exit("%s", err)
+	}
+}
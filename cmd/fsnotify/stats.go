@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Show a table of the events per second, refreshed every interval, to find out
+// what's hammering a filesystem:
+//
+//	fsnotify stats -r -top 20 /srv
+//
+// With --json a snapshot is printed as a JSON object every interval instead.
+
+type statCounter struct {
+	Name  string  `json:"name"`
+	Count int     `json:"count"`
+	Rate  float64 `json:"per_second"`
+}
+
+type statSnapshot struct {
+	Time      time.Time     `json:"time"`
+	Interval  float64       `json:"interval"` // In seconds.
+	Total     int           `json:"total"`
+	Ops       []statCounter `json:"ops"`
+	Paths     []statCounter `json:"paths"`
+	Watches   int           `json:"watches"`
+	Dropped   uint64        `json:"dropped"`   // DroppedEvents() since the start.
+	Overflows int           `json:"overflows"` // ErrEventOverflow since the start.
+	Errors    int           `json:"errors"`    // All other errors since the start.
+}
+
+type stats struct {
+	mu        sync.Mutex
+	ops       map[string]int
+	paths     map[string]int
+	total     int
+	overflows int
+	errors    int
+}
+
+func (s *stats) event(e fsnotify.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total++
+	s.paths[e.Name]++
+	for _, op := range strings.Split(e.Op.String(), "|") {
+		s.ops[op]++
+	}
+}
+
+func (s *stats) error(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if errors.Is(err, fsnotify.ErrEventOverflow) {
+		s.overflows++
+	} else {
+		s.errors++
+	}
+}
+
+// snapshot gets the counts since the last snapshot, and resets them.
+func (s *stats) snapshot(w *fsnotify.Watcher, interval time.Duration, top int) statSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counters := func(m map[string]int, n int) []statCounter {
+		l := make([]statCounter, 0, len(m))
+		for k, v := range m {
+			l = append(l, statCounter{Name: k, Count: v, Rate: float64(v) / interval.Seconds()})
+		}
+		sort.Slice(l, func(i, j int) bool {
+			if l[i].Count == l[j].Count {
+				return l[i].Name < l[j].Name
+			}
+			return l[i].Count > l[j].Count
+		})
+		if n > 0 && len(l) > n {
+			l = l[:n]
+		}
+		return l
+	}
+
+	snap := statSnapshot{
+		Time:      time.Now(),
+		Interval:  interval.Seconds(),
+		Total:     s.total,
+		Ops:       counters(s.ops, 0),
+		Paths:     counters(s.paths, top),
+		Watches:   len(w.WatchList()),
+		Dropped:   w.DroppedEvents(),
+		Overflows: s.overflows,
+		Errors:    s.errors,
+	}
+	s.ops, s.paths, s.total = make(map[string]int), make(map[string]int), 0
+	return snap
+}
+
+func (snap statSnapshot) print(clear bool) {
+	if jsonOutput {
+		j, _ := json.Marshal(snap)
+		fmt.Println(string(j))
+		return
+	}
+
+	if clear {
+		fmt.Print("\x1b[H\x1b[2J")
+	}
+	fmt.Printf("%s   watches: %d   dropped: %d   overflows: %d   errors: %d\n\n",
+		snap.Time.Format("15:04:05"), snap.Watches, snap.Dropped, snap.Overflows, snap.Errors)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "EVENTS/S\tOP\t\n")
+	fmt.Fprintf(tw, "%.1f\t%s\t\n", float64(snap.Total)/snap.Interval, "total")
+	for _, c := range snap.Ops {
+		fmt.Fprintf(tw, "%.1f\t%s\t\n", c.Rate, c.Name)
+	}
+	tw.Flush()
+	fmt.Println()
+
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "EVENTS/S\tPATH\n")
+	for _, c := range snap.Paths {
+		fmt.Fprintf(tw, "%8.1f\t%s\n", c.Rate, c.Name)
+	}
+	tw.Flush()
+}
+
+func statsCmd(args ...string) {
+	var (
+		t        tree
+		interval time.Duration
+		top      int
+		flags    = flag.NewFlagSet("stats", flag.ExitOnError)
+	)
+	flags.BoolVar(&t.recursive, "r", false, "Watch directories recursively.")
+	flags.BoolVar(&t.recursive, "recursive", false, "Watch directories recursively.")
+	flags.Var(&t.exclude, "exclude", "Don't watch or count paths matching the glob; can be repeated.")
+	flags.IntVar(&t.maxDepth, "max-depth", 0, "Only watch this many levels of subdirectories with -r.")
+	flags.StringVar(&t.backend, "backend", "", "Fail if the backend isn't this one.")
+	flags.DurationVar(&interval, "interval", time.Second, "How often to refresh.")
+	flags.IntVar(&top, "top", 10, "Number of paths to show.")
+	flags.Parse(args)
+
+	paths := flags.Args()
+	if len(paths) < 1 {
+		exit("must specify at least one path to watch")
+	}
+	if interval <= 0 {
+		exit("-interval must be positive")
+	}
+	for _, p := range paths {
+		t.roots = append(t.roots, filepath.Clean(p))
+	}
+
+	w, err := t.newWatcher()
+	if err != nil {
+		exit("creating a new watcher: %s", err)
+	}
+	defer w.Close()
+
+	dirs, err := t.dirs()
+	if err != nil {
+		exit("%s", err)
+	}
+	for _, p := range dirs {
+		if err := w.Add(p); err != nil {
+			exit("%q: %s", p, err)
+		}
+	}
+
+	s := &stats{ops: make(map[string]int), paths: make(map[string]int)}
+	go func() {
+		for err := range w.Errors {
+			s.error(err)
+		}
+	}()
+	go func() {
+		for e := range w.Events {
+			if t.excluded(e.Name) {
+				continue
+			}
+			if e.IsDir() && e.Op&(fsnotify.IN_CREATE|fsnotify.IN_MOVED_TO) != 0 && t.watchDir(e.Name) {
+				w.Add(e.Name)
+			}
+			s.event(e)
+		}
+	}()
+
+	// Only clear the screen if it's a terminal, so the output can be piped.
+	fi, _ := os.Stdout.Stat()
+	clear := fi != nil && fi.Mode()&os.ModeCharDevice != 0
+	for range time.Tick(interval) {
+		s.snapshot(w, interval, top).print(clear)
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"path/filepath"
+	"strings"
 
 	"github.com/hohodqr/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // This is the most basic example: it prints events to the terminal as we
@@ -11,31 +15,161 @@ import (
 var w *fsnotify.Watcher
 var err error
 
-func watch(paths ...string) {
+// globs is a repeatable flag.
+type globs []string
+
+func (g *globs) String() string { return strings.Join(*g, ",") }
+func (g *globs) Set(v string) error {
+	if _, err := filepath.Match(v, ""); err != nil {
+		return err
+	}
+	*g = append(*g, v)
+	return nil
+}
+
+// tree decides which directories to watch and which events to print.
+type tree struct {
+	roots     []string
+	recursive bool
+	exclude   globs
+	maxDepth  int
+	backend   string // Backend to use, for -backend.
+}
+
+// newWatcher creates the watcher for the tree.
+func (t tree) newWatcher() (*fsnotify.Watcher, error) {
+	if t.backend == "" {
+		return fsnotify.NewWatcher()
+	}
+	return fsnotify.NewWatcherWithBackend(fsnotify.Backend(t.backend))
+}
+
+// excluded reports if the path, or any directory between it and the root it's
+// in, matches one of the --exclude globs.
+func (t tree) excluded(path string) bool {
+	for _, g := range t.exclude {
+		if ok, _ := filepath.Match(g, path); ok {
+			return true
+		}
+	}
+	root, rel := t.root(path)
+	if root == "" {
+		return false
+	}
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		for _, g := range t.exclude {
+			if ok, _ := filepath.Match(g, elem); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// root gets the root the path is in, and the path relative to it.
+func (t tree) root(path string) (string, string) {
+	for _, r := range t.roots {
+		if rel, err := filepath.Rel(r, path); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return r, rel
+		}
+	}
+	return "", ""
+}
+
+// watchDir reports if a directory that was created should be watched.
+func (t tree) watchDir(path string) bool {
+	if !t.recursive || t.excluded(path) {
+		return false
+	}
+	root, rel := t.root(path)
+	if root == "" {
+		return false
+	}
+	return t.maxDepth <= 0 || len(strings.Split(rel, string(filepath.Separator))) <= t.maxDepth
+}
+
+// dirs gets all directories to watch at the start.
+func (t tree) dirs() ([]string, error) {
+	if !t.recursive {
+		return t.roots, nil
+	}
+	all, err := fsnotify.GetDirNamesWith(t.roots, fsnotify.WithMaxDepth(t.maxDepth))
+	if err != nil {
+		return nil, err
+	}
+	dirs := all[:0]
+	for _, d := range all {
+		if !t.excluded(d) {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs, nil
+}
+
+func watch(args ...string) {
+	var (
+		t     tree
+		flags = flag.NewFlagSet("watch", flag.ExitOnError)
+	)
+	flags.BoolVar(&t.recursive, "r", false, "Watch directories recursively.")
+	flags.BoolVar(&t.recursive, "recursive", false, "Watch directories recursively.")
+	flags.Var(&t.exclude, "exclude", "Don't watch or print paths matching the glob; can be repeated.")
+	flags.IntVar(&t.maxDepth, "max-depth", 0, "Only watch this many levels of subdirectories with -r.")
+	flags.StringVar(&t.backend, "backend", "", "Use this backend: poll, or fail if the platform's backend isn't this one.")
+	flags.Parse(args)
+
+	paths := flags.Args()
 	if len(paths) < 1 {
 		exit("must specify at least one path to watch")
 	}
+	for _, p := range paths {
+		t.roots = append(t.roots, filepath.Clean(p))
+	}
 
 	// Create a new watcher.
-	// w, err := fsnotify.NewWatcher()
-	w, err = fsnotify.WatcherRecursivelyWithExclude()
+	w, err = t.newWatcher()
 	if err != nil {
 		exit("creating a new watcher: %s", err)
 	}
 	defer w.Close()
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "fsnotify_dropped_events_total",
+		Help: "Number of events dropped because of the backpressure policy.",
+	}, func() float64 { return float64(w.DroppedEvents()) }))
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "fsnotify_overflows_total",
+		Help: "Number of times the kernel reported that events were lost.",
+	}, func() float64 { return float64(w.Stats().Overflows) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fsnotify_queue_depth",
+		Help: "Number of events waiting to be read from the Events channel.",
+	}, func() float64 { return float64(w.Stats().QueueDepth) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fsnotify_queue_capacity",
+		Help: "Capacity of the Events channel.",
+	}, func() float64 { return float64(cap(w.Events)) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fsnotify_queue_high_water",
+		Help: "Most events that were waiting to be read from the Events channel.",
+	}, func() float64 { return float64(w.Stats().QueueHighWater) }))
 
 	// Start listening for events.
-	go watchLoop(w)
+	go watchLoop(w, t)
 
 	// Add all paths from the commandline
-	paths, err = fsnotify.GetDirNames(paths)
+	dirs, err := t.dirs()
 	if err != nil {
 		exit("add init watch path err %s", err)
 	}
-	for _, p := range paths {
-		err = w.Add(p)
-		if err != nil {
-			exit("%q: %s", p, err)
+	res, err := w.AddPaths(dirs)
+	if err != nil {
+		for _, r := range res {
+			if r.Err != nil {
+				log.Printf("ERROR: %q: %s", r.Path, r.Err)
+			}
+		}
+		if len(w.WatchList()) == 0 {
+			exit("no paths could be watched")
 		}
 	}
 
@@ -43,7 +177,7 @@ func watch(paths ...string) {
 	<-make(chan struct{}) // Block forever
 }
 
-func watchLoop(w *fsnotify.Watcher) {
+func watchLoop(w *fsnotify.Watcher, t tree) {
 	// i := 0
 	for {
 		select {
@@ -52,13 +186,20 @@ func watchLoop(w *fsnotify.Watcher) {
 			if !ok { // Channel was closed (i.e. Watcher.Close() was called).
 				return
 			}
+			if jsonOutput {
+				printJSON("", "", err)
+				continue
+			}
 			log.Printf("ERROR: %s", err)
 		// Read from Events.
 		case e, ok := <-w.Events:
 			if !ok { // Channel was closed (i.e. Watcher.Close() was called).
 				return
 			}
-			if e.Op.String() == "IN_CREATE|IN_ISDIR" {
+			if t.excluded(e.Name) {
+				continue
+			}
+			if e.IsDir() && e.Op&(fsnotify.IN_CREATE|fsnotify.IN_MOVED_TO) != 0 && t.watchDir(e.Name) {
 				w.Add(e.Name)
 			}
 
@@ -66,7 +207,12 @@ func watchLoop(w *fsnotify.Watcher) {
 			// events we've seen.
 			// i++
 			// printTime("%3d %s", i, e)
-			log.Printf("Op:%s Name: %s", e.Op, e.Name)
+			if jsonOutput {
+				printJSON(e.Op.String(), e.Name, nil)
+			} else {
+				log.Printf("Op:%s Name: %s", e.Op, e.Name)
+			}
+			eventHistory.add(e.Op.String(), e.Name)
 			// log.Printf("%v", w.WatchList())
 		}
 	}
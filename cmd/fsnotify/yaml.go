@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// There is no YAML parser in the standard library, and this is just an example
+// command, so parseYAML parses the subset of YAML that's needed for config
+// files:
+//
+//   - block mappings and sequences;
+//   - flow sequences of scalars ([a, "b", 3]);
+//   - plain, "double-quoted", and 'single-quoted' scalars;
+//   - comments.
+//
+// Anchors, tags, multi-line strings, flow mappings, and multiple documents
+// aren't supported. The result is made of map[string]interface{},
+// []interface{}, string, int64, bool, and nil, so it can be converted to JSON
+// and decoded in to a struct with encoding/json.
+
+type yamlLine struct {
+	n      int // Line number, for errors.
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	i     int
+}
+
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, l := range strings.Split(string(data), "\n") {
+		l = strings.TrimRight(stripComment(l), " \t\r")
+		text := strings.TrimLeft(l, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if text[0] == '\t' {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{n: i + 1, indent: len(l) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+
+	v, err := p.node(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.i].n)
+	}
+	return v, nil
+}
+
+// stripComment removes a "#" comment that's not in a quoted string. Quotes only
+// start a string at the start of a scalar, so "don't # x" is a comment.
+func stripComment(l string) string {
+	var quote byte
+	for i := 0; i < len(l); i++ {
+		switch c := l[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == '\'' && quote == c && i+1 < len(l) && l[i+1] == c {
+				i++ // '' in a single-quoted string.
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[,", l[i-1]) != -1):
+			quote = c
+		case c == '#' && (i == 0 || l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
+
+// node parses the mapping or sequence at the current line, which must have the
+// given indent.
+func (p *yamlParser) node(indent int) (interface{}, error) {
+	l := p.lines[p.i]
+	if l.indent != indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", l.n)
+	}
+	if isSeqItem(l.text) {
+		return p.seq(indent)
+	}
+	if _, _, ok := splitKey(l.text); ok {
+		return p.mapping(indent)
+	}
+	// A single scalar as the entire document.
+	p.i++
+	return parseScalar(l.text, l.n)
+}
+
+func isSeqItem(s string) bool { return s == "-" || strings.HasPrefix(s, "- ") }
+
+func (p *yamlParser) seq(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent || (l.indent == indent && !isSeqItem(l.text)) {
+			// A list at the same indentation as its key ends at the next key.
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: expected a %q list item", l.n, "-")
+		}
+
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		switch _, _, isKey := splitKey(rest); {
+		case rest == "":
+			// The item is on the next lines.
+			p.i++
+			if p.i == len(p.lines) || p.lines[p.i].indent <= indent {
+				list = append(list, nil)
+				continue
+			}
+			v, err := p.node(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		case isKey || isSeqItem(rest):
+			// "- key: value" starts a mapping (or "- - x" a list) that's
+			// indented to where the key is.
+			p.lines[p.i] = yamlLine{n: l.n, indent: indent + len(l.text) - len(rest), text: rest}
+			v, err := p.node(p.lines[p.i].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		default:
+			v, err := parseScalar(rest, l.n)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			p.i++
+		}
+	}
+	return list, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.i < len(p.lines) {
+		l := p.lines[p.i]
+		if l.indent < indent {
+			break
+		}
+		k, v, ok := splitKey(l.text)
+		if l.indent > indent || !ok {
+			return nil, fmt.Errorf("line %d: expected %q", l.n, "key: value")
+		}
+		key, err := parseScalar(k, l.n)
+		if err != nil {
+			return nil, err
+		}
+		ks := fmt.Sprint(key)
+		if _, dup := m[ks]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.n, ks)
+		}
+		p.i++
+
+		if v != "" {
+			m[ks], err = parseScalar(v, l.n)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch {
+		case p.i < len(p.lines) && p.lines[p.i].indent > indent:
+			m[ks], err = p.node(p.lines[p.i].indent)
+		case p.i < len(p.lines) && p.lines[p.i].indent == indent && isSeqItem(p.lines[p.i].text):
+			// Lists can be at the same indentation as the key.
+			m[ks], err = p.seq(indent)
+		default:
+			m[ks] = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// splitKey splits "key: value" or "key:"; the key may be quoted.
+func splitKey(s string) (string, string, bool) {
+	start := 0
+	if s != "" && (s[0] == '"' || s[0] == '\'') {
+		end := strings.IndexByte(s[1:], s[0])
+		if end == -1 {
+			return "", "", false
+		}
+		start = end + 2
+	}
+	i := strings.Index(s[start:], ":")
+	for i != -1 {
+		i += start
+		if i == len(s)-1 || s[i+1] == ' ' {
+			return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), true
+		}
+		start = i + 1
+		i = strings.Index(s[start:], ":")
+	}
+	return "", "", false
+}
+
+func parseScalar(s string, line int) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, nil
+	case s[0] == '[':
+		if s[len(s)-1] != ']' {
+			return nil, fmt.Errorf("line %d: unterminated %q", line, "[")
+		}
+		list := []interface{}{}
+		for _, elem := range splitFlow(s[1 : len(s)-1]) {
+			elem = strings.TrimSpace(elem)
+			if elem == "" {
+				continue
+			}
+			v, err := parseScalar(elem, line)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case s[0] == '{':
+		return nil, fmt.Errorf("line %d: flow mappings ({...}) aren't supported", line)
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid quoted string: %s", line, s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' {
+			return nil, fmt.Errorf("line %d: invalid quoted string: %s", line, s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s == "null" || s == "~":
+		return nil, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	return s, nil
+}
+
+// splitFlow splits the elements of a flow sequence on commas that aren't
+// quoted.
+func splitFlow(s string) []string {
+	var (
+		elems []string
+		quote byte
+		start int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			elems = append(elems, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(elems, s[start:])
+}
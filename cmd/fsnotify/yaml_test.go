@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripComment(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{``, ``},
+		{`# comment`, ``},
+		{`key: value`, `key: value`},
+		{`key: value # comment`, `key: value `},
+		{`key: value	# comment`, `key: value	`},
+		{`key: a#b`, `key: a#b`},
+		{`key: "a # b" # c`, `key: "a # b" `},
+		{`key: 'a # b' # c`, `key: 'a # b' `},
+		{`key: "a \" # b" # c`, `key: "a \" # b" `},
+		{`key: 'it''s # here' # c`, `key: 'it''s # here' `},
+		{`key: don't # comment`, `key: don't `},
+		{`key: a"b # comment`, `key: a"b `},
+		{`key: [a, "b # c"] # d`, `key: [a, "b # c"] `},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have := stripComment(tt.in)
+			if have != tt.want {
+				t.Errorf("\nin:   %q\nhave: %q\nwant: %q", tt.in, have, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	type (
+		m = map[string]interface{}
+		l = []interface{}
+	)
+	tests := []struct {
+		in      string
+		want    interface{}
+		wantErr string
+	}{
+		{``, nil, ""},
+		{"# only a comment\n\n", nil, ""},
+		{`hello`, "hello", ""},
+
+		// Scalars.
+		{`k: v`, m{"k": "v"}, ""},
+		{`k: 42`, m{"k": int64(42)}, ""},
+		{`k: -3`, m{"k": int64(-3)}, ""},
+		{`k: 1.5`, m{"k": "1.5"}, ""},
+		{`k: 500ms`, m{"k": "500ms"}, ""},
+		{`k: true`, m{"k": true}, ""},
+		{`k: false`, m{"k": false}, ""},
+		{`k: null`, m{"k": nil}, ""},
+		{`k: ~`, m{"k": nil}, ""},
+		{`k:`, m{"k": nil}, ""},
+		{`k: "a: b"`, m{"k": "a: b"}, ""},
+		{`k: "tab\there"`, m{"k": "tab\there"}, ""},
+		{`k: 'it''s'`, m{"k": "it's"}, ""},
+		{`k: 'a # b' # c`, m{"k": "a # b"}, ""},
+		{`k: http://localhost:8080/x`, m{"k": "http://localhost:8080/x"}, ""},
+		{`"quoted key": v`, m{"quoted key": "v"}, ""},
+		{`'a: b': v`, m{"a: b": "v"}, ""},
+		{`---` + "\nk: v", m{"k": "v"}, ""},
+		{"k: v\r\n", m{"k": "v"}, ""},
+
+		// Flow sequences.
+		{`k: []`, m{"k": l{}}, ""},
+		{`k: [a, "b, c", 'd', 3, true]`, m{"k": l{"a", "b, c", "d", int64(3), true}}, ""},
+		{`k: [a, b,]`, m{"k": l{"a", "b"}}, ""},
+
+		// Block mappings and sequences.
+		{"a: 1\nb:\n  c: 2\n  d: 3\ne: 4",
+			m{"a": int64(1), "b": m{"c": int64(2), "d": int64(3)}, "e": int64(4)}, ""},
+		{"- a\n- b", l{"a", "b"}, ""},
+		{"k:\n  - a\n  - b", m{"k": l{"a", "b"}}, ""},
+		{"k:\n- a\n- b\nz: 1", m{"k": l{"a", "b"}, "z": int64(1)}, ""},
+		{"k:\n  -\n  - b", m{"k": l{nil, "b"}}, ""},
+		{"k:\n  -\n    x: 1", m{"k": l{m{"x": int64(1)}}}, ""},
+		{"- - a\n  - b\n- c", l{l{"a", "b"}, "c"}, ""},
+		{"k:\n  - x: 1\n    y: 2\n  - x: 3", m{"k": l{m{"x": int64(1), "y": int64(2)}, m{"x": int64(3)}}}, ""},
+		{"  k: v\n  z: w", m{"k": "v", "z": "w"}, ""},
+
+		// The example from the daemon command's docs.
+		{`
+watches:
+  - path: /srv/uploads
+    recursive: true
+    exclude: ["*.tmp", .git]
+    ops: [create, write]     # Only these ops; the default is all.
+    debounce: 500ms          # Wait for changes to a path to stop.
+    actions:
+      - log: true
+      - exec: [rsync, "{path}", "backup:/srv/uploads/"]
+      - post: http://localhost:8080/hook
+`, m{"watches": l{m{
+			"path":      "/srv/uploads",
+			"recursive": true,
+			"exclude":   l{"*.tmp", ".git"},
+			"ops":       l{"create", "write"},
+			"debounce":  "500ms",
+			"actions": l{
+				m{"log": true},
+				m{"exec": l{"rsync", "{path}", "backup:/srv/uploads/"}},
+				m{"post": "http://localhost:8080/hook"},
+			},
+		}}}, ""},
+
+		// Errors.
+		{"k:\n\t- a", nil, "line 2: tabs can't be used"},
+		{"a: 1\n  b: 2", nil, "line 2: expected"},
+		{"a:\n    b: 1\n  c: 2", nil, "line 3: expected"},
+		{"k:\n  - a\n    b: 1", nil, "line 3: expected"},
+		{"  a: 1\nb: 2", nil, "line 2: unexpected indentation"},
+		{"a: 1\na: 2", nil, `line 2: duplicate key "a"`},
+		{"- a\nb: 1", nil, "line 2: unexpected indentation"},
+		{"a: 1\n- b", nil, "line 2: expected"},
+		{`k: {a: 1}`, nil, "line 1: flow mappings"},
+		{`k: [a, b`, nil, `line 1: unterminated "["`},
+		{`k: "abc`, nil, "line 1: invalid quoted string"},
+		{`k: 'abc`, nil, "line 1: invalid quoted string"},
+		{`k: '`, nil, "line 1: invalid quoted string"},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have, err := parseYAML([]byte(tt.in))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("\nin:   %q\nhave error: %v\nwant error: %s", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("\nin: %q\nerror: %s", tt.in, err)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nin:   %q\nhave: %#v\nwant: %#v", tt.in, have, tt.want)
+			}
+		})
+	}
+}
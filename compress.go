@@ -0,0 +1,105 @@
+package fsnotify
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compression is a compression format for journal and record files.
+type Compression string
+
+// Compression formats. Only gzip is built in, as that's the only format in
+// the standard library; other formats can be added with [RegisterCompression].
+const (
+	CompressionNone Compression = ""
+	CompressionGzip Compression = "gzip"
+)
+
+// Compressor implements a compression format.
+type Compressor struct {
+	// Magic bytes at the start of the compressed stream, used to detect the
+	// format when reading.
+	Magic []byte
+
+	NewWriter func(io.Writer) (io.WriteCloser, error)
+	NewReader func(io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = struct {
+	sync.RWMutex
+	m map[Compression]Compressor
+}{m: map[Compression]Compressor{
+	CompressionGzip: {
+		Magic:     []byte{0x1f, 0x8b},
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+}}
+
+// RegisterCompression registers (or replaces) a compression format, so it can
+// be used for writing journals and is detected when reading them. For example
+// zstd with github.com/klauspost/compress/zstd:
+//
+//	const CompressionZstd fsnotify.Compression = "zstd"
+//
+//	fsnotify.RegisterCompression(CompressionZstd, fsnotify.Compressor{
+//		Magic: []byte{0x28, 0xb5, 0x2f, 0xfd},
+//		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+//			return zstd.NewWriter(w)
+//		},
+//		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+//			d, err := zstd.NewReader(r)
+//			if err != nil {
+//				return nil, err
+//			}
+//			return d.IOReadCloser(), nil
+//		},
+//	})
+func RegisterCompression(c Compression, comp Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.m[c] = comp
+}
+
+// compressWriter wraps w to compress with c. Closing the returned writer
+// flushes the compressor, but doesn't close w.
+func compressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	if c == CompressionNone {
+		return nopWriteCloser{w}, nil
+	}
+
+	compressors.RLock()
+	comp, ok := compressors.m[c]
+	compressors.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("fsnotify: compression %q not registered", c)
+	}
+	return comp.NewWriter(w)
+}
+
+// decompressReader wraps r to transparently decompress any of the registered
+// formats, detected by the magic bytes. Uncompressed data is read as-is.
+func decompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	compressors.RLock()
+	defer compressors.RUnlock()
+	for _, comp := range compressors.m {
+		if len(comp.Magic) == 0 {
+			continue
+		}
+		head, _ := br.Peek(len(comp.Magic))
+		if bytes.Equal(head, comp.Magic) {
+			return comp.NewReader(br)
+		}
+	}
+	return io.NopCloser(br), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
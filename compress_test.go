@@ -0,0 +1,44 @@
+package fsnotify
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	for _, c := range []Compression{CompressionNone, CompressionGzip} {
+		t.Run(string(c), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := compressWriter(&buf, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, "hello, world\n"); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := decompressReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			have, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(have) != "hello, world\n" {
+				t.Errorf("have %q", have)
+			}
+		})
+	}
+
+	t.Run("not registered", func(t *testing.T) {
+		if _, err := compressWriter(io.Discard, "nope"); err == nil {
+			t.Fatal("err is nil")
+		}
+	})
+}
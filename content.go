@@ -0,0 +1,291 @@
+package fsnotify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// WithContentDiff sets [Event.Change] on Write events for regular files of up
+// to maxSize bytes, describing what changed in the file. This is only used by
+// [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// The contents of every file that's seen are kept in memory to compare with, so
+// keep maxSize small. There is no Change for the first Write for a file that
+// existed before it was watched, as the previous contents are unknown.
+func WithContentDiff(maxSize int64) addOpt {
+	return func(opt *withOpts) { opt.diffSize = maxSize }
+}
+
+// ContentChange describes how the contents of a file changed; see
+// [WithContentDiff].
+type ContentChange struct {
+	// Ranges of bytes that changed, in order. The ranges are per line, so
+	// changing a single byte will report the entire line as changed.
+	Ranges []ChangedRange
+
+	// Diff is a unified diff of the old and new contents, or "" if either
+	// isn't valid UTF-8 text.
+	Diff string
+}
+
+// ChangedRange is a range of bytes that changed: OldLen bytes at Offset in the
+// old contents were replaced with NewLen bytes.
+type ChangedRange struct {
+	Offset int64
+	OldLen int64
+	NewLen int64
+}
+
+// contents sets Event.Change according to WithContentDiff.
+type contents struct {
+	maxSize int64
+	mu      sync.Mutex
+	prev    map[string][]byte // Last seen contents per path.
+}
+
+func newContents(with withOpts) *contents {
+	return &contents{maxSize: with.diffSize, prev: make(map[string][]byte)}
+}
+
+// diff sets Change on Write events, and records the contents on Create and
+// Write events.
+func (c *contents) diff(e Event) Event {
+	if c.maxSize <= 0 {
+		return e
+	}
+
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	if e.Op&(opRemove|opRename) != 0 {
+		c.mu.Lock()
+		delete(c.prev, name)
+		c.mu.Unlock()
+	}
+	if e.Op&(opCreate|opWrite) == 0 {
+		return e
+	}
+
+	data, ok := c.read(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !ok {
+		delete(c.prev, name)
+		return e
+	}
+	prev, seen := c.prev[name]
+	// The name may be reused by WithCallback.
+	c.prev[cloneString(name)] = data
+	if seen && e.Op&opWrite != 0 && !bytes.Equal(prev, data) {
+		e.Change = diffContents(name, prev, data)
+	}
+	return e
+}
+
+// read a regular file if it's not larger than maxSize.
+func (c *contents) read(path string) ([]byte, bool) {
+	// Check first, as opening a FIFO would block.
+	if st, err := os.Lstat(path); err != nil || !st.Mode().IsRegular() || st.Size() > c.maxSize {
+		return nil, false
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer fp.Close()
+	// The file may have grown since the Lstat.
+	data, err := io.ReadAll(io.LimitReader(fp, c.maxSize+1))
+	if err != nil || int64(len(data)) > c.maxSize {
+		return nil, false
+	}
+	return data, true
+}
+
+type edit struct {
+	op   byte // ' ', '-', or '+'
+	line string
+}
+
+func diffContents(name string, old, new []byte) *ContentChange {
+	edits := diffLines(splitLines(old), splitLines(new))
+
+	var (
+		ch     ContentChange
+		offset int64
+	)
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			offset += int64(len(edits[i].line))
+			i++
+			continue
+		}
+		r := ChangedRange{Offset: offset}
+		for ; i < len(edits) && edits[i].op != ' '; i++ {
+			if edits[i].op == '-' {
+				r.OldLen += int64(len(edits[i].line))
+			} else {
+				r.NewLen += int64(len(edits[i].line))
+			}
+		}
+		offset += r.OldLen
+		ch.Ranges = append(ch.Ranges, r)
+	}
+
+	if isText(old) && isText(new) {
+		ch.Diff = unifiedDiff(name, edits)
+	}
+	return &ch
+}
+
+func isText(b []byte) bool { return utf8.Valid(b) && bytes.IndexByte(b, 0) == -1 }
+
+// splitLines splits b after every newline.
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.SplitAfter(string(b), "\n")[:bytes.Count(b, []byte("\n"))+1-boolInt(b[len(b)-1] == '\n')]
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// diffLines returns the edits to go from a to b, based on the longest common
+// subsequence. Files with large changes (more than maxCells lines in both
+// after removing the common prefix and suffix) are reported as removing and
+// adding all changed lines, rather than finding the smallest diff.
+func diffLines(a, b []string) []edit {
+	const maxCells = 1 << 20
+
+	var pre, suf int
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for _, l := range a[:pre] {
+		edits = append(edits, edit{' ', l})
+	}
+	am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if (len(am)+1)*(len(bm)+1) > maxCells {
+		for _, l := range am {
+			edits = append(edits, edit{'-', l})
+		}
+		for _, l := range bm {
+			edits = append(edits, edit{'+', l})
+		}
+	} else {
+		// lcs[i][j] is the length of the LCS of am[i:] and bm[j:].
+		lcs := make([][]int, len(am)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(bm)+1)
+		}
+		for i := len(am) - 1; i >= 0; i-- {
+			for j := len(bm) - 1; j >= 0; j-- {
+				if am[i] == bm[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(am) || j < len(bm) {
+			switch {
+			case i < len(am) && j < len(bm) && am[i] == bm[j]:
+				edits = append(edits, edit{' ', am[i]})
+				i, j = i+1, j+1
+			case j == len(bm) || (i < len(am) && lcs[i+1][j] >= lcs[i][j+1]):
+				edits = append(edits, edit{'-', am[i]})
+				i++
+			default:
+				edits = append(edits, edit{'+', bm[j]})
+				j++
+			}
+		}
+	}
+	for _, l := range a[len(a)-suf:] {
+		edits = append(edits, edit{' ', l})
+	}
+	return edits
+}
+
+// unifiedDiff formats the edits as a unified diff with 3 lines of context.
+func unifiedDiff(name string, edits []edit) string {
+	const context = 3
+
+	// Line number in the old and new file before every edit.
+	oldLine, newLine := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if e.op != '+' {
+			oldLine[i+1]++
+		}
+		if e.op != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", name, name)
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk as long as the next change is within the context.
+		start, end := i-context, i
+		if start < 0 {
+			start = 0
+		}
+		for j := i; j < len(edits) && j <= end+2*context+1; j++ {
+			if edits[j].op != ' ' {
+				end = j
+			}
+		}
+		end += context + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[start], oldLine[end]-oldLine[start]),
+			hunkRange(newLine[start], newLine[end]-newLine[start]))
+		for _, e := range edits[start:end] {
+			b.WriteByte(e.op)
+			b.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
@@ -0,0 +1,111 @@
+package fsnotify
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffContents(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		ranges   []ChangedRange
+		diff     string
+	}{
+		{"append", "a\nb\n", "a\nb\nc\n",
+			[]ChangedRange{{Offset: 4, NewLen: 2}},
+			"--- f\n+++ f\n@@ -1,2 +1,3 @@\n a\n b\n+c\n"},
+		{"change line", "a\nb\nc\n", "a\nX\nc\n",
+			[]ChangedRange{{Offset: 2, OldLen: 2, NewLen: 2}},
+			"--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+X\n c\n"},
+		{"remove line", "a\nb\nc\n", "a\nc\n",
+			[]ChangedRange{{Offset: 2, OldLen: 2}},
+			"--- f\n+++ f\n@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{"from empty", "", "a\n",
+			[]ChangedRange{{NewLen: 2}},
+			"--- f\n+++ f\n@@ -0,0 +1 @@\n+a\n"},
+		{"no newline", "a\nb", "a\nc",
+			[]ChangedRange{{Offset: 2, OldLen: 1, NewLen: 1}},
+			"--- f\n+++ f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+		{"two hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "X\n2\n3\n4\n5\n6\n7\n8\n9\nY\n",
+			[]ChangedRange{{OldLen: 2, NewLen: 2}, {Offset: 18, OldLen: 3, NewLen: 2}},
+			"--- f\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+X\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+Y\n"},
+		{"binary", "a\x00\n", "b\x00\n",
+			[]ChangedRange{{OldLen: 3, NewLen: 3}},
+			""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			have := diffContents("f", []byte(tt.old), []byte(tt.new))
+			if !reflect.DeepEqual(have.Ranges, tt.ranges) {
+				t.Errorf("ranges\nhave: %+v\nwant: %+v", have.Ranges, tt.ranges)
+			}
+			if have.Diff != tt.diff {
+				t.Errorf("diff\nhave:\n%s\nwant:\n%s", have.Diff, tt.diff)
+			}
+		})
+	}
+}
+
+func TestWithContentDiff(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	file := join(tmp, "file")
+	cat(t, "hello\n", file, noWait)
+
+	w, err := NewWatcherWith(WithContentDiff(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp)
+
+	change := func() *ContentChange {
+		t.Helper()
+		for {
+			select {
+			case e := <-w.Events:
+				if e.Op&opWrite != 0 {
+					return e.Change
+				}
+			case err := <-w.Errors:
+				t.Fatal(err)
+			case <-time.After(2 * time.Second):
+				t.Fatal("no Write event")
+			}
+		}
+	}
+	drain := func() {
+		for {
+			select {
+			case <-w.Events:
+			case <-time.After(300 * time.Millisecond):
+				return
+			}
+		}
+	}
+
+	// Nothing to compare with for the first Write.
+	cat(t, "world\n", file, noWait)
+	if ch := change(); ch != nil {
+		t.Errorf("Change set for first write: %+v", ch)
+	}
+	drain()
+
+	cat(t, "!\n", file, noWait)
+	ch := change()
+	if ch == nil {
+		t.Fatal("Change not set")
+	}
+	want := []ChangedRange{{Offset: 12, NewLen: 2}}
+	if !reflect.DeepEqual(ch.Ranges, want) {
+		t.Errorf("ranges\nhave: %+v\nwant: %+v", ch.Ranges, want)
+	}
+	if ch.Diff == "" {
+		t.Error("Diff not set")
+	}
+}
@@ -0,0 +1,158 @@
+package fsnotify
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WithContext removes the watch when ctx is canceled or its deadline passes,
+// for watches that are only needed for a request or a tenant.
+//
+// The watch is removed as if Remove was called with the path passed to
+// AddWith; if ctx is already done it's removed right away. Calling Remove or
+// adding the path again without WithContext stops waiting for ctx; adding it
+// again with another context replaces it.
+func WithContext(ctx context.Context) addOpt {
+	return func(opt *withOpts) { opt.ctx = ctx }
+}
+
+// WithTTL removes the watch after d, and sends an [Expired] event for the path
+// passed to AddWith once it's removed; for example to watch an upload directory
+// for the next 10 minutes.
+//
+// This works like [WithContext] with a deadline, and can be combined with it;
+// adding the path again restarts the TTL.
+func WithTTL(d time.Duration) addOpt {
+	return func(opt *withOpts) { opt.ttl = d }
+}
+
+// watchContexts keeps track of WithContext and WithTTL watches.
+type watchContexts struct {
+	mu    sync.Mutex
+	paths map[string]chan struct{} // Path passed to AddWith → closed to stop waiting
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newWatchContexts() *watchContexts {
+	return &watchContexts{
+		paths: make(map[string]chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// set calls remove for the path added with AddWith when the context from
+// WithContext is done, or calls remove and then expire after the WithTTL
+// duration. It stops waiting for an earlier context or TTL if neither is set.
+func (c *watchContexts) set(path string, with withOpts,
+	remove func(string) error, expire func(string, chan struct{}),
+) {
+	path = filepath.Clean(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.paths[path]; ok {
+		close(s)
+		delete(c.paths, path)
+	}
+	if with.ctx == nil && with.ttl <= 0 {
+		return
+	}
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	var (
+		s       = make(chan struct{})
+		ctxDone <-chan struct{}
+		ttl     *time.Timer
+		expired <-chan time.Time
+	)
+	if with.ctx != nil {
+		ctxDone = with.ctx.Done()
+	}
+	if with.ttl > 0 {
+		ttl = time.NewTimer(with.ttl)
+		expired = ttl.C
+	}
+	c.paths[path] = s
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if ttl != nil {
+			defer ttl.Stop()
+		}
+		isExpired := false
+		select {
+		case <-ctxDone:
+		case <-expired:
+			isExpired = true
+		case <-s:
+			return
+		case <-c.done:
+			return
+		}
+		c.mu.Lock()
+		if c.paths[path] != s {
+			c.mu.Unlock()
+			return
+		}
+		delete(c.paths, path)
+		c.mu.Unlock()
+		_ = remove(path)
+		if isExpired {
+			expire(path, c.done)
+		}
+	}()
+}
+
+// clear stops waiting for the contexts of all watches for the path, for Remove.
+func (c *watchContexts) clear(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	root, _ := recursivePath(filepath.Clean(path))
+	for p, s := range c.paths {
+		if r, _ := recursivePath(p); r == root {
+			close(s)
+			delete(c.paths, p)
+		}
+	}
+}
+
+// stop waiting for all contexts and wait for watches that are being removed,
+// for Close.
+func (c *watchContexts) stop() {
+	c.mu.Lock()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.paths = make(map[string]chan struct{})
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+// sendExpired sends an Expired event for a WithTTL watch, unless stop is closed
+// first.
+func (w *Watcher) sendExpired(name string, stop chan struct{}) {
+	e := Event{Name: name, Op: Expired}
+	w.stats.event(e, len(w.Events))
+	if w.subs.send(e, w.callback != nil) {
+		w.delivery.sent(e)
+		return
+	}
+	if w.callback != nil {
+		w.callback.call(e)
+		w.delivery.sent(e)
+		return
+	}
+	if !w.delivery.blocking() {
+		w.delivery.send(w.Events, e)
+		return
+	}
+	w.delivery.sendBlocking(w.Events, e, stop)
+}
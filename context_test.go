@@ -0,0 +1,101 @@
+package fsnotify
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithContext(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "cancel", noWait)
+	mkdir(t, tmp, "readd", noWait)
+	mkdir(t, tmp, "removed", noWait)
+
+	w, err := NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, p := range []string{"cancel", "readd", "removed"} {
+		if err := w.AddWith(join(tmp, p), WithContext(ctx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Adding again without WithContext or removing it stops waiting for ctx.
+	if err := w.Add(join(tmp, "readd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Remove(join(tmp, "removed")); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	want := join(tmp, "readd")
+	for i := 0; i < 50; i++ {
+		if l := w.WatchList(); len(l) == 1 && l[0] == want {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != want {
+		t.Errorf("WatchList() = %q; want %q", l, want)
+	}
+
+	// A context that's already done.
+	if err := w.AddWith(join(tmp, "cancel"), WithContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50 && len(w.WatchList()) != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l := w.WatchList(); len(l) != 1 {
+		t.Errorf("watch not removed: %q", l)
+	}
+}
+
+func TestWithTTL(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.AddWith(tmp, WithTTL(50*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, tmp, "file")
+
+	var have []Event
+	timeout := time.After(2 * time.Second)
+loop:
+	for {
+		select {
+		case e := <-w.Events:
+			have = append(have, e)
+			if e.Has(Expired) {
+				break loop
+			}
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("no Expired event: %v", have)
+		}
+	}
+	if e := have[len(have)-1]; e.Name != tmp || e.Op != Expired {
+		t.Errorf("wrong event: %v", e)
+	}
+	if len(have) < 2 || have[0].Name != join(tmp, "file") {
+		t.Errorf("no event for file before Expired: %v", have)
+	}
+	if l := w.WatchList(); len(l) != 0 {
+		t.Errorf("watch not removed: %q", l)
+	}
+}
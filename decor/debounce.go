@@ -0,0 +1,129 @@
+package decor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// pending is a list of events waiting to be sent, with at most one event per
+// path.
+type pending struct {
+	order  []string // Paths in the order of the first event.
+	events map[string]*pendingEvent
+}
+
+type pendingEvent struct {
+	ev fsnotify.Event
+	at time.Time // When to send it, for Debounce.
+}
+
+func newPending() *pending { return &pending{events: make(map[string]*pendingEvent)} }
+
+// add the event, merging the Op with a pending event for the same path.
+func (p *pending) add(e fsnotify.Event, at time.Time) {
+	if pe, ok := p.events[e.Name]; ok {
+		pe.ev.Op |= e.Op
+		pe.at = at
+		return
+	}
+	p.order = append(p.order, e.Name)
+	p.events[e.Name] = &pendingEvent{ev: e, at: at}
+}
+
+// take removes and returns all events for which ready returns true, in order.
+func (p *pending) take(ready func(*pendingEvent) bool) []*pendingEvent {
+	var (
+		out  []*pendingEvent
+		keep = p.order[:0]
+	)
+	for _, name := range p.order {
+		pe := p.events[name]
+		if !ready(pe) {
+			keep = append(keep, name)
+			continue
+		}
+		out = append(out, pe)
+		delete(p.events, name)
+	}
+	p.order = keep
+	return out
+}
+
+func all(*pendingEvent) bool { return true }
+
+// Debounce merges the events for a path until there are no new events for it
+// for the wait duration, and then sends a single event with all the Ops that
+// were seen. Events for different paths don't affect each other, and are sent
+// in the order they become ready.
+//
+// Use [Dedup] to send the first event right away and drop the rest, or
+// [fsnotify.Deduper] without a Source.
+func Debounce(src Source, wait time.Duration) Source {
+	var (
+		s = newSource(src)
+		p = newPending()
+	)
+	send := func(events []*pendingEvent) {
+		sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+		for _, pe := range events {
+			s.send(pe.ev)
+		}
+	}
+	go s.run(
+		func(e fsnotify.Event) { p.add(e, time.Now().Add(wait)) },
+		func() time.Time {
+			var first time.Time
+			for _, pe := range p.events {
+				if first.IsZero() || pe.at.Before(first) {
+					first = pe.at
+				}
+			}
+			return first
+		},
+		func(now time.Time) {
+			send(p.take(func(pe *pendingEvent) bool { return !pe.at.After(now) }))
+		},
+		func() { send(p.take(all)) })
+	return s
+}
+
+// Batch collects events for the wait duration after the first event, and then
+// sends them all at once, merging the Ops of events for the same path. A batch
+// is sent early if it has events for max paths; there is no limit if max is 0.
+//
+// This is useful to process changes in bulk; for example to rebuild once after
+// a "git checkout" instead of for every file.
+func Batch(src Source, wait time.Duration, max int) Source {
+	var (
+		s     = newSource(src)
+		p     = newPending()
+		start time.Time // Start of the current batch.
+	)
+	flush := func() {
+		for _, pe := range p.take(all) {
+			s.send(pe.ev)
+		}
+		start = time.Time{}
+	}
+	go s.run(
+		func(e fsnotify.Event) {
+			if start.IsZero() {
+				start = time.Now()
+			}
+			p.add(e, time.Time{})
+			if max > 0 && len(p.order) >= max {
+				flush()
+			}
+		},
+		func() time.Time {
+			if start.IsZero() {
+				return time.Time{}
+			}
+			return start.Add(wait)
+		},
+		func(time.Time) { flush() },
+		flush)
+	return s
+}
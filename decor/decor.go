@@ -0,0 +1,174 @@
+// Package decor provides decorators for the events from a [fsnotify.Watcher],
+// such as debouncing and filtering.
+//
+// Every decorator reads from a Source and is a Source itself, so they can be
+// combined in any order:
+//
+//	w, err := fsnotify.NewWatcher()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	src := decor.Debounce(decor.Filter(decor.Watch(w), isGoFile), 100*time.Millisecond)
+//	defer src.Close()
+//	for e := range src.Events() {
+//		log.Println(e)
+//	}
+//
+// Decorators can also be chained with [Use]:
+//
+//	src := decor.Use(decor.Watch(w), decor.Dedup(100*time.Millisecond), decor.FilterOps(fsnotify.Write))
+//
+// Errors are passed on unchanged. A decorator sends everything it still has
+// pending (e.g. events waiting for Debounce) once the Source it reads from is
+// closed, and then closes its own channels.
+package decor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Source sends events and errors like a [fsnotify.Watcher].
+//
+// As with the Watcher both channels should be read, and they're closed when
+// the Source is closed.
+type Source interface {
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+
+	// Close the Source, and all the Sources it reads from (including the
+	// Watcher). Pending events are still sent for up to a second, after which
+	// they're dropped and the channels are closed.
+	Close() error
+}
+
+// Watch returns a Source for the watcher, which can be a [fsnotify.Watcher]
+// or any other [fsnotify.Interface].
+func Watch(w fsnotify.Interface) Source { return watcher{w} }
+
+type watcher struct{ w fsnotify.Interface }
+
+func (w watcher) Events() <-chan fsnotify.Event { return w.w.EventChan() }
+func (w watcher) Errors() <-chan error          { return w.w.ErrorChan() }
+func (w watcher) Close() error                  { return w.w.Close() }
+
+// source is the base for all decorators.
+type source struct {
+	parent    Source
+	events    chan fsnotify.Event
+	errors    chan error
+	done      chan struct{}
+	deadline  time.Time // Set before done is closed.
+	closeOnce sync.Once
+}
+
+// closeTimeout is how long the remaining events are still sent after Close.
+var closeTimeout = time.Second
+
+func newSource(parent Source) *source {
+	return &source{
+		parent: parent,
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *source) Events() <-chan fsnotify.Event { return s.events }
+func (s *source) Errors() <-chan error          { return s.errors }
+
+func (s *source) Close() error {
+	s.closeOnce.Do(func() {
+		s.deadline = time.Now().Add(closeTimeout)
+		close(s.done)
+	})
+	return s.parent.Close()
+}
+
+// send an event. After Close this waits until the deadline for the consumer to
+// read it, returning false if it doesn't.
+func (s *source) send(e fsnotify.Event) bool {
+	select {
+	case s.events <- e:
+		return true
+	case <-s.done:
+	}
+	t := time.NewTimer(time.Until(s.deadline))
+	defer t.Stop()
+	select {
+	case s.events <- e:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// sendError sends an error, returning false if the Source was closed; errors
+// aren't sent after Close.
+func (s *source) sendError(err error) bool {
+	select {
+	case s.errors <- err:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// run reads from the parent until its Events channel is closed, calling
+// handle for every event and passing on errors.
+//
+// Decorators that wait for something set next and fire: next returns when fire
+// should be called, or the zero time if there's nothing to wait for.
+//
+// Once the parent is closed finish is called to send anything that's pending,
+// and the channels are closed.
+func (s *source) run(handle func(fsnotify.Event), next func() time.Time, fire func(now time.Time), finish func()) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer func() {
+		timer.Stop()
+		if finish != nil {
+			finish()
+		}
+		close(s.events)
+		close(s.errors)
+	}()
+
+	var (
+		events = s.parent.Events()
+		errs   = s.parent.Errors()
+	)
+	for {
+		var tick <-chan time.Time
+		if next != nil {
+			if at := next(); !at.IsZero() {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(time.Until(at))
+				tick = timer.C
+			}
+		}
+
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			handle(e)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			s.sendError(err)
+		case now := <-tick:
+			fire(now)
+		}
+	}
+}
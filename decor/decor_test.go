@@ -0,0 +1,435 @@
+package decor
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+	"github.com/hohodqr/fsnotify/fsnotifytest"
+)
+
+// fake is a Source that sends the events it's given.
+type fake struct {
+	events chan fsnotify.Event
+	errors chan error
+	closed chan struct{}
+}
+
+func newFake() *fake {
+	return &fake{
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		closed: make(chan struct{}),
+	}
+}
+
+func (f *fake) Events() <-chan fsnotify.Event { return f.events }
+func (f *fake) Errors() <-chan error          { return f.errors }
+func (f *fake) Close() error {
+	select {
+	case <-f.closed:
+	default:
+		close(f.closed)
+		close(f.events)
+		close(f.errors)
+	}
+	return nil
+}
+
+func ev(name string, op fsnotify.Op) fsnotify.Event { return fsnotify.Event{Name: name, Op: op} }
+
+// collect all events from src until it's closed.
+func collect(t *testing.T, src Source) <-chan []fsnotify.Event {
+	t.Helper()
+	ch := make(chan []fsnotify.Event, 1)
+	go func() {
+		var have []fsnotify.Event
+		for e := range src.Events() {
+			have = append(have, e)
+		}
+		ch <- have
+	}()
+	return ch
+}
+
+func wait(t *testing.T, ch <-chan []fsnotify.Event) []fsnotify.Event {
+	t.Helper()
+	select {
+	case have := <-ch:
+		return have
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+func check(t *testing.T, have []fsnotify.Event, want ...fsnotify.Event) {
+	t.Helper()
+	if len(have) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %v\nwant: %v", have, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+
+	f := newFake()
+	src := Filter(f, func(e fsnotify.Event) bool { return strings.HasSuffix(e.Name, ".go") })
+	out := collect(t, src)
+	f.events <- ev("a.go", fsnotify.Write)
+	f.events <- ev("b.txt", fsnotify.Write)
+	f.events <- ev("c.go", fsnotify.Create)
+	src.Close()
+
+	check(t, wait(t, out), ev("a.go", fsnotify.Write), ev("c.go", fsnotify.Create))
+}
+
+func TestEnrich(t *testing.T) {
+	t.Parallel()
+
+	f := newFake()
+	src := Enrich(f, func(e fsnotify.Event) (fsnotify.Event, error) {
+		if e.Name == "bad" {
+			return e, errors.New("oops")
+		}
+		e.Name = strings.ToUpper(e.Name)
+		return e, nil
+	})
+	out := collect(t, src)
+	errc := make(chan error, 1)
+	go func() {
+		for err := range src.Errors() {
+			errc <- err
+		}
+	}()
+	f.events <- ev("a", fsnotify.Write)
+	f.events <- ev("bad", fsnotify.Write)
+	if err := <-errc; err == nil || err.Error() != "oops" {
+		t.Errorf("wrong error: %v", err)
+	}
+	src.Close()
+
+	check(t, wait(t, out), ev("A", fsnotify.Write))
+}
+
+func TestPersist(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := fsnotify.OpenJournal(path, fsnotify.CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFake()
+	src := Persist(f, j)
+	out := collect(t, src)
+	f.events <- ev("a", fsnotify.Write)
+	f.events <- ev("b", fsnotify.Remove)
+	src.Close()
+	check(t, wait(t, out), ev("a", fsnotify.Write), ev("b", fsnotify.Remove))
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	r, err := fsnotify.NewJournalReader(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, rec.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("journal has %v", names)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	t.Parallel()
+
+	f := newFake()
+	src := Debounce(f, 50*time.Millisecond)
+	out := collect(t, src)
+	f.events <- ev("a", fsnotify.Create)
+	f.events <- ev("b", fsnotify.Write)
+	f.events <- ev("a", fsnotify.Write)
+	time.Sleep(200 * time.Millisecond)
+	f.events <- ev("a", fsnotify.Write)
+	// Pending events are sent on close.
+	f.events <- ev("c", fsnotify.Write)
+	src.Close()
+
+	check(t, wait(t, out),
+		ev("b", fsnotify.Write),
+		ev("a", fsnotify.Create|fsnotify.Write),
+		ev("a", fsnotify.Write),
+		ev("c", fsnotify.Write))
+}
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+
+	f := newFake()
+	src := Batch(f, time.Hour, 2)
+	out := collect(t, src)
+	f.events <- ev("a", fsnotify.Create)
+	f.events <- ev("a", fsnotify.Write)
+	f.events <- ev("b", fsnotify.Write) // Sends the batch: max is 2.
+	f.events <- ev("c", fsnotify.Write) // Sent on close.
+	src.Close()
+
+	check(t, wait(t, out),
+		ev("a", fsnotify.Create|fsnotify.Write),
+		ev("b", fsnotify.Write),
+		ev("c", fsnotify.Write))
+
+	f = newFake()
+	src = Batch(f, 50*time.Millisecond, 0)
+	out = collect(t, src)
+	f.events <- ev("a", fsnotify.Write)
+	f.events <- ev("b", fsnotify.Write)
+	f.events <- ev("a", fsnotify.Remove)
+	time.Sleep(200 * time.Millisecond)
+	f.events <- ev("a", fsnotify.Create)
+	src.Close()
+
+	check(t, wait(t, out),
+		ev("a", fsnotify.Write|fsnotify.Remove),
+		ev("b", fsnotify.Write),
+		ev("a", fsnotify.Create))
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	f := newFake()
+	src := RateLimit(f, 2, 100*time.Millisecond)
+	out := collect(t, src)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		f.events <- ev("a", fsnotify.Write)
+	}
+	// The 5th event can only be read after the 3rd and 4th were sent, which
+	// had to wait for a period after the first two.
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("took %s", d)
+	}
+	src.Close()
+	if have := wait(t, out); len(have) != 5 {
+		t.Errorf("have %d events", len(have))
+	}
+}
+
+// Decorators can be combined in any order.
+func TestCompose(t *testing.T) {
+	t.Parallel()
+
+	isGo := func(e fsnotify.Event) bool { return strings.HasSuffix(e.Name, ".go") }
+	for _, tt := range []struct {
+		name string
+		wrap func(Source) Source
+	}{
+		{"filter,debounce", func(s Source) Source { return Debounce(Filter(s, isGo), time.Second) }},
+		{"debounce,filter", func(s Source) Source { return Filter(Debounce(s, time.Second), isGo) }},
+		{"batch,ratelimit,filter", func(s Source) Source {
+			return Filter(RateLimit(Batch(s, time.Hour, 0), 10, time.Second), isGo)
+		}},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			f := newFake()
+			src := tt.wrap(f)
+			out := collect(t, src)
+			f.events <- ev("a.go", fsnotify.Create)
+			f.events <- ev("b.txt", fsnotify.Write)
+			f.events <- ev("a.go", fsnotify.Write)
+			src.Close()
+
+			check(t, wait(t, out), ev("a.go", fsnotify.Create|fsnotify.Write))
+		})
+	}
+}
+
+func TestUse(t *testing.T) {
+	t.Parallel()
+
+	var (
+		create = fsnotify.NativeOps(fsnotify.Create)
+		write  = fsnotify.NativeOps(fsnotify.Write)
+		remove = fsnotify.NativeOps(fsnotify.Remove)
+	)
+	f := newFake()
+	src := Use(f,
+		Dedup(time.Hour),
+		FilterOps(fsnotify.Write|fsnotify.Create),
+		Rewrite("/srv", "www"))
+	out := collect(t, src)
+	f.events <- ev("/srv/a", create)
+	f.events <- ev("/srv/a", write)
+	f.events <- ev("/srv/a", write)
+	f.events <- ev("/srv/a", remove)
+	f.events <- ev("/srv", write)
+	f.events <- ev("/srvx/b", write)
+	src.Close()
+
+	check(t, wait(t, out),
+		ev("www/a", create), ev("www/a", write), ev("www", write),
+		ev("/srvx/b", write))
+}
+
+func TestFilterOps(t *testing.T) {
+	t.Parallel()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	file := filepath.Join(tmp, "file")
+	if err := os.WriteFile(file, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(tmp); err != nil {
+		t.Fatal(err)
+	}
+
+	src := Use(Watch(w), FilterOps(fsnotify.Create|fsnotify.Remove))
+	out := collect(t, src)
+	go func() {
+		for range src.Errors() {
+		}
+	}()
+	// Reading and changing the mode of an existing file shouldn't be passed
+	// on; on Linux Create is IN_ACCESS and Remove is IN_ATTRIB.
+	if _, err := os.ReadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "new"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	src.Close()
+
+	var names []string
+	for _, e := range wait(t, out) {
+		names = append(names, filepath.Base(e.Name))
+	}
+	if want := []string{"new", "file"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("\nhave: %v\nwant: %v", names, want)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	t.Parallel()
+
+	f := newFake()
+	src := Use(f, Dedup(50*time.Millisecond))
+	out := collect(t, src)
+	f.events <- ev("a", fsnotify.Write)
+	f.events <- ev("a", fsnotify.Write)
+	f.events <- ev("b", fsnotify.Write)
+	time.Sleep(100 * time.Millisecond)
+	f.events <- ev("a", fsnotify.Write)
+	src.Close()
+
+	check(t, wait(t, out), ev("a", fsnotify.Write), ev("b", fsnotify.Write), ev("a", fsnotify.Write))
+}
+
+func TestRewrite(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		from, to, in, want string
+	}{
+		{"/srv/", "", "/srv/a/b", "a/b"},
+		{"/srv", "", "/srv", "."},
+		{"/srv", "/new", "/srv/a", "/new/a"},
+		{"/srv", "/new", "/other/a", "/other/a"},
+		{"/", "", "/a", "a"},
+	}
+	for _, tt := range tests {
+		f := newFake()
+		src := Use(f, Rewrite(filepath.FromSlash(tt.from), filepath.FromSlash(tt.to)))
+		out := collect(t, src)
+		f.events <- ev(filepath.FromSlash(tt.in), fsnotify.Write)
+		src.Close()
+		check(t, wait(t, out), ev(filepath.FromSlash(tt.want), fsnotify.Write))
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	if err := w.Add(tmp); err != nil {
+		t.Fatal(err)
+	}
+
+	src := Debounce(Watch(w), 50*time.Millisecond)
+	out := collect(t, src)
+	go func() {
+		for range src.Errors() {
+		}
+	}()
+	if err := os.WriteFile(filepath.Join(tmp, "file"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	src.Close()
+
+	have := wait(t, out)
+	if len(have) != 1 || have[0].Name != filepath.Join(tmp, "file") {
+		t.Errorf("wrong events: %v", have)
+	}
+}
+
+func TestWatchInterface(t *testing.T) {
+	t.Parallel()
+
+	w := fsnotifytest.NewWatcher()
+	src := Watch(w)
+	out := collect(t, src)
+	go func() {
+		for range src.Errors() {
+		}
+	}()
+	if err := w.InjectEvent(ev("a", fsnotify.Write)); err != nil {
+		t.Fatal(err)
+	}
+	src.Close()
+	check(t, wait(t, out), ev("a", fsnotify.Write))
+}
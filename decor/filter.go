@@ -0,0 +1,46 @@
+package decor
+
+import "github.com/hohodqr/fsnotify"
+
+// Filter only passes on the events for which keep returns true.
+func Filter(src Source, keep func(fsnotify.Event) bool) Source {
+	s := newSource(src)
+	go s.run(func(e fsnotify.Event) {
+		if keep(e) {
+			s.send(e)
+		}
+	}, nil, nil, nil)
+	return s
+}
+
+// Enrich passes on the event returned by fn, for example to add a
+// [fsnotify.ContentChange] or to make the path relative. If fn returns an
+// error it's sent on the Errors channel and the event is dropped.
+func Enrich(src Source, fn func(fsnotify.Event) (fsnotify.Event, error)) Source {
+	s := newSource(src)
+	go s.run(func(e fsnotify.Event) {
+		e, err := fn(e)
+		if err != nil {
+			s.sendError(err)
+			return
+		}
+		s.send(e)
+	}, nil, nil, nil)
+	return s
+}
+
+// Persist writes every event to the journal before passing it on, so it can be
+// replayed later with [fsnotify.JournalReader]. Errors from writing are sent
+// on the Errors channel, and the event is still passed on.
+//
+// The journal isn't closed when the Source is closed.
+func Persist(src Source, j *fsnotify.Journal) Source {
+	s := newSource(src)
+	go s.run(func(e fsnotify.Event) {
+		if err := j.Write(e); err != nil {
+			s.sendError(err)
+		}
+		s.send(e)
+	}, nil, nil, nil)
+	return s
+}
@@ -0,0 +1,107 @@
+package decor
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Middleware is a decorator that can be chained with [Use].
+type Middleware func(Source) Source
+
+// Use chains the middleware on top of src, in order: the first reads from src
+// and the Source of the last is returned. For example:
+//
+//	src := decor.Use(decor.Watch(w),
+//		decor.Dedup(100*time.Millisecond),
+//		decor.FilterOps(fsnotify.Write|fsnotify.Create),
+//		decor.Rewrite("/srv/www/", ""))
+//
+// Any decorator can be used with a closure:
+//
+//	decor.Use(decor.Watch(w), func(s decor.Source) decor.Source {
+//		return decor.Debounce(s, time.Second)
+//	})
+func Use(src Source, mw ...Middleware) Source {
+	for _, m := range mw {
+		src = m(src)
+	}
+	return src
+}
+
+// Dedup drops events that have the same path and Op as an event that was sent
+// less than window ago. Unlike [Debounce] events aren't delayed, so the first
+// event is sent right away.
+//
+// [fsnotify.Deduper] is like Debounce, but sends the first event instead of
+// merging the Ops, and doesn't need a Source.
+func Dedup(window time.Duration) Middleware {
+	return func(src Source) Source {
+		var (
+			s     = newSource(src)
+			seen  = make(map[dedupKey]time.Time)
+			swept time.Time
+		)
+		go s.run(func(e fsnotify.Event) {
+			key := dedupKey{e.Name, e.Op}
+			now := time.Now()
+			if t, ok := seen[key]; ok && now.Sub(t) < window {
+				return
+			}
+			// Don't keep every path ever seen; once per window is enough, as
+			// entries can't expire sooner.
+			if now.Sub(swept) >= window {
+				for k, t := range seen {
+					if now.Sub(t) >= window {
+						delete(seen, k)
+					}
+				}
+				swept = now
+			}
+			seen[key] = now
+			s.send(e)
+		}, nil, nil, nil)
+		return s
+	}
+}
+
+type dedupKey struct {
+	name string
+	op   fsnotify.Op
+}
+
+// FilterOps only passes on events that have at least one of the operations in
+// ops. The portable operations are converted with [fsnotify.NativeOps], as on
+// Linux the Op of events from a Watcher is the raw inotify mask.
+func FilterOps(ops fsnotify.Op) Middleware {
+	ops = fsnotify.NativeOps(ops)
+	return func(src Source) Source {
+		return Filter(src, func(e fsnotify.Event) bool { return e.Op&ops != 0 })
+	}
+}
+
+// Rewrite replaces the prefix from with to in the path of events; paths that
+// don't start with from are passed on unchanged. Use an empty to to make paths
+// relative to from.
+func Rewrite(from, to string) Middleware {
+	from = filepath.Clean(from)
+	prefix := strings.TrimSuffix(from, string(filepath.Separator)) + string(filepath.Separator)
+	return func(src Source) Source {
+		return Enrich(src, func(e fsnotify.Event) (fsnotify.Event, error) {
+			var rel string
+			switch {
+			case e.Name == from:
+			case strings.HasPrefix(e.Name, prefix):
+				rel = e.Name[len(prefix):]
+			default:
+				return e, nil
+			}
+			if e.Name = filepath.Join(to, rel); e.Name == "" {
+				e.Name = "."
+			}
+			return e, nil
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package decor
+
+import (
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// RateLimit sends at most n events per period. Nothing is dropped: when the
+// limit is reached RateLimit stops reading from src until the period is over,
+// so the events queue up in the Watcher, where [fsnotify.WithBackpressure]
+// decides what happens to them.
+func RateLimit(src Source, n int, per time.Duration) Source {
+	var (
+		s    = newSource(src)
+		sent = make([]time.Time, 0, n) // Times of the last n sends, oldest first.
+	)
+	go s.run(func(e fsnotify.Event) {
+		if n <= 0 {
+			s.send(e)
+			return
+		}
+		if len(sent) == n {
+			if wait := time.Until(sent[0].Add(per)); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-s.done:
+					t.Stop()
+				}
+			}
+			sent = append(sent[:0], sent[1:]...)
+		}
+		if s.send(e) {
+			sent = append(sent, time.Now())
+		}
+	}, nil, nil, nil)
+	return s
+}
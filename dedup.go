@@ -0,0 +1,139 @@
+package fsnotify
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DedupKey returns the key that [Deduper] groups events by.
+type DedupKey func(Event) string
+
+// DedupPath groups events by path, so that e.g. a Create followed by a number
+// of Writes is sent as a single event.
+func DedupPath(e Event) string { return e.Name }
+
+// DedupPathOp groups events by path and Op, so that e.g. a Create and Write
+// are both sent, but many Writes are sent only once.
+func DedupPathOp(e Event) string { return e.Name + "\x00" + e.Op.String() }
+
+// Deduper suppresses duplicate events.
+//
+// Depending on the system, a single "write" can generate many Write events; for
+// example compiling a large Go program can generate hundreds of Write events on
+// the binary. The Deduper waits for the window duration after an event, and
+// resets the wait for every new event with the same key. Once no new events
+// arrived for the window the first event is sent.
+//
+// The decor package has the same for a decor.Source: decor.Debounce merges the
+// Ops of all events instead of sending the first, and decor.Dedup sends the
+// first event right away and drops duplicates within the window.
+//
+// For example:
+//
+//	d := fsnotify.NewDeduper(100*time.Millisecond, fsnotify.DedupPath, func(e fsnotify.Event) {
+//		log.Println(e)
+//	})
+//	defer d.Stop()
+//	for e := range w.Events {
+//		d.Add(e)
+//	}
+type Deduper struct {
+	window time.Duration
+	key    DedupKey
+	fn     func(Event)
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[string]*dedupEvent // Key → first event.
+}
+
+type dedupEvent struct {
+	ev    Event
+	seq   uint64 // Order in which the events were added, for Flush.
+	timer *time.Timer
+}
+
+// NewDeduper creates a new Deduper, which calls fn for every deduplicated
+// event. fn is called from a new goroutine, and may be called concurrently for
+// different keys.
+//
+// The window is 100ms if it's 0 or lower, and the key defaults to [DedupPath]
+// if it's nil.
+func NewDeduper(window time.Duration, key DedupKey, fn func(Event)) *Deduper {
+	if window <= 0 {
+		window = 100 * time.Millisecond
+	}
+	if key == nil {
+		key = DedupPath
+	}
+	return &Deduper{
+		window:  window,
+		key:     key,
+		fn:      fn,
+		pending: make(map[string]*dedupEvent),
+	}
+}
+
+// Add an event. If there's no pending event with the same key then the event
+// is sent after the window, otherwise the wait for the pending event is reset.
+func (d *Deduper) Add(e Event) {
+	k := d.key(e)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p, ok := d.pending[k]; ok {
+		p.timer.Reset(d.window)
+		return
+	}
+
+	d.seq++
+	p := &dedupEvent{ev: e, seq: d.seq}
+	p.timer = time.AfterFunc(d.window, func() { d.fire(k, p) })
+	d.pending[k] = p
+}
+
+// fire sends the pending event after the window has passed, unless it was
+// already sent by Flush or dropped by Stop.
+func (d *Deduper) fire(k string, p *dedupEvent) {
+	d.mu.Lock()
+	if d.pending[k] != p {
+		d.mu.Unlock()
+		return
+	}
+	delete(d.pending, k)
+	d.mu.Unlock()
+
+	d.fn(p.ev)
+}
+
+// Len returns the number of pending events.
+func (d *Deduper) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending)
+}
+
+// Flush sends all pending events now, in the order they were added. fn is
+// called from the current goroutine.
+func (d *Deduper) Flush() {
+	for _, p := range d.take() {
+		d.fn(p.ev)
+	}
+}
+
+// Stop drops all pending events.
+func (d *Deduper) Stop() { d.take() }
+
+func (d *Deduper) take() []*dedupEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	all := make([]*dedupEvent, 0, len(d.pending))
+	for k, p := range d.pending {
+		p.timer.Stop()
+		all = append(all, p)
+		delete(d.pending, k)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].seq < all[j].seq })
+	return all
+}
@@ -0,0 +1,96 @@
+package fsnotify
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeduper(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		key   DedupKey
+		early int // Sent before Flush.
+		want  []Event
+	}{
+		{"path", DedupPath, 0, []Event{{Name: "a", Op: Create}, {Name: "b", Op: Write}}},
+		{"path op", DedupPathOp, 1, []Event{{Name: "a", Op: Create}, {Name: "a", Op: Write}, {Name: "b", Op: Write}}},
+		{"default", nil, 0, []Event{{Name: "a", Op: Create}, {Name: "b", Op: Write}}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu   sync.Mutex
+				have []Event
+			)
+			d := NewDeduper(100*time.Millisecond, tt.key, func(e Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				have = append(have, e)
+			})
+			defer d.Stop()
+
+			d.Add(Event{Name: "a", Op: Create})
+			for i := 0; i < 10; i++ {
+				d.Add(Event{Name: "a", Op: Write})
+				time.Sleep(20 * time.Millisecond)
+			}
+			// Every Write reset the timer; only the Create is sent if the Op
+			// is part of the key.
+			mu.Lock()
+			if len(have) != tt.early {
+				t.Errorf("sent before Flush: %v", have)
+			}
+			mu.Unlock()
+			d.Add(Event{Name: "b", Op: Write})
+			d.Flush()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nhave: %v\nwant: %v", have, tt.want)
+			}
+			if d.Len() != 0 {
+				t.Errorf("Len() = %d after Flush", d.Len())
+			}
+		})
+	}
+}
+
+func TestDeduperWindow(t *testing.T) {
+	t.Parallel()
+
+	sent := make(chan Event, 10)
+	d := NewDeduper(20*time.Millisecond, nil, func(e Event) { sent <- e })
+	defer d.Stop()
+
+	d.Add(Event{Name: "a", Op: Write})
+	d.Add(Event{Name: "a", Op: Write})
+	select {
+	case e := <-sent:
+		if e.Name != "a" {
+			t.Errorf("wrong event: %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if len(sent) != 0 {
+		t.Errorf("sent more than once: %d", len(sent))
+	}
+
+	d.Add(Event{Name: "a", Op: Write})
+	d.Stop()
+	time.Sleep(100 * time.Millisecond)
+	if len(sent) != 0 {
+		t.Errorf("sent after Stop: %d", len(sent))
+	}
+}
@@ -0,0 +1,169 @@
+package ebpf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// A minimal eBPF assembler, for the few instructions the programs need; see
+// Documentation/bpf/standardization/instruction-set.rst in the kernel.
+
+const (
+	// Instruction classes.
+	classLDX   = 0x01
+	classST    = 0x02
+	classSTX   = 0x03
+	classJMP   = 0x05
+	classALU64 = 0x07
+	classLD    = 0x00
+
+	// Sizes for loads and stores.
+	sizeW  = 0x00
+	sizeH  = 0x08
+	sizeDW = 0x18
+
+	modeIMM = 0x00
+	modeMEM = 0x60
+
+	// ALU and jump operations, with the source.
+	aluAdd  = 0x00
+	aluAnd  = 0x50
+	aluLsh  = 0x60
+	aluRsh  = 0x70
+	aluMov  = 0xb0
+	jmpJA   = 0x00
+	jmpJEQ  = 0x10
+	jmpJGT  = 0x20
+	jmpJNE  = 0x50
+	jmpCall = 0x80
+	jmpExit = 0x90
+	srcK    = 0x00
+	srcX    = 0x08
+
+	pseudoMapFD = 1
+)
+
+// Registers: r0 is the return value, r1-r5 are arguments (clobbered by calls),
+// r6-r9 are kept across calls, and r10 is the read-only frame pointer.
+type reg uint8
+
+const (
+	r0 reg = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10
+)
+
+// Helper functions, from enum bpf_func_id in linux/bpf.h.
+const (
+	fnGetCurrentPidTgid  = 14
+	fnGetCurrentUIDGid   = 15
+	fnGetCurrentComm     = 16
+	fnProbeReadKernel    = 113
+	fnProbeReadKernelStr = 115
+	fnRingbufReserve     = 131
+	fnRingbufSubmit      = 132
+)
+
+type insn struct {
+	code uint8
+	regs uint8 // dst in the low 4 bits, src in the high 4 bits.
+	off  int16
+	imm  int32
+}
+
+// asm builds a program; jumps are to labels, which are resolved by bytes().
+type asm struct {
+	insns  []insn
+	labels map[string]int // Label → instruction index.
+	jumps  map[int]string // Instruction index → label it jumps to.
+	seq    int            // For unique labels.
+}
+
+func newAsm() *asm {
+	return &asm{labels: make(map[string]int), jumps: make(map[int]string)}
+}
+
+func (a *asm) emit(code uint8, dst, src reg, off int16, imm int32) {
+	a.insns = append(a.insns, insn{code: code, regs: uint8(dst) | uint8(src)<<4, off: off, imm: imm})
+}
+
+// label returns a new unique label name with the prefix.
+func (a *asm) label(prefix string) string {
+	a.seq++
+	return fmt.Sprintf("%s%d", prefix, a.seq)
+}
+
+func (a *asm) mark(label string)         { a.labels[label] = len(a.insns) }
+func (a *asm) movImm(dst reg, imm int32) { a.emit(classALU64|aluMov|srcK, dst, 0, 0, imm) }
+func (a *asm) mov(dst, src reg)          { a.emit(classALU64|aluMov|srcX, dst, src, 0, 0) }
+func (a *asm) addImm(dst reg, imm int32) { a.emit(classALU64|aluAdd|srcK, dst, 0, 0, imm) }
+func (a *asm) andImm(dst reg, imm int32) { a.emit(classALU64|aluAnd|srcK, dst, 0, 0, imm) }
+func (a *asm) lshImm(dst reg, imm int32) { a.emit(classALU64|aluLsh|srcK, dst, 0, 0, imm) }
+func (a *asm) rshImm(dst reg, imm int32) { a.emit(classALU64|aluRsh|srcK, dst, 0, 0, imm) }
+func (a *asm) ldx(size uint8, dst, src reg, off int16) {
+	a.emit(classLDX|size|modeMEM, dst, src, off, 0)
+}
+func (a *asm) stx(size uint8, dst reg, off int16, src reg) {
+	a.emit(classSTX|size|modeMEM, dst, src, off, 0)
+}
+func (a *asm) st(size uint8, dst reg, off int16, imm int32) {
+	a.emit(classST|size|modeMEM, dst, 0, off, imm)
+}
+func (a *asm) call(fn int32) { a.emit(classJMP|jmpCall, 0, 0, 0, fn) }
+func (a *asm) exit()         { a.emit(classJMP|jmpExit, 0, 0, 0, 0) }
+
+// loadMap loads the file descriptor of a map in to dst; this takes two
+// instructions.
+func (a *asm) loadMap(dst reg, fd int) {
+	a.emit(classLD|sizeDW|modeIMM, dst, pseudoMapFD, 0, int32(fd))
+	a.emit(0, 0, 0, 0, 0)
+}
+
+func (a *asm) jump(code uint8, dst, src reg, imm int32, label string) {
+	a.jumps[len(a.insns)] = label
+	a.emit(classJMP|code, dst, src, 0, imm)
+}
+func (a *asm) ja(label string)                         { a.jump(jmpJA, 0, 0, 0, label) }
+func (a *asm) jeqImm(dst reg, imm int32, label string) { a.jump(jmpJEQ|srcK, dst, 0, imm, label) }
+func (a *asm) jneImm(dst reg, imm int32, label string) { a.jump(jmpJNE|srcK, dst, 0, imm, label) }
+func (a *asm) jgtImm(dst reg, imm int32, label string) { a.jump(jmpJGT|srcK, dst, 0, imm, label) }
+func (a *asm) jeq(dst, src reg, label string)          { a.jump(jmpJEQ|srcX, dst, src, 0, label) }
+
+// probeRead emits bpf_probe_read_kernel(r10+stack, size, src+off), after which
+// the value is at r10+stack.
+func (a *asm) probeRead(stack int16, size int32, src reg, off int32) {
+	a.mov(r3, src)
+	if off != 0 {
+		a.addImm(r3, off)
+	}
+	a.mov(r1, r10)
+	a.addImm(r1, int32(stack))
+	a.movImm(r2, size)
+	a.call(fnProbeReadKernel)
+}
+
+// bytes resolves the jumps and returns the encoded program.
+func (a *asm) bytes() ([]byte, error) {
+	for i, label := range a.jumps {
+		to, ok := a.labels[label]
+		if !ok {
+			return nil, fmt.Errorf("undefined label %q", label)
+		}
+		a.insns[i].off = int16(to - i - 1)
+	}
+	b := make([]byte, 8*len(a.insns))
+	for i, in := range a.insns {
+		b[8*i], b[8*i+1] = in.code, in.regs
+		binary.LittleEndian.PutUint16(b[8*i+2:], uint16(in.off))
+		binary.LittleEndian.PutUint32(b[8*i+4:], uint32(in.imm))
+	}
+	return b, nil
+}
@@ -0,0 +1,137 @@
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpf() commands and types, from linux/bpf.h.
+const (
+	cmdMapCreate         = 0
+	cmdProgLoad          = 5
+	cmdRawTracepointOpen = 17
+
+	mapTypeRingbuf   = 27
+	progTypeTracing  = 26
+	attachTraceEntry = 24
+	attachTraceExit  = 25
+)
+
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+type progLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+	progBTFFd          uint32
+	funcInfoRecSize    uint32
+	funcInfo           uint64
+	funcInfoCnt        uint32
+	lineInfoRecSize    uint32
+	lineInfo           uint64
+	lineInfoCnt        uint32
+	attachBTFID        uint32
+	attachProgFd       uint32
+	_                  uint32
+}
+
+type rawTracepointAttr struct {
+	name   uint64
+	progFd uint32
+	_      uint32
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// newRingbuf creates a BPF_MAP_TYPE_RINGBUF map of size bytes, which must be
+// a power of 2 and a multiple of the page size.
+func newRingbuf(size int) (int, error) {
+	attr := mapCreateAttr{mapType: mapTypeRingbuf, maxEntries: uint32(size)}
+	fd, err := bpf(cmdMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return -1, fmt.Errorf("creating ring buffer: %w", err)
+	}
+	return fd, nil
+}
+
+// loadTracing loads a fentry or fexit program for the kernel function with
+// the BTF ID, and attaches it. It returns the file descriptor of the link;
+// closing it detaches the program.
+func loadTracing(name string, attach uint32, btfID uint32, a *asm) (int, error) {
+	prog, err := a.bytes()
+	if err != nil {
+		return -1, err
+	}
+	var (
+		license = []byte("GPL\x00") // bpf_probe_read_kernel is GPL-only.
+		log     = make([]byte, 64*1024)
+		attr    = progLoadAttr{
+			progType:           progTypeTracing,
+			insnCnt:            uint32(len(prog) / 8),
+			insns:              uint64(uintptr(unsafe.Pointer(&prog[0]))),
+			license:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+			logLevel:           1,
+			logSize:            uint32(len(log)),
+			logBuf:             uint64(uintptr(unsafe.Pointer(&log[0]))),
+			expectedAttachType: attach,
+			attachBTFID:        btfID,
+		}
+	)
+	copy(attr.progName[:len(attr.progName)-1], name)
+
+	progFd, err := bpf(cmdProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(prog)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return -1, fmt.Errorf("loading program for %s: %w%s", name, err, verifierError(log))
+	}
+	defer unix.Close(progFd)
+
+	tp := rawTracepointAttr{progFd: uint32(progFd)}
+	fd, err := bpf(cmdRawTracepointOpen, unsafe.Pointer(&tp), unsafe.Sizeof(tp))
+	if err != nil {
+		return -1, fmt.Errorf("attaching program to %s: %w", name, err)
+	}
+	return fd, nil
+}
+
+// verifierError returns the last line of the verifier log, which usually
+// says why the program was rejected.
+func verifierError(log []byte) string {
+	if i := bytes.IndexByte(log, 0); i >= 0 {
+		log = log[:i]
+	}
+	lines := strings.Split(strings.TrimSpace(string(log)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if l := strings.TrimSpace(lines[i]); l != "" && !strings.HasPrefix(l, "processed ") {
+			return ": " + l
+		}
+	}
+	return ""
+}
@@ -0,0 +1,236 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// BTF kinds, from linux/btf.h.
+const (
+	btfKindInt = iota + 1
+	btfKindPtr
+	btfKindArray
+	btfKindStruct
+	btfKindUnion
+	btfKindEnum
+	btfKindFwd
+	btfKindTypedef
+	btfKindVolatile
+	btfKindConst
+	btfKindRestrict
+	btfKindFunc
+	btfKindFuncProto
+	btfKindVar
+	btfKindDatasec
+	btfKindFloat
+	btfKindDeclTag
+	btfKindTypeTag
+	btfKindEnum64
+)
+
+// btf is the type information of the running kernel, from
+// /sys/kernel/btf/vmlinux. Only what's needed to find functions and struct
+// members is kept.
+type btf struct {
+	strs  []byte
+	types []btfType // Index is the type ID; 0 is void.
+	funcs map[string]uint32
+}
+
+type btfType struct {
+	name    string
+	kind    uint8
+	kflag   bool
+	typ     uint32      // Referenced type, for pointers, modifiers, typedefs, and functions.
+	members []btfMember // Struct and union members, or function parameters.
+}
+
+type btfMember struct {
+	name   string
+	typ    uint32
+	offset uint32 // In bits.
+}
+
+func loadKernelBTF() (*btf, error) {
+	data, err := os.ReadFile("/sys/kernel/btf/vmlinux")
+	if err != nil {
+		return nil, fmt.Errorf("reading kernel BTF: %w", err)
+	}
+	return parseBTF(data)
+}
+
+func parseBTF(data []byte) (*btf, error) {
+	var hdr struct {
+		Magic   uint16
+		Version uint8
+		Flags   uint8
+		HdrLen  uint32
+		TypeOff uint32
+		TypeLen uint32
+		StrOff  uint32
+		StrLen  uint32
+	}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("reading BTF header: %w", err)
+	}
+	if hdr.Magic != 0xeb9f {
+		return nil, errors.New("BTF is not little-endian, or not BTF")
+	}
+	var (
+		typeStart = uint64(hdr.HdrLen) + uint64(hdr.TypeOff)
+		strStart  = uint64(hdr.HdrLen) + uint64(hdr.StrOff)
+	)
+	if typeStart+uint64(hdr.TypeLen) > uint64(len(data)) || strStart+uint64(hdr.StrLen) > uint64(len(data)) {
+		return nil, errors.New("BTF sections out of bounds")
+	}
+
+	b := &btf{
+		strs:  data[strStart : strStart+uint64(hdr.StrLen)],
+		types: []btfType{{}},
+		funcs: make(map[string]uint32),
+	}
+	raw := data[typeStart : typeStart+uint64(hdr.TypeLen)]
+	u32 := func(off int) uint32 { return binary.LittleEndian.Uint32(raw[off:]) }
+	for off := 0; off+12 <= len(raw); {
+		var (
+			info = u32(off + 4)
+			t    = btfType{
+				name:  b.str(u32(off)),
+				kind:  uint8(info >> 24 & 0x1f),
+				kflag: info>>31 == 1,
+				typ:   u32(off + 8),
+			}
+			vlen = int(info & 0xffff)
+		)
+		off += 12
+
+		var size int
+		switch t.kind {
+		case btfKindInt, btfKindVar, btfKindDeclTag:
+			size = 4
+		case btfKindArray:
+			size = 12
+		case btfKindStruct, btfKindUnion:
+			size = 12 * vlen
+			if off+size > len(raw) {
+				return nil, errors.New("BTF type out of bounds")
+			}
+			for i := 0; i < vlen; i++ {
+				m := off + 12*i
+				offset := u32(m + 8)
+				if t.kflag {
+					offset &= 0xffffff // The top 8 bits are the bitfield size.
+				}
+				t.members = append(t.members, btfMember{name: b.str(u32(m)), typ: u32(m + 4), offset: offset})
+			}
+		case btfKindEnum:
+			size = 8 * vlen
+		case btfKindFuncProto:
+			size = 8 * vlen
+			if off+size > len(raw) {
+				return nil, errors.New("BTF type out of bounds")
+			}
+			for i := 0; i < vlen; i++ {
+				m := off + 8*i
+				t.members = append(t.members, btfMember{name: b.str(u32(m)), typ: u32(m + 4)})
+			}
+		case btfKindDatasec, btfKindEnum64:
+			size = 12 * vlen
+		case btfKindPtr, btfKindFwd, btfKindTypedef, btfKindVolatile, btfKindConst,
+			btfKindRestrict, btfKindFunc, btfKindFloat, btfKindTypeTag:
+		default:
+			return nil, fmt.Errorf("unknown BTF kind %d", t.kind)
+		}
+		off += size
+
+		if t.kind == btfKindFunc {
+			b.funcs[t.name] = uint32(len(b.types))
+		}
+		b.types = append(b.types, t)
+	}
+	return b, nil
+}
+
+func (b *btf) str(off uint32) string {
+	if int(off) >= len(b.strs) {
+		return ""
+	}
+	s := b.strs[off:]
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return string(s)
+}
+
+// skip resolves typedefs and modifiers such as const.
+func (b *btf) skip(id uint32) btfType {
+	for int(id) < len(b.types) {
+		t := b.types[id]
+		switch t.kind {
+		case btfKindTypedef, btfKindVolatile, btfKindConst, btfKindRestrict, btfKindTypeTag:
+			id = t.typ
+		default:
+			return t
+		}
+	}
+	return btfType{}
+}
+
+// pointee returns the name of the struct that id points to, or "" if it's not
+// a pointer to a struct.
+func (b *btf) pointee(id uint32) string {
+	t := b.skip(id)
+	if t.kind != btfKindPtr {
+		return ""
+	}
+	if t = b.skip(t.typ); t.kind != btfKindStruct {
+		return ""
+	}
+	return t.name
+}
+
+// params returns the parameters of the function, and its ID.
+func (b *btf) params(fn string) ([]btfMember, uint32, error) {
+	id, ok := b.funcs[fn]
+	if !ok {
+		return nil, 0, fmt.Errorf("kernel function %s not found", fn)
+	}
+	proto := b.types[b.types[id].typ]
+	if proto.kind != btfKindFuncProto {
+		return nil, 0, fmt.Errorf("kernel function %s has no prototype", fn)
+	}
+	return proto.members, id, nil
+}
+
+// offset returns the byte offset of the member in the struct, looking in
+// anonymous structs and unions.
+func (b *btf) offset(strct, member string) (uint32, error) {
+	for _, t := range b.types {
+		if t.kind != btfKindStruct || t.name != strct {
+			continue
+		}
+		if off, ok := b.find(t, member); ok {
+			return off / 8, nil
+		}
+	}
+	return 0, fmt.Errorf("kernel struct member %s.%s not found", strct, member)
+}
+
+func (b *btf) find(t btfType, member string) (uint32, bool) {
+	for _, m := range t.members {
+		if m.name == member {
+			return m.offset, true
+		}
+		if m.name == "" {
+			if mt := b.skip(m.typ); mt.kind == btfKindStruct || mt.kind == btfKindUnion {
+				if off, ok := b.find(mt, member); ok {
+					return m.offset + off, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,167 @@
+// Package ebpf monitors file activity for the whole system with eBPF, and
+// reports which process caused it; for example for security and audit tools
+// that need more than inotify offers.
+//
+// Unlike a [fsnotify.Watcher] nothing needs to be added to get events: all
+// files that are created, removed, or renamed on any filesystem are reported,
+// including paths that don't exist yet and trees that are too large to watch
+// with inotify. Use [Monitor.Add] to only get events for some directories.
+//
+//	m, err := ebpf.New()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer m.Close()
+//	for e := range m.Events {
+//		log.Printf("%s %s by %s (pid %d)", e.Op, e.Name, e.Comm, e.PID)
+//	}
+//
+// This is experimental, and only works on Linux 5.5 or newer with BTF
+// (CONFIG_DEBUG_INFO_BTF) and the BPF ring buffer (Linux 5.8), for processes
+// with CAP_BPF and CAP_PERFMON (or CAP_SYS_ADMIN). Programs are attached to
+// the vfs_* functions in the kernel, which aren't a stable interface, so it
+// may break with any kernel release. It's a separate module so that it can
+// change or be removed without a new fsnotify release, and fsnotify doesn't
+// depend on it.
+//
+// New fails with EPERM if the kernel is locked down in confidentiality mode
+// (see /sys/kernel/security/lockdown), as that doesn't allow reading kernel
+// memory from BPF programs.
+package ebpf
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Event is a file activity event, with the process that caused it.
+//
+// The Op is the same as for inotify: IN_CREATE, IN_DELETE, IN_MOVED_FROM, or
+// IN_MOVED_TO, with IN_ISDIR for directories. A rename is sent as IN_MOVED_FROM
+// with the old name followed by IN_MOVED_TO with the new name, although events
+// from other processes may be sent between them.
+//
+// Name is an absolute path. If the filesystem isn't mounted in the mount
+// namespace of the Monitor (for example in a container), it's relative to the
+// root of the filesystem, and Unmounted is set.
+type Event struct {
+	fsnotify.Event
+
+	PID       int    // Process ID.
+	UID       int    // Real user ID of the process.
+	Comm      string // Process name, up to 15 bytes; see /proc/[pid]/comm.
+	Unmounted bool   // The filesystem isn't mounted in the mount namespace of the Monitor.
+}
+
+// ErrPathTooDeep is sent on the Errors channel for files that are nested too
+// deeply to get their path.
+var ErrPathTooDeep = errors.New("fsnotify/ebpf: path has too many elements")
+
+// Monitor reports file activity for the whole system.
+type Monitor struct {
+	// Events sends the file activity events. Events are dropped if they're
+	// not read fast enough; the kernel buffers a few hundred.
+	Events chan Event
+
+	// Errors sends any errors.
+	Errors chan error
+
+	mu    sync.Mutex
+	paths map[string]struct{}
+
+	done     chan struct{}
+	doneResp chan struct{}
+	closeMu  sync.Mutex
+	sys      *system // Platform-specific state.
+}
+
+// Add only sends events for files in the directory or its subdirectories, or
+// for the file itself. If no paths are added, events for all files are sent.
+//
+// The path doesn't need to exist, and paths are compared as strings: symlinks
+// aren't resolved, and bind mounts of the directory aren't matched.
+func (m *Monitor) Add(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paths[abs] = struct{}{}
+	return nil
+}
+
+// Remove a path added with Add. Returns [fsnotify.ErrNonExistentWatch] if it
+// wasn't added.
+func (m *Monitor) Remove(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.paths[abs]; !ok {
+		return fsnotify.ErrNonExistentWatch
+	}
+	delete(m.paths, abs)
+	return nil
+}
+
+// WatchList returns all paths added with Add; the order is undefined.
+func (m *Monitor) WatchList() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := make([]string, 0, len(m.paths))
+	for p := range m.paths {
+		l = append(l, p)
+	}
+	return l
+}
+
+// match reports if events for the path should be sent.
+func (m *Monitor) match(path string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.paths) == 0 {
+		return true
+	}
+	for p := range m.paths {
+		if path == p || strings.HasPrefix(path, p) && (p == "/" || path[len(p)] == '/') {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Monitor) isClosed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns true if the event was sent, or false if the Monitor is closed.
+func (m *Monitor) sendEvent(e Event) bool {
+	select {
+	case m.Events <- e:
+		return true
+	case <-m.done:
+		return false
+	}
+}
+
+// Returns true if the error was sent, or false if the Monitor is closed.
+func (m *Monitor) sendError(err error) bool {
+	select {
+	case m.Errors <- err:
+		return true
+	case <-m.done:
+		return false
+	}
+}
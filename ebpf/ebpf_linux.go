@@ -0,0 +1,198 @@
+package ebpf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/hohodqr/fsnotify"
+	"golang.org/x/sys/unix"
+)
+
+// Size of the ring buffer; every record is a bit over 8K, so this fits about
+// a thousand events.
+const ringbufSize = 8 << 20
+
+type system struct {
+	ringbufFd int
+	ring      *ringbuf
+	links     []int // Attached programs.
+	wake      int   // eventfd to wake up readEvents() on Close.
+	mounts    *mounts
+}
+
+// New creates a new Monitor, and starts reporting events.
+func New() (*Monitor, error) {
+	b, err := loadKernelBTF()
+	if err != nil {
+		return nil, err
+	}
+	o, err := kernelOffsets(b)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &system{ringbufFd: -1, wake: -1, mounts: newMounts()}
+	err = s.open(b, o)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("fsnotify/ebpf: %w", err)
+	}
+	s.mounts.load()
+
+	m := &Monitor{
+		Events:   make(chan Event),
+		Errors:   make(chan error),
+		paths:    make(map[string]struct{}),
+		done:     make(chan struct{}),
+		doneResp: make(chan struct{}),
+		sys:      s,
+	}
+	go m.readEvents()
+	return m, nil
+}
+
+func (s *system) open(b *btf, o offsets) error {
+	var err error
+	s.ringbufFd, err = newRingbuf(ringbufSize)
+	if err != nil {
+		return err
+	}
+	s.ring, err = openRingbuf(s.ringbufFd, ringbufSize)
+	if err != nil {
+		return err
+	}
+	s.wake, err = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		a, id, err := h.program(b, o, s.ringbufFd)
+		if err != nil {
+			return err
+		}
+		attach := uint32(attachTraceExit)
+		if h.entry {
+			attach = attachTraceEntry
+		}
+		link, err := loadTracing(h.fn, attach, id, a)
+		if err != nil {
+			return err
+		}
+		s.links = append(s.links, link)
+	}
+	return nil
+}
+
+func (s *system) close() {
+	for _, l := range s.links {
+		unix.Close(l)
+	}
+	s.links = nil
+	if s.ring != nil {
+		s.ring.close()
+	}
+	if s.ringbufFd != -1 {
+		unix.Close(s.ringbufFd)
+	}
+	if s.wake != -1 {
+		unix.Close(s.wake)
+	}
+}
+
+// Close detaches the programs and closes the Events and Errors channels.
+func (m *Monitor) Close() error {
+	m.closeMu.Lock()
+	if m.isClosed() {
+		m.closeMu.Unlock()
+		return nil
+	}
+	close(m.done)
+	m.closeMu.Unlock()
+
+	_, err := unix.Write(m.sys.wake, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	<-m.doneResp
+	return err
+}
+
+func (m *Monitor) readEvents() {
+	defer func() {
+		m.sys.close()
+		close(m.doneResp)
+		close(m.Errors)
+		close(m.Events)
+	}()
+
+	fds := []unix.PollFd{
+		{Fd: int32(m.sys.ringbufFd), Events: unix.POLLIN},
+		{Fd: int32(m.sys.wake), Events: unix.POLLIN},
+	}
+	for {
+		open := true
+		m.sys.ring.read(func(rec []byte) bool {
+			open = m.handleRecord(rec)
+			return open
+		})
+		if !open || m.isClosed() {
+			return
+		}
+
+		_, err := unix.Poll(fds, -1)
+		if err != nil && err != unix.EINTR {
+			if !m.sendError(err) {
+				return
+			}
+		}
+	}
+}
+
+// handleRecord sends the event for a record from the ring buffer; it returns
+// false if the Monitor was closed.
+func (m *Monitor) handleRecord(rec []byte) bool {
+	if len(rec) < recSize {
+		return true
+	}
+	var (
+		u32   = func(off int) uint32 { return *(*uint32)(unsafe.Pointer(&rec[off])) }
+		depth = int(u32(recDepth))
+		op    = u32(recOp)
+		mode  = *(*uint16)(unsafe.Pointer(&rec[recMode]))
+	)
+	if depth > maxDepth {
+		return m.sendError(fmt.Errorf("%w: .../%s", ErrPathTooDeep, names(rec, maxDepth)))
+	}
+	if mode&unix.S_IFMT == unix.S_IFDIR {
+		op |= unix.IN_ISDIR
+	}
+
+	path, mounted := m.sys.mounts.path(u32(recDev), "/"+names(rec, depth))
+	if !m.match(path) {
+		return true
+	}
+	return m.sendEvent(Event{
+		Event:     fsnotify.Event{Name: path, Op: fsnotify.Op(op)},
+		PID:       int(u32(recPID)),
+		UID:       int(u32(recUID)),
+		Comm:      cstring(rec[recComm : recComm+16]),
+		Unmounted: !mounted,
+	})
+}
+
+// names joins the path elements in the record, which are from the file up.
+func names(rec []byte, depth int) string {
+	elems := make([]string, depth)
+	for i := 0; i < depth; i++ {
+		off := recNames + i*nameSize
+		elems[depth-1-i] = cstring(rec[off : off+nameSize])
+	}
+	return strings.Join(elems, "/")
+}
+
+func cstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
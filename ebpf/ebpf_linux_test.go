@@ -0,0 +1,101 @@
+package ebpf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+	"golang.org/x/sys/unix"
+)
+
+func TestMonitor(t *testing.T) {
+	m, err := New()
+	if err != nil {
+		t.Skipf("eBPF not available: %s", err)
+	}
+	defer m.Close()
+
+	tmp := t.TempDir()
+	if err := m.Add(tmp); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		dir  = filepath.Join(tmp, "dir")
+		file = filepath.Join(dir, "file")
+		mv   = filepath.Join(dir, "mv")
+	)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(file, mv); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(mv); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []fsnotify.Event{
+		{Name: dir, Op: unix.IN_CREATE | unix.IN_ISDIR},
+		{Name: file, Op: unix.IN_CREATE},
+		{Name: file, Op: unix.IN_MOVED_FROM},
+		{Name: mv, Op: unix.IN_MOVED_TO},
+		{Name: mv, Op: unix.IN_DELETE},
+		{Name: dir, Op: unix.IN_DELETE | unix.IN_ISDIR},
+	}
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < len(want); i++ {
+		select {
+		case e := <-m.Events:
+			if e.Event != want[i] {
+				t.Fatalf("event %d\nhave: %v\nwant: %v", i, e.Event, want[i])
+			}
+			if e.PID != os.Getpid() || e.UID != os.Getuid() || e.Unmounted {
+				t.Errorf("event %d: PID=%d UID=%d Unmounted=%t; want %d, %d, false",
+					i, e.PID, e.UID, e.Unmounted, os.Getpid(), os.Getuid())
+			}
+		case err := <-m.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("timeout waiting for event %d: %v", i, want[i])
+		}
+	}
+}
+
+func TestMonitorMatch(t *testing.T) {
+	m := &Monitor{paths: make(map[string]struct{})}
+	if !m.match("/any/path") {
+		t.Error("no paths: should match everything")
+	}
+	m.Add("/a/b")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/a/b", true},
+		{"/a/b/c", true},
+		{"/a/bc", false},
+		{"/a", false},
+		{"/x", false},
+	}
+	for _, tt := range tests {
+		if have := m.match(tt.path); have != tt.want {
+			t.Errorf("match(%q) = %t; want %t", tt.path, have, tt.want)
+		}
+	}
+	if err := m.Remove("/x"); err != fsnotify.ErrNonExistentWatch {
+		t.Errorf("Remove: %v", err)
+	}
+	m.Remove("/a/b")
+	if l := m.WatchList(); len(l) != 0 {
+		t.Errorf("WatchList: %v", l)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package ebpf
+
+import "errors"
+
+type system struct{}
+
+// New creates a new Monitor, and starts reporting events.
+//
+// This always returns an error, as eBPF is only supported on Linux.
+func New() (*Monitor, error) {
+	return nil, errors.New("fsnotify/ebpf: only supported on Linux")
+}
+
+// Close detaches the programs and closes the Events and Errors channels.
+func (m *Monitor) Close() error { return nil }
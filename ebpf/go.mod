@@ -0,0 +1,12 @@
+// The ebpf package is an experiment, and is a separate module so that it can
+// change (or be removed) without affecting fsnotify itself.
+module github.com/hohodqr/fsnotify/ebpf
+
+go 1.17
+
+require (
+	github.com/hohodqr/fsnotify v0.0.0-00010101000000-000000000000
+	golang.org/x/sys v0.6.0
+)
+
+replace github.com/hohodqr/fsnotify => ../
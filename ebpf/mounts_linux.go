@@ -0,0 +1,129 @@
+package ebpf
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mounts maps paths relative to the root of a filesystem, which is all the
+// programs can see, to paths in the mount namespace of the process, using
+// /proc/self/mountinfo.
+type mounts struct {
+	byDev  map[uint32][]mount
+	read   time.Time
+	reread time.Duration // Don't read mountinfo more often than this.
+}
+
+type mount struct {
+	root  string // Path in the filesystem that's mounted.
+	point string // Where it's mounted.
+}
+
+func newMounts() *mounts { return &mounts{reread: time.Second} }
+
+// path returns the path for the device and path relative to the root of the
+// filesystem, and false if the filesystem isn't mounted.
+func (m *mounts) path(dev uint32, fsPath string) (string, bool) {
+	if p, ok := m.lookup(dev, fsPath); ok {
+		return p, true
+	}
+	// Mounted after the last time mountinfo was read?
+	if time.Since(m.read) < m.reread {
+		return fsPath, false
+	}
+	m.load()
+	if p, ok := m.lookup(dev, fsPath); ok {
+		return p, true
+	}
+	return fsPath, false
+}
+
+// lookup finds the mount with the longest root that contains the path; if a
+// filesystem is mounted more than once, the first mount is used.
+func (m *mounts) lookup(dev uint32, fsPath string) (string, bool) {
+	var (
+		best  mount
+		found bool
+	)
+	for _, mnt := range m.byDev[dev] {
+		if (!found || len(mnt.root) > len(best.root)) && within(fsPath, mnt.root) {
+			best, found = mnt, true
+		}
+	}
+	if !found {
+		return "", false
+	}
+	rel := fsPath
+	if best.root != "/" {
+		rel = strings.TrimPrefix(fsPath, best.root)
+	}
+	if rel = strings.TrimPrefix(rel, "/"); rel == "" {
+		return best.point, true
+	}
+	return filepath.Join(best.point, rel), true
+}
+
+func within(path, root string) bool {
+	return root == "/" || path == root || strings.HasPrefix(path, root+"/")
+}
+
+func (m *mounts) load() {
+	m.read = time.Now()
+	fp, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return
+	}
+	defer fp.Close()
+	m.byDev = parseMountinfo(fp)
+}
+
+// parseMountinfo parses the format described in proc(5):
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountinfo(r io.Reader) map[uint32][]mount {
+	byDev := make(map[uint32][]mount)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 5 {
+			continue
+		}
+		i := strings.IndexByte(f[2], ':')
+		if i < 0 {
+			continue
+		}
+		major, err1 := strconv.ParseUint(f[2][:i], 10, 32)
+		minor, err2 := strconv.ParseUint(f[2][i+1:], 10, 32)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// The kernel encoding of dev_t, as in s_dev; not the one in st_dev.
+		dev := uint32(major<<20 | minor)
+		byDev[dev] = append(byDev[dev], mount{root: unescape(f[3]), point: unescape(f[4])})
+	}
+	return byDev
+}
+
+// unescape the octal escapes for spaces, tabs, newlines, and backslashes.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package ebpf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMounts(t *testing.T) {
+	m := newMounts()
+	m.byDev = parseMountinfo(strings.NewReader(strings.Join([]string{
+		`22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw`,
+		`23 22 8:2 / /home rw,relatime shared:2 - ext4 /dev/sda2 rw`,
+		`24 22 8:2 /user/data /srv/with\040space rw,relatime shared:2 - ext4 /dev/sda2 rw`,
+		`25 22 0:42 / /tmp rw,nosuid shared:3 - tmpfs tmpfs rw`,
+		`garbage`,
+	}, "\n")))
+	m.reread = 1 << 62 // Never read the real mountinfo.
+
+	dev := func(major, minor uint32) uint32 { return major<<20 | minor }
+	tests := []struct {
+		dev     uint32
+		in      string
+		want    string
+		mounted bool
+	}{
+		{dev(8, 1), "/etc/passwd", "/etc/passwd", true},
+		{dev(8, 1), "/", "/", true},
+		{dev(8, 2), "/user/file", "/home/user/file", true},
+		{dev(8, 2), "/user/data", "/srv/with space", true},
+		{dev(8, 2), "/user/data/x/y", "/srv/with space/x/y", true},
+		{dev(8, 2), "/user/datafile", "/home/user/datafile", true},
+		{dev(0, 42), "/a", "/tmp/a", true},
+		{dev(0, 43), "/a", "/a", false},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have, mounted := m.path(tt.dev, tt.in)
+			if have != tt.want || mounted != tt.mounted {
+				t.Errorf("path(%d:%d, %q)\nhave: %q %t\nwant: %q %t",
+					tt.dev>>20, tt.dev&(1<<20-1), tt.in, have, mounted, tt.want, tt.mounted)
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{``, ``},
+		{`/a/b`, `/a/b`},
+		{`/a\040b`, `/a b`},
+		{`/a\011\012\134`, "/a\t\n\\"},
+		{`/a\04`, `/a\04`},
+		{`/a\xyz`, `/a\xyz`},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			if have := unescape(tt.in); have != tt.want {
+				t.Errorf("unescape(%q)\nhave: %q\nwant: %q", tt.in, have, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package ebpf
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Layout of the records the programs write to the ring buffer; all fields are
+// in host byte order.
+const (
+	recOp    = 0  // uint32: IN_* flags.
+	recPID   = 4  // uint32: thread group ID.
+	recUID   = 8  // uint32
+	recDev   = 12 // uint32: st_dev of the filesystem, in the kernel encoding.
+	recComm  = 16 // [16]byte
+	recDepth = 32 // uint32: number of names; maxDepth+1 if the path is deeper.
+	recMode  = 36 // uint16: i_mode of the inode, or 0.
+	recNames = 40 // [maxDepth][nameSize]byte: path elements, from the file up.
+
+	maxDepth = 32
+	nameSize = 256 // NAME_MAX + NUL.
+	recSize  = recNames + maxDepth*nameSize
+)
+
+// FMODE_CREATED from linux/fs.h; set on the file if open() created it. It's a
+// #define, so it's not in the BTF.
+const fmodeCreated = 1 << 20
+
+// Stack slots.
+const (
+	stackTmp    = -8  // Scratch for probe reads.
+	stackDentry = -16 // Dentry of the current record.
+	stackParent = -24 // Parent of the dentry in the path walk.
+	stackName   = -32 // Name pointer in the path walk.
+	stackOld    = -40 // Old dentry, for renames.
+	stackNew    = -48 // New dentry, for renames.
+)
+
+// offsets are the struct member offsets the programs need, from the BTF.
+type offsets struct {
+	dParent, dName, dSB, dInode uint32
+	qstrName                    uint32
+	sbDev                       uint32
+	iMode                       uint32
+	pathDentry                  uint32
+	fileMode                    uint32
+	rdOldDentry, rdNewDentry    uint32
+}
+
+func kernelOffsets(b *btf) (offsets, error) {
+	var (
+		o   offsets
+		err error
+	)
+	get := func(dst *uint32, strct, member string) {
+		if err == nil {
+			*dst, err = b.offset(strct, member)
+		}
+	}
+	get(&o.dParent, "dentry", "d_parent")
+	get(&o.dName, "dentry", "d_name")
+	get(&o.dSB, "dentry", "d_sb")
+	get(&o.dInode, "dentry", "d_inode")
+	get(&o.qstrName, "qstr", "name")
+	get(&o.sbDev, "super_block", "s_dev")
+	get(&o.iMode, "inode", "i_mode")
+	get(&o.pathDentry, "path", "dentry")
+	get(&o.fileMode, "file", "f_mode")
+	if err != nil {
+		return o, err
+	}
+	// struct renamedata is only used since Linux 5.12.
+	o.rdOldDentry, _ = b.offset("renamedata", "old_dentry")
+	o.rdNewDentry, _ = b.offset("renamedata", "new_dentry")
+	return o, nil
+}
+
+// hook is a kernel function that a program is attached to.
+type hook struct {
+	fn     string
+	op     uint32 // IN_* flags for the event.
+	entry  bool   // Attach at the entry rather than the exit.
+	rename bool   // vfs_rename: send IN_MOVED_FROM and IN_MOVED_TO.
+	open   bool   // vfs_open: only send if the file was created.
+}
+
+var hooks = []hook{
+	{fn: "vfs_create", op: unix.IN_CREATE},
+	{fn: "vfs_mknod", op: unix.IN_CREATE},
+	{fn: "vfs_symlink", op: unix.IN_CREATE},
+	{fn: "vfs_link", op: unix.IN_CREATE},
+	{fn: "vfs_mkdir", op: unix.IN_CREATE | unix.IN_ISDIR},
+	{fn: "vfs_open", op: unix.IN_CREATE, open: true},
+	{fn: "vfs_unlink", op: unix.IN_DELETE},
+	{fn: "vfs_rmdir", op: unix.IN_DELETE | unix.IN_ISDIR},
+	{fn: "vfs_rename", op: unix.IN_MOVED_FROM, entry: true, rename: true},
+}
+
+// program builds the program for the hook.
+//
+// Renames are sent from the entry of vfs_rename, as the dentries have the new
+// names afterwards; everything else from the exit, and only if the function
+// returned success.
+func (h hook) program(b *btf, o offsets, ringbuf int) (*asm, uint32, error) {
+	params, id, err := b.params(h.fn)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	a := newAsm()
+	a.mov(r6, r1) // Context: the arguments as an array of uint64, and the return value after them.
+	done := a.label("done")
+
+	switch {
+	case h.open:
+		if len(params) < 2 || b.pointee(params[0].typ) != "path" || b.pointee(params[1].typ) != "file" {
+			return nil, 0, fmt.Errorf("unexpected signature for %s", h.fn)
+		}
+		h.checkReturn(a, b, id, len(params), done)
+		a.ldx(sizeDW, r7, r6, 8)
+		a.probeRead(stackTmp, 4, r7, int32(o.fileMode))
+		a.ldx(sizeW, r1, r10, stackTmp)
+		a.andImm(r1, fmodeCreated)
+		a.jeqImm(r1, 0, done)
+		a.ldx(sizeDW, r7, r6, 0)
+		a.probeRead(stackDentry, 8, r7, int32(o.pathDentry))
+		h.record(a, o, ringbuf, h.op, stackDentry, stackDentry, done)
+
+	case h.rename:
+		if err := h.renameDentries(a, b, o, params); err != nil {
+			return nil, 0, err
+		}
+		h.record(a, o, ringbuf, unix.IN_MOVED_FROM, stackOld, stackOld, done)
+		h.record(a, o, ringbuf, unix.IN_MOVED_TO, stackNew, stackOld, done)
+
+	default:
+		// The last dentry parameter is the one that was created or removed;
+		// vfs_link also has the dentry of the existing file first.
+		arg := -1
+		for i, p := range params {
+			if b.pointee(p.typ) == "dentry" {
+				arg = i
+			}
+		}
+		if arg == -1 {
+			return nil, 0, fmt.Errorf("no dentry parameter for %s", h.fn)
+		}
+		a.ldx(sizeDW, r1, r6, int16(8*arg))
+		a.stx(sizeDW, r10, stackDentry, r1)
+		h.checkReturn(a, b, id, len(params), done)
+
+		mode := int16(stackDentry)
+		if h.op&unix.IN_DELETE != 0 {
+			mode = 0 // The dentry is negative after it's removed.
+		}
+		h.record(a, o, ringbuf, h.op, stackDentry, mode, done)
+	}
+
+	a.mark(done)
+	a.movImm(r0, 0)
+	a.exit()
+	return a, id, nil
+}
+
+// checkReturn jumps to done if the function returned an error. Functions that
+// return a dentry rather than an int (vfs_mkdir since Linux 6.15) may return a
+// different one than was passed, which is stored as the dentry for the record.
+func (h hook) checkReturn(a *asm, b *btf, id uint32, nparams int, done string) {
+	if h.entry {
+		return
+	}
+	a.ldx(sizeDW, r1, r6, int16(8*nparams))
+	if b.skip(b.types[b.types[id].typ].typ).kind != btfKindPtr {
+		a.lshImm(r1, 32) // int: ignore the upper 32 bits.
+		a.jneImm(r1, 0, done)
+		return
+	}
+	a.jgtImm(r1, -4096, done) // ERR_PTR
+	same := a.label("same")
+	a.jeqImm(r1, 0, same)
+	a.stx(sizeDW, r10, stackDentry, r1)
+	a.mark(same)
+}
+
+// renameDentries stores the old and new dentries for vfs_rename, which takes
+// a struct renamedata since Linux 5.12, and the dentries as parameters before
+// that.
+func (h hook) renameDentries(a *asm, b *btf, o offsets, params []btfMember) error {
+	if len(params) > 0 && b.pointee(params[0].typ) == "renamedata" {
+		a.ldx(sizeDW, r7, r6, 0)
+		a.probeRead(stackOld, 8, r7, int32(o.rdOldDentry))
+		a.probeRead(stackNew, 8, r7, int32(o.rdNewDentry))
+		return nil
+	}
+	var dentries []int
+	for i, p := range params {
+		if b.pointee(p.typ) == "dentry" {
+			dentries = append(dentries, i)
+		}
+	}
+	if len(dentries) < 2 {
+		return fmt.Errorf("unexpected signature for %s", h.fn)
+	}
+	a.ldx(sizeDW, r1, r6, int16(8*dentries[0]))
+	a.stx(sizeDW, r10, stackOld, r1)
+	a.ldx(sizeDW, r1, r6, int16(8*dentries[1]))
+	a.stx(sizeDW, r10, stackNew, r1)
+	return nil
+}
+
+// record emits the code to send a record for the dentry stored at the stack
+// offset dentry, with the mode of the dentry at the offset mode (or 0 to not
+// read it).
+func (h hook) record(a *asm, o offsets, ringbuf int, op uint32, dentry, mode int16, done string) {
+	a.loadMap(r1, ringbuf)
+	a.movImm(r2, recSize)
+	a.movImm(r3, 0)
+	a.call(fnRingbufReserve)
+	a.jeqImm(r0, 0, done) // Ring buffer is full; the event is lost.
+	a.mov(r8, r0)
+
+	a.st(sizeW, r8, recOp, int32(op))
+	a.call(fnGetCurrentPidTgid)
+	a.rshImm(r0, 32)
+	a.stx(sizeW, r8, recPID, r0)
+	a.call(fnGetCurrentUIDGid)
+	a.stx(sizeW, r8, recUID, r0)
+	a.mov(r1, r8)
+	a.addImm(r1, recComm)
+	a.movImm(r2, 16)
+	a.call(fnGetCurrentComm)
+
+	// Device: dentry->d_sb->s_dev
+	a.ldx(sizeDW, r7, r10, dentry)
+	a.st(sizeW, r8, recDev, 0)
+	a.probeRead(stackTmp, 8, r7, int32(o.dSB))
+	a.ldx(sizeDW, r3, r10, stackTmp)
+	noDev := a.label("nodev")
+	a.jeqImm(r3, 0, noDev)
+	a.addImm(r3, int32(o.sbDev))
+	a.mov(r1, r8)
+	a.addImm(r1, recDev)
+	a.movImm(r2, 4)
+	a.call(fnProbeReadKernel)
+	a.mark(noDev)
+
+	// Mode: dentry->d_inode->i_mode
+	a.st(sizeW, r8, recMode, 0)
+	if mode != 0 {
+		a.ldx(sizeDW, r7, r10, mode)
+		a.probeRead(stackTmp, 8, r7, int32(o.dInode))
+		a.ldx(sizeDW, r3, r10, stackTmp)
+		noMode := a.label("nomode")
+		a.jeqImm(r3, 0, noMode)
+		a.addImm(r3, int32(o.iMode))
+		a.mov(r1, r8)
+		a.addImm(r1, recMode)
+		a.movImm(r2, 2)
+		a.call(fnProbeReadKernel)
+		a.mark(noMode)
+	}
+
+	// Walk up the parents until the root of the filesystem, whose parent is
+	// itself. This is unrolled, as older kernels don't allow loops.
+	a.ldx(sizeDW, r7, r10, dentry)
+	submit := a.label("submit")
+	for i := 0; i < maxDepth; i++ {
+		depth := a.label("depth")
+		a.probeRead(stackParent, 8, r7, int32(o.dParent))
+		a.ldx(sizeDW, r9, r10, stackParent)
+		a.jeqImm(r9, 0, depth)
+		a.jeq(r9, r7, depth)
+
+		a.probeRead(stackName, 8, r7, int32(o.dName+o.qstrName))
+		a.ldx(sizeDW, r3, r10, stackName)
+		a.mov(r1, r8)
+		a.addImm(r1, int32(recNames+i*nameSize))
+		a.movImm(r2, nameSize)
+		a.call(fnProbeReadKernelStr)
+		a.mov(r7, r9)
+		next := a.label("next")
+		a.ja(next)
+
+		a.mark(depth)
+		a.st(sizeW, r8, recDepth, int32(i))
+		a.ja(submit)
+		a.mark(next)
+	}
+	// Deeper than maxDepth, unless this is the root.
+	a.st(sizeW, r8, recDepth, maxDepth+1)
+	a.probeRead(stackParent, 8, r7, int32(o.dParent))
+	a.ldx(sizeDW, r9, r10, stackParent)
+	root := a.label("root")
+	a.jeq(r9, r7, root)
+	a.ja(submit)
+	a.mark(root)
+	a.st(sizeW, r8, recDepth, maxDepth)
+
+	a.mark(submit)
+	a.mov(r1, r8)
+	a.movImm(r2, 0)
+	a.call(fnRingbufSubmit)
+}
@@ -0,0 +1,90 @@
+package ebpf
+
+import (
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Bits in the length of a ring buffer record header, from linux/bpf.h.
+const (
+	ringbufBusy    = 1 << 31
+	ringbufDiscard = 1 << 30
+	ringbufHdrSize = 8
+)
+
+// ringbuf reads records from a BPF_MAP_TYPE_RINGBUF map.
+//
+// The map is mapped as a consumer page, which we write the consumer position
+// to, followed by a producer page and the data, which is mapped twice so that
+// records that wrap around can be read as one slice.
+type ringbuf struct {
+	fd       int
+	size     int
+	consumer []byte
+	producer []byte
+	data     []byte
+}
+
+func openRingbuf(fd, size int) (*ringbuf, error) {
+	page := os.Getpagesize()
+	consumer, err := unix.Mmap(fd, 0, page, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	producer, err := unix.Mmap(fd, int64(page), page+2*size, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		unix.Munmap(consumer)
+		return nil, err
+	}
+	return &ringbuf{
+		fd:       fd,
+		size:     size,
+		consumer: consumer,
+		producer: producer,
+		data:     producer[page:],
+	}, nil
+}
+
+func (r *ringbuf) pos(b []byte) *uint64 { return (*uint64)(unsafe.Pointer(&b[0])) }
+
+// read calls fn for all records that are available, and returns the number of
+// records. The record is only valid until fn returns. It stops early if fn
+// returns false.
+func (r *ringbuf) read(fn func([]byte) bool) int {
+	var (
+		cons = atomic.LoadUint64(r.pos(r.consumer))
+		prod = atomic.LoadUint64(r.pos(r.producer))
+		mask = uint64(r.size - 1)
+		n    int
+	)
+	for cons < prod {
+		hdr := (*uint32)(unsafe.Pointer(&r.data[cons&mask]))
+		l := atomic.LoadUint32(hdr)
+		if l&ringbufBusy != 0 {
+			break // Reserved but not yet submitted.
+		}
+		size := l &^ (ringbufBusy | ringbufDiscard)
+		start := (cons + ringbufHdrSize) & mask
+		next := cons + (uint64(size)+ringbufHdrSize+7)&^7
+
+		ok := true
+		if l&ringbufDiscard == 0 {
+			n++
+			ok = fn(r.data[start : start+uint64(size)])
+		}
+		cons = next
+		atomic.StoreUint64(r.pos(r.consumer), cons)
+		if !ok {
+			break
+		}
+	}
+	return n
+}
+
+func (r *ringbuf) close() {
+	unix.Munmap(r.consumer)
+	unix.Munmap(r.producer)
+}
@@ -0,0 +1,178 @@
+package fsnotify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FollowRotation keeps watching a file added with [Watcher.AddFile] if it's
+// replaced by a new file, like "tail -F". This is a no-op for
+// [Watcher.AddWith].
+//
+// The parent directory is watched instead of the file itself, and a [Rotated]
+// event is sent instead of a Create when a new file appears at the path (e.g.
+// after logrotate renamed the old file, or an editor replaced it). The Remove or
+// Rename for the old file is still sent as usual.
+func FollowRotation() addOpt {
+	return func(opt *withOpts) { opt.followRotation = true }
+}
+
+// AddFile starts monitoring a single file.
+//
+// Without any options this is the same as [Watcher.AddWith], except that it
+// returns an error if path is a directory. With [FollowRotation] the watch
+// survives the file being replaced; only events for the file are sent, unless
+// the parent directory was already added with Add.
+//
+// Use [Watcher.RemoveFile] to stop monitoring the file.
+func (w *Watcher) AddFile(path string, opts ...addOpt) error {
+	path = filepath.Clean(w.casePath(path))
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		return fmt.Errorf("fsnotify.AddFile: %q is a directory, not a file", path)
+	}
+
+	with := getOptions(opts...)
+	if !with.followRotation {
+		return w.AddWith(path, opts...)
+	}
+
+	dev, ino, err := fileID(path, st)
+	if err != nil {
+		return fmt.Errorf("fsnotify.AddFile: %w", err)
+	}
+	add, err := w.files.add(path, dev, ino, w.WatchList())
+	if err != nil || !add {
+		return err
+	}
+	err = w.AddWith(filepath.Dir(path), opts...)
+	if err != nil {
+		w.files.remove(path)
+	}
+	return err
+}
+
+// RemoveFile stops monitoring a file added with [Watcher.AddFile].
+func (w *Watcher) RemoveFile(path string) error {
+	path = filepath.Clean(w.casePath(path))
+	tracked, rmDir := w.files.remove(path)
+	if !tracked {
+		return w.Remove(path)
+	}
+	if rmDir {
+		return w.Remove(filepath.Dir(path))
+	}
+	return nil
+}
+
+// fileWatches keeps track of files added with AddFile and FollowRotation.
+type fileWatches struct {
+	mu    sync.Mutex
+	files map[string]*watchedFile // path → file
+	dirs  map[string]int          // Directories added by AddFile → number of files.
+}
+
+type watchedFile struct {
+	dev, ino uint64
+	gone     bool // Removed or renamed; next Create is a rotation.
+}
+
+func newFileWatches() *fileWatches {
+	return &fileWatches{
+		files: make(map[string]*watchedFile),
+		dirs:  make(map[string]int),
+	}
+}
+
+// add the file, returning true if the parent directory needs to be added.
+func (f *fileWatches) add(path string, dev, ino uint64, watchList []string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.files[path]; ok {
+		return false, nil
+	}
+	f.files[path] = &watchedFile{dev: dev, ino: ino}
+
+	dir := filepath.Dir(path)
+	if f.dirs[dir] > 0 {
+		f.dirs[dir]++
+		return false, nil
+	}
+	for _, p := range watchList {
+		if p == dir { // Added by the user; don't filter it.
+			return false, nil
+		}
+	}
+	f.dirs[dir] = 1
+	return true, nil
+}
+
+// remove the file, returning if it was tracked and if the parent directory
+// should be removed.
+func (f *fileWatches) remove(path string) (tracked, rmDir bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.files[path]; !ok {
+		return false, false
+	}
+	delete(f.files, path)
+
+	dir := filepath.Dir(path)
+	if n, ok := f.dirs[dir]; ok {
+		if n > 1 {
+			f.dirs[dir]--
+			return true, false
+		}
+		delete(f.dirs, dir)
+		return true, true
+	}
+	return true, false
+}
+
+// filter an event from the backend: events for other files in directories
+// added by AddFile are dropped, and a Create for a rotated file is replaced
+// with Rotated.
+func (f *fileWatches) filter(e Event) (Event, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.files) == 0 {
+		return e, true
+	}
+
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	wf, ok := f.files[name]
+	if !ok {
+		_, filesOnly := f.dirs[filepath.Dir(name)]
+		return e, !filesOnly
+	}
+
+	if e.Op&(opRemove|opRename) != 0 {
+		wf.gone = true
+		return e, true
+	}
+	if e.Op&opCreate != 0 {
+		st, err := os.Stat(name)
+		if err != nil {
+			return e, true
+		}
+		dev, ino, err := fileID(name, st)
+		if err != nil {
+			return e, true
+		}
+		if wf.gone || dev != wf.dev || ino != wf.ino {
+			wf.dev, wf.ino, wf.gone = dev, ino, false
+			e.Op = Rotated
+		}
+	}
+	return e, true
+}
@@ -0,0 +1,76 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("directory", func(t *testing.T) {
+		t.Parallel()
+
+		w := newWatcher(t)
+		defer w.Close()
+		if err := w.AddFile(t.TempDir()); err == nil {
+			t.Fatal("err is nil")
+		}
+	})
+
+	t.Run("follow rotation", func(t *testing.T) {
+		t.Parallel()
+
+		tmp := t.TempDir()
+		file := join(tmp, "log")
+		touch(t, file, noWait)
+
+		w := newWatcher(t)
+		defer w.Close()
+		if err := w.AddFile(file, FollowRotation()); err != nil {
+			t.Fatal(err)
+		}
+
+		next := func() Event {
+			t.Helper()
+			select {
+			case e := <-w.Events:
+				return e
+			case err := <-w.Errors:
+				t.Fatal(err)
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout")
+			}
+			return Event{}
+		}
+
+		touch(t, tmp, "other", noWait) // Not sent.
+		eventSeparator()
+		mv(t, file, tmp, "log.1", noWait)
+		eventSeparator()
+		cat(t, "data", file)
+
+		if e := next(); e.Name != file || e.Op&opRename == 0 {
+			t.Fatalf("want rename for %q, have %s", file, e)
+		}
+		if e := next(); e.Name != file || !e.Has(Rotated) {
+			t.Fatalf("want rotated for %q, have %s", file, e)
+		}
+		for {
+			e := next()
+			if e.Name != file || e.Has(Rotated) {
+				t.Fatalf("unexpected event: %s", e)
+			}
+			if e.Op&opWrite != 0 {
+				break
+			}
+		}
+
+		if err := w.RemoveFile(file); err != nil {
+			t.Fatal(err)
+		}
+		if l := w.WatchList(); len(l) != 0 {
+			t.Errorf("WatchList not empty: %v", l)
+		}
+	})
+}
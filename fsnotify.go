@@ -10,10 +10,15 @@
 package fsnotify
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hash"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Event represents a file system notification.
@@ -30,6 +35,22 @@ type Event struct {
 	// This is a bitmask and some systems may send multiple operations at once.
 	// Use the Event.Has() method instead of comparing with ==.
 	Op Op
+
+	// Root is the path passed to Add() that this event was matched to, and
+	// Name is relative to it. This is only set with [WithRootRelativeNames].
+	Root string
+
+	// Change describes what changed in the file for Write events. This is only
+	// set with [WithContentDiff].
+	Change *ContentChange
+
+	// RawName is the Name as the system reported it, if it was changed by
+	// [WithNameEncoding] or [WithUnicodeNorm].
+	RawName string
+
+	// Attrs describes which attributes changed for Chmod and Xattr events.
+	// This is only set with [WithAttrChanges].
+	Attrs AttrChange
 }
 
 // Op describes a set of file operations.
@@ -55,6 +76,11 @@ const (
 	// The path was removed; any watches on it will be removed. Some "remove"
 	// operations may trigger a Rename if the file is actually moved (for
 	// example "remove to trash" is often a rename).
+	//
+	// When a directory is removed or moved away, a Remove is sent first for
+	// every path below it that there was an event for (or that was found by
+	// [WithReconcile]) and that wasn't removed yet, deepest paths first, so
+	// that consumers that keep a copy of the tree don't keep those paths.
 	Remove
 
 	// The path was renamed to something else; any watched on it will be
@@ -105,8 +131,106 @@ const (
 	IN_ONLYDIR       = 0x1000000
 	IN_OPEN          = 0x20
 	IN_Q_OVERFLOW    = 0x4000
+	IN_UNMOUNT       = 0x2000
+)
+
+// Operations that fsnotify synthesizes; these use bits that are never set by
+// inotify.
+const (
+	// A watched symlink was pointed at a new target, and the watch now
+	// follows the new target; see [WithRetarget].
+	Retargeted Op = 0x100000
+
+	// A file added with AddFile and [FollowRotation] was replaced by a new
+	// file.
+	Rotated Op = 0x200000
+
+	// The last event from a watcher that was replaced with
+	// [Watcher.Handover]; the Name is empty. Events are sent on the new
+	// watcher after this.
+	Handover Op = 0x400000
+
+	// The filesystem of a watched path was unmounted, or the volume or
+	// network share it's on was removed; the watch is removed, and no more
+	// events are sent for it. On illumos this is also sent if another
+	// filesystem is mounted over the path. On Linux IN_UNMOUNT is set as well.
+	Unmount Op = 0x8000000
+
+	// A watch added with [WithTTL] was removed because the TTL passed; the
+	// Name is the path passed to AddWith.
+	Expired Op = 0x10000
+
+	// The kernel removed the watch while the path was still watched, and it
+	// couldn't be added again; the Name is the path of the watch. This is
+	// preceded by a [WatchError] with the reason, unless the path no longer
+	// exists. This is only sent on Linux, for IN_IGNORED without
+	// IN_DELETE_SELF or IN_UNMOUNT.
+	WatchRemoved Op = 0x1000
 )
 
+// Operations that are only sent for watches added with [WithOps], on the
+// platforms listed by [SupportedOps]. These use bits that are never set by
+// inotify; on Linux the IN_OPEN, IN_ACCESS, or IN_CLOSE_* bits are set as well.
+const (
+	// The file or directory was opened.
+	Open Op = 0x20000
+
+	// The file was read from, or a directory was listed.
+	Read Op = 0x40000
+
+	// The file or directory was closed, after it was opened for reading or
+	// writing.
+	Close Op = 0x80000
+
+	// The extended attributes (or ACLs) of the file changed. This is sent
+	// instead of Chmod if the mode, owner, and modification time of the file
+	// didn't change; see [WithAttrChanges] for the details.
+	Xattr Op = 0x800000
+)
+
+// SupportedOps returns the operations that can be enabled with [WithOps] on the
+// current platform: Open, Read, and Close on Linux and FreeBSD, and Xattr on
+// all platforms except Windows.
+func SupportedOps() Op { return supportedOps }
+
+// Supported returns which operations can be sent on the current platform; for
+// example Supported()[Close] is true on Linux and FreeBSD. Operations that need
+// [WithOps] are included if they can be enabled.
+func Supported() map[Op]bool {
+	m := make(map[Op]bool)
+	for _, op := range []Op{Create, Write, Remove, Rename, Chmod, Open, Read, Close, Xattr,
+		Retargeted, Rotated, Handover, Unmount, Expired, WatchRemoved} {
+		m[op] = sentOps.Has(op)
+	}
+	return m
+}
+
+// Supports reports if events with all the operations in op can be sent on the
+// current platform, so portable code can check for them rather than use build
+// tags. Operations that need [WithOps] are only sent if they're enabled.
+func (w *Watcher) Supports(op Op) bool { return sentOps.Has(op) }
+
+// NativeOps converts the portable operations Create, Write, Remove, Rename, and
+// Chmod in ops to the Op bits the backend sets on events, and leaves the other
+// operations as they are. On Linux the Op is the raw inotify mask, so Create is
+// IN_CREATE|IN_MOVED_TO (and Create itself is IN_ACCESS); elsewhere this
+// returns ops unchanged. To check an event for any of the operations:
+//
+//	if e.Op&fsnotify.NativeOps(fsnotify.Create|fsnotify.Write) != 0 {
+//		...
+//	}
+func NativeOps(ops Op) Op {
+	n := ops &^ (Create | Write | Remove | Rename | Chmod)
+	for _, m := range []struct{ op, native Op }{
+		{Create, opCreate}, {Write, opWrite}, {Remove, opRemove}, {Rename, opRename}, {Chmod, opChmod},
+	} {
+		if ops&m.op != 0 {
+			n |= m.native
+		}
+	}
+	return n
+}
+
 // Common errors that can be reported.
 var (
 	ErrNonExistentWatch = errors.New("fsnotify: can't remove non-existent watcher")
@@ -114,6 +238,129 @@ var (
 	ErrClosed           = errors.New("fsnotify: watcher already closed")
 )
 
+// ErrUnsupported is returned by [Watcher.AddWith] if an option isn't supported
+// by the backend for the current platform, rather than silently ignoring it.
+// Use [errors.As] to check for it:
+//
+//	var unsup fsnotify.ErrUnsupported
+//	if errors.As(err, &unsup) {
+//		// Fall back to something else.
+//	}
+type ErrUnsupported struct {
+	Feature string // Option that isn't supported, e.g. "WithRetarget".
+	Backend string // Name of the backend: "inotify", "kqueue", "windows", or "fen".
+}
+
+func (e ErrUnsupported) Error() string {
+	return fmt.Sprintf("fsnotify: %s is not supported by the %s backend", e.Feature, e.Backend)
+}
+
+// checkPlatformOpts returns ErrUnsupported for options that only work on one
+// backend. watcher is set for the options passed to NewWatcherWith, and unset
+// for AddWith.
+func checkPlatformOpts(with withOpts, backend string, watcher bool) error {
+	switch {
+	case watcher && with.shared && backend != "inotify":
+		return ErrUnsupported{Feature: "WithSharedInstance", Backend: backend}
+	case watcher && with.ioUring && backend != "inotify":
+		return ErrUnsupported{Feature: "WithIOUring", Backend: backend}
+	case watcher && with.filePolling > 0 && backend != "kqueue":
+		return ErrUnsupported{Feature: "WithFilePolling", Backend: backend}
+	case !watcher && with.windowsFilters != 0 && backend != "windows":
+		return ErrUnsupported{Feature: "WithWindowsFilters", Backend: backend}
+	}
+	return nil
+}
+
+// WatchError is sent on the Errors channel for errors that happened for a
+// specific path. Use [errors.As] to check for it; errors.Is works for the
+// underlying error:
+//
+//	var werr fsnotify.WatchError
+//	if errors.As(err, &werr) && !werr.Alive {
+//		// Add werr.Path again, or give up on it.
+//	}
+type WatchError struct {
+	Path string // Path of the watch or the file in it.
+	Op   string // What was being done: "add", "remove", "read", or "retarget".
+	Err  error  // Underlying error; usually a syscall.Errno or *os.SyscallError.
+
+	// The watch is still active. If this is false events for Path are no
+	// longer sent, and it needs to be added again if it still exists.
+	Alive bool
+}
+
+func (e WatchError) Error() string {
+	return fmt.Sprintf("fsnotify: %s %q: %s", e.Op, e.Path, e.Err)
+}
+
+func (e WatchError) Unwrap() error { return e.Err }
+
+// ErrTooManyWatches is returned by [Watcher.Add] if the system limit on the
+// number of watches was reached: inotify's max_user_watches on Linux, or the
+// limit on open files for kqueue. Use [errors.As] to check for it; errors.Is
+// works for the underlying error (ENOSPC or EMFILE).
+type ErrTooManyWatches struct {
+	Path    string // Path that couldn't be added.
+	Watches int    // Number of watches in use by this Watcher.
+	Limit   int    // System limit, or 0 if it couldn't be read.
+	Setting string // How to raise the limit, e.g. "fs.inotify.max_user_watches".
+	Err     error  // Underlying error.
+}
+
+func (e ErrTooManyWatches) Error() string {
+	limit := "unknown"
+	if e.Limit > 0 {
+		limit = strconv.Itoa(e.Limit)
+	}
+	return fmt.Sprintf("fsnotify: too many watches adding %q (%d in use, limit %s); raise %s",
+		e.Path, e.Watches, limit, e.Setting)
+}
+
+func (e ErrTooManyWatches) Unwrap() error { return e.Err }
+
+// ErrNotDirectory is returned by [Watcher.AddWith] with [WithOnlyDir] if the
+// path isn't a directory. Use [errors.As] to check for it.
+type ErrNotDirectory struct {
+	Path string
+}
+
+func (e ErrNotDirectory) Error() string {
+	return fmt.Sprintf("fsnotify: %q is not a directory", e.Path)
+}
+
+// Interface is implemented by [Watcher], and can be used to accept any watcher
+// implementation; for example the fake Watcher in the fsnotifytest package in
+// tests:
+//
+//	func watchConfig(w fsnotify.Interface, path string) error {
+//		if err := w.Add(path); err != nil {
+//			return err
+//		}
+//		for e := range w.EventChan() {
+//			...
+//		}
+//	}
+//
+// The Events and Errors fields of a Watcher can't be part of an interface, so
+// they're available as the EventChan and ErrorChan methods.
+type Interface interface {
+	Add(name string) error
+	Remove(name string) error
+	WatchList() []string
+	Close() error
+	EventChan() <-chan Event
+	ErrorChan() <-chan error
+}
+
+var _ Interface = (*Watcher)(nil)
+
+// EventChan returns the [Watcher.Events] channel, for [Interface].
+func (w *Watcher) EventChan() <-chan Event { return w.Events }
+
+// ErrorChan returns the [Watcher.Errors] channel, for [Interface].
+func (w *Watcher) ErrorChan() <-chan error { return w.Errors }
+
 func (o Op) String() string {
 	var b strings.Builder
 	if o.Has(IN_ACCESS) {
@@ -161,10 +408,43 @@ func (o Op) String() string {
 	if o.Has(IN_OPEN) {
 		b.WriteString("|IN_OPEN")
 	}
+	if o.Has(IN_UNMOUNT) {
+		b.WriteString("|IN_UNMOUNT")
+	}
 	// ----------
 	if o.Has(IN_DONT_FOLLOW) {
 		b.WriteString("|IN_DONT_FOLLOW")
 	}
+	if o.Has(Retargeted) {
+		b.WriteString("|RETARGETED")
+	}
+	if o.Has(Rotated) {
+		b.WriteString("|ROTATED")
+	}
+	if o.Has(Handover) {
+		b.WriteString("|HANDOVER")
+	}
+	if o.Has(Unmount) {
+		b.WriteString("|UNMOUNT")
+	}
+	if o.Has(Expired) {
+		b.WriteString("|EXPIRED")
+	}
+	if o.Has(WatchRemoved) {
+		b.WriteString("|WATCH_REMOVED")
+	}
+	if o.Has(Open) {
+		b.WriteString("|OPEN")
+	}
+	if o.Has(Read) {
+		b.WriteString("|READ")
+	}
+	if o.Has(Close) {
+		b.WriteString("|CLOSE")
+	}
+	if o.Has(Xattr) {
+		b.WriteString("|XATTR")
+	}
 	// --------
 	// if o.Has(Create) {
 	// 	b.WriteString("|CREATE")
@@ -193,6 +473,27 @@ func (o Op) Has(h Op) bool { return o&h == h }
 // Has reports if this event has the given operation.
 func (e Event) Has(op Op) bool { return e.Op.Has(op) }
 
+// IsDir reports if the event is for a directory, rather than a file.
+//
+// This is the IN_ISDIR flag, which inotify sets on Linux; on other platforms
+// it's set from what the backend knows about the path, and new paths are
+// checked when the Create event is sent. For Remove and Rename the path no
+// longer exists, so this is only known if the directory was watched or there
+// was an earlier event for it.
+func (e Event) IsDir() bool { return e.Op&IN_ISDIR != 0 }
+
+// statDir sets IN_ISDIR if the path is a directory, for backends that don't
+// know if a new path is a directory.
+func statDir(e Event) Event {
+	if e.Op&IN_ISDIR != 0 {
+		return e
+	}
+	if fi, err := os.Lstat(e.Name); err == nil && fi.IsDir() {
+		e.Op |= IN_ISDIR
+	}
+	return e
+}
+
 // String returns a string representation of the event with their path.
 func (e Event) String() string {
 	// return fmt.Sprintf("%-13s %q %+v", e.Op.String(), e.Name, e.Op)
@@ -202,12 +503,51 @@ func (e Event) String() string {
 type (
 	addOpt   func(opt *withOpts)
 	withOpts struct {
-		bufsize int
+		bufsize         int
+		eventsSize      uint
+		rootRelative    bool
+		relativeTo      string
+		backpressure    Backpressure
+		retarget        bool
+		followRotation  bool
+		chmod           chmodMode
+		noFollow        bool
+		callback        func(*Event)
+		loopPolicy      LoopPolicy
+		fileTypes       FileType
+		maxDepth        int
+		continueOnError bool
+		walkWorkers     int
+		progress        func(dirs, watched int)
+		initialScan     bool
+		journal         *Journal
+		newHash         func() hash.Hash
+		diffSize        int64
+		nameEncoding    NameEncoding
+		shared          bool
+		ioUring         bool
+		reconcile       time.Duration
+		poll            bool
+		filePolling     time.Duration
+		windowsFilters  uint32
+		unicodeNorm     UnicodeNorm
+		caseInsensitive bool
+		absolutePaths   bool
+		excludeUnlinked bool
+		oneShot         bool
+		ctx             context.Context
+		ttl             time.Duration
+		onlyDir         bool
+		ops             Op
+		attrChanges     bool
+		reArm           bool
+		fromSpec        bool
 	}
 )
 
 var defaultOpts = withOpts{
-	bufsize: 65536, // 64K
+	bufsize:     65536, // 64K
+	walkWorkers: 8,
 }
 
 func getOptions(opts ...addOpt) withOpts {
@@ -218,8 +558,13 @@ func getOptions(opts ...addOpt) withOpts {
 	return with
 }
 
-// WithBufferSize sets the buffer size for the Windows backend. This is a no-op
-// for other backends.
+// WithBufferSize sets the size of the buffer events are read into.
+//
+// On Windows this is the buffer for every watch, and it's passed to AddWith. On
+// Linux it's the buffer inotify events are read into, and on kqueue it sets how
+// many kevents are read at once (the size divided by the size of a kevent);
+// both are passed to NewWatcherWith. This is a no-op for other backends, and
+// with [WithSharedInstance]. The size can't be smaller than 4096 bytes.
 //
 // The default value is 64K (65536 bytes) which is the highest value that works
 // on all filesystems and should be enough for most applications, but if you
@@ -229,6 +574,259 @@ func WithBufferSize(bytes int) addOpt {
 	return func(opt *withOpts) { opt.bufsize = bytes }
 }
 
+// WithWindowsFilters sets the notify filters that are passed to
+// ReadDirectoryChangesW for the Windows backend; this is a combination of the
+// windows.FILE_NOTIFY_CHANGE_* flags from golang.org/x/sys/windows. AddWith
+// returns [ErrUnsupported] on other platforms.
+//
+// By default only FILE_NOTIFY_CHANGE_FILE_NAME, FILE_NOTIFY_CHANGE_DIR_NAME,
+// and FILE_NOTIFY_CHANGE_LAST_WRITE are used. Changes the kernel doesn't
+// report aren't sent at all, rather than being filtered afterwards; for
+// example using only FILE_NOTIFY_CHANGE_FILE_NAME|FILE_NOTIFY_CHANGE_DIR_NAME
+// drops all Write events. Changes for the other flags, such as
+// FILE_NOTIFY_CHANGE_SECURITY, are sent as Write events.
+//
+// The filters apply to the entire directory: if a directory (or files in it)
+// is added more than once, the filters from the last AddWith that set them
+// are used.
+func WithWindowsFilters(filters uint32) addOpt {
+	return func(opt *withOpts) { opt.windowsFilters = filters }
+}
+
+// WithFilePolling makes the kqueue backend only open the watched directories,
+// and not every file in them. This is only used by [NewWatcherWith], which
+// returns [ErrUnsupported] on platforms that don't use kqueue.
+//
+// kqueue needs a file descriptor for every watched path, so watching a
+// directory normally opens all the files in it, which can run in to the limit
+// for open files (ulimit -n) on large trees. With this only directories (and
+// files passed to Add) are opened: Create, Remove, and Rename events for files
+// are detected by comparing the directory contents when it changes, and Write
+// and Chmod events by checking the size, modification time, and mode of every
+// file once per interval.
+//
+// Write and Chmod events are delayed by up to the interval, and several writes
+// within the interval are sent as one Write event.
+func WithFilePolling(interval time.Duration) addOpt {
+	return func(opt *withOpts) { opt.filePolling = interval }
+}
+
+// WithRetarget follows changes to the target of a symlink: if the path passed
+// to AddWith is a symlink and it's changed to point somewhere else (e.g. a
+// "current" symlink that's switched to a new release directory), the watch is
+// moved to the new target and a single [Retargeted] event is sent for the
+// symlink. Subdirectories that were added below the symlink are moved as well.
+//
+// Without this the watch keeps following the old target, as the symlink is only
+// resolved when the watch is added.
+//
+// This is currently only supported on Linux; AddWith returns [ErrUnsupported]
+// elsewhere.
+func WithRetarget() addOpt {
+	return func(opt *withOpts) { opt.retarget = true }
+}
+
+// WithFollowSymlinks sets whether a symlink passed to AddWith is resolved and
+// the target is watched (true, the default), or if the symlink itself is
+// watched (false). In the last case only changes to the link itself are sent,
+// such as Remove and Rename.
+//
+// This only applies to the path passed to AddWith; symlinks inside a watched
+// directory are never followed.
+//
+// The symlink is watched with IN_DONT_FOLLOW on Linux, O_SYMLINK on macOS,
+// FILE_NOFOLLOW on illumos, and as an entry in the parent directory on Windows.
+// It's not supported on FreeBSD, OpenBSD, NetBSD, and DragonFly BSD, where
+// [ErrUnsupported] is returned when adding a symlink. On Windows symlinks to
+// files are always watched as the symlink.
+//
+// On Linux a Remove is sent if the link is replaced, followed by a [Retargeted]
+// event if it points somewhere else, and the watch is moved to the new link.
+// On other platforms the watch is removed along with the link.
+func WithFollowSymlinks(follow bool) addOpt {
+	return func(opt *withOpts) { opt.noFollow = !follow }
+}
+
+// WithMaxDepth limits recursive watches (e.g. "dir/...") to n levels of
+// subdirectories below the path; with n=1 only "dir" and its direct
+// subdirectories are watched. The default (or n<=0) is unlimited.
+//
+// This also applies to directories that are created later, and to
+// [GetDirNamesWith].
+//
+// On Windows the entire tree is always watched by the system, and events from
+// deeper directories are dropped. kqueue and FEN don't support recursive
+// watches, and AddWith returns [ErrUnsupported].
+func WithMaxDepth(n int) addOpt {
+	return func(opt *withOpts) { opt.maxDepth = n }
+}
+
+// WithOps enables the [Open], [Read], [Close], and [Xattr] operations for the
+// watch, which aren't sent by default as they're very frequent or expensive to
+// detect. Other operations in ops are ignored, as they're always sent.
+//
+// AddWith returns [ErrUnsupported] if an operation isn't in [SupportedOps].
+// Note that Open and Read are also sent for directories that are listed, which
+// includes listings done by fsnotify itself (e.g. for [WithInitialScan]).
+//
+// No platform reports which attributes changed, so for Xattr the attributes of
+// every file in the path are read when it's added, and again on every event.
+func WithOps(ops Op) addOpt {
+	return func(opt *withOpts) { opt.ops = ops & (Open | Read | Close | Xattr) }
+}
+
+// WithOnlyDir makes AddWith return [ErrNotDirectory] if the path isn't a
+// directory, rather than watching a single file. With WithFollowSymlinks(false) a symlink
+// to a directory isn't a directory either.
+//
+// This uses IN_ONLYDIR on Linux, so the check is atomic with adding the watch.
+// Other platforms stat the path first.
+func WithOnlyDir() addOpt {
+	return func(opt *withOpts) { opt.onlyDir = true }
+}
+
+// checkDir returns ErrNotDirectory if the path isn't a directory, for
+// WithOnlyDir.
+func checkDir(path string, noFollow bool) error {
+	stat := os.Stat
+	if noFollow {
+		stat = os.Lstat
+	}
+	fi, err := stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return ErrNotDirectory{Path: path}
+	}
+	return nil
+}
+
+// WithEventChannelSize sets the capacity of the [Watcher.Events] channel. This
+// is only used by [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// The default is an unbuffered channel (0) on all platforms except Windows,
+// where it's 50. Consumers that can't keep up with bursts of events can use a
+// larger value so the backend doesn't block on sending every event, at the cost
+// of some memory. Note this doesn't prevent kernel-level overflows if the
+// channel fills up.
+func WithEventChannelSize(n uint) addOpt {
+	return func(opt *withOpts) { opt.eventsSize = n }
+}
+
+// WithSharedInstance makes the Watcher share a single inotify instance with
+// all other Watchers created with this option, rather than creating a new one.
+// This is only used by [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// Every Watcher normally uses its own inotify instance, and processes that
+// create many Watchers can run in to the fs.inotify.max_user_instances limit
+// (128 by default). With this all Watchers read from the same instance, and the
+// events are routed to the Watchers that added the watch. The instance is
+// closed when the last Watcher using it is closed.
+//
+// Watches still count against fs.inotify.max_user_watches once per path, even
+// if several Watchers add the same path. Events are read by one goroutine, so a
+// Watcher whose events aren't read also delays the events for the others; use
+// [WithBackpressure] or [WithEventChannelSize] to avoid this.
+//
+// NewWatcherWith returns [ErrUnsupported] on all platforms other than Linux.
+func WithSharedInstance() addOpt {
+	return func(opt *withOpts) { opt.shared = true }
+}
+
+// WithIOUring reads events from inotify with io_uring, rather than with a
+// read() syscall for every batch of events. This is only used by
+// [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// This is experimental, and only useful for very high event rates: the buffer
+// set with [WithBufferSize] is registered with the kernel once, and the next
+// read is queued while the events from the previous one are processed.
+// NewWatcherWith returns an error if io_uring isn't available, for example
+// because it's disabled with the kernel.io_uring_disabled sysctl or by a
+// seccomp filter, or if the buffer can't be locked in memory (RLIMIT_MEMLOCK
+// on kernels before 5.12). It can't be used with [WithSharedInstance].
+//
+// NewWatcherWith returns [ErrUnsupported] on all platforms other than Linux.
+func WithIOUring() addOpt {
+	return func(opt *withOpts) { opt.ioUring = true }
+}
+
+// WithRootRelativeNames sets [Event.Root] to the path the event was matched to
+// (as passed to Add()), and makes [Event.Name] relative to it; for example with
+// Add("/tmp/a") and Add("dir") the names will be "file" with the Root set to
+// "/tmp/a" and "dir", rather than "/tmp/a/file" and "dir/file". Events for the
+// root itself have the Name ".".
+//
+// This gives a stable naming contract when watching several roots, regardless
+// of whether they were added as absolute or relative paths. It's only used by
+// [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// The Name is left unchanged and Root empty if the root can't be determined,
+// for example because the watch was already removed.
+func WithRootRelativeNames() addOpt {
+	return func(opt *withOpts) { opt.rootRelative = true }
+}
+
+// WithRelativeTo makes [Event.Name] relative to root and sets [Event.Root] to
+// root, for events for root or any path below it; for example with
+// WithRelativeTo("/src") and Add("/src/...") the name for "/src/pkg/file.go" is
+// "pkg/file.go", which can be matched against the paths in a build manifest.
+// Events for other paths are left unchanged. It's only used by
+// [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// The root is compared to the paths as they're passed to Add (or as converted
+// by [WithAbsolutePaths]), so it should be absolute if they are. This takes
+// precedence over [WithRootRelativeNames] for events below root.
+func WithRelativeTo(root string) addOpt {
+	return func(opt *withOpts) { opt.relativeTo = filepath.Clean(root) }
+}
+
+// relativeTo makes the event relative to root for WithRelativeTo, if it's for
+// a path below root.
+func (e Event) relativeTo(root string) Event {
+	if root == "" {
+		return e
+	}
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	n := len(root)
+	if !strings.HasPrefix(name, root) ||
+		(len(name) > n && name[n] != filepath.Separator && root[n-1] != filepath.Separator) {
+		return e
+	}
+	e.Root, e.Name = "", name
+	return e.rootRelative(root)
+}
+
+// rootRelative sets the Root and makes the Name relative to it.
+func (e Event) rootRelative(root string) Event {
+	if root == "" {
+		return e
+	}
+
+	// The names from the backends are almost always below the root they were
+	// joined with, so try a substring first; filepath.Rel cleans both paths,
+	// which is the most expensive part of sending an event.
+	var rel string
+	switch n := len(root); {
+	case e.Name == root:
+		rel = "."
+	case len(e.Name) > n && e.Name[n] == filepath.Separator && e.Name[:n] == root &&
+		root[n-1] != filepath.Separator:
+		rel = filepath.Clean(e.Name[n+1:])
+	default:
+		var err error
+		rel, err = filepath.Rel(root, e.Name)
+		if err != nil {
+			return e
+		}
+	}
+	e.Root, e.Name = root, rel
+	return e
+}
+
 // Check if this path is recursive (ends with "/..." or "\..."), and return the
 // path with the /... stripped.
 func recursivePath(path string) (string, bool) {
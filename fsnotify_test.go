@@ -16,7 +16,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/fsnotify/fsnotify/internal"
+	"github.com/hohodqr/fsnotify/internal"
 )
 
 // Set soft open file limit to the maximum; on e.g. OpenBSD it's 512/1024.
@@ -1223,6 +1223,105 @@ func TestAdd(t *testing.T) {
 			remove /file
 		`))
 	})
+
+	t.Run("don't follow symlinks", func(t *testing.T) {
+		switch runtime.GOOS {
+		case "freebsd", "openbsd", "netbsd", "dragonfly":
+			t.Skip("not supported on BSD")
+		}
+		if !internal.HasPrivilegesForSymlink() {
+			t.Skip("does not have privileges for symlink on this OS")
+		}
+		t.Parallel()
+
+		tmp := t.TempDir()
+		dir := join(tmp, "dir")
+		link := join(tmp, "link")
+		mkdir(t, dir)
+		symlink(t, dir, link)
+
+		w := newWatcher(t)
+		defer w.Close()
+		if err := w.AddWith(link, WithFollowSymlinks(false)); err != nil {
+			t.Fatal(err)
+		}
+
+		touch(t, dir, "file", noWait) // Not sent.
+		eventSeparator()
+		rm(t, link, noWait)
+
+		for {
+			select {
+			case e := <-w.Events:
+				if e.Name != link {
+					t.Fatalf("wrong event: %s", e)
+				}
+				if e.Op&opRemove != 0 {
+					return
+				}
+			case err := <-w.Errors:
+				t.Fatal(err)
+			case <-time.After(2 * time.Second):
+				t.Fatal("timeout")
+			}
+		}
+	})
+
+	t.Run("unsupported option", func(t *testing.T) {
+		var opt addOpt
+		switch {
+		case isKqueue(), isSolaris():
+			opt = WithMaxDepth(1)
+		case runtime.GOOS == "windows":
+			opt = WithRetarget()
+		case runtime.GOOS == "linux":
+			opt = WithWindowsFilters(1)
+		default:
+			t.Skip("all options are supported")
+		}
+		t.Parallel()
+
+		w := newWatcher(t)
+		defer w.Close()
+		err := w.AddWith(t.TempDir(), opt)
+
+		var unsup ErrUnsupported
+		if !errors.As(err, &unsup) {
+			t.Fatalf("wrong error: %#v", err)
+		}
+		if unsup.Backend != defaultBackend() {
+			t.Errorf("wrong backend: %q", unsup.Backend)
+		}
+		if l := w.WatchList(); len(l) != 0 {
+			t.Errorf("WatchList not empty: %v", l)
+		}
+	})
+
+	t.Run("only dir", func(t *testing.T) {
+		t.Parallel()
+
+		tmp := t.TempDir()
+		file := join(tmp, "file")
+		touch(t, file)
+
+		w := newWatcher(t)
+		defer w.Close()
+		if err := w.AddWith(tmp, WithOnlyDir()); err != nil {
+			t.Fatal(err)
+		}
+
+		err := w.AddWith(file, WithOnlyDir())
+		var notDir ErrNotDirectory
+		if !errors.As(err, &notDir) {
+			t.Fatalf("wrong error: %#v", err)
+		}
+		if notDir.Path != file {
+			t.Errorf("wrong path: %q", notDir.Path)
+		}
+		if l := w.WatchList(); len(l) != 1 {
+			t.Errorf("file was added: %v", l)
+		}
+	})
 }
 
 // TODO: should also check internal state is correct/cleaned up; e.g. no
@@ -1326,15 +1425,15 @@ func TestEventString(t *testing.T) {
 		want string
 	}{
 		{Event{}, `[no events]   ""`},
-		{Event{"/file", 0}, `[no events]   "/file"`},
+		{Event{Name: "/file", Op: 0}, `[no events]   "/file"`},
 
-		{Event{"/file", Chmod | Create},
+		{Event{Name: "/file", Op: Chmod | Create},
 			`CREATE|CHMOD  "/file"`},
-		{Event{"/file", Rename},
+		{Event{Name: "/file", Op: Rename},
 			`RENAME        "/file"`},
-		{Event{"/file", Remove},
+		{Event{Name: "/file", Op: Remove},
 			`REMOVE        "/file"`},
-		{Event{"/file", Write | Chmod},
+		{Event{Name: "/file", Op: Write | Chmod},
 			`WRITE|CHMOD   "/file"`},
 	}
 
@@ -1526,6 +1625,268 @@ func TestWatchList(t *testing.T) {
 	}
 }
 
+func TestWatchError(t *testing.T) {
+	var err error = WatchError{Path: "/dir", Op: "add", Err: syscall.ENOENT}
+	err = fmt.Errorf("wrapped: %w", err)
+
+	if !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("not ENOENT: %#v", err)
+	}
+	var werr WatchError
+	if !errors.As(err, &werr) {
+		t.Fatalf("not a WatchError: %#v", err)
+	}
+	if werr.Path != "/dir" || werr.Alive {
+		t.Errorf("wrong fields: %#v", werr)
+	}
+	if want := `fsnotify: add "/dir": ` + syscall.ENOENT.Error(); werr.Error() != want {
+		t.Errorf("\nhave: %s\nwant: %s", werr.Error(), want)
+	}
+}
+
+func TestErrTooManyWatches(t *testing.T) {
+	tests := []struct {
+		in   ErrTooManyWatches
+		want string
+	}{
+		{ErrTooManyWatches{Path: "/dir", Watches: 3, Limit: 8192, Setting: "fs.inotify.max_user_watches", Err: syscall.ENOSPC},
+			`fsnotify: too many watches adding "/dir" (3 in use, limit 8192); raise fs.inotify.max_user_watches`},
+		{ErrTooManyWatches{Path: "/dir", Watches: 3, Setting: "ulimit -n", Err: syscall.EMFILE},
+			`fsnotify: too many watches adding "/dir" (3 in use, limit unknown); raise ulimit -n`},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			err := fmt.Errorf("wrapped: %w", tt.in)
+			if !errors.Is(err, tt.in.Err) {
+				t.Errorf("not %s: %#v", tt.in.Err, err)
+			}
+			var terr ErrTooManyWatches
+			if !errors.As(err, &terr) {
+				t.Fatalf("not an ErrTooManyWatches: %#v", err)
+			}
+			if have := terr.Error(); have != tt.want {
+				t.Errorf("\nhave: %s\nwant: %s", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventIsDir(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t, tmp)
+	defer w.Close()
+
+	dir, file := join(tmp, "dir"), join(tmp, "file")
+	mkdir(t, dir)
+	touch(t, file)
+
+	have := make(map[string]bool)
+	timeout := time.After(2 * time.Second)
+	for len(have) < 2 {
+		select {
+		case e := <-w.Events:
+			have[e.Name] = have[e.Name] || e.IsDir()
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("not all events: %v", have)
+		}
+	}
+	if !have[dir] || have[file] {
+		t.Errorf("wrong IsDir: %v", have)
+	}
+}
+
+func TestWithOps(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	file := join(tmp, "file")
+	cat(t, "data", file)
+
+	w := newWatcher(t)
+	defer w.Close()
+	err := w.AddWith(tmp, WithOps(Open|Read|Close))
+	if SupportedOps() == 0 {
+		var unsup ErrUnsupported
+		if !errors.As(err, &unsup) {
+			t.Fatalf("wrong error: %#v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.ReadFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	var have Op
+	timeout := time.After(2 * time.Second)
+	for have&(Open|Read|Close) != Open|Read|Close {
+		select {
+		case e := <-w.Events:
+			if e.Name == file {
+				have |= e.Op
+			}
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("not all ops: %s", have)
+		}
+	}
+}
+
+func TestSupports(t *testing.T) {
+	t.Parallel()
+
+	w := newWatcher(t)
+	defer w.Close()
+
+	if !w.Supports(Create | Write | Remove | Rename | Chmod) {
+		t.Error("basic ops not supported")
+	}
+	for _, op := range []Op{Open, Read, Close} {
+		if w.Supports(op) != SupportedOps().Has(op) || Supported()[op] != SupportedOps().Has(op) {
+			t.Errorf("Supports(%s) = %t; SupportedOps = %s", op, w.Supports(op), SupportedOps())
+		}
+	}
+	if w.Supports(Retargeted) != (runtime.GOOS == "linux") {
+		t.Errorf("Supports(Retargeted) = %t on %s", w.Supports(Retargeted), runtime.GOOS)
+	}
+}
+
+func TestNewWatcherWith(t *testing.T) {
+	t.Run("event channel size", func(t *testing.T) {
+		t.Parallel()
+
+		w, err := NewWatcherWith(WithEventChannelSize(42))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		if have := cap(w.Events); have != 42 {
+			t.Errorf("cap(Events) = %d; want 42", have)
+		}
+	})
+
+	t.Run("unsupported option", func(t *testing.T) {
+		t.Parallel()
+
+		opt := WithSharedInstance()
+		if runtime.GOOS == "linux" {
+			opt = WithFilePolling(time.Second)
+		}
+		w, err := NewWatcherWith(opt)
+		if err == nil {
+			w.Close()
+		}
+		var unsup ErrUnsupported
+		if !errors.As(err, &unsup) {
+			t.Fatalf("wrong error: %#v", err)
+		}
+	})
+
+	t.Run("root relative names", func(t *testing.T) {
+		t.Parallel()
+
+		tmp := t.TempDir()
+		w, err := NewWatcherWith(WithRootRelativeNames())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		addWatch(t, w, tmp)
+
+		touch(t, tmp, "file")
+		select {
+		case e := <-w.Events:
+			if e.Name != "file" || e.Root != tmp {
+				t.Errorf("wrong event: Name=%q Root=%q", e.Name, e.Root)
+			}
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout")
+		}
+	})
+
+	t.Run("relative to", func(t *testing.T) {
+		t.Parallel()
+
+		tmp := t.TempDir()
+		mkdir(t, tmp, "src", noWait)
+		mkdir(t, tmp, "other", noWait)
+		w, err := NewWatcherWith(WithRelativeTo(join(tmp, "src")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		addWatch(t, w, tmp, "src")
+		addWatch(t, w, tmp, "other")
+
+		touch(t, tmp, "src", "file")
+		touch(t, tmp, "other", "file")
+		var have []string
+		for _, e := range eventsFor(t, w, 200*time.Millisecond) {
+			have = append(have, e.Root+"|"+e.Name)
+		}
+		want := []string{join(tmp, "src") + "|file", "|" + join(tmp, "other", "file")}
+		if len(have) != 2 || have[0] != want[0] || have[1] != want[1] {
+			t.Errorf("\nhave: %q\nwant: %q", have, want)
+		}
+	})
+
+	t.Run("backpressure", func(t *testing.T) {
+		for _, b := range []Backpressure{BackpressureDropOldest, BackpressureDropNewest, BackpressureCoalesce} {
+			b := b
+			t.Run(b.String(), func(t *testing.T) {
+				t.Parallel()
+
+				tmp := t.TempDir()
+				w, err := NewWatcherWith(WithEventChannelSize(1), WithBackpressure(b))
+				if err != nil {
+					t.Fatal(err)
+				}
+				addWatch(t, w, tmp)
+
+				// Nothing is reading, so the backend should never block.
+				for i := 0; i < 10; i++ {
+					touch(t, tmp, fmt.Sprintf("file%d", i), noWait)
+				}
+				waitForEvents()
+
+				if b == BackpressureCoalesce {
+					// One event per path, in order.
+					seen := make(map[string]bool)
+					for len(seen) < 10 {
+						select {
+						case e := <-w.Events:
+							if seen[e.Name] {
+								t.Fatalf("duplicate event for %q", e.Name)
+							}
+							seen[e.Name] = true
+						case <-time.After(2 * time.Second):
+							t.Fatalf("timeout; seen %d paths", len(seen))
+						}
+					}
+				} else if w.DroppedEvents() == 0 {
+					t.Error("DroppedEvents() is 0")
+				}
+
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	})
+}
+
 func BenchmarkWatch(b *testing.B) {
 	w, err := NewWatcher()
 	if err != nil {
@@ -1577,6 +1938,47 @@ func BenchmarkWatch(b *testing.B) {
 	wg.Wait()
 }
 
+func TestEventRootRelative(t *testing.T) {
+	sep := string(filepath.Separator)
+	tests := []struct {
+		root, name string
+		wantRoot   string
+		wantName   string
+	}{
+		{"", "dir" + sep + "file", "", "dir" + sep + "file"},
+		{"dir", "dir", "dir", "."},
+		{"dir", "dir" + sep + "file", "dir", "file"},
+		{"dir", "dir" + sep + "sub" + sep + "file", "dir", "sub" + sep + "file"},
+		{"dir", "dirfile", "dir", ".." + sep + "dirfile"},
+		{"dir" + sep, "dir" + sep + "file", "dir" + sep, "file"},
+		{"dir", "dir" + sep + "." + sep + "file", "dir", "file"},
+		{sep, sep + "file", sep, "file"},
+		{sep + "dir", "dir", "", "dir"}, // Rel fails; unchanged.
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			e := Event{Name: tt.name}.rootRelative(tt.root)
+			if e.Root != tt.wantRoot || e.Name != tt.wantName {
+				t.Errorf("rootRelative(%q) of %q\nhave: Root=%q Name=%q\nwant: Root=%q Name=%q",
+					tt.root, tt.name, e.Root, e.Name, tt.wantRoot, tt.wantName)
+			}
+		})
+	}
+}
+
+func BenchmarkRootRelative(b *testing.B) {
+	var (
+		root = filepath.Join("home", "user", "src")
+		e    = Event{Name: filepath.Join(root, "dir", "file.go"), Op: Write}
+	)
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if r := e.rootRelative(root); r.Root != root {
+			b.Fatal(r)
+		}
+	}
+}
+
 func BenchmarkAddRemove(b *testing.B) {
 	w, err := NewWatcher()
 	if err != nil {
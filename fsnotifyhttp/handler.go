@@ -0,0 +1,145 @@
+// Package fsnotifyhttp serves the events from a [fsnotify.Watcher] over HTTP.
+package fsnotifyhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// keepAlive is how often a comment is sent if there are no events, so proxies
+// don't close the connection.
+var keepAlive = 15 * time.Second
+
+// Event is the JSON for an event.
+type Event struct {
+	Time time.Time `json:"time"`
+	Op   []string  `json:"op"`   // Op names, as printed by Op.String().
+	Path string    `json:"path"` // Event.Name
+}
+
+// Handler streams the events from the watcher as Server-Sent Events, with every
+// event as JSON in the data field:
+//
+//	data: {"time":"2006-01-02T15:04:05Z","op":["IN_CREATE"],"path":"/tmp/file"}
+//
+// This is easy to use from a browser with EventSource, for example to reload a
+// page when a file changes:
+//
+//	new EventSource('/events?path=*.css').onmessage = () => location.reload()
+//
+// The events can be filtered with query parameters:
+//
+//	path   Only send events for which the full path or the file name
+//	       matches this glob; see filepath.Match. Can be given more than
+//	       once.
+//	op     Only send events which have at least one of these ops, as a
+//	       comma-separated list of names as printed by Op.String (e.g.
+//	       "IN_CREATE,IN_MODIFY"). The names are case-insensitive.
+//
+// The events are read with [fsnotify.Watcher.SubscribeCtx], so the Events
+// channel must still be read as usual. A client that doesn't read fast enough
+// blocks the watcher until it disconnects.
+func Handler(w *fsnotify.Watcher) http.Handler { return handler{w} }
+
+type handler struct{ w *fsnotify.Watcher }
+
+func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	flush, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	globs, ops, err := filters(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events := h.w.SubscribeCtx(r.Context(), func(e fsnotify.Event) bool {
+		return matchPath(e.Name, globs) && matchOp(e.Op, ops)
+	})
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	flush.Flush()
+
+	t := time.NewTicker(keepAlive)
+	defer t.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok { // Request cancelled or watcher closed.
+				return
+			}
+			j, err := json.Marshal(Event{Time: time.Now(), Op: strings.Split(e.Op.String(), "|"), Path: e.Name})
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(rw, "data: %s\n\n", j); err != nil {
+				return
+			}
+		case <-t.C:
+			if _, err := fmt.Fprint(rw, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flush.Flush()
+	}
+}
+
+// filters gets the path and op filters from the query.
+func filters(r *http.Request) (globs, ops []string, err error) {
+	q := r.URL.Query()
+	globs = q["path"]
+	for _, g := range globs {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return nil, nil, fmt.Errorf("path %q: %w", g, err)
+		}
+	}
+	for _, o := range q["op"] {
+		for _, op := range strings.Split(o, ",") {
+			if op = strings.TrimSpace(op); op != "" {
+				ops = append(ops, op)
+			}
+		}
+	}
+	return globs, ops, nil
+}
+
+func matchPath(path string, globs []string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, g := range globs {
+		if ok, _ := filepath.Match(g, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(g, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOp(op fsnotify.Op, ops []string) bool {
+	if len(ops) == 0 {
+		return true
+	}
+	for _, have := range strings.Split(op.String(), "|") {
+		for _, want := range ops {
+			if strings.EqualFold(have, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
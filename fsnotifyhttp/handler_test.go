@@ -0,0 +1,82 @@
+package fsnotifyhttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+func TestHandler(t *testing.T) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	go func() {
+		for range w.Events {
+		}
+	}()
+	tmp := t.TempDir()
+	if err := w.Add(tmp); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(Handler(w))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"?path=*.css&op=in_create", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("wrong Content-Type: %q", ct)
+	}
+
+	for _, f := range []string{"a.txt", "b.css"} {
+		if err := os.WriteFile(filepath.Join(tmp, f), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "data: ") {
+		t.Fatalf("not a data line: %q", line)
+	}
+	var e Event
+	if err := json.Unmarshal([]byte(line[6:]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != filepath.Join(tmp, "b.css") || len(e.Op) != 1 || e.Op[0] != "IN_CREATE" {
+		t.Errorf("wrong event: %+v", e)
+	}
+}
+
+func TestHandlerBadGlob(t *testing.T) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	rec := httptest.NewRecorder()
+	Handler(w).ServeHTTP(rec, httptest.NewRequest("GET", "/?path=[", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d", rec.Code)
+	}
+}
@@ -0,0 +1,409 @@
+package fsnotifyhttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Command is a command sent by a WebSocket client.
+type Command struct {
+	ID   int64  `json:"id,omitempty"` // Copied to the Reply.
+	Cmd  string `json:"cmd"`          // "add", "remove", or "list".
+	Path string `json:"path,omitempty"`
+}
+
+// Reply is the reply to a Command.
+type Reply struct {
+	ID    int64    `json:"id,omitempty"`
+	Error string   `json:"error,omitempty"`
+	Paths []string `json:"paths,omitempty"` // Watched paths, for "list".
+}
+
+// Message is a message sent to a WebSocket client; only one of the fields is
+// set.
+type Message struct {
+	Event *Event `json:"event,omitempty"`
+	Reply *Reply `json:"reply,omitempty"`
+}
+
+// WebSocketHandler streams the events from the watcher to WebSocket clients,
+// and lets them add and remove watches. Every message is a JSON object; the
+// server sends events and replies as a [Message]:
+//
+//	{"event":{"time":"2006-01-02T15:04:05Z","op":["IN_CREATE"],"path":"/tmp/file"}}
+//	{"reply":{"id":1}}
+//	{"reply":{"id":2,"error":"no such file or directory"}}
+//
+// And clients send a [Command]:
+//
+//	{"id":1,"cmd":"add","path":"/tmp"}
+//	{"id":2,"cmd":"remove","path":"/tmp"}
+//	{"id":3,"cmd":"list"}
+//
+// The watcher is shared: a watch added by one client sends events to all of
+// them. The events can be filtered with the same query parameters as for
+// [Handler].
+//
+// Connections from browsers are only accepted if the Origin is the same as the
+// Host, so other websites can't add watches. Requests without an Origin header
+// are rejected unless [AllowNoOrigin] is used.
+//
+// Clients can add and remove any path the process can read, unless the paths
+// are restricted with [WithRoots].
+func WebSocketHandler(w *fsnotify.Watcher, opts ...WebSocketOption) http.Handler {
+	h := wsHandler{w: w}
+	for _, o := range opts {
+		o(&h.opts)
+	}
+	return h
+}
+
+// WebSocketOption is an option for [WebSocketHandler].
+type WebSocketOption func(*wsOptions)
+
+type wsOptions struct {
+	noOrigin bool
+	roots    []string
+}
+
+// AllowNoOrigin accepts requests without an Origin header. Browsers always send
+// it for WebSocket connections, but other clients usually don't.
+func AllowNoOrigin() WebSocketOption {
+	return func(o *wsOptions) { o.noOrigin = true }
+}
+
+// WithRoots only lets clients add and remove paths inside one of the roots (or
+// the roots themselves). Symlinks are resolved before the paths are compared.
+func WithRoots(roots ...string) WebSocketOption {
+	return func(o *wsOptions) {
+		for _, r := range roots {
+			o.roots = append(o.roots, resolve(r))
+		}
+	}
+}
+
+// allowed reports if a client can add or remove the path.
+func (o wsOptions) allowed(path string) bool {
+	if o.roots == nil {
+		return true
+	}
+	if filepath.Base(path) == "..." {
+		path = filepath.Dir(path)
+	}
+	path = resolve(path)
+	for _, r := range o.roots {
+		rel, err := filepath.Rel(r, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve makes the path absolute and resolves symlinks, if it exists.
+func resolve(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if r, err := filepath.EvalSymlinks(abs); err == nil {
+		return r
+	}
+	return abs
+}
+
+type wsHandler struct {
+	w    *fsnotify.Watcher
+	opts wsOptions
+}
+
+// maxMessage is the largest message accepted from a client.
+const maxMessage = 1 << 20
+
+func (h wsHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	globs, ops, err := filters(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if o := r.Header.Get("Origin"); o == "" {
+		if !h.opts.noOrigin {
+			http.Error(rw, "no Origin header", http.StatusForbidden)
+			return
+		}
+	} else if u, err := url.Parse(o); err != nil || !strings.EqualFold(u.Host, r.Host) {
+		http.Error(rw, "cross-origin request", http.StatusForbidden)
+		return
+	}
+	ws, err := upgrade(rw, r)
+	if err != nil {
+		return // upgrade() wrote the error.
+	}
+	defer ws.conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := h.w.SubscribeCtx(ctx, func(e fsnotify.Event) bool {
+		return matchPath(e.Name, globs) && matchOp(e.Op, ops)
+	})
+
+	go func() {
+		defer cancel()
+		for {
+			msg, err := ws.read()
+			if err != nil {
+				return
+			}
+			var c Command
+			if err := json.Unmarshal(msg, &c); err != nil {
+				ws.write(Message{Reply: &Reply{Error: fmt.Sprintf("invalid command: %s", err)}})
+				continue
+			}
+			if err := ws.write(Message{Reply: h.run(c)}); err != nil {
+				return
+			}
+		}
+	}()
+
+	t := time.NewTicker(keepAlive)
+	defer t.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				ws.close(1001, "going away")
+				return
+			}
+			err := ws.write(Message{Event: &Event{Time: time.Now(), Op: strings.Split(e.Op.String(), "|"), Path: e.Name}})
+			if err != nil {
+				return
+			}
+		case <-t.C:
+			if err := ws.frame(opPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (h wsHandler) run(c Command) *Reply {
+	reply := &Reply{ID: c.ID}
+	var err error
+	switch c.Cmd {
+	case "add", "remove":
+		if !h.opts.allowed(c.Path) {
+			err = fmt.Errorf("path not allowed: %q", c.Path)
+			break
+		}
+		if c.Cmd == "add" {
+			err = h.w.Add(c.Path)
+		} else {
+			err = h.w.Remove(c.Path)
+		}
+	case "list":
+		reply.Paths = h.w.WatchList()
+	default:
+		err = fmt.Errorf("unknown command: %q", c.Cmd)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+// Just enough of RFC 6455 for the server side.
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex // Protects writes.
+}
+
+func upgrade(rw http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	fail := func(code int, msg string) (*wsConn, error) {
+		http.Error(rw, msg, code)
+		return nil, errors.New(msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "method not allowed")
+	}
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return fail(http.StatusBadRequest, "no Sec-WebSocket-Key")
+	}
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "websocket not supported")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, err.Error())
+	}
+
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHas reports if the comma-separated header has the token.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ws *wsConn) write(m Message) error {
+	j, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ws.frame(opText, j)
+}
+
+// frame writes a single unfragmented frame.
+func (ws *wsConn) frame(op byte, payload []byte) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op // FIN
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = append(hdr, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = append(hdr, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.conn.SetWriteDeadline(time.Now().Add(keepAlive))
+	if _, err := ws.conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (ws *wsConn) close(code uint16, reason string) {
+	p := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(p, code)
+	ws.frame(opClose, append(p, reason...))
+}
+
+// read the next data message, answering pings and closes.
+func (ws *wsConn) read() ([]byte, error) {
+	var msg []byte
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(ws.br, hdr[:]); err != nil {
+			return nil, err
+		}
+		var (
+			fin    = hdr[0]&0x80 != 0
+			op     = hdr[0] & 0x0f
+			masked = hdr[1]&0x80 != 0
+			n      = uint64(hdr[1] & 0x7f)
+		)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+				return nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+				return nil, err
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+		if !masked {
+			ws.close(1002, "frame not masked")
+			return nil, errors.New("websocket: frame not masked")
+		}
+		if n > maxMessage || uint64(len(msg))+n > maxMessage {
+			ws.close(1009, "message too large")
+			return nil, errors.New("websocket: message too large")
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(ws.br, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch op {
+		case opPing:
+			if err := ws.frame(opPong, payload); err != nil {
+				return nil, err
+			}
+		case opPong:
+		case opClose:
+			ws.frame(opClose, payload)
+			return nil, io.EOF
+		case opText, opContinuation:
+			msg = append(msg, payload...)
+			if fin {
+				return msg, nil
+			}
+		default:
+			ws.close(1003, "only text messages are supported")
+			return nil, fmt.Errorf("websocket: unsupported opcode %d", op)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package fsnotifyhttp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// wsClient is a minimal WebSocket client.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWS(t *testing.T, addr, path string) *wsClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nOrigin: http://%[2]s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", path, addr)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %s", resp.Status)
+	}
+	// Example from RFC 6455.
+	if a := resp.Header.Get("Sec-WebSocket-Accept"); a != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wrong Sec-WebSocket-Accept: %q", a)
+	}
+	return &wsClient{t: t, conn: conn, br: br}
+}
+
+func (c *wsClient) send(cmd Command) {
+	c.t.Helper()
+	payload, _ := json.Marshal(cmd)
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opText, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// recv reads the next text message, skipping pings.
+func (c *wsClient) recv() Message {
+	c.t.Helper()
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+			c.t.Fatal(err)
+		}
+		n := int(hdr[1] & 0x7f)
+		if n == 126 {
+			var b [2]byte
+			io.ReadFull(c.br, b[:])
+			n = int(binary.BigEndian.Uint16(b[:]))
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			c.t.Fatal(err)
+		}
+		if hdr[0]&0x0f != opText {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(payload, &m); err != nil {
+			c.t.Fatal(err)
+		}
+		return m
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	go func() {
+		for range w.Events {
+		}
+	}()
+
+	srv := httptest.NewServer(WebSocketHandler(w))
+	defer srv.Close()
+	c := dialWS(t, strings.TrimPrefix(srv.URL, "http://"), "/")
+
+	tmp := t.TempDir()
+	c.send(Command{ID: 1, Cmd: "add", Path: tmp})
+	if m := c.recv(); !reflect.DeepEqual(m.Reply, &Reply{ID: 1}) {
+		t.Fatalf("wrong reply: %+v", m)
+	}
+	c.send(Command{ID: 2, Cmd: "add", Path: filepath.Join(tmp, "nonexistent")})
+	if m := c.recv(); m.Reply == nil || m.Reply.ID != 2 || m.Reply.Error == "" {
+		t.Errorf("wrong reply: %+v", m)
+	}
+	c.send(Command{ID: 3, Cmd: "list"})
+	if m := c.recv(); !reflect.DeepEqual(m.Reply, &Reply{ID: 3, Paths: []string{tmp}}) {
+		t.Errorf("wrong reply: %+v", m)
+	}
+	c.send(Command{ID: 4, Cmd: "oops"})
+	if m := c.recv(); m.Reply == nil || m.Reply.Error == "" {
+		t.Errorf("wrong reply: %+v", m)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmp, "file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if m := c.recv(); m.Event == nil || m.Event.Path != filepath.Join(tmp, "file") {
+		t.Errorf("wrong event: %+v", m)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	tests := []struct {
+		origin    string
+		opts      []WebSocketOption
+		forbidden bool
+	}{
+		{"http://evil.example", nil, true},
+		{"http://evil.example", []WebSocketOption{AllowNoOrigin()}, true},
+		{"", nil, true},
+		{"", []WebSocketOption{AllowNoOrigin()}, false},
+		{"http://example.com", nil, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		WebSocketHandler(w, tt.opts...).ServeHTTP(rec, req)
+		if have := rec.Code == http.StatusForbidden; have != tt.forbidden {
+			t.Errorf("origin %q with %d options: status %d", tt.origin, len(tt.opts), rec.Code)
+		}
+	}
+}
+
+func TestWebSocketRoots(t *testing.T) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	go func() {
+		for range w.Events {
+		}
+	}()
+
+	var (
+		tmp   = t.TempDir()
+		root  = filepath.Join(tmp, "root")
+		other = filepath.Join(tmp, "other")
+	)
+	for _, d := range []string{filepath.Join(root, "sub"), other} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Add(other); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(WebSocketHandler(w, WithRoots(root)))
+	defer srv.Close()
+	c := dialWS(t, strings.TrimPrefix(srv.URL, "http://"), "/")
+
+	type test struct {
+		cmd, path string
+		allowed   bool
+	}
+	tests := []test{
+		{"add", root, true},
+		{"add", filepath.Join(root, "sub"), true},
+		{"remove", filepath.Join(root, "sub"), true},
+		{"add", other, false},
+		{"add", root + "-not", false},
+		{"add", filepath.Join(root, "..", "other"), false},
+		{"remove", other, false},
+	}
+	if err := os.Symlink(other, filepath.Join(root, "link")); err == nil {
+		tests = append(tests, test{"add", filepath.Join(root, "link"), false})
+	}
+	for i, tt := range tests {
+		c.send(Command{ID: int64(i + 1), Cmd: tt.cmd, Path: tt.path})
+		m := c.recv()
+		if m.Reply == nil || m.Reply.ID != int64(i+1) {
+			t.Fatalf("wrong reply: %+v", m)
+		}
+		if have := !strings.Contains(m.Reply.Error, "not allowed"); have != tt.allowed {
+			t.Errorf("%s %q: error %q", tt.cmd, tt.path, m.Reply.Error)
+		}
+	}
+
+	have := w.WatchList()
+	sort.Strings(have)
+	if want := []string{other, root}; !reflect.DeepEqual(have, want) {
+		t.Errorf("wrong WatchList\nhave: %q\nwant: %q", have, want)
+	}
+}
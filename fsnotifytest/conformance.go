@@ -0,0 +1,325 @@
+package fsnotifytest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Impl is a watcher implementation to test with [RunConformance].
+type Impl struct {
+	Events <-chan fsnotify.Event
+	Errors <-chan error
+	Add    func(name string) error
+	Remove func(name string) error
+	Close  func() error
+
+	// Ops converts the Op of an event to the portable operations (Create,
+	// Write, Remove, Rename, Chmod). It can be nil if the events already use
+	// them.
+	Ops func(fsnotify.Op) fsnotify.Op
+}
+
+// FromWatcher gets the Impl for a Watcher.
+func FromWatcher(w *fsnotify.Watcher) Impl {
+	impl := Impl{Events: w.Events, Errors: w.Errors, Add: w.Add, Remove: w.Remove, Close: w.Close}
+	if runtime.GOOS == "linux" {
+		impl.Ops = inotifyOps
+	}
+	return impl
+}
+
+// inotifyOps converts the raw inotify mask that's set as the Op on Linux.
+func inotifyOps(op fsnotify.Op) fsnotify.Op {
+	var p fsnotify.Op
+	for _, m := range []struct{ in, op fsnotify.Op }{
+		{fsnotify.IN_CREATE | fsnotify.IN_MOVED_TO, fsnotify.Create},
+		{fsnotify.IN_MODIFY | fsnotify.IN_CLOSE_WRITE, fsnotify.Write},
+		{fsnotify.IN_DELETE | fsnotify.IN_DELETE_SELF, fsnotify.Remove},
+		{fsnotify.IN_MOVED_FROM | fsnotify.IN_MOVE_SELF, fsnotify.Rename},
+		{fsnotify.IN_ATTRIB, fsnotify.Chmod},
+	} {
+		if op&m.in != 0 {
+			p |= m.op
+		}
+	}
+	return p
+}
+
+// conformanceTimeout is how long to wait for events, and how long to wait to
+// make sure there are no events.
+var conformanceTimeout = 5 * time.Second
+
+const quiet = 500 * time.Millisecond
+
+// RunConformance tests that a watcher implementation matches the portable
+// semantics of fsnotify, so alternative backends and wrappers can check they
+// behave the same:
+//
+//	func TestConformance(t *testing.T) {
+//		fsnotifytest.RunConformance(t, func(t *testing.T) fsnotifytest.Impl {
+//			w, err := mywatcher.New()
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return fsnotifytest.Impl{Events: w.Events(), ...}
+//		})
+//	}
+//
+// newWatcher is called for every subtest, which closes the watcher at the end.
+// The subtests run in parallel, in temporary directories.
+//
+// Only the behaviour that's the same on all platforms is tested: an event with
+// the right Op for every change to a file in a watched directory, no events for
+// subdirectories, and the errors for Add, Remove, and Close. Other events may
+// be sent as well.
+func RunConformance(t *testing.T, newWatcher func(t *testing.T) Impl) {
+	for _, tt := range []struct {
+		name string
+		run  func(t *testing.T, w *conformance, tmp string)
+	}{
+		{"create file", func(t *testing.T, w *conformance, tmp string) {
+			w.add(t, tmp)
+			file := touch(t, tmp, "file")
+			w.want(t, file, fsnotify.Create)
+		}},
+		{"create directory", func(t *testing.T, w *conformance, tmp string) {
+			w.add(t, tmp)
+			dir := filepath.Join(tmp, "dir")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			w.want(t, dir, fsnotify.Create)
+		}},
+		{"write", func(t *testing.T, w *conformance, tmp string) {
+			file := touch(t, tmp, "file")
+			w.add(t, tmp)
+			write(t, file)
+			w.want(t, file, fsnotify.Write)
+		}},
+		{"remove", func(t *testing.T, w *conformance, tmp string) {
+			file := touch(t, tmp, "file")
+			w.add(t, tmp)
+			if err := os.Remove(file); err != nil {
+				t.Fatal(err)
+			}
+			w.want(t, file, fsnotify.Remove)
+		}},
+		{"rename", func(t *testing.T, w *conformance, tmp string) {
+			file := touch(t, tmp, "file")
+			w.add(t, tmp)
+			if err := os.Rename(file, file+".new"); err != nil {
+				t.Fatal(err)
+			}
+			w.want(t, file, fsnotify.Rename)
+			w.want(t, file+".new", fsnotify.Create)
+		}},
+		{"chmod", func(t *testing.T, w *conformance, tmp string) {
+			if runtime.GOOS == "windows" {
+				t.Skip("no chmod events on Windows")
+			}
+			file := touch(t, tmp, "file")
+			w.add(t, tmp)
+			if err := os.Chmod(file, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			w.want(t, file, fsnotify.Chmod)
+		}},
+		{"watch file", func(t *testing.T, w *conformance, tmp string) {
+			file := touch(t, tmp, "file")
+			w.add(t, file)
+			write(t, file)
+			w.want(t, file, fsnotify.Write)
+		}},
+		{"not recursive", func(t *testing.T, w *conformance, tmp string) {
+			dir := filepath.Join(tmp, "dir")
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			w.add(t, tmp)
+			file := touch(t, dir, "file")
+			w.wantNone(t, file)
+		}},
+		{"remove watch", func(t *testing.T, w *conformance, tmp string) {
+			w.add(t, tmp)
+			if err := w.impl.Remove(tmp); err != nil {
+				t.Fatalf("Remove: %s", err)
+			}
+			// Drain events from removing the watch.
+			w.wantNone(t, "")
+			file := touch(t, tmp, "file")
+			w.wantNone(t, file)
+
+			if err := w.impl.Remove(tmp); !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+				t.Errorf("second Remove: have %v; want ErrNonExistentWatch", err)
+			}
+		}},
+		{"add nonexistent", func(t *testing.T, w *conformance, tmp string) {
+			if err := w.impl.Add(filepath.Join(tmp, "nonexistent")); err == nil {
+				t.Error("no error adding a nonexistent path")
+			}
+		}},
+		{"close", func(t *testing.T, w *conformance, tmp string) {
+			w.add(t, tmp)
+			if err := w.impl.Close(); err != nil {
+				t.Fatalf("Close: %s", err)
+			}
+			select {
+			case <-w.closed:
+			case <-time.After(conformanceTimeout):
+				t.Fatal("Events and Errors not closed after Close")
+			}
+			if err := w.impl.Close(); err != nil {
+				t.Errorf("second Close: %s", err)
+			}
+			if err := w.impl.Add(tmp); err == nil {
+				t.Error("no error from Add after Close")
+			}
+		}},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			tmp := t.TempDir()
+			// Resolve symlinks, such as /tmp → /private/tmp on macOS, as
+			// the event names may be resolved.
+			if r, err := filepath.EvalSymlinks(tmp); err == nil {
+				tmp = r
+			}
+			w := newConformance(newWatcher(t))
+			defer w.impl.Close()
+			tt.run(t, w, tmp)
+		})
+	}
+}
+
+// conformance reads the events and errors from an Impl.
+type conformance struct {
+	impl   Impl
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []fsnotify.Event
+	errs   []error
+	closed chan struct{} // Closed once both channels are closed.
+}
+
+func newConformance(impl Impl) *conformance {
+	w := &conformance{impl: impl, closed: make(chan struct{})}
+	w.cond = sync.NewCond(&w.mu)
+	go func() {
+		defer close(w.closed)
+		events, errs := impl.Events, impl.Errors
+		for events != nil || errs != nil {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				if impl.Ops != nil {
+					e.Op = impl.Ops(e.Op)
+				}
+				w.mu.Lock()
+				w.events = append(w.events, e)
+				w.mu.Unlock()
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				w.mu.Lock()
+				w.errs = append(w.errs, err)
+				w.mu.Unlock()
+			}
+		}
+	}()
+	return w
+}
+
+func (w *conformance) add(t *testing.T, path string) {
+	t.Helper()
+	if err := w.impl.Add(path); err != nil {
+		t.Fatalf("Add(%q): %s", path, err)
+	}
+}
+
+// want waits for an event for the path with the op, and removes all events up
+// to and including it.
+func (w *conformance) want(t *testing.T, path string, op fsnotify.Op) {
+	t.Helper()
+	deadline := time.Now().Add(conformanceTimeout)
+	for time.Now().Before(deadline) {
+		w.mu.Lock()
+		for i, e := range w.events {
+			if e.Name == path && e.Op.Has(op) {
+				w.events = w.events[i+1:]
+				w.mu.Unlock()
+				return
+			}
+		}
+		w.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t.Errorf("no %s event for %q; have events: %v; errors: %v", opName(op), path, w.events, w.errs)
+}
+
+// wantNone checks there are no events for the path (or for any path if it's
+// "") for a while, and removes all events.
+func (w *conformance) wantNone(t *testing.T, path string) {
+	t.Helper()
+	time.Sleep(quiet)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, e := range w.events {
+		if path != "" && e.Name == path {
+			t.Errorf("unexpected event: %s", e)
+		}
+	}
+	w.events = nil
+}
+
+func opName(op fsnotify.Op) string {
+	switch op {
+	case fsnotify.Create:
+		return "Create"
+	case fsnotify.Write:
+		return "Write"
+	case fsnotify.Remove:
+		return "Remove"
+	case fsnotify.Rename:
+		return "Rename"
+	case fsnotify.Chmod:
+		return "Chmod"
+	}
+	return op.String()
+}
+
+func touch(t *testing.T, dir, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func write(t *testing.T, path string) {
+	t.Helper()
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	if _, err := fp.WriteString("data\n"); err != nil {
+		t.Fatal(err)
+	}
+}
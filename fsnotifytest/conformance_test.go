@@ -0,0 +1,17 @@
+package fsnotifytest
+
+import (
+	"testing"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+func TestConformance(t *testing.T) {
+	RunConformance(t, func(t *testing.T) Impl {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return FromWatcher(w)
+	})
+}
@@ -0,0 +1,162 @@
+package fsnotifytest
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// Watcher is an in-memory fake of [fsnotify.Watcher], for testing code that
+// handles events without touching the filesystem. It implements
+// [fsnotify.Interface]. Events and errors are only sent when they're injected:
+//
+//	w := fsnotifytest.NewWatcher()
+//	go handleEvents(w.Events, w.Errors)
+//	w.InjectEvent(fsnotify.Event{Name: "/tmp/file", Op: fsnotify.Write})
+//
+// Like a real Watcher the channels are unbuffered, so InjectEvent returns once
+// the event was read. Add and Remove only keep track of the watched paths; they
+// don't check if they exist.
+type Watcher struct {
+	// Events sends the injected events.
+	Events chan fsnotify.Event
+
+	// Errors sends the injected errors.
+	Errors chan error
+
+	mu      sync.Mutex
+	watches map[string]struct{}
+	done    chan struct{}
+	closed  bool
+	sending sync.WaitGroup // InjectEvent and InjectError calls in progress.
+}
+
+var _ fsnotify.Interface = (*Watcher)(nil)
+
+// NewWatcher creates a new fake Watcher.
+func NewWatcher() *Watcher {
+	return &Watcher{
+		Events:  make(chan fsnotify.Event),
+		Errors:  make(chan error),
+		watches: make(map[string]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Add records the path as watched. Adding a path more than once is a no-op.
+//
+// Returns [fsnotify.ErrClosed] if Close was called.
+func (w *Watcher) Add(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fsnotify.ErrClosed
+	}
+	w.watches[filepath.Clean(name)] = struct{}{}
+	return nil
+}
+
+// Remove removes the path from the watched paths.
+//
+// Returns an error wrapping [fsnotify.ErrNonExistentWatch] if the path isn't
+// watched, and nil if Close was called.
+func (w *Watcher) Remove(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	name = filepath.Clean(name)
+	if _, ok := w.watches[name]; !ok {
+		return fmt.Errorf("%w: %s", fsnotify.ErrNonExistentWatch, name)
+	}
+	delete(w.watches, name)
+	return nil
+}
+
+// WatchList returns all watched paths, sorted.
+//
+// Returns nil if Close was called.
+func (w *Watcher) WatchList() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	l := make([]string, 0, len(w.watches))
+	for p := range w.watches {
+		l = append(l, p)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// EventChan returns the Events channel, for [fsnotify.Interface].
+func (w *Watcher) EventChan() <-chan fsnotify.Event { return w.Events }
+
+// ErrorChan returns the Errors channel, for [fsnotify.Interface].
+func (w *Watcher) ErrorChan() <-chan error { return w.Errors }
+
+// InjectEvent sends the event on the Events channel. It blocks until the event
+// is read, and returns [fsnotify.ErrClosed] if the Watcher is closed before
+// that.
+func (w *Watcher) InjectEvent(e fsnotify.Event) error {
+	if !w.startSend() {
+		return fsnotify.ErrClosed
+	}
+	defer w.sending.Done()
+	select {
+	case w.Events <- e:
+		return nil
+	case <-w.done:
+		return fsnotify.ErrClosed
+	}
+}
+
+// InjectError sends the error on the Errors channel. It blocks until the error
+// is read, and returns [fsnotify.ErrClosed] if the Watcher is closed before
+// that.
+func (w *Watcher) InjectError(err error) error {
+	if !w.startSend() {
+		return fsnotify.ErrClosed
+	}
+	defer w.sending.Done()
+	select {
+	case w.Errors <- err:
+		return nil
+	case <-w.done:
+		return fsnotify.ErrClosed
+	}
+}
+
+func (w *Watcher) startSend() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return false
+	}
+	w.sending.Add(1)
+	return true
+}
+
+// Close removes all watches and closes the Events and Errors channels. Calling
+// it more than once is a no-op.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.watches = make(map[string]struct{})
+	close(w.done)
+	w.mu.Unlock()
+
+	w.sending.Wait()
+	close(w.Events)
+	close(w.Errors)
+	return nil
+}
@@ -0,0 +1,94 @@
+package fsnotifytest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+func TestFakeWatcher(t *testing.T) {
+	w := NewWatcher()
+
+	if err := w.Add("/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add("/a/"); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); !reflect.DeepEqual(l, []string{"/a", "/b"}) {
+		t.Errorf("wrong WatchList: %v", l)
+	}
+	if err := w.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Remove("/a"); !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	var (
+		events []fsnotify.Event
+		errs   []error
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for w.Events != nil || w.Errors != nil {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					w.Events = nil
+					continue
+				}
+				events = append(events, e)
+			case err, ok := <-w.Errors:
+				if !ok {
+					w.Errors = nil
+					continue
+				}
+				errs = append(errs, err)
+			}
+		}
+	}()
+
+	e := fsnotify.Event{Name: "/b/file", Op: fsnotify.Write}
+	if err := w.InjectEvent(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.InjectError(fsnotify.ErrEventOverflow); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if !reflect.DeepEqual(events, []fsnotify.Event{e}) {
+		t.Errorf("wrong events: %v", events)
+	}
+	if !reflect.DeepEqual(errs, []error{fsnotify.ErrEventOverflow}) {
+		t.Errorf("wrong errors: %v", errs)
+	}
+
+	if err := w.InjectEvent(e); err != fsnotify.ErrClosed {
+		t.Errorf("InjectEvent after Close: %v", err)
+	}
+	if err := w.Add("/c"); err != fsnotify.ErrClosed {
+		t.Errorf("Add after Close: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+// InjectEvent doesn't block forever if nothing reads the events.
+func TestFakeWatcherCloseUnblocks(t *testing.T) {
+	w := NewWatcher()
+	errc := make(chan error)
+	go func() { errc <- w.InjectEvent(fsnotify.Event{Name: "x"}) }()
+	go w.Close()
+	if err := <-errc; err != fsnotify.ErrClosed {
+		t.Errorf("wrong error: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+// Package fsnotifytest provides helpers for testing code that uses fsnotify.
+package fsnotifytest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hohodqr/fsnotify/internal"
+)
+
+// leakTimeout is how long VerifyNoLeaks waits for handles to be closed; some
+// backends close them asynchronously after Close returns.
+var leakTimeout = time.Second
+
+// VerifyNoLeaks checks that everything fsnotify opens during the test is closed
+// again by the end of the test: inotify instances on Linux, kqueues and the
+// file descriptors for watched paths on BSD and macOS, directory handles and
+// completion ports on Windows, and event ports on illumos. Call it at the start
+// of the test:
+//
+//	func TestWatch(t *testing.T) {
+//		fsnotifytest.VerifyNoLeaks(t)
+//		w, err := fsnotify.NewWatcher()
+//		...
+//	}
+//
+// On Linux the inotify instances in /proc/self/fd are also counted, to catch
+// instances that are open without fsnotify knowing about it.
+//
+// The check runs in t.Cleanup, after the test function (and its deferred
+// calls) returned. It checks all handles in the process, so it can't be used in
+// parallel tests: handles from other tests running at the same time would be
+// reported as leaks.
+func VerifyNoLeaks(t testing.TB) {
+	t.Helper()
+
+	var (
+		before   = make(map[internal.Handle]struct{})
+		osBefore = osHandles()
+	)
+	for _, h := range internal.OpenHandles() {
+		before[h] = struct{}{}
+	}
+
+	t.Cleanup(func() {
+		t.Helper()
+
+		var leaked []string
+		deadline := time.Now().Add(leakTimeout)
+		for {
+			leaked = leaked[:0]
+			for _, h := range internal.OpenHandles() {
+				if _, ok := before[h]; !ok {
+					leaked = append(leaked, h.String())
+				}
+			}
+			for kind, n := range osHandles() {
+				if n > osBefore[kind] {
+					leaked = append(leaked, kind+" (from the system)")
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(leaked) > 0 {
+			t.Errorf("fsnotifytest.VerifyNoLeaks: %d handles still open:\n\t%s",
+				len(leaked), strings.Join(leaked, "\n\t"))
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package fsnotifytest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+)
+
+// recorder is a testing.TB that records errors and cleanups.
+type recorder struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorder) Helper()          {}
+func (r *recorder) Cleanup(f func()) { r.cleanups = append(r.cleanups, f) }
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestVerifyNoLeaks(t *testing.T) {
+	defer func(d time.Duration) { leakTimeout = d }(leakTimeout)
+	leakTimeout = 100 * time.Millisecond
+
+	watch := func(t *testing.T) *fsnotify.Watcher {
+		t.Helper()
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Add(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	t.Run("closed", func(t *testing.T) {
+		r := &recorder{TB: t}
+		VerifyNoLeaks(r)
+		w := watch(t)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r.finish()
+		if len(r.errors) > 0 {
+			t.Errorf("errors for a closed watcher: %v", r.errors)
+		}
+	})
+
+	t.Run("leaked", func(t *testing.T) {
+		r := &recorder{TB: t}
+		VerifyNoLeaks(r)
+		w := watch(t)
+		defer w.Close()
+		r.finish()
+		if len(r.errors) != 1 {
+			t.Errorf("wrong errors for a leaked watcher: %v", r.errors)
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package fsnotifytest
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// osHandles counts the inotify instances in /proc/self/fd.
+func osHandles() map[string]int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil
+	}
+	n := 0
+	for _, fd := range fds {
+		if l, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil && l == "anon_inode:inotify" {
+			n++
+		}
+	}
+	return map[string]int{"inotify": n}
+}
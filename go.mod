@@ -2,22 +2,7 @@ module github.com/hohodqr/fsnotify
 
 go 1.17
 
-require (
-	github.com/fsnotify/fsnotify v1.6.0
-	github.com/prometheus/client_golang v1.14.0
-	golang.org/x/sys v0.6.0
-)
-
-require (
-	github.com/beorn7/perks v1.0.1 // indirect
-	github.com/cespare/xxhash/v2 v2.1.2 // indirect
-	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
-	github.com/prometheus/client_model v0.3.0 // indirect
-	github.com/prometheus/common v0.42.0 // indirect
-	github.com/prometheus/procfs v0.8.0 // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
-)
+require golang.org/x/sys v0.6.0
 
 retract (
 	v1.5.3 // Published an incorrect branch accidentally https://github.com/fsnotify/fsnotify/issues/445
@@ -0,0 +1,137 @@
+package fsnotify
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DirChanged is sent by [Grouper] for all changes in a directory.
+type DirChanged struct {
+	Dir      string   // Directory the files are in.
+	Children []string // Paths of the changed files, in the order of the first event.
+	Op       Op       // All operations that were seen for the children.
+}
+
+// Grouper aggregates events for all files in a directory, and sends them as a
+// single DirChanged once there were no new events in the directory for the
+// window duration; for example to rebuild a static site or assets once per
+// directory rather than for every file.
+//
+// Events are grouped by the parent directory of Event.Name, so an event for a
+// directory (e.g. if it's removed) is grouped with its siblings, rather than
+// the files in it.
+//
+// For example:
+//
+//	g := fsnotify.NewGrouper(100*time.Millisecond, func(d fsnotify.DirChanged) {
+//		rebuild(d.Dir, d.Children)
+//	})
+//	defer g.Stop()
+//	for e := range w.Events {
+//		g.Add(e)
+//	}
+type Grouper struct {
+	window time.Duration
+	fn     func(DirChanged)
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[string]*dirGroup // Directory → changes.
+}
+
+type dirGroup struct {
+	ch    DirChanged
+	seen  map[string]struct{}
+	seq   uint64 // Order in which the directories were added, for Flush.
+	timer *time.Timer
+}
+
+// NewGrouper creates a new Grouper, which calls fn for every directory. fn is
+// called from a new goroutine, and may be called concurrently for different
+// directories.
+//
+// The window is 100ms if it's 0 or lower.
+func NewGrouper(window time.Duration, fn func(DirChanged)) *Grouper {
+	if window <= 0 {
+		window = 100 * time.Millisecond
+	}
+	return &Grouper{
+		window:  window,
+		fn:      fn,
+		pending: make(map[string]*dirGroup),
+	}
+}
+
+// Add an event, resetting the wait for the directory it's in.
+func (g *Grouper) Add(e Event) {
+	dir := filepath.Dir(e.Name)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.pending[dir]
+	if ok {
+		p.timer.Reset(g.window)
+	} else {
+		g.seq++
+		p = &dirGroup{
+			ch:   DirChanged{Dir: dir},
+			seen: make(map[string]struct{}),
+			seq:  g.seq,
+		}
+		p.timer = time.AfterFunc(g.window, func() { g.fire(dir, p) })
+		g.pending[dir] = p
+	}
+
+	p.ch.Op |= e.Op
+	if _, ok := p.seen[e.Name]; !ok {
+		p.seen[e.Name] = struct{}{}
+		p.ch.Children = append(p.ch.Children, e.Name)
+	}
+}
+
+// fire sends the changes after the window has passed, unless they were
+// already sent by Flush or dropped by Stop.
+func (g *Grouper) fire(dir string, p *dirGroup) {
+	g.mu.Lock()
+	if g.pending[dir] != p {
+		g.mu.Unlock()
+		return
+	}
+	delete(g.pending, dir)
+	g.mu.Unlock()
+
+	g.fn(p.ch)
+}
+
+// Len returns the number of directories with pending changes.
+func (g *Grouper) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.pending)
+}
+
+// Flush sends all pending changes now, in the order of the first event for the
+// directory. fn is called from the current goroutine.
+func (g *Grouper) Flush() {
+	for _, p := range g.take() {
+		g.fn(p.ch)
+	}
+}
+
+// Stop drops all pending changes.
+func (g *Grouper) Stop() { g.take() }
+
+func (g *Grouper) take() []*dirGroup {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	all := make([]*dirGroup, 0, len(g.pending))
+	for k, p := range g.pending {
+		p.timer.Stop()
+		all = append(all, p)
+		delete(g.pending, k)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].seq < all[j].seq })
+	return all
+}
@@ -0,0 +1,71 @@
+package fsnotify
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGrouper(t *testing.T) {
+	t.Parallel()
+
+	sent := make(chan DirChanged, 10)
+	g := NewGrouper(50*time.Millisecond, func(d DirChanged) { sent <- d })
+	defer g.Stop()
+
+	var (
+		a   = filepath.Join("site", "a")
+		b   = filepath.Join("site", "b")
+		css = filepath.Join("assets", "style.css")
+	)
+	g.Add(Event{Name: a, Op: Create})
+	g.Add(Event{Name: b, Op: Write})
+	g.Add(Event{Name: a, Op: Write})
+	g.Add(Event{Name: css, Op: Write})
+	if g.Len() != 2 {
+		t.Errorf("Len() = %d; want 2", g.Len())
+	}
+
+	have := make(map[string]DirChanged)
+	for i := 0; i < 2; i++ {
+		select {
+		case d := <-sent:
+			have[d.Dir] = d
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	want := map[string]DirChanged{
+		"site":   {Dir: "site", Children: []string{a, b}, Op: Create | Write},
+		"assets": {Dir: "assets", Children: []string{css}, Op: Write},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %v\nwant: %v", have, want)
+	}
+}
+
+func TestGrouperFlush(t *testing.T) {
+	t.Parallel()
+
+	var have []DirChanged
+	g := NewGrouper(time.Hour, func(d DirChanged) { have = append(have, d) })
+	g.Add(Event{Name: filepath.Join("b", "file"), Op: Write})
+	g.Add(Event{Name: filepath.Join("a", "file"), Op: Write})
+	g.Add(Event{Name: filepath.Join("b", "file"), Op: Write})
+	g.Flush()
+
+	if len(have) != 2 || have[0].Dir != "b" || have[1].Dir != "a" {
+		t.Errorf("wrong order: %v", have)
+	}
+	if g.Len() != 0 {
+		t.Errorf("Len() = %d after Flush", g.Len())
+	}
+
+	g.Add(Event{Name: filepath.Join("a", "file"), Op: Write})
+	g.Stop()
+	g.Flush()
+	if len(have) != 2 {
+		t.Errorf("sent after Stop: %v", have)
+	}
+}
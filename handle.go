@@ -0,0 +1,70 @@
+package fsnotify
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+)
+
+// Watch is a watch added with [Watcher.AddWatch], which has its own Events
+// channel.
+type Watch struct {
+	// Events for this watch. These aren't sent on the Watcher's Events
+	// channel, or to the [WithCallback] function. The channel is closed when
+	// the watch is closed.
+	//
+	// Errors are still sent on the Watcher's Errors channel.
+	Events <-chan Event
+
+	w      *Watcher
+	path   string
+	cancel context.CancelFunc
+	once   sync.Once
+	err    error
+}
+
+// AddWatch is like [Watcher.AddWith], but returns a Watch that receives all
+// events for the path on its own channel, so that different parts of a
+// program can own their watches without splitting up the Events channel by
+// path.
+//
+// The Watch is closed when the context of [WithContext] is done or when the
+// Watcher is closed. With [WithTTL] the [Expired] event is sent to the Watch,
+// which should still be closed after that.
+func (w *Watcher) AddWatch(name string, opts ...addOpt) (*Watch, error) {
+	parent := getOptions(opts...).ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+
+	path := filepath.Clean(w.addPath(name, opts))
+	h := &Watch{w: w, path: path, cancel: cancel}
+	h.Events = w.subs.subscribe(ctx, func(e Event) bool { return isFor(path, e) }, true)
+
+	// Remove the watch when ctx is done; this also replaces any WithContext.
+	err := w.AddWith(name, append(opts, WithContext(ctx))...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Path returns the path passed to AddWatch.
+func (h *Watch) Path() string { return h.path }
+
+// Close removes the watch and closes the Events channel.
+//
+// It's not an error if the watch was already removed.
+func (h *Watch) Close() error {
+	h.once.Do(func() {
+		err := h.w.Remove(h.path)
+		if !errors.Is(err, ErrNonExistentWatch) {
+			h.err = err
+		}
+		h.cancel()
+	})
+	return h.err
+}
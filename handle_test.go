@@ -0,0 +1,76 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddWatch(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "a", noWait)
+	mkdir(t, tmp, "b", noWait)
+
+	w, err := NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, join(tmp, "b"))
+
+	a, err := w.AddWatch(join(tmp, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Path() != join(tmp, "a") {
+		t.Errorf("Path() = %q", a.Path())
+	}
+
+	touch(t, tmp, "a", "file")
+	touch(t, tmp, "b", "file")
+
+	timeout := time.After(2 * time.Second)
+	var haveA, haveW []string
+	for len(haveA) == 0 || len(haveW) == 0 {
+		select {
+		case e := <-a.Events:
+			haveA = append(haveA, e.Name)
+		case e := <-w.Events:
+			haveW = append(haveW, e.Name)
+		case <-timeout:
+			t.Fatalf("timeout: Watch=%v Watcher=%v", haveA, haveW)
+		}
+	}
+	// Wait for any other events.
+	haveW = append(haveW, eventNames(eventsFor(t, w, 100*time.Millisecond))...)
+
+	if len(haveA) != 1 || haveA[0] != join(tmp, "a", "file") {
+		t.Errorf("wrong events on the Watch: %v", haveA)
+	}
+	for _, n := range haveW {
+		if n != join(tmp, "b", "file") {
+			t.Errorf("wrong event on the Watcher: %v", n)
+		}
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range a.Events {
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != join(tmp, "b") {
+		t.Errorf("WatchList() = %q", l)
+	}
+}
+
+func eventNames(events []Event) []string {
+	names := make([]string, 0, len(events))
+	for _, e := range events {
+		names = append(names, e.Name)
+	}
+	return names
+}
@@ -0,0 +1,170 @@
+package fsnotify
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// IdempotencyKey identifies a logical change to a file: the path, the file ID
+// (device and inode, or volume and file index on Windows) and the change
+// generation (size and modification time).
+//
+// Many events can map to the same key (e.g. several Write events for one
+// write), and the key changes if the file is replaced by another file with the
+// same name, so it can be used to process every version of a file once even
+// when events are duplicated or the process is restarted; see [ProcessOnce]
+// and [ProcessAtLeastOnce] for what "once" means if the process crashes.
+type IdempotencyKey string
+
+// KeyFor gets the idempotency key for the current state of the file in the
+// event. It returns an error wrapping [os.ErrNotExist] if the file no longer
+// exists, which is always the case for Remove and Rename events.
+func KeyFor(e Event) (IdempotencyKey, error) {
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	dev, ino, err := fileID(name, fi)
+	if err != nil {
+		return "", fmt.Errorf("fsnotify.KeyFor: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(name))
+	var b [32]byte
+	binary.LittleEndian.PutUint64(b[0:], dev)
+	binary.LittleEndian.PutUint64(b[8:], ino)
+	binary.LittleEndian.PutUint64(b[16:], uint64(fi.Size()))
+	binary.LittleEndian.PutUint64(b[24:], uint64(fi.ModTime().UnixNano()))
+	h.Write(b[:])
+	return IdempotencyKey(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// KeyStore records which keys have been processed. Implementations should
+// persist the keys (e.g. in a database) so they're remembered across restarts.
+type KeyStore interface {
+	// Claim marks the key as processed, returning false if it already was.
+	// This must be atomic if the store is shared between processes.
+	Claim(IdempotencyKey) (bool, error)
+
+	// Release undoes a Claim, so the key can be processed again.
+	Release(IdempotencyKey) error
+}
+
+// KeyChecker is implemented by a KeyStore that can check if a key was claimed
+// without claiming it; this is needed for [ProcessAtLeastOnce].
+type KeyChecker interface {
+	// Claimed reports if the key was claimed.
+	Claimed(IdempotencyKey) (bool, error)
+}
+
+// MemoryKeyStore is a KeyStore that keeps the keys in memory.
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[IdempotencyKey]struct{}
+}
+
+// Claim marks the key as processed, returning false if it already was.
+func (s *MemoryKeyStore) Claim(k IdempotencyKey) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[IdempotencyKey]struct{})
+	}
+	if _, ok := s.keys[k]; ok {
+		return false, nil
+	}
+	s.keys[k] = struct{}{}
+	return true, nil
+}
+
+// Release undoes a Claim, so the key can be processed again.
+func (s *MemoryKeyStore) Release(k IdempotencyKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, k)
+	return nil
+}
+
+// Claimed reports if the key was claimed.
+func (s *MemoryKeyStore) Claimed(k IdempotencyKey) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.keys[k]
+	return ok, nil
+}
+
+// ProcessOnce runs fn for the event, unless the current version of the file
+// was already processed according to the store. The key is released if fn
+// returns an error, so it will be retried on the next event.
+//
+// The key is claimed before fn runs, so concurrent events for the same version
+// never run fn twice, but this is at-most-once processing: if the process
+// crashes while fn is running the key stays claimed and that version is never
+// processed. Use [ProcessAtLeastOnce] if fn must always complete.
+//
+// Events for files that no longer exist are skipped, as there is nothing to
+// process; it returns false in that case and if the key was already claimed.
+func ProcessOnce(store KeyStore, e Event, fn func(Event, IdempotencyKey) error) (bool, error) {
+	k, err := KeyFor(e)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	ok, err := store.Claim(k)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := fn(e, k); err != nil {
+		if rerr := store.Release(k); rerr != nil {
+			return true, fmt.Errorf("%w (releasing key: %s)", err, rerr)
+		}
+		return true, err
+	}
+	return true, nil
+}
+
+// ProcessAtLeastOnce is like [ProcessOnce], but only claims the key after fn
+// succeeds, so a version of the file that was being processed when the process
+// crashed is processed again on the next event. The store must implement
+// [KeyChecker].
+//
+// This means fn may run more than once for the same version: after a crash, or
+// if events for it are processed concurrently. fn should be idempotent, for
+// example by using the key to deduplicate in the system it writes to.
+func ProcessAtLeastOnce(store KeyStore, e Event, fn func(Event, IdempotencyKey) error) (bool, error) {
+	check, ok := store.(KeyChecker)
+	if !ok {
+		return false, fmt.Errorf("fsnotify.ProcessAtLeastOnce: %T doesn't implement KeyChecker", store)
+	}
+	k, err := KeyFor(e)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	done, err := check.Claimed(k)
+	if err != nil || done {
+		return false, err
+	}
+	if err := fn(e, k); err != nil {
+		return true, err
+	}
+	_, err = store.Claim(k)
+	return true, err
+}
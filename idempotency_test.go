@@ -0,0 +1,90 @@
+package fsnotify
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProcessOnce(t *testing.T) {
+	var (
+		tmp   = t.TempDir()
+		file  = join(tmp, "file")
+		store = new(MemoryKeyStore)
+		n     int
+		fn    = func(Event, IdempotencyKey) error { n++; return nil }
+		e     = Event{Name: file, Op: opWrite}
+	)
+	cat(t, "data", file)
+
+	for i := 0; i < 3; i++ {
+		if _, err := ProcessOnce(store, e, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 1 {
+		t.Fatalf("processed %d times; want 1", n)
+	}
+
+	// New version of the file.
+	time.Sleep(10 * time.Millisecond)
+	cat(t, "more data", file)
+	if ok, err := ProcessOnce(store, e, fn); err != nil || !ok {
+		t.Fatalf("not processed after change: %t, %v", ok, err)
+	}
+
+	// Errors release the key.
+	cat(t, "even more data", file)
+	fail := errors.New("oops")
+	if _, err := ProcessOnce(store, e, func(Event, IdempotencyKey) error { return fail }); !errors.Is(err, fail) {
+		t.Fatalf("wrong error: %v", err)
+	}
+	if ok, _ := ProcessOnce(store, e, fn); !ok {
+		t.Fatal("not retried after error")
+	}
+
+	// Gone.
+	rm(t, file)
+	if ok, err := ProcessOnce(store, e, fn); err != nil || ok {
+		t.Fatalf("removed file: %t, %v", ok, err)
+	}
+}
+
+func TestProcessAtLeastOnce(t *testing.T) {
+	var (
+		tmp   = t.TempDir()
+		file  = join(tmp, "file")
+		store = new(MemoryKeyStore)
+		n     int
+		fn    = func(Event, IdempotencyKey) error { n++; return nil }
+		e     = Event{Name: file, Op: opWrite}
+	)
+	cat(t, "data", file)
+
+	// A failure (or crash) doesn't claim the key.
+	fail := errors.New("oops")
+	if _, err := ProcessAtLeastOnce(store, e, func(Event, IdempotencyKey) error { return fail }); !errors.Is(err, fail) {
+		t.Fatalf("wrong error: %v", err)
+	}
+	k, err := KeyFor(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.Claimed(k); ok {
+		t.Fatal("key claimed after error")
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := ProcessAtLeastOnce(store, e, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n != 1 {
+		t.Fatalf("processed %d times; want 1", n)
+	}
+
+	type claimOnly struct{ KeyStore }
+	if _, err := ProcessAtLeastOnce(claimOnly{store}, e, fn); err == nil {
+		t.Fatal("no error for store without KeyChecker")
+	}
+}
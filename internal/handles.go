@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// The backends record the file descriptors and handles they open and close
+// here, so fsnotifytest.VerifyNoLeaks can check they're all closed again.
+
+// Handle is a file descriptor or handle opened by a backend.
+type Handle struct {
+	Kind string      // "inotify", "kqueue", "watch", etc.
+	ID   interface{} // The fd or handle; anything comparable.
+}
+
+func (h Handle) String() string { return fmt.Sprintf("%s %v", h.Kind, h.ID) }
+
+var handles = struct {
+	mu   sync.Mutex
+	open map[Handle]struct{}
+}{open: make(map[Handle]struct{})}
+
+// Opened records that a handle was opened.
+func Opened(kind string, id interface{}) {
+	handles.mu.Lock()
+	defer handles.mu.Unlock()
+	handles.open[Handle{Kind: kind, ID: id}] = struct{}{}
+}
+
+// Closed records that a handle was closed.
+func Closed(kind string, id interface{}) {
+	handles.mu.Lock()
+	defer handles.mu.Unlock()
+	delete(handles.open, Handle{Kind: kind, ID: id})
+}
+
+// OpenHandles returns all handles that are currently open, sorted by kind.
+func OpenHandles() []Handle {
+	handles.mu.Lock()
+	defer handles.mu.Unlock()
+	open := make([]Handle, 0, len(handles.open))
+	for h := range handles.open {
+		open = append(open, h)
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].String() < open[j].String() })
+	return open
+}
@@ -0,0 +1,506 @@
+package fsnotify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JournalRecord is a single event in a journal, with the time it was
+// delivered. Journals are stored as newline-delimited JSON with one record per
+// line.
+type JournalRecord struct {
+	Time time.Time `json:"time"`
+	Op   Op        `json:"op"`
+	Name string    `json:"name"`
+	Root string    `json:"root,omitempty"`
+}
+
+// Event gets the event for this record.
+func (r JournalRecord) Event() Event { return Event{Name: r.Name, Op: r.Op, Root: r.Root} }
+
+// Retention describes which journal records to keep; the zero value keeps
+// everything. Use [Journal.SetRetention] to apply it to a journal file, or
+// [ApplyRetention] for records that were read already.
+type Retention struct {
+	// MaxAge is the maximum age of records; older records are removed.
+	MaxAge time.Duration
+
+	// MaxSize is the maximum size of the journal in bytes, before
+	// compression; the oldest records are removed until the journal fits.
+	MaxSize int64
+
+	// Compact collapses superseded events per path; see [CompactRecords].
+	Compact bool
+}
+
+// ApplyRetention returns the records to keep according to the retention
+// policy, relative to the time now. The records must be ordered by time, and
+// the returned slice may share memory with the input.
+func ApplyRetention(records []JournalRecord, r Retention, now time.Time) []JournalRecord {
+	if r.MaxAge > 0 {
+		cutoff := now.Add(-r.MaxAge)
+		i := sort.Search(len(records), func(i int) bool { return !records[i].Time.Before(cutoff) })
+		records = records[i:]
+	}
+	if r.Compact {
+		records = CompactRecords(records)
+	}
+	if r.MaxSize > 0 {
+		var (
+			size int64
+			i    = len(records)
+		)
+		for i > 0 {
+			s := recordSize(records[i-1])
+			if size+s > r.MaxSize {
+				break
+			}
+			size += s
+			i--
+		}
+		records = records[i:]
+	}
+	return records
+}
+
+// CompactRecords collapses superseded records: only the last record for every
+// path is kept, with the Op of all events since the path was last removed or
+// renamed. If the last event was a remove or rename it's kept as-is, since the
+// earlier events no longer matter.
+//
+// This keeps enough information to know which paths changed and how they ended
+// up, which is all most consumers replaying a journal care about. The order of
+// the remaining records is kept.
+func CompactRecords(records []JournalRecord) []JournalRecord {
+	var (
+		last       = make(map[string]int, len(records)) // path → index in out
+		out        = make([]JournalRecord, 0, len(records))
+		superseded = make([]bool, len(records))
+	)
+	for _, r := range records {
+		prev, ok := last[r.Name]
+		if ok {
+			if r.Op&(opRemove|opRename) == 0 && out[prev].Op&(opRemove|opRename) == 0 {
+				r.Op |= out[prev].Op
+			}
+			superseded[prev] = true
+		}
+		last[r.Name] = len(out)
+		out = append(out, r)
+	}
+
+	n := 0
+	for i, r := range out {
+		if !superseded[i] {
+			out[n] = r
+			n++
+		}
+	}
+	return out[:n]
+}
+
+// RetainedWindow returns the time of the first and last record.
+func RetainedWindow(records []JournalRecord) (from, to time.Time) {
+	if len(records) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	return records[0].Time, records[len(records)-1].Time
+}
+
+func recordSize(r JournalRecord) int64 {
+	b, _ := json.Marshal(r)
+	return int64(len(b)) + 1 // Newline.
+}
+
+// WithJournal appends every event that's delivered to the journal. This is only
+// used by [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// An event is delivered once it's put on the Events channel, or passed to the
+// [WithCallback] function or a subscription that owns it. Events that are
+// dropped or merged by [WithBackpressure] aren't written, except that
+// BackpressureDropOldest may still drop an event from the channel after it was
+// written.
+//
+// Errors writing to the journal are returned by [Journal.Close]. The journal
+// isn't closed when the watcher is closed.
+func WithJournal(j *Journal) addOpt {
+	return func(opt *withOpts) { opt.journal = j }
+}
+
+// Journal appends events to a journal file, for debugging missed events or
+// replaying them in tests with [JournalReader].
+//
+// The journal grows without limit unless a retention policy is set with
+// [Journal.SetRetention].
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	c    Compression
+	ret  Retention
+	fp   *os.File
+	w    io.WriteCloser
+	enc  *json.Encoder
+	err  error
+	now  func() time.Time
+
+	// What's in the file, for the retention policy and Window.
+	size        int64 // Uncompressed size of the records.
+	first, last time.Time
+}
+
+// OpenJournal opens the journal at path for appending, creating it if it
+// doesn't exist. The existing records are read to know the retained window.
+//
+// Records are compressed with c. Every OpenJournal starts a new compressed
+// stream, which is fine for gzip, but the same compression should be used
+// every time the journal is opened.
+//
+// If the process crashed the last stream was never finished, and a new stream
+// can't be appended to it; in that case the records that can be read are
+// written to a new file first.
+func OpenJournal(path string, c Compression) (*Journal, error) {
+	j := &Journal{path: path, c: c, now: time.Now}
+	records, finished, err := readJournal(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if !finished {
+		if err := writeJournal(path, c, records); err != nil {
+			return nil, err
+		}
+	}
+	j.setWindow(records)
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// open the file for appending; j.mu must be held.
+func (j *Journal) open() error {
+	fp, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	w, err := compressWriter(fp, j.c)
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	j.fp, j.w, j.enc = fp, w, json.NewEncoder(w)
+	return nil
+}
+
+func (j *Journal) setWindow(records []JournalRecord) {
+	j.size = 0
+	for _, r := range records {
+		j.size += recordSize(r)
+	}
+	j.first, j.last = RetainedWindow(records)
+}
+
+// SetRetention sets the retention policy for the journal, and applies it right
+// away with [Journal.Compact].
+//
+// After this the policy is applied again by Write once the journal is 1.5 times
+// MaxSize, or once the oldest record is 1.5 times MaxAge old, so that the file
+// isn't rewritten on every event. Retention.Compact is only applied along with
+// these, or when Compact is called.
+func (j *Journal) SetRetention(r Retention) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.ret = r
+	return j.compact()
+}
+
+// Compact applies the retention policy set with [Journal.SetRetention] now,
+// rewriting the file with only the records to keep. It's a no-op if no policy
+// is set.
+//
+// The file is replaced atomically, so readers that have the journal open
+// keep reading the old records.
+func (j *Journal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compact()
+}
+
+// compact the journal; j.mu must be held.
+func (j *Journal) compact() error {
+	if j.err != nil {
+		return j.err
+	}
+	if j.fp == nil {
+		return ErrClosed
+	}
+	if j.ret == (Retention{}) {
+		return nil
+	}
+
+	// Finish the compressed stream, so all records can be read.
+	j.err = j.w.Close()
+	if err := j.fp.Close(); j.err == nil {
+		j.err = err
+	}
+	j.fp = nil
+	if j.err != nil {
+		return j.err
+	}
+
+	records, _, err := readJournal(j.path)
+	if err == nil {
+		records = ApplyRetention(records, j.ret, j.now())
+		err = writeJournal(j.path, j.c, records)
+	}
+	if err == nil {
+		j.setWindow(records)
+		err = j.open()
+	}
+	j.err = err
+	return j.err
+}
+
+// due reports if the retention policy should be applied before writing a
+// record at now; j.mu must be held.
+func (j *Journal) due(now time.Time) bool {
+	return (j.ret.MaxSize > 0 && j.size > j.ret.MaxSize+j.ret.MaxSize/2) ||
+		(j.ret.MaxAge > 0 && !j.first.IsZero() && now.Sub(j.first) > j.ret.MaxAge+j.ret.MaxAge/2)
+}
+
+// Window returns the time of the first and last record in the journal file.
+// Both are zero if the journal is empty.
+func (j *Journal) Window() (from, to time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.first, j.last
+}
+
+// Records reads the records in the journal file with a time between from and
+// to (inclusive). A zero from or to isn't used as a limit, so Records({}, {})
+// returns everything in the retained window.
+func (j *Journal) Records(from, to time.Time) ([]JournalRecord, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return nil, j.err
+	}
+
+	records, _, err := readJournal(j.path)
+	if err != nil {
+		return nil, err
+	}
+	out := records[:0]
+	for _, r := range records {
+		if (from.IsZero() || !r.Time.Before(from)) && (to.IsZero() || !r.Time.After(to)) {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// readJournal reads all records from the journal file at path, and reports if
+// the file ends with a finished compressed stream and a complete record.
+func readJournal(path string) (records []JournalRecord, finished bool, err error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, true, err
+	}
+	defer fp.Close()
+	r, err := NewJournalReader(fp)
+	if err != nil {
+		if err == io.EOF { // Empty compressed file.
+			return nil, true, nil
+		}
+		if err == io.ErrUnexpectedEOF { // Crashed before the header was written.
+			return nil, false, nil
+		}
+		return nil, true, err
+	}
+	defer r.Close()
+
+	for {
+		rec, err := r.Next()
+		switch {
+		case err == io.EOF:
+			return records, true, nil
+		case err == io.ErrUnexpectedEOF:
+			// The compressed stream of a journal that's still open (or
+			// was open when the process crashed) isn't finished;
+			// everything up to the last flush can be read.
+			return records, false, nil
+		case err != nil:
+			return nil, true, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// writeJournal replaces the journal file at path with the records.
+func writeJournal(path string, c Compression, records []JournalRecord) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w, err := compressWriter(tmp, c)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err = enc.Encode(r); err != nil {
+			break
+		}
+	}
+	if err2 := w.Close(); err == nil {
+		err = err2
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Write appends the event to the journal, with the current time.
+//
+// Compressed records are flushed after every write, so the journal is complete
+// up to the last event if the process crashes (but not if the system crashes,
+// as the file isn't synced).
+func (j *Journal) Write(e Event) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return j.err
+	}
+	if j.fp == nil {
+		return ErrClosed
+	}
+
+	now := j.now()
+	if j.due(now) {
+		if err := j.compact(); err != nil {
+			return err
+		}
+	}
+	rec := JournalRecord{Time: now, Op: e.Op, Name: e.Name, Root: e.Root}
+	j.err = j.enc.Encode(rec)
+	if f, ok := j.w.(interface{ Flush() error }); ok && j.err == nil {
+		j.err = f.Flush()
+	}
+	if j.err == nil {
+		j.size += recordSize(rec)
+		if j.first.IsZero() {
+			j.first = now
+		}
+		j.last = now
+	}
+	return j.err
+}
+
+// Close the journal, returning the first error from Write if there was one.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.fp == nil {
+		return j.err
+	}
+	err := j.w.Close()
+	if err2 := j.fp.Close(); err == nil {
+		err = err2
+	}
+	j.fp = nil
+	if j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
+
+// JournalReader reads the records from a journal written with [Journal].
+type JournalReader struct {
+	r   io.ReadCloser
+	dec *json.Decoder
+	err error
+}
+
+// NewJournalReader reads a journal from r; the compression is detected
+// automatically.
+func NewJournalReader(r io.Reader) (*JournalReader, error) {
+	rd, err := decompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &JournalReader{r: rd, dec: json.NewDecoder(rd)}, nil
+}
+
+// Next reads the next record, returning [io.EOF] at the end of the journal.
+func (r *JournalReader) Next() (JournalRecord, error) {
+	var rec JournalRecord
+	err := r.dec.Decode(&rec)
+	return rec, err
+}
+
+// Replay sends all events in the journal on the returned channel, which is
+// closed at the end of the journal or when ctx is cancelled. Use
+// [JournalReader.Err] to check if an error occurred after the channel is
+// closed.
+//
+// If realtime is set the events are sent with the same delays between them as
+// when they were recorded, and otherwise as fast as they're read.
+func (r *JournalReader) Replay(ctx context.Context, realtime bool) <-chan Event {
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		var prev time.Time
+		for {
+			rec, err := r.Next()
+			if err != nil {
+				if err != io.EOF {
+					r.err = err
+				}
+				return
+			}
+			if realtime && !prev.IsZero() && rec.Time.After(prev) {
+				t := time.NewTimer(rec.Time.Sub(prev))
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+					r.err = ctx.Err()
+					return
+				}
+			}
+			prev = rec.Time
+
+			select {
+			case ch <- rec.Event():
+			case <-ctx.Done():
+				r.err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Err returns the error that stopped [JournalReader.Replay], if any. It's only
+// valid after the channel returned by Replay is closed.
+func (r *JournalReader) Err() error { return r.err }
+
+// Close the reader; this doesn't close the io.Reader passed to
+// NewJournalReader.
+func (r *JournalReader) Close() error { return r.r.Close() }
@@ -0,0 +1,338 @@
+package fsnotify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyRetention(t *testing.T) {
+	var (
+		now = time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+		rec = func(ago time.Duration, op Op, name string) JournalRecord {
+			return JournalRecord{Time: now.Add(-ago), Op: op, Name: name}
+		}
+		records = []JournalRecord{
+			rec(time.Hour, opCreate, "/a"),
+			rec(50*time.Minute, opWrite, "/a"),
+			rec(40*time.Minute, opCreate, "/b"),
+			rec(30*time.Minute, opWrite, "/a"),
+			rec(20*time.Minute, opRemove, "/b"),
+			rec(10*time.Minute, opCreate, "/c"),
+		}
+	)
+
+	tests := []struct {
+		name string
+		r    Retention
+		want []JournalRecord
+	}{
+		{"keep everything", Retention{}, records},
+		{"max age", Retention{MaxAge: 35 * time.Minute}, records[3:]},
+		{"max size", Retention{MaxSize: recordSize(records[4]) + recordSize(records[5])}, records[4:]},
+		{"compact", Retention{Compact: true}, []JournalRecord{
+			rec(30*time.Minute, opCreate|opWrite, "/a"),
+			rec(20*time.Minute, opRemove, "/b"),
+			rec(10*time.Minute, opCreate, "/c"),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := append([]JournalRecord(nil), records...)
+			have := ApplyRetention(in, tt.r, now)
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nhave: %v\nwant: %v", have, tt.want)
+			}
+		})
+	}
+
+	from, to := RetainedWindow(records)
+	if !from.Equal(records[0].Time) || !to.Equal(records[5].Time) {
+		t.Errorf("wrong window: %s – %s", from, to)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	for _, c := range []Compression{CompressionNone, CompressionGzip} {
+		c := c
+		t.Run(string(c), func(t *testing.T) {
+			t.Parallel()
+			path := join(t.TempDir(), "journal")
+			want := []Event{
+				{Name: "/a", Op: opCreate},
+				{Name: "/a", Op: opWrite},
+				{Name: "b", Op: opRemove, Root: "/dir"},
+			}
+
+			// Written in two sessions.
+			for _, events := range [][]Event{want[:2], want[2:]} {
+				j, err := OpenJournal(path, c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, e := range events {
+					if err := j.Write(e); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := j.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fp, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fp.Close()
+			r, err := NewJournalReader(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			var have []Event
+			for e := range r.Replay(context.Background(), false) {
+				have = append(have, e)
+			}
+			if err := r.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("\nhave: %v\nwant: %v", have, want)
+			}
+		})
+	}
+}
+
+func TestJournalCrash(t *testing.T) {
+	comps := []Compression{CompressionNone}
+	compressors.RLock()
+	for c := range compressors.m {
+		comps = append(comps, c)
+	}
+	compressors.RUnlock()
+
+	for _, c := range comps {
+		c := c
+		t.Run(string(c), func(t *testing.T) {
+			t.Parallel()
+			path := join(t.TempDir(), "journal")
+
+			// Crash twice without closing the journal, so the compressed
+			// stream is never finished, and then close it normally.
+			var want []string
+			for i := 0; i < 3; i++ {
+				j, err := OpenJournal(path, c)
+				if err != nil {
+					t.Fatalf("open %d: %s", i, err)
+				}
+				name := fmt.Sprintf("/file%d", i)
+				if err := j.Write(Event{Name: name, Op: opCreate}); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, name)
+
+				if i < 2 {
+					j.fp.Close() // Crash.
+					continue
+				}
+				if err := j.SetRetention(Retention{MaxSize: 1 << 20}); err != nil {
+					t.Fatal(err)
+				}
+				if err := j.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			j, err := OpenJournal(path, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.Close()
+			records, err := j.Records(time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var have []string
+			for _, r := range records {
+				have = append(have, r.Name)
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("\nhave: %v\nwant: %v", have, want)
+			}
+		})
+	}
+}
+
+func TestJournalRetention(t *testing.T) {
+	for _, c := range []Compression{CompressionNone, CompressionGzip} {
+		c := c
+		t.Run(string(c), func(t *testing.T) {
+			t.Parallel()
+			path := join(t.TempDir(), "journal")
+			j, err := OpenJournal(path, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.Close()
+
+			start := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+			now := start
+			j.now = func() time.Time { return now }
+			write := func(name string, op Op) {
+				t.Helper()
+				if err := j.Write(Event{Name: name, Op: op}); err != nil {
+					t.Fatal(err)
+				}
+				now = now.Add(time.Minute)
+			}
+			for i := 0; i < 10; i++ {
+				write(fmt.Sprintf("/file%d", i), opCreate)
+			}
+
+			// Records 0 to 9 are 10 to 1 minutes old.
+			if err := j.SetRetention(Retention{MaxAge: 5 * time.Minute}); err != nil {
+				t.Fatal(err)
+			}
+			from, to := j.Window()
+			if !from.Equal(start.Add(5*time.Minute)) || !to.Equal(start.Add(9*time.Minute)) {
+				t.Errorf("wrong window: %s – %s", from, to)
+			}
+
+			// Applied again once the oldest record is 7.5 minutes old.
+			write("/file5", opWrite)
+			write("/file5", opWrite)
+			write("/file5", opWrite)
+			if have, _ := j.Window(); !have.Equal(from) {
+				t.Errorf("compacted too early: %s", have)
+			}
+			write("/file5", opWrite)
+			if have, _ := j.Window(); !have.Equal(start.Add(8 * time.Minute)) {
+				t.Errorf("not compacted: %s", have)
+			}
+
+			have, err := j.Records(start.Add(9*time.Minute), start.Add(11*time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []JournalRecord{
+				{Time: start.Add(9 * time.Minute), Op: opCreate, Name: "/file9"},
+				{Time: start.Add(10 * time.Minute), Op: opWrite, Name: "/file5"},
+				{Time: start.Add(11 * time.Minute), Op: opWrite, Name: "/file5"},
+			}
+			if !reflect.DeepEqual(have, want) {
+				t.Errorf("\nhave: %v\nwant: %v", have, want)
+			}
+
+			// Compaction keeps the last record for every path.
+			if err := j.SetRetention(Retention{Compact: true}); err != nil {
+				t.Fatal(err)
+			}
+			have, err = j.Records(time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(have) != 3 || have[2].Name != "/file5" || have[2].Op != opWrite {
+				t.Errorf("wrong records after compacting: %v", have)
+			}
+
+			// Still appends after compacting, and the records are kept when
+			// opened again.
+			write("/file10", opCreate)
+			if err := j.Close(); err != nil {
+				t.Fatal(err)
+			}
+			j, err = OpenJournal(path, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, err = j.Records(time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(have) != 4 || have[3].Name != "/file10" {
+				t.Errorf("wrong records after opening: %v", have)
+			}
+			if from, to := j.Window(); !from.Equal(have[0].Time) || !to.Equal(have[3].Time) {
+				t.Errorf("wrong window: %s – %s", from, to)
+			}
+		})
+	}
+}
+
+func TestWithJournal(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	path := join(t.TempDir(), "journal")
+	j, err := OpenJournal(path, CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWatcherWith(WithJournal(j))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add(tmp); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, tmp, "file", noWait)
+	select {
+	case <-w.Events:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	r, err := NewJournalReader(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Name != join(tmp, "file") || rec.Time.IsZero() {
+		t.Errorf("wrong record: %+v", rec)
+	}
+}
+
+// Events that are dropped because of the backpressure aren't written.
+func TestJournalDropped(t *testing.T) {
+	t.Parallel()
+
+	j, err := OpenJournal(join(t.TempDir(), "journal"), CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	d := newDelivery(withOpts{backpressure: BackpressureDropNewest, journal: j})
+	ch := make(chan Event, 1)
+	d.send(ch, Event{Name: "/a", Op: opCreate})
+	d.send(ch, Event{Name: "/b", Op: opCreate})
+
+	have, err := j.Records(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have) != 1 || have[0].Name != "/a" {
+		t.Errorf("wrong records: %v", have)
+	}
+}
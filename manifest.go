@@ -0,0 +1,267 @@
+package fsnotify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManifestFile is the name of the manifest file in every directory.
+const ManifestFile = ".fsnotify-manifest"
+
+// ManifestEntry describes a single file in a manifest.
+type ManifestEntry struct {
+	Hash    string    `json:"hash"` // Hex-encoded SHA-256 of the contents.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// Manifest lists the regular files in a directory by name, not including
+// subdirectories or the manifest file itself.
+type Manifest map[string]ManifestEntry
+
+// ReadManifest reads the manifest file in dir. It returns an error wrapping
+// [os.ErrNotExist] if there is no manifest.
+func ReadManifest(dir string) (Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := make(Manifest)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("fsnotify.ReadManifest: %s: %w", dir, err)
+	}
+	return m, nil
+}
+
+// Write the manifest to the manifest file in dir. The file is replaced
+// atomically, so readers never see a partial manifest.
+func (m Manifest) Write(dir string) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ManifestFile+".tmp")
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, ManifestFile))
+}
+
+// Diff compares the manifest with other (e.g. the manifest of a copy that's
+// being transferred), returning the names that are only in other, the names
+// that have a different hash, and the names that are only in m.
+func (m Manifest) Diff(other Manifest) (added, changed, removed []string) {
+	for name, e := range other {
+		have, ok := m[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case have.Hash != e.Hash:
+			changed = append(changed, name)
+		}
+	}
+	for name := range m {
+		if _, ok := other[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
+
+// ScanManifest creates a manifest for dir by hashing all files in it.
+func ScanManifest(dir string) (Manifest, error) {
+	ls, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := make(Manifest, len(ls))
+	for _, f := range ls {
+		if !f.Type().IsRegular() || isManifestFile(f.Name()) {
+			continue
+		}
+		e, err := manifestEntry(filepath.Join(dir, f.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		m[f.Name()] = e
+	}
+	return m, nil
+}
+
+// VerifyManifest checks the files in dir against the manifest file, returning
+// the names of files that were added, changed, or removed since the manifest
+// was written. This reads all files.
+func VerifyManifest(dir string) (added, changed, removed []string, err error) {
+	want, err := ReadManifest(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	have, err := ScanManifest(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	added, changed, removed = want.Diff(have)
+	return added, changed, removed, nil
+}
+
+func isManifestFile(name string) bool {
+	return name == ManifestFile || name == ManifestFile+".tmp"
+}
+
+// manifestEntry gets the entry for path, or an empty entry if it's not a
+// regular file.
+func manifestEntry(path string) (ManifestEntry, error) {
+	// Check first, as opening a FIFO would block.
+	if st, err := os.Lstat(path); err != nil || !st.Mode().IsRegular() {
+		return ManifestEntry{}, err
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer fp.Close()
+	st, err := fp.Stat()
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return ManifestEntry{}, err
+	}
+	return ManifestEntry{
+		Hash:    hex.EncodeToString(h.Sum(nil)),
+		Size:    st.Size(),
+		ModTime: st.ModTime(),
+	}, nil
+}
+
+// Manifests keeps the manifest files of watched directories up to date from
+// events, so that they never need to be fully rescanned.
+//
+// Manifests are loaded the first time an event for the directory is seen (or
+// created with [ScanManifest] if the directory doesn't have one yet), and only
+// written on [Manifests.Flush], so that a file that's written many times is
+// only hashed on every event, and not written to disk every time.
+type Manifests struct {
+	mu    sync.Mutex
+	dirs  map[string]Manifest
+	dirty map[string]struct{}
+}
+
+// NewManifests creates a new Manifests.
+func NewManifests() *Manifests {
+	return &Manifests{
+		dirs:  make(map[string]Manifest),
+		dirty: make(map[string]struct{}),
+	}
+}
+
+// Update the manifest for the directory of the file in the event.
+//
+// Events for directories and for the manifest files are ignored. Files that no
+// longer exist are removed from the manifest.
+func (m *Manifests) Update(e Event) error {
+	path := e.Name
+	if e.Root != "" {
+		path = filepath.Join(e.Root, e.Name)
+	}
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	if isManifestFile(name) {
+		return nil
+	}
+
+	entry, err := manifestEntry(path)
+	gone := errors.Is(err, os.ErrNotExist)
+	if err != nil && !gone {
+		return err
+	}
+	if !gone && entry.Hash == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	man, err := m.load(dir)
+	if err != nil {
+		if gone && errors.Is(err, os.ErrNotExist) { // Directory was removed.
+			return nil
+		}
+		return err
+	}
+	if gone {
+		if _, ok := man[name]; ok {
+			delete(man, name)
+			m.dirty[dir] = struct{}{}
+		}
+		return nil
+	}
+	if man[name] != entry {
+		man[name] = entry
+		m.dirty[dir] = struct{}{}
+	}
+	return nil
+}
+
+// Get the current manifest for dir; this may be newer than what's on disk.
+func (m *Manifests) Get(dir string) (Manifest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	man, err := m.load(filepath.Clean(dir))
+	if err != nil {
+		return nil, err
+	}
+	cp := make(Manifest, len(man))
+	for k, v := range man {
+		cp[k] = v
+	}
+	return cp, nil
+}
+
+// Flush writes all manifests that changed since the last Flush.
+func (m *Manifests) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir := range m.dirty {
+		err := m.dirs[dir].Write(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		delete(m.dirty, dir)
+		if err != nil { // Directory was removed.
+			delete(m.dirs, dir)
+		}
+	}
+	return nil
+}
+
+func (m *Manifests) load(dir string) (Manifest, error) {
+	if man, ok := m.dirs[dir]; ok {
+		return man, nil
+	}
+	man, err := ReadManifest(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		man, err = ScanManifest(dir)
+		m.dirty[dir] = struct{}{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.dirs[dir] = man
+	return man, nil
+}
@@ -0,0 +1,82 @@
+package fsnotify
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestManifests(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	cat(t, "one", tmp, "one")
+	cat(t, "two", tmp, "two")
+	mkdir(t, tmp, "sub")
+
+	if _, err := ReadManifest(tmp); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	m := NewManifests()
+	cat(t, "three", tmp, "three")
+	if err := m.Update(Event{Name: join(tmp, "three"), Op: Create}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Update(Event{Name: join(tmp, "sub"), Op: Create}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := ReadManifest(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ScanManifest(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have) != 3 || !reflect.DeepEqual(manifestNames(have), manifestNames(want)) {
+		t.Fatalf("wrong manifest:\nhave: %v\nwant: %v", have, want)
+	}
+
+	// Changes are only written on Flush.
+	cat(t, "changed", tmp, "one")
+	rm(t, tmp, "two")
+	for _, n := range []string{"one", "two", ManifestFile} {
+		if err := m.Update(Event{Name: join(tmp, n), Op: Write}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	added, changed, removed, err := VerifyManifest(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 0 || !reflect.DeepEqual(changed, []string{"one"}) || !reflect.DeepEqual(removed, []string{"two"}) {
+		t.Errorf("wrong verify result: added=%v changed=%v removed=%v", added, changed, removed)
+	}
+
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	added, changed, removed, err = VerifyManifest(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added)+len(changed)+len(removed) != 0 {
+		t.Errorf("manifest not up to date: added=%v changed=%v removed=%v", added, changed, removed)
+	}
+}
+
+func manifestNames(m Manifest) []string {
+	var k []string
+	for n := range m {
+		k = append(k, n)
+	}
+	sort.Strings(k)
+	return k
+}
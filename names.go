@@ -0,0 +1,189 @@
+package fsnotify
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// NameEncoding describes how filenames that aren't valid UTF-8 are represented
+// in [Event.Name]; see [WithNameEncoding].
+//
+// Filenames on Unix systems can contain any byte except for "/" and NULL, and
+// on Windows they can contain unpaired UTF-16 surrogates. On Windows these are
+// represented as WTF-8: the surrogate is encoded as a 3-byte sequence like any
+// other code point, which isn't valid UTF-8.
+type NameEncoding uint8
+
+const (
+	// NameRaw keeps the name as the system reported it. This is the default.
+	//
+	// The Name can be passed to the os package as-is; on Windows this requires
+	// Go 1.21 or newer for names with unpaired surrogates.
+	NameRaw NameEncoding = iota
+
+	// NameReplace replaces every invalid UTF-8 sequence with U+FFFD. This can't
+	// be reversed, so the original name is kept in [Event.RawName].
+	NameReplace
+
+	// NamePercent encodes every byte of an invalid UTF-8 sequence as %XX, and
+	// "%" itself as %25. The result is always valid UTF-8, and
+	// [NameEncoding.Decode] gives back the original name. Event.RawName is set
+	// if the name changed.
+	NamePercent
+)
+
+func (n NameEncoding) String() string {
+	switch n {
+	case NameRaw:
+		return "raw"
+	case NameReplace:
+		return "replace"
+	case NamePercent:
+		return "percent"
+	default:
+		return "unknown"
+	}
+}
+
+// WithNameEncoding sets how filenames that aren't valid UTF-8 are represented
+// in Event.Name. This is only used by [NewWatcherWith], and is a no-op for
+// [Watcher.AddWith].
+//
+// The default is [NameRaw]. Only the part of the name that's reported by the
+// system is encoded, not the path passed to Add (which is already a Go string
+// chosen by the caller).
+func WithNameEncoding(enc NameEncoding) addOpt {
+	return func(opt *withOpts) { opt.nameEncoding = enc }
+}
+
+// UnicodeNorm is a Unicode normalization form for [WithUnicodeNorm]. This is
+// implemented by norm.Form from golang.org/x/text/unicode/norm:
+//
+//	w, err := fsnotify.NewWatcherWith(fsnotify.WithUnicodeNorm(norm.NFC))
+type UnicodeNorm interface {
+	// String returns the normalized form of s.
+	String(s string) string
+}
+
+// WithUnicodeNorm normalizes Event.Name to the Unicode normalization form,
+// setting [Event.RawName] to the name the system reported if it changed. This is
+// only used by [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// The same filename can be represented in different ways in Unicode: for
+// example "é" can be a single code point (NFC) or "e" followed by a combining
+// accent (NFD). macOS typically reports NFD, whereas most input from users and
+// other programs is NFC, so comparing names with a string from elsewhere fails
+// even though it's the same name. The default is to not normalize names.
+//
+// Names are normalized before [WithNameEncoding] is applied.
+func WithUnicodeNorm(form UnicodeNorm) addOpt {
+	return func(opt *withOpts) { opt.unicodeNorm = form }
+}
+
+// normEvent normalizes the Name of e, setting RawName if it changed.
+func normEvent(form UnicodeNorm, e Event) Event {
+	if form == nil || isASCII(e.Name) { // ASCII is the same in all forms.
+		return e
+	}
+	if n := form.String(e.Name); n != e.Name {
+		e.RawName, e.Name = e.Name, n
+	}
+	return e
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode the name. Names that are valid UTF-8 are only changed by NamePercent,
+// and only if they contain a "%".
+func (n NameEncoding) Encode(name string) string {
+	switch n {
+	case NameReplace:
+		if utf8.ValidString(name) {
+			return name
+		}
+		return strings.ToValidUTF8(name, string(utf8.RuneError))
+	case NamePercent:
+		if utf8.ValidString(name) && !strings.Contains(name, "%") {
+			return name
+		}
+		var b strings.Builder
+		b.Grow(len(name) + 8)
+		for i := 0; i < len(name); {
+			r, size := utf8.DecodeRuneInString(name[i:])
+			switch {
+			case r == '%' || (r == utf8.RuneError && size == 1):
+				fmt.Fprintf(&b, "%%%02X", name[i])
+			default:
+				b.WriteString(name[i : i+size])
+			}
+			i += size
+		}
+		return b.String()
+	default:
+		return name
+	}
+}
+
+// Decode a name encoded with Encode, returning the original name. This returns
+// an error for NameReplace, which can't be reversed; use [Event.RawName]
+// instead.
+func (n NameEncoding) Decode(name string) (string, error) {
+	switch n {
+	case NameRaw:
+		return name, nil
+	case NamePercent:
+		if !strings.Contains(name, "%") {
+			return name, nil
+		}
+		b := make([]byte, 0, len(name))
+		for i := 0; i < len(name); i++ {
+			if name[i] != '%' {
+				b = append(b, name[i])
+				continue
+			}
+			if i+2 >= len(name) || unhex(name[i+1]) < 0 || unhex(name[i+2]) < 0 {
+				return "", fmt.Errorf("fsnotify.NameEncoding.Decode: invalid escape at offset %d in %q", i, name)
+			}
+			b = append(b, byte(unhex(name[i+1])<<4|unhex(name[i+2])))
+			i += 2
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("fsnotify.NameEncoding.Decode: %s can't be decoded", n)
+	}
+}
+
+func unhex(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c - 'a' + 10)
+	case c >= 'A' && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
+
+// event encodes the Name of e, setting RawName if it changed and wasn't
+// already set.
+func (n NameEncoding) event(e Event) Event {
+	if n == NameRaw {
+		return e
+	}
+	if enc := n.Encode(e.Name); enc != e.Name {
+		if e.RawName == "" {
+			e.RawName = e.Name
+		}
+		e.Name = enc
+	}
+	return e
+}
@@ -0,0 +1,159 @@
+package fsnotify
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestNameEncoding(t *testing.T) {
+	tests := []struct {
+		in               string
+		replace, percent string
+	}{
+		{"", "", ""},
+		{"file", "file", "file"},
+		{"€uro", "€uro", "€uro"},
+		{"100%", "100%", "100%25"},
+		{"a\xffb", "a�b", "a%FFb"},
+		{"\xe2\x82", "�", "%E2%82"},
+		{"%\xff%", "%�%", "%25%FF%25"},
+		{"\xed\xa0\x80x", "�x", "%ED%A0%80x"}, // WTF-8 unpaired surrogate.
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run("", func(t *testing.T) {
+			if have := NameReplace.Encode(tt.in); have != tt.replace {
+				t.Errorf("replace %q\nhave: %q\nwant: %q", tt.in, have, tt.replace)
+			}
+			if have := NameRaw.Encode(tt.in); have != tt.in {
+				t.Errorf("raw %q\nhave: %q", tt.in, have)
+			}
+
+			have := NamePercent.Encode(tt.in)
+			if have != tt.percent {
+				t.Errorf("percent %q\nhave: %q\nwant: %q", tt.in, have, tt.percent)
+			}
+			if !utf8.ValidString(have) {
+				t.Errorf("percent %q: not valid UTF-8: %q", tt.in, have)
+			}
+			dec, err := NamePercent.Decode(have)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dec != tt.in {
+				t.Errorf("percent round-trip\nhave: %q\nwant: %q", dec, tt.in)
+			}
+		})
+	}
+
+	for _, bad := range []string{"%", "%4", "%zz", "a%4x"} {
+		if _, err := NamePercent.Decode(bad); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+	if _, err := NameReplace.Decode("x"); err == nil {
+		t.Error("no error for NameReplace")
+	}
+}
+
+func TestDecodeWTF16(t *testing.T) {
+	tests := []struct {
+		in   []uint16
+		want string
+	}{
+		{[]uint16{'a', 'b'}, "ab"},
+		{[]uint16{0xd83d, 0xde00}, "\U0001F600"},
+		{[]uint16{0xd800}, "\xed\xa0\x80"},
+		{[]uint16{'a', 0xdc00, 'b'}, "a\xed\xb0\x80b"},
+		{[]uint16{0xdc00, 0xd800}, "\xed\xb0\x80\xed\xa0\x80"},
+	}
+	for _, tt := range tests {
+		if have := decodeWTF16(tt.in); have != tt.want {
+			t.Errorf("%x\nhave: %q\nwant: %q", tt.in, have, tt.want)
+		}
+	}
+}
+
+func TestWithNameEncoding(t *testing.T) {
+	switch runtime.GOOS {
+	case "windows", "darwin", "ios":
+		t.Skip("filesystem doesn't allow names that aren't valid UTF-8")
+	}
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithNameEncoding(NamePercent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp)
+
+	file := join(tmp, "a\xffb")
+	touch(t, file, noWait)
+
+	select {
+	case e := <-w.Events:
+		if want := join(tmp, "a%FFb"); e.Name != want {
+			t.Errorf("Name\nhave: %q\nwant: %q", e.Name, want)
+		}
+		if e.RawName != file {
+			t.Errorf("RawName\nhave: %q\nwant: %q", e.RawName, file)
+		}
+		if dec, err := NamePercent.Decode(e.Name); err != nil || dec != file {
+			t.Errorf("Decode: %q, %v", dec, err)
+		}
+	case err := <-w.Errors:
+		t.Fatal(err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+}
+
+// nfc is a minimal UnicodeNorm for the tests, which only knows about "é".
+type nfc struct{}
+
+func (nfc) String(s string) string { return strings.ReplaceAll(s, "e\u0301", "\u00e9") }
+
+func TestWithUnicodeNorm(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithUnicodeNorm(nfc{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp)
+
+	var (
+		file    = join(tmp, "file")
+		nfd     = join(tmp, "cafe\u0301")
+		nfcName = join(tmp, "caf\u00e9")
+	)
+	touch(t, file, noWait)
+	touch(t, nfd, noWait)
+
+	have := make(map[string]string) // Name → RawName
+	timeout := time.After(2 * time.Second)
+	for len(have) < 2 {
+		select {
+		case e := <-w.Events:
+			have[e.Name] = e.RawName
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("not all events: %q", have)
+		}
+	}
+	if raw, ok := have[file]; !ok || raw != "" {
+		t.Errorf("ASCII name: %q", have)
+	}
+	if raw, ok := have[nfcName]; !ok || raw != nfd {
+		t.Errorf("NFD name not normalized: %q", have)
+	}
+}
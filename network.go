@@ -0,0 +1,227 @@
+package fsnotify
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetworkWatch is sent on the Errors channel as a warning when a path on a
+// network share (such as \\server\share) is watched on Windows, to tell how
+// changes are detected. The watch is active; use [errors.As] to check for it:
+//
+//	var nw fsnotify.NetworkWatch
+//	if errors.As(err, &nw) {
+//		log.Printf("watching %s with %s", nw.Path, nw.Mode)
+//		continue
+//	}
+//
+// The server reports changes with "notify", but this only includes changes
+// that went through the server: changes made locally on the server are
+// usually sent, but not always for NFS and other non-Windows servers. If the
+// server doesn't support notifications at all the path is scanned for changes
+// every few seconds with "poll". If the connection is lost "retry" is sent,
+// and "notify" again once it's restored; if it isn't restored in about a minute
+// an Unmount event is sent and the watch is removed.
+type NetworkWatch struct {
+	Path string // Path that was added.
+	Mode string // "notify", "poll", or "retry".
+	Err  error  // Why "poll" or "retry" is used; nil for "notify".
+}
+
+func (e NetworkWatch) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("fsnotify: watching network path %q with %s", e.Path, e.Mode)
+	}
+	return fmt.Sprintf("fsnotify: watching network path %q with %s: %s", e.Path, e.Mode, e.Err)
+}
+
+func (e NetworkWatch) Unwrap() error { return e.Err }
+
+// isUNC reports if path is a UNC path for a network share: \\server\share or
+// \\?\UNC\server\share.
+func isUNC(path string) bool {
+	switch {
+	case strings.HasPrefix(path, `\\?\UNC\`):
+		return true
+	case strings.HasPrefix(path, `\\?\`), strings.HasPrefix(path, `\\.\`):
+		return false
+	}
+	return strings.HasPrefix(path, `\\`) || strings.HasPrefix(path, `//`)
+}
+
+// How often paths on network shares without notifications are scanned.
+var networkPollInterval = 2 * time.Second
+
+// How often to try to reconnect to a network share after the connection was
+// lost, waiting netRetryDelay before every attempt.
+const netRetries = 8
+
+// netRetryDelay gets the delay before reconnecting for the given attempt
+// (starting at 0): 250ms, doubled every attempt, up to 30 seconds.
+func netRetryDelay(attempt int) time.Duration {
+	if attempt > 7 {
+		return 30 * time.Second
+	}
+	d := 250 * time.Millisecond << attempt
+	if d > 30*time.Second {
+		d = 30 * time.Second
+	}
+	return d
+}
+
+// netPoller scans paths on network shares that don't support notifications.
+type netPoller struct {
+	mu      sync.Mutex
+	paths   map[string]Snapshot // Key is the path as added, with "/..." if recursive.
+	started bool
+	wg      sync.WaitGroup
+	done    chan struct{}
+}
+
+func newNetPoller() *netPoller {
+	return &netPoller{paths: make(map[string]Snapshot), done: make(chan struct{})}
+}
+
+// add starts polling path, sending changes with send. Polling starts with the
+// first path.
+func (p *netPoller) add(path string, send func(name string, op Op, isDir bool) bool) error {
+	s, err := TakeSnapshot([]string{path})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return ErrClosed
+	default:
+	}
+	p.paths[path] = s
+	if !p.started {
+		p.started = true
+		p.wg.Add(1)
+		go p.run(send)
+	}
+	return nil
+}
+
+// remove stops polling path, reporting if it was polled.
+func (p *netPoller) remove(path string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	root, _ := recursivePath(path)
+	for k := range p.paths {
+		if r, _ := recursivePath(k); r == root {
+			delete(p.paths, k)
+			return true
+		}
+	}
+	return false
+}
+
+// list gets the polled paths, without "/...".
+func (p *netPoller) list() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l := make([]string, 0, len(p.paths))
+	for k := range p.paths {
+		root, _ := recursivePath(k)
+		l = append(l, root)
+	}
+	return l
+}
+
+// rootOf gets the polled path that name is in, or "" if it's not in any.
+func (p *netPoller) rootOf(name string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var root string
+	for k := range p.paths {
+		r, recurse := recursivePath(k)
+		if len(r) > len(root) && (name == r || filepath.Dir(name) == r ||
+			(recurse && strings.HasPrefix(name, r+string(filepath.Separator)))) {
+			root = r
+		}
+	}
+	return root
+}
+
+func (p *netPoller) run(send func(name string, op Op, isDir bool) bool) {
+	defer p.wg.Done()
+	t := time.NewTicker(networkPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+		}
+		if !p.check(send) {
+			return
+		}
+	}
+}
+
+// check scans all paths once, returning false if send did.
+func (p *netPoller) check(send func(name string, op Op, isDir bool) bool) bool {
+	p.mu.Lock()
+	paths := make([]string, 0, len(p.paths))
+	for k := range p.paths {
+		paths = append(paths, k)
+	}
+	p.mu.Unlock()
+
+	for _, path := range paths {
+		cur, err := TakeSnapshot([]string{path})
+		if err != nil {
+			continue // Try again on the next scan.
+		}
+		p.mu.Lock()
+		prev, ok := p.paths[path]
+		if ok {
+			p.paths[path] = cur
+		}
+		root, _ := recursivePath(path)
+		if _, exists := cur[root]; !exists {
+			delete(p.paths, path) // Removed, like the watch for a removed directory.
+		}
+		p.mu.Unlock()
+		if !ok {
+			continue // Removed while scanning.
+		}
+
+		added, changed, removed := prev.Diff(cur)
+		for _, n := range removed {
+			if !send(n, Remove, prev[n].IsDir) {
+				return false
+			}
+		}
+		for _, n := range added {
+			if !send(n, Create, cur[n].IsDir) {
+				return false
+			}
+		}
+		for _, n := range changed {
+			if !send(n, Write, cur[n].IsDir) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// stop polling and wait for it to finish.
+func (p *netPoller) stop() {
+	p.mu.Lock() // Don't start polling in add() after this.
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,109 @@
+package fsnotify
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestIsUNC(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{`\\server\share`, true},
+		{`\\server\share\dir\...`, true},
+		{`//server/share`, true},
+		{`\\?\UNC\server\share\dir`, true},
+		{`\\?\C:\dir`, false},
+		{`\\.\pipe\name`, false},
+		{`C:\dir`, false},
+		{`/tmp/dir`, false},
+		{`relative`, false},
+	}
+	for _, tt := range tests {
+		if have := isUNC(tt.in); have != tt.want {
+			t.Errorf("isUNC(%q) = %t; want %t", tt.in, have, tt.want)
+		}
+	}
+}
+
+func TestNetRetryDelay(t *testing.T) {
+	var total time.Duration
+	for i := 0; i < netRetries; i++ {
+		d := netRetryDelay(i)
+		if i > 0 && d < netRetryDelay(i-1) {
+			t.Errorf("delay for attempt %d is shorter than the one before: %s", i, d)
+		}
+		total += d
+	}
+	if total < 30*time.Second || total > 2*time.Minute {
+		t.Errorf("total delay of %s isn't about a minute", total)
+	}
+	if d := netRetryDelay(100); d != 30*time.Second {
+		t.Errorf("delay for attempt 100: %s", d)
+	}
+}
+
+func TestNetworkWatch(t *testing.T) {
+	var err error = NetworkWatch{Path: `\\server\share`, Mode: "poll", Err: ErrUnsupported{}}
+	var nw NetworkWatch
+	if !errors.As(err, &nw) || nw.Mode != "poll" {
+		t.Errorf("errors.As: %#v", nw)
+	}
+	if !errors.As(err, &ErrUnsupported{}) {
+		t.Error("doesn't unwrap")
+	}
+	if have, want := (NetworkWatch{Path: `\\server\share`, Mode: "notify"}).Error(),
+		`fsnotify: watching network path "\\\\server\\share" with notify`; have != want {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestNetPoller(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "sub", noWait)
+	touch(t, tmp, "sub", "file", noWait)
+	touch(t, tmp, "gone", noWait)
+
+	p := newNetPoller()
+	close(p.done) // Don't start polling in the background.
+	p.paths[join(tmp, "...")], _ = TakeSnapshot([]string{join(tmp, "...")})
+	check := func() []string {
+		t.Helper()
+		var have []string
+		p.check(func(name string, op Op, isDir bool) bool {
+			have = append(have, fmt.Sprintf("%s %s", op, name[len(tmp):]))
+			return true
+		})
+		sort.Strings(have)
+		return have
+	}
+
+	if have := check(); len(have) != 0 {
+		t.Fatalf("events without changes: %s", have)
+	}
+
+	touch(t, tmp, "new", noWait)
+	cat(t, "data", join(tmp, "sub", "file"), noWait)
+	rm(t, tmp, "gone", noWait)
+	want := []string{Create.String() + " /new", Remove.String() + " /gone", Write.String() + " " + join("/sub", "file")}
+	sort.Strings(want)
+	if have := check(); fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	if have := p.list(); len(have) != 1 || have[0] != tmp {
+		t.Errorf("list: %s", have)
+	}
+	if have := p.rootOf(join(tmp, "sub", "file")); have != tmp {
+		t.Errorf("rootOf: %q", have)
+	}
+	if !p.remove(tmp) || p.remove(tmp) || len(p.list()) != 0 {
+		t.Errorf("remove: %s", p.list())
+	}
+}
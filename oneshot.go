@@ -0,0 +1,122 @@
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// WithOneShot removes the watch after the first event for it is sent, for code
+// that waits for a file to be created or changed once and would otherwise race
+// with new events while calling Remove.
+//
+// This uses IN_ONESHOT on Linux, where the kernel removes the watch after the
+// first event it reports, even if that event is dropped later (e.g. by
+// [WithChmod] or [WithChecksum]). Other platforms, and recursive watches or
+// [WithSharedInstance] on Linux, remove the watch in the background after the
+// first event is sent, and drop any events that are read in the meantime.
+//
+// Adding the path again without WithOneShot makes it a regular watch.
+func WithOneShot() addOpt {
+	return func(opt *withOpts) { opt.oneShot = true }
+}
+
+// oneShots keeps track of WithOneShot watches that are removed by fsnotify
+// rather than the kernel.
+type oneShots struct {
+	mu    sync.Mutex
+	paths map[string]*oneShot // Path passed to AddWith → watch
+}
+
+type oneShot struct {
+	fired bool // Event was sent; waiting for Remove.
+}
+
+func newOneShots() *oneShots {
+	return &oneShots{paths: make(map[string]*oneShot)}
+}
+
+// set the path added with AddWith as one-shot, or as a regular watch.
+func (o *oneShots) set(path string, enable bool) {
+	if !enable {
+		o.clear(path)
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.paths[filepath.Clean(path)] = &oneShot{}
+}
+
+// clear all watches for the path that didn't fire yet, for Remove.
+func (o *oneShots) clear(path string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	root, _ := recursivePath(filepath.Clean(path))
+	for p, s := range o.paths {
+		if r, _ := recursivePath(p); r == root && !s.fired {
+			delete(o.paths, p)
+		}
+	}
+}
+
+// forget stops tracking the watch after it was removed.
+func (o *oneShots) forget(path string, s *oneShot) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.paths[path] == s {
+		delete(o.paths, path)
+	}
+}
+
+// match finds the watch the event is for; o.mu must be held.
+func (o *oneShots) match(e Event) (string, *oneShot) {
+	for p, s := range o.paths {
+		if isFor(p, e) {
+			return p, s
+		}
+	}
+	return "", nil
+}
+
+// isFor reports if the event is for the path passed to AddWith: the path
+// itself, an entry in the directory, or anything below it for recursive
+// watches.
+func isFor(path string, e Event) bool {
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	root, recurse := recursivePath(path)
+	return name == root || filepath.Dir(name) == root ||
+		(recurse && strings.HasPrefix(name, root+string(os.PathSeparator)))
+}
+
+// oneShot reports if the event should be sent according to WithOneShot, and
+// removes the watch in the background if this is the first event for it.
+func (w *Watcher) oneShot(e Event) bool {
+	w.oneShots.mu.Lock()
+	if len(w.oneShots.paths) == 0 {
+		w.oneShots.mu.Unlock()
+		return true
+	}
+	path, s := w.oneShots.match(e)
+	if s == nil {
+		w.oneShots.mu.Unlock()
+		return true
+	}
+	if s.fired {
+		w.oneShots.mu.Unlock()
+		return false
+	}
+	s.fired = true
+	w.oneShots.mu.Unlock()
+
+	// Can't remove it here, as the backends call this while reading events
+	// (and sometimes with locks held).
+	go func() {
+		_ = w.Remove(path)
+		w.oneShots.forget(path, s)
+	}()
+	return true
+}
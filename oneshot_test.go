@@ -0,0 +1,102 @@
+package fsnotify
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestWithOneShot(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		recurse bool
+		opts    []addOpt
+	}{
+		{"dir", false, nil},
+		{"shared", false, []addOpt{WithSharedInstance()}},
+		{"recurse", true, nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if tt.recurse && runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+				t.Skip("recursion not supported on " + runtime.GOOS)
+			}
+			if tt.name == "shared" && runtime.GOOS != "linux" {
+				t.Skip("WithSharedInstance not supported on " + runtime.GOOS)
+			}
+
+			tmp := t.TempDir()
+			w, err := NewWatcherWith(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			path := tmp
+			if tt.recurse {
+				path = join(tmp, "...")
+			}
+			if err := w.AddWith(path, WithOneShot()); err != nil {
+				t.Fatal(err)
+			}
+
+			touch(t, tmp, "first")
+			touch(t, tmp, "second")
+
+			have := eventsFor(t, w, 500*time.Millisecond)
+			if len(have) != 1 || have[0].Name != join(tmp, "first") {
+				t.Errorf("want one event for %q, have %v", join(tmp, "first"), have)
+			}
+			if l := w.WatchList(); len(l) != 0 {
+				t.Errorf("watch not removed: %q", l)
+			}
+		})
+	}
+}
+
+func TestWithOneShotReAdd(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t)
+	defer w.Close()
+
+	if err := w.AddWith(tmp, WithOneShot()); err != nil {
+		t.Fatal(err)
+	}
+	addWatch(t, w, tmp) // Regular watch now.
+
+	touch(t, tmp, "first")
+	touch(t, tmp, "second")
+
+	have := eventsFor(t, w, 500*time.Millisecond)
+	if len(have) < 2 {
+		t.Errorf("want events for both files, have %v", have)
+	}
+	if l := w.WatchList(); len(l) != 1 {
+		t.Errorf("watch removed: %q", l)
+	}
+}
+
+// eventsFor reads all events for the duration.
+func eventsFor(t *testing.T, w *Watcher, d time.Duration) []Event {
+	t.Helper()
+	var (
+		have    []Event
+		timeout = time.After(d)
+	)
+	for {
+		select {
+		case e := <-w.Events:
+			have = append(have, e)
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			return have
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package fsnotify
+
+import "sync"
+
+// OnOverflow sets a function that's called as soon as events are lost, so
+// applications can rescan or switch to a degraded mode right away rather than
+// waiting for an [ErrEventOverflow] on the Errors channel.
+//
+// count is the number of events that were lost, or 0 if that's not known: this
+// is the case when the kernel reports an overflow (which is also sent as
+// ErrEventOverflow), while events dropped because of [WithBackpressure] are
+// reported with a count of 1 for every event.
+//
+// fn is called from the goroutine that reads or sends events, and should
+// return quickly. Passing nil removes it.
+func (w *Watcher) OnOverflow(fn func(count int)) {
+	w.stats.overflow.set(fn)
+	w.delivery.overflow.set(fn)
+}
+
+// overflowFunc is the function set with OnOverflow.
+type overflowFunc struct {
+	mu sync.RWMutex
+	fn func(count int)
+}
+
+func (o *overflowFunc) set(fn func(count int)) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.fn = fn
+}
+
+func (o *overflowFunc) call(count int) {
+	o.mu.RLock()
+	fn := o.fn
+	o.mu.RUnlock()
+	if fn != nil {
+		fn(count)
+	}
+}
@@ -0,0 +1,56 @@
+package fsnotify
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestOnOverflow(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithBackpressure(BackpressureDropNewest), WithEventChannelSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var (
+		mu     sync.Mutex
+		counts []int
+	)
+	w.OnOverflow(func(count int) {
+		mu.Lock()
+		defer mu.Unlock()
+		counts = append(counts, count)
+	})
+	addWatch(t, w, tmp)
+
+	// Don't read from Events, so all but the first are dropped.
+	touch(t, tmp, "a", noWait)
+	touch(t, tmp, "b", noWait)
+	touch(t, tmp, "c")
+
+	// The kernel overflowing.
+	w.stats.error(ErrEventOverflow)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(counts) < 3 {
+		t.Fatalf("have %v", counts)
+	}
+	for _, c := range counts[:len(counts)-1] {
+		if c != 1 {
+			t.Errorf("count %d for a dropped event; want 1", c)
+		}
+	}
+	if c := counts[len(counts)-1]; c != 0 {
+		t.Errorf("count %d for a kernel overflow; want 0", c)
+	}
+	if d := w.DroppedEvents(); d != uint64(len(counts)-1) {
+		t.Errorf("DroppedEvents() = %d; called %d times", d, len(counts)-1)
+	}
+
+	w.OnOverflow(nil)
+	w.stats.error(ErrEventOverflow)
+}
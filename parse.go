@@ -0,0 +1,167 @@
+package fsnotify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// The parsers for the buffers the kernel gives us are kept separate from the
+// backends and don't depend on the platform, so they can be fuzzed everywhere.
+// They must never panic or read outside the buffer, no matter the input.
+
+var errShortBuffer = errors.New("fsnotify: event buffer too short")
+
+// nativeEndian is the byte order of the current platform, which inotify uses.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// sizeofInotifyEvent is the size of struct inotify_event, without the name.
+const sizeofInotifyEvent = 16
+
+// inotifyRecord is a single struct inotify_event.
+type inotifyRecord struct {
+	wd     int32
+	mask   uint32
+	cookie uint32
+	name   []byte // Without the NULL padding; points in to the buffer.
+}
+
+// readInotifyRecord reads the first event from buf, returning the number of
+// bytes it takes up in the buffer.
+func readInotifyRecord(buf []byte) (inotifyRecord, int, error) {
+	if len(buf) < sizeofInotifyEvent {
+		return inotifyRecord{}, 0, errShortBuffer
+	}
+	var (
+		r = inotifyRecord{
+			wd:     int32(nativeEndian.Uint32(buf[0:])),
+			mask:   nativeEndian.Uint32(buf[4:]),
+			cookie: nativeEndian.Uint32(buf[8:]),
+		}
+		nameLen = uint64(nativeEndian.Uint32(buf[12:]))
+	)
+	if nameLen > uint64(len(buf)-sizeofInotifyEvent) {
+		return inotifyRecord{}, 0, fmt.Errorf("%w: name length %d for %d bytes", errShortBuffer, nameLen, len(buf))
+	}
+	size := sizeofInotifyEvent + int(nameLen)
+	r.name = buf[sizeofInotifyEvent:size]
+	if i := bytes.IndexByte(r.name, 0); i > -1 {
+		r.name = r.name[:i]
+	}
+	return r, size, nil
+}
+
+// sizeofFileNotifyInformation is the size of FILE_NOTIFY_INFORMATION, without
+// the name.
+const sizeofFileNotifyInformation = 12
+
+// windowsRecord is a single FILE_NOTIFY_INFORMATION.
+type windowsRecord struct {
+	next   uint32 // Offset of the next record, or 0 if this is the last one.
+	action uint32
+	name   string
+}
+
+// readWindowsRecord reads the first FILE_NOTIFY_INFORMATION from buf; Windows
+// is always little-endian.
+func readWindowsRecord(buf []byte) (windowsRecord, error) {
+	if len(buf) < sizeofFileNotifyInformation {
+		return windowsRecord{}, errShortBuffer
+	}
+	var (
+		r = windowsRecord{
+			next:   binary.LittleEndian.Uint32(buf[0:]),
+			action: binary.LittleEndian.Uint32(buf[4:]),
+		}
+		nameLen = uint64(binary.LittleEndian.Uint32(buf[8:]))
+	)
+	if nameLen > uint64(len(buf)-sizeofFileNotifyInformation) {
+		return windowsRecord{}, fmt.Errorf("%w: name length %d for %d bytes", errShortBuffer, nameLen, len(buf))
+	}
+
+	// Decode in to buffers on the stack, so that only the string is allocated
+	// for names up to 128 UTF-16 code units.
+	var (
+		name = buf[sizeofFileNotifyInformation : sizeofFileNotifyInformation+int(nameLen)]
+		ubuf [128]uint16
+		bbuf [128 * 3]byte
+		u    = ubuf[:0]
+	)
+	for i := 0; i+1 < len(name); i += 2 {
+		c := binary.LittleEndian.Uint16(name[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	r.name = string(appendWTF16(bbuf[:0], u))
+	return r, nil
+}
+
+// decodeWTF16 converts the UTF-16 name to WTF-8, which is like UTF-8 except
+// that unpaired surrogates (which NTFS allows in filenames) are kept as a
+// 3-byte sequence instead of being replaced by U+FFFD. This is the same as
+// syscall.UTF16ToString does since Go 1.21, so the name can be passed back to
+// the os package.
+func decodeWTF16(u []uint16) string {
+	return string(appendWTF16(make([]byte, 0, len(u)*3), u))
+}
+
+// appendWTF16 appends the WTF-8 encoding of u to b.
+func appendWTF16(b []byte, u []uint16) []byte {
+	var tmp [utf8.UTFMax]byte
+	for i := 0; i < len(u); i++ {
+		c := rune(u[i])
+		switch {
+		case utf16.IsSurrogate(c) && c < 0xdc00 && i+1 < len(u) &&
+			rune(u[i+1]) >= 0xdc00 && rune(u[i+1]) < 0xe000:
+			b = append(b, tmp[:utf8.EncodeRune(tmp[:], utf16.DecodeRune(c, rune(u[i+1])))]...)
+			i++
+		case utf16.IsSurrogate(c):
+			b = append(b, 0xe0|byte(c>>12), 0x80|byte(c>>6)&0x3f, 0x80|byte(c)&0x3f)
+		default:
+			b = append(b, tmp[:utf8.EncodeRune(tmp[:], c)]...)
+		}
+	}
+	return b
+}
+
+// dirDiff compares the entries in a listing of dir with the paths that are
+// already known (as kqueue doesn't tell us what changed in a directory),
+// returning the paths that are new in the order of the listing.
+//
+// Names that aren't a single path element are skipped.
+func dirDiff(dir string, names []string, known map[string]struct{}) []string {
+	var (
+		created []string
+		seen    = make(map[string]struct{}, len(names))
+	)
+	for _, n := range names {
+		if n == "" || n == "." || n == ".." || strings.ContainsAny(n, "/\x00") ||
+			strings.ContainsRune(n, filepath.Separator) {
+			continue
+		}
+		p := filepath.Join(dir, n)
+		if _, ok := known[p]; ok {
+			continue
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		created = append(created, p)
+	}
+	return created
+}
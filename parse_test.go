@@ -0,0 +1,139 @@
+//go:build go1.18
+// +build go1.18
+
+package fsnotify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func inotifyBuf(wd int32, mask uint32, name string, pad int) []byte {
+	b := make([]byte, sizeofInotifyEvent, sizeofInotifyEvent+len(name)+pad)
+	nativeEndian.PutUint32(b[0:], uint32(wd))
+	nativeEndian.PutUint32(b[4:], mask)
+	nativeEndian.PutUint32(b[12:], uint32(len(name)+pad))
+	return append(append(b, name...), make([]byte, pad)...)
+}
+
+func windowsBuf(next, action uint32, name string) []byte {
+	u := utf16.Encode([]rune(name))
+	b := make([]byte, sizeofFileNotifyInformation+len(u)*2)
+	binary.LittleEndian.PutUint32(b[0:], next)
+	binary.LittleEndian.PutUint32(b[4:], action)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(u)*2))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[sizeofFileNotifyInformation+i*2:], c)
+	}
+	return b
+}
+
+func FuzzReadInotifyRecord(f *testing.F) {
+	f.Add(inotifyBuf(1, 0x100, "file", 12))
+	f.Add(append(inotifyBuf(1, 0x100, "a", 3), inotifyBuf(2, 0x200, "", 0)...))
+	f.Add(inotifyBuf(1, 0x100, "file", 12)[:20])
+	f.Add([]byte{1, 2, 3})
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		for offset := 0; offset < len(buf); {
+			r, size, err := readInotifyRecord(buf[offset:])
+			if err != nil {
+				return
+			}
+			if size < sizeofInotifyEvent || offset+size > len(buf) {
+				t.Fatalf("wrong size %d at offset %d for %d bytes", size, offset, len(buf))
+			}
+			if bytes.IndexByte(r.name, 0) > -1 {
+				t.Fatalf("NULL byte in name: %q", r.name)
+			}
+			offset += size
+		}
+	})
+}
+
+func FuzzReadWindowsRecord(f *testing.F) {
+	one := windowsBuf(0, 1, `dir\file`)
+	f.Add(one)
+	f.Add(append(windowsBuf(uint32(len(one)), 4, "old"), one...))
+	f.Add(windowsBuf(0xffffffff, 1, "x"))
+	f.Add(one[:len(one)-3])
+
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		var offset uint32
+		for {
+			r, err := readWindowsRecord(buf[offset:])
+			if err != nil {
+				return
+			}
+			if strings.ContainsRune(r.name, 0) {
+				t.Fatalf("NULL byte in name: %q", r.name)
+			}
+			if r.next == 0 || r.next >= uint32(len(buf))-offset {
+				return
+			}
+			offset += r.next
+		}
+	})
+}
+
+func FuzzDirDiff(f *testing.F) {
+	f.Add("/dir", "a\nb\nc", "/dir/b")
+	f.Add("/dir", "a\na\n..\n.\n\n/etc/passwd", "")
+	f.Add("", "x\ny/z", "x")
+
+	f.Fuzz(func(t *testing.T, dir, names, known string) {
+		k := make(map[string]struct{})
+		for _, p := range strings.Split(known, "\n") {
+			k[p] = struct{}{}
+		}
+
+		seen := make(map[string]bool)
+		for _, p := range dirDiff(dir, strings.Split(names, "\n"), k) {
+			if _, ok := k[p]; ok {
+				t.Errorf("known path %q returned", p)
+			}
+			if seen[p] {
+				t.Errorf("duplicate path %q", p)
+			}
+			seen[p] = true
+			if filepath.Dir(p) != filepath.Clean(dir) {
+				t.Errorf("path %q not in %q", p, dir)
+			}
+		}
+	})
+}
+
+func FuzzNamePercent(f *testing.F) {
+	f.Add("file")
+	f.Add("100%")
+	f.Add("a\xffb")
+	f.Add("\xed\xa0\x80")
+	f.Fuzz(func(t *testing.T, name string) {
+		enc := NamePercent.Encode(name)
+		if !utf8.ValidString(enc) {
+			t.Fatalf("not valid UTF-8: %q", enc)
+		}
+		dec, err := NamePercent.Decode(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec != name {
+			t.Fatalf("round-trip\nhave: %q\nwant: %q", dec, name)
+		}
+	})
+}
+
+func BenchmarkReadWindowsRecord(b *testing.B) {
+	buf := windowsBuf(0, 1, `dir\file.txt`)
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		if _, err := readWindowsRecord(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
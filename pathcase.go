@@ -0,0 +1,147 @@
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// WithCaseInsensitivePaths makes the paths passed to Add, Remove, AddFile, and
+// RemoveFile match regardless of case on case-insensitive volumes, such as NTFS
+// and the default APFS volumes on macOS. This is only used by
+// [NewWatcherWith], and is a no-op for [Watcher.AddWith].
+//
+// Paths are converted to the case that's stored on the disk before they're
+// used, so that Add("Foo") and Add("foo") are the same watch, Remove("FOO")
+// removes it, and the names of events match the paths passed to AddFile. This
+// also means that Event.Name uses the case on the disk, rather than the case
+// passed to Add.
+//
+// Whether a volume is case-insensitive is detected for every path, and paths on
+// case-sensitive volumes are used as-is. This isn't free: every directory in
+// the path is read to find its case.
+func WithCaseInsensitivePaths() addOpt {
+	return func(opt *withOpts) { opt.caseInsensitive = true }
+}
+
+// casePath converts a path passed to Add or Remove for WithAbsolutePaths and
+// WithCaseInsensitivePaths; this must be called without any locks held.
+func (w *Watcher) casePath(name string) string {
+	if w.with.absolutePaths {
+		name = absPath(name, false, w.WatchList)
+	}
+	return w.foldCase(name)
+}
+
+// foldCase converts a path for WithCaseInsensitivePaths.
+func (w *Watcher) foldCase(name string) string {
+	if !w.with.caseInsensitive {
+		return name
+	}
+	return foldPath(name, w.WatchList)
+}
+
+// foldPath converts path to the case on the disk if it's on a case-insensitive
+// volume. Paths that don't exist (any more) are matched against the paths in
+// watched instead, so they can still be removed.
+func foldPath(path string, watched func() []string) string {
+	p, recurse := recursivePath(path)
+	p = filepath.Clean(p)
+
+	fold := p
+	if fi, err := os.Lstat(p); err == nil {
+		if caseInsensitive(p, fi) {
+			fold = diskCase(p)
+		}
+	} else {
+		for _, w := range watched() {
+			if strings.EqualFold(w, p) {
+				fold = w
+				break
+			}
+		}
+	}
+	if fold == p {
+		return path
+	}
+	if recurse {
+		return filepath.Join(fold, "...")
+	}
+	return fold
+}
+
+// caseInsensitive reports if the path is on a case-insensitive volume, by
+// checking if the path with the case of the last element flipped is the same
+// file.
+func caseInsensitive(path string, fi os.FileInfo) bool {
+	dir, base := filepath.Split(path)
+	flip := flipCase(base)
+	if flip == base { // No letters in the name; try the parent.
+		dir = filepath.Clean(dir)
+		if dir == path || base == "" {
+			return false
+		}
+		dfi, err := os.Lstat(dir)
+		return err == nil && caseInsensitive(dir, dfi)
+	}
+	ffi, err := os.Lstat(filepath.Join(dir, flip))
+	return err == nil && os.SameFile(fi, ffi)
+}
+
+func flipCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, s)
+}
+
+// diskCase gets the path with every element in the case it's stored on the
+// disk, by reading the directory listings. Elements that can't be found are
+// kept as-is.
+func diskCase(path string) string {
+	var (
+		vol  = filepath.VolumeName(path)
+		rest = path[len(vol):]
+		dir  = vol
+	)
+	if len(rest) > 0 && os.IsPathSeparator(rest[0]) {
+		dir += string(filepath.Separator)
+	}
+
+	elems := strings.Split(rest, string(filepath.Separator))
+	for i, elem := range elems {
+		if elem != "" && elem != "." && elem != ".." {
+			elems[i] = diskName(dir, elem)
+		}
+		dir = filepath.Join(dir, elems[i])
+	}
+	return vol + strings.Join(elems, string(filepath.Separator))
+}
+
+// diskName finds the name in the directory: the exact name if it exists, or
+// else the first name that's the same when ignoring case.
+func diskName(dir, name string) string {
+	if dir == "" {
+		dir = "."
+	}
+	fp, err := os.Open(dir)
+	if err != nil {
+		return name
+	}
+	names, _ := fp.Readdirnames(-1)
+	fp.Close()
+
+	fold := name
+	for _, n := range names {
+		if n == name {
+			return name
+		}
+		if fold == name && strings.EqualFold(n, name) {
+			fold = n
+		}
+	}
+	return fold
+}
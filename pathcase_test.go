@@ -0,0 +1,88 @@
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFoldPath(t *testing.T) {
+	tmp := t.TempDir()
+	mkdirAll(t, tmp, "Dir", "Sub")
+	touch(t, tmp, "Dir", "File")
+
+	// diskCase only looks at the directory listings, so it works the same on
+	// case-sensitive volumes.
+	tests := []struct{ in, want string }{
+		{join(tmp, "dir"), join(tmp, "Dir")},
+		{join(tmp, "DIR", "sub"), join(tmp, "Dir", "Sub")},
+		{join(tmp, "dir", "file"), join(tmp, "Dir", "File")},
+		{join(tmp, "Dir", "Sub"), join(tmp, "Dir", "Sub")},
+		{join(tmp, "dir", "nonexistent"), join(tmp, "Dir", "nonexistent")},
+	}
+	for _, tt := range tests {
+		if have := diskCase(tt.in); have != tt.want {
+			t.Errorf("diskCase(%q)\nhave: %q\nwant: %q", tt.in, have, tt.want)
+		}
+	}
+
+	fi, err := os.Lstat(join(tmp, "Dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	insensitive := caseInsensitive(join(tmp, "Dir"), fi)
+	_, err = os.Lstat(join(tmp, "dIR"))
+	if want := err == nil; insensitive != want {
+		t.Errorf("caseInsensitive: %t; want %t", insensitive, want)
+	}
+
+	// Paths that don't exist are matched against the watched paths.
+	watched := func() []string { return []string{join(tmp, "Gone")} }
+	if have, want := foldPath(join(tmp, "gone"), watched), join(tmp, "Gone"); have != want {
+		t.Errorf("foldPath for removed path\nhave: %q\nwant: %q", have, want)
+	}
+	if have, want := foldPath(join(tmp, "gone", "..."), watched), join(tmp, "Gone", "..."); have != want {
+		t.Errorf("foldPath for recursive path\nhave: %q\nwant: %q", have, want)
+	}
+
+	// Only converted on case-insensitive volumes.
+	want := join(tmp, "dir")
+	if insensitive {
+		want = join(tmp, "Dir")
+	}
+	if have := foldPath(join(tmp, "dir"), watched); have != want {
+		t.Errorf("foldPath\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestWithCaseInsensitivePaths(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	dir := join(tmp, "Dir")
+	mkdir(t, dir)
+	if _, err := os.Lstat(join(tmp, "DIR")); err != nil {
+		t.Skip("volume is case-sensitive")
+	}
+
+	w, err := NewWatcherWith(WithCaseInsensitivePaths())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, p := range []string{join(tmp, "dir"), join(tmp, "DIR")} {
+		if err := w.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l := w.WatchList(); len(l) != 1 || filepath.Clean(l[0]) != dir {
+		t.Errorf("wrong WatchList: %q", l)
+	}
+	if err := w.Remove(join(tmp, "dIr")); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 0 {
+		t.Errorf("not removed: %q", l)
+	}
+}
@@ -0,0 +1,210 @@
+package fsnotify
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// PathStats describes the memory used to store the watched paths, as returned
+// by [Watcher.PathStats].
+type PathStats struct {
+	Paths     int // Number of watched paths, including internal watches.
+	PathBytes int // Total length of the paths.
+
+	// Number of path elements that are stored, and the estimated memory used
+	// to store the paths in bytes.
+	//
+	// On Linux the paths are stored as a tree of path elements, so that the
+	// directories that paths have in common are only stored once. Every
+	// element has some overhead, so this uses less memory than storing the
+	// full paths if they're long: e.g. for a recursive watch on a large
+	// node_modules directory. On other platforms every path is stored as a
+	// string, and Nodes is the same as Paths.
+	Nodes int
+	Bytes int
+}
+
+// flatPathStats gets the PathStats for paths that are stored as strings.
+func flatPathStats(paths []string) PathStats {
+	s := PathStats{Paths: len(paths), Nodes: len(paths)}
+	for _, p := range paths {
+		s.PathBytes += len(p)
+	}
+	// Every path is referenced twice: as a map key and in the watch.
+	s.Bytes = s.PathBytes + s.Paths*2*int(unsafe.Sizeof(""))
+	return s
+}
+
+// pathTree stores paths as a tree of path elements, so that the directories
+// many paths have in common are only stored once rather than in every path.
+// With a recursive watch on a large tree most paths are long, and differ only
+// in the last element.
+//
+// Paths are split on "/", and are the same when joined again; they don't need
+// to be clean. It's not safe for concurrent use, but a *pathNode never changes
+// once it's created and can be read concurrently.
+type pathTree struct {
+	nodes     map[pathKey]*pathNode
+	paths     int // Number of paths added and not released.
+	pathBytes int // Total length of the added paths.
+	nameBytes int // Total length of the names of all nodes.
+}
+
+type (
+	pathKey struct {
+		parent *pathNode
+		name   string
+	}
+	// pathNode is a path element; the path is the names of the parents and
+	// the node joined with "/".
+	pathNode struct {
+		parent *pathNode
+		name   string
+		refs   int // Number of added paths that use this node.
+	}
+)
+
+func newPathTree() *pathTree {
+	return &pathTree{nodes: make(map[pathKey]*pathNode)}
+}
+
+// add the path, returning the node for it. Every add must be matched by a call
+// to release.
+func (t *pathTree) add(path string) *pathNode {
+	t.paths++
+	t.pathBytes += len(path)
+
+	var n *pathNode
+	for {
+		name, rest, more := cutPath(path)
+		c, ok := t.nodes[pathKey{n, name}]
+		if !ok {
+			// Copy the name, so it doesn't keep the entire path alive.
+			c = &pathNode{parent: n, name: cloneString(name)}
+			t.nodes[pathKey{n, c.name}] = c
+			t.nameBytes += len(name)
+		}
+		c.refs++
+		n = c
+		if !more {
+			return n
+		}
+		path = rest
+	}
+}
+
+// lookup gets the node for the path, or nil if there isn't one. This doesn't
+// mean the path was added; the node may only be a parent of other paths.
+func (t *pathTree) lookup(path string) *pathNode {
+	var n *pathNode
+	for {
+		name, rest, more := cutPath(path)
+		c, ok := t.nodes[pathKey{n, name}]
+		if !ok {
+			return nil
+		}
+		n = c
+		if !more {
+			return n
+		}
+		path = rest
+	}
+}
+
+// release a path that was added, removing the nodes that are no longer used.
+func (t *pathTree) release(n *pathNode) {
+	t.paths--
+	t.pathBytes -= n.len()
+	for ; n != nil; n = n.parent {
+		n.refs--
+		if n.refs == 0 {
+			delete(t.nodes, pathKey{n.parent, n.name})
+			t.nameBytes -= len(n.name)
+		}
+	}
+}
+
+func (t *pathTree) stats() PathStats {
+	const (
+		perNode = int(unsafe.Sizeof(pathNode{}) + unsafe.Sizeof(pathKey{}) + unsafe.Sizeof(&pathNode{}))
+		perPath = 2 * int(unsafe.Sizeof(&pathNode{})) // Like flatPathStats.
+	)
+	return PathStats{
+		Paths:     t.paths,
+		PathBytes: t.pathBytes,
+		Nodes:     len(t.nodes),
+		Bytes:     len(t.nodes)*perNode + t.nameBytes + t.paths*perPath,
+	}
+}
+
+func cutPath(path string) (name, rest string, more bool) {
+	if i := strings.IndexByte(path, '/'); i > -1 {
+		return path[:i], path[i+1:], true
+	}
+	return path, "", false
+}
+
+// len gets the length of the path.
+func (n *pathNode) len() int {
+	l := -1
+	for ; n != nil; n = n.parent {
+		l += len(n.name) + 1
+	}
+	if l < 0 {
+		return 0
+	}
+	return l
+}
+
+// String gets the path; this is "" for a nil node.
+func (n *pathNode) String() string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.Grow(n.len())
+	n.write(&b)
+	return b.String()
+}
+
+// join gets the path with "/" and name appended, with a single allocation.
+func (n *pathNode) join(name []byte) string {
+	var b strings.Builder
+	b.Grow(n.len() + 1 + len(name))
+	n.write(&b)
+	b.WriteByte('/')
+	b.Write(name)
+	return b.String()
+}
+
+func (n *pathNode) write(b *strings.Builder) {
+	if n == nil {
+		return
+	}
+	if n.parent != nil {
+		n.parent.write(b)
+		b.WriteByte('/')
+	}
+	b.WriteString(n.name)
+}
+
+// appendTo appends the path to b.
+func (n *pathNode) appendTo(b []byte) []byte {
+	if n == nil {
+		return b
+	}
+	if n.parent != nil {
+		b = append(n.parent.appendTo(b), '/')
+	}
+	return append(b, n.name...)
+}
+
+// in reports if the path is dir or below it.
+func (n *pathNode) in(dir *pathNode) bool {
+	for ; n != nil; n = n.parent {
+		if n == dir {
+			return true
+		}
+	}
+	return false
+}
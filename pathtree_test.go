@@ -0,0 +1,97 @@
+package fsnotify
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPathTree(t *testing.T) {
+	tr := newPathTree()
+
+	paths := []string{"/", "/a", "/a/b", "/a/b/c", "/a/bb", "rel/dir", "a//b/", ""}
+	nodes := make(map[string]*pathNode)
+	for _, p := range paths {
+		n := tr.add(p)
+		if have := n.String(); have != p {
+			t.Errorf("String() for %q: %q", p, have)
+		}
+		if have := n.len(); have != len(p) {
+			t.Errorf("len() for %q: %d", p, have)
+		}
+		if have := string(n.appendTo([]byte("x"))); have != "x"+p {
+			t.Errorf("appendTo() for %q: %q", p, have)
+		}
+		if have := n.join([]byte("f")); have != p+"/f" {
+			t.Errorf("join() for %q: %q", p, have)
+		}
+		if tr.lookup(p) != n {
+			t.Errorf("lookup(%q) is not the added node", p)
+		}
+		nodes[p] = n
+	}
+
+	if !nodes["/a/b/c"].in(nodes["/a"]) || !nodes["/a"].in(nodes["/a"]) {
+		t.Error("/a/b/c not in /a")
+	}
+	if nodes["/a/bb"].in(nodes["/a/b"]) {
+		t.Error("/a/bb in /a/b")
+	}
+	if tr.lookup("/a/x") != nil || tr.lookup("/x") != nil {
+		t.Error("lookup of path that wasn't added")
+	}
+
+	// Adding the same path again gives the same node, and it's kept until
+	// it's released twice.
+	if n := tr.add("/a/b"); n != nodes["/a/b"] {
+		t.Error("different node for /a/b")
+	}
+	tr.release(nodes["/a/b"])
+	if tr.lookup("/a/b") == nil {
+		t.Error("/a/b removed after one release")
+	}
+	tr.release(nodes["/a/b"])
+	if tr.lookup("/a/b") == nil {
+		t.Error("/a/b removed while /a/b/c still uses it")
+	}
+
+	for _, p := range paths {
+		if p != "/a/b" {
+			tr.release(nodes[p])
+		}
+	}
+	if s := tr.stats(); s != (PathStats{}) || len(tr.nodes) != 0 {
+		t.Errorf("not empty after releasing everything: %+v; %v", s, tr.nodes)
+	}
+}
+
+func TestPathTreeStats(t *testing.T) {
+	// A recursive watch on a node_modules directory, where every package has
+	// a few levels of subdirectories.
+	var (
+		tr    = newPathTree()
+		root  = "/home/user/src/github.com/example/project/node_modules"
+		paths = []string{root}
+	)
+	for i := 0; i < 20; i++ {
+		pkg := fmt.Sprintf("%s/package-%d", root, i)
+		paths = append(paths, pkg, pkg+"/lib")
+		for j := 0; j < 50; j++ {
+			paths = append(paths, fmt.Sprintf("%s/lib/dir-%d", pkg, j))
+		}
+	}
+	for _, p := range paths {
+		tr.add(p)
+	}
+
+	have, flat := tr.stats(), flatPathStats(paths)
+	if have.Paths != flat.Paths || have.PathBytes != flat.PathBytes {
+		t.Errorf("wrong stats\nhave: %+v\nwant: %+v", have, flat)
+	}
+	// "", home, user, src, github.com, example, project, node_modules
+	if want := 8 + len(paths) - 1; have.Nodes != want {
+		t.Errorf("wrong number of nodes: %d; want %d", have.Nodes, want)
+	}
+	if have.Bytes >= flat.Bytes {
+		t.Errorf("tree doesn't use less memory:\ntree: %+v\nflat: %+v", have, flat)
+	}
+}
@@ -0,0 +1,333 @@
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// AddPending watches a path that may not exist yet, such as a socket, pid
+// file, or config file that's created by another program at boot.
+//
+// If the path exists it's added with [Watcher.Add]. Otherwise the nearest
+// parent directory that exists is watched, moving down as directories are
+// created, and once the path is created a Create event is sent for it and it's
+// watched like any other path. Other events in the parent directories aren't
+// sent, unless the directory was already watched.
+//
+// The path is added as soon as it exists, so it may already exist once
+// AddPending returns without a Create event being sent; use os.Stat after
+// calling AddPending to check.
+//
+// The parent directories show up in [Watcher.WatchList] while they're watched.
+// Use [Watcher.Remove] with the path to stop waiting for it.
+func (w *Watcher) AddPending(path string) error {
+	w.pendings.op.Lock()
+	defer w.pendings.op.Unlock()
+	return w.resolvePending(filepath.Clean(path), false)
+}
+
+// WithReArm adds the path again if it's removed and then recreated, for
+// example by a deploy or logrotate. Normally the watch is removed along with the
+// path, and no more events are sent for it.
+//
+// After the Remove or Rename event for the path itself it's watched as with
+// [Watcher.AddPending], and a Create event is sent once the path is recreated.
+// The options passed to AddWith are used again. The path is added after the
+// Create event, so changes right after it's recreated may not be sent.
+//
+// On Windows a directory can't be recreated while there's still an open handle
+// for it, so the handle is closed as soon as the removal is seen, before the
+// Remove event is sent.
+//
+// Use [Watcher.Remove] to stop watching or waiting for the path.
+func WithReArm() addOpt {
+	return func(opt *withOpts) { opt.reArm = true }
+}
+
+// pendings keeps track of paths added with AddPending that don't exist yet.
+type pendings struct {
+	op      sync.Mutex // Held while adding or removing watches.
+	mu      sync.Mutex
+	paths   map[string]string   // Pending path → watched parent directory.
+	parents map[string]int      // Directories added by AddPending → number of pending paths.
+	retired map[string]struct{} // Directories that were removed; their events may still be read.
+	sent    map[string]struct{} // Pending paths for which the Create was sent.
+	user    map[string]struct{} // Paths added with Add while AddPending is used.
+	reArm   map[string]reArm    // WithReArm watches.
+}
+
+type reArm struct {
+	name string   // Path as passed to AddWith, e.g. with "/...".
+	opts []addOpt // Options passed to AddWith.
+}
+
+func newPendings() *pendings {
+	return &pendings{
+		paths:   make(map[string]string),
+		parents: make(map[string]int),
+		retired: make(map[string]struct{}),
+		sent:    make(map[string]struct{}),
+		user:    make(map[string]struct{}),
+		reArm:   make(map[string]reArm),
+	}
+}
+
+// added records that the path was added with AddWith, for WithReArm, and so
+// that its events are always sent while AddPending is used.
+func (p *pendings) added(path string, opts []addOpt) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	root, _ := recursivePath(filepath.Clean(path))
+	if getOptions(opts...).reArm {
+		p.reArm[root] = reArm{name: path, opts: opts}
+	} else {
+		delete(p.reArm, root)
+	}
+	if len(p.paths) == 0 && len(p.retired) == 0 {
+		return
+	}
+	delete(p.retired, root)
+	p.user[root] = struct{}{}
+}
+
+// addPending adds the path once it exists, with the same name and options as
+// before for WithReArm.
+func (w *Watcher) addPending(path string) error {
+	w.pendings.mu.Lock()
+	r, ok := w.pendings.reArm[path]
+	w.pendings.mu.Unlock()
+	if ok {
+		return w.AddWith(r.name, r.opts...)
+	}
+	return w.Add(path)
+}
+
+// resolvePending watches the path if it exists, or else its nearest existing
+// parent directory. If send is true a Create event is sent if the path exists;
+// w.pendings.op must be held.
+func (w *Watcher) resolvePending(path string, send bool) error {
+	for {
+		st, err := os.Lstat(path)
+		if err == nil {
+			err = w.addPending(path)
+			if w.releasePending(path) {
+				send = false
+			}
+			if err == nil && send {
+				isDir := st.IsDir()
+				w.scan.run(func() { w.sendSynthetic(path, Create, isDir) })
+			}
+			return err
+		}
+
+		parent := filepath.Dir(path)
+		for {
+			if st, err := os.Stat(parent); err == nil && st.IsDir() {
+				break
+			}
+			if filepath.Dir(parent) == parent {
+				return err
+			}
+			parent = filepath.Dir(parent)
+		}
+
+		w.pendings.mu.Lock()
+		old, ok := w.pendings.paths[path]
+		w.pendings.mu.Unlock()
+		if ok && old == parent {
+			return nil
+		}
+		if err := w.watchPendingParent(parent); err != nil {
+			return err
+		}
+		if ok {
+			w.releasePending(path)
+		}
+		w.pendings.mu.Lock()
+		w.pendings.paths[path] = parent
+		w.pendings.mu.Unlock()
+
+		// The next directory (or the path itself) may have been created before
+		// the parent was watched; move down if it was.
+		next := path
+		for filepath.Dir(next) != parent {
+			next = filepath.Dir(next)
+		}
+		if _, err := os.Lstat(next); err != nil {
+			return nil
+		}
+		send = true
+	}
+}
+
+// watchPendingParent adds the parent directory, unless it's already watched.
+func (w *Watcher) watchPendingParent(parent string) error {
+	w.pendings.mu.Lock()
+	if _, ok := w.pendings.parents[parent]; ok {
+		w.pendings.parents[parent]++
+		w.pendings.mu.Unlock()
+		return nil
+	}
+	w.pendings.mu.Unlock()
+
+	for _, p := range w.WatchList() {
+		if root, _ := recursivePath(p); root == parent {
+			return nil // Added by the user; leave it alone.
+		}
+	}
+
+	if err := w.Add(parent); err != nil {
+		return err
+	}
+	w.pendings.mu.Lock()
+	delete(w.pendings.user, parent)
+	w.pendings.parents[parent]++
+	w.pendings.mu.Unlock()
+	return nil
+}
+
+// releasePending stops waiting for path, and removes the parent directory if
+// no other pending paths use it. It returns true if the Create event for the
+// path was already sent; w.pendings.op must be held.
+func (w *Watcher) releasePending(path string) bool {
+	w.pendings.mu.Lock()
+	parent, ok := w.pendings.paths[path]
+	_, sent := w.pendings.sent[path]
+	delete(w.pendings.paths, path)
+	delete(w.pendings.sent, path)
+	n, ours := w.pendings.parents[parent]
+	if ok && ours {
+		if n <= 1 {
+			delete(w.pendings.parents, parent)
+			w.pendings.retired[parent] = struct{}{}
+		} else {
+			w.pendings.parents[parent]--
+		}
+	}
+	w.pendings.mu.Unlock()
+
+	if ok && ours && n <= 1 {
+		_ = w.Remove(parent)
+	}
+	return sent
+}
+
+// removePending stops waiting for the path, for Remove. It returns false if
+// the path wasn't added with AddPending.
+func (w *Watcher) removePending(path string) bool {
+	path = filepath.Clean(path)
+	w.pendings.mu.Lock()
+	root, _ := recursivePath(path)
+	delete(w.pendings.user, root)
+	delete(w.pendings.reArm, root)
+	_, ok := w.pendings.paths[root]
+	w.pendings.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	w.pendings.op.Lock()
+	defer w.pendings.op.Unlock()
+	w.releasePending(root)
+	return true
+}
+
+// pendingEvent reports if the event should be sent: events in directories
+// that were only added by AddPending, and in the directories that are created
+// on the way to a pending path, are dropped; except the Create for the pending
+// path itself.
+//
+// If a directory on the way to a pending path was created, or the parent was
+// removed, the watch is moved in the background.
+func (w *Watcher) pendingEvent(e Event) bool {
+	w.pendings.mu.Lock()
+	defer w.pendings.mu.Unlock()
+	if len(w.pendings.paths) == 0 && len(w.pendings.parents) == 0 && len(w.pendings.retired) == 0 &&
+		len(w.pendings.reArm) == 0 {
+		return true
+	}
+
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	var (
+		dir  = filepath.Dir(name)
+		want bool
+		move []string
+	)
+	for path, parent := range w.pendings.paths {
+		switch {
+		case dir == parent && e.Op&opCreate != 0 &&
+			(name == path || strings.HasPrefix(path, name+string(os.PathSeparator))):
+			move = append(move, path)
+			if name == path {
+				want = true
+				w.pendings.sent[path] = struct{}{}
+			}
+		case name == parent && e.Op&(opRemove|opRename) != 0:
+			move = append(move, path)
+		}
+	}
+	if _, ok := w.pendings.reArm[name]; ok && e.Op&(opRemove|opRename) != 0 {
+		if _, ok := w.pendings.paths[name]; !ok {
+			move = append(move, cloneString(name))
+		}
+	}
+	if len(move) > 0 {
+		w.scan.run(func() {
+			w.pendings.op.Lock()
+			defer w.pendings.op.Unlock()
+			for _, path := range move {
+				w.pendings.mu.Lock()
+				_, pending := w.pendings.paths[path]
+				_, reArm := w.pendings.reArm[path]
+				w.pendings.mu.Unlock()
+				if !pending && !reArm {
+					continue // Removed in the meantime.
+				}
+				err := w.resolvePending(path, true)
+				if err != nil {
+					w.sendError(WatchError{Path: path, Op: "add", Err: err})
+				}
+			}
+		})
+	}
+
+	if want {
+		return true
+	}
+	if _, ok := w.pendings.user[name]; ok {
+		return true
+	}
+	return !w.pendings.ours(dir) && !w.pendings.ours(name)
+}
+
+// owns reports if the directory was added by AddPending, rather than the user.
+func (p *pendings) owns(dir string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.parents[dir]
+	return ok
+}
+
+// ours reports if the directory was added by AddPending, or is a directory
+// that was created on the way to a pending path; p.mu must be held.
+func (p *pendings) ours(dir string) bool {
+	if _, ok := p.parents[dir]; ok {
+		return true
+	}
+	if _, ok := p.retired[dir]; ok {
+		return true
+	}
+	for path, parent := range p.paths {
+		if _, ok := p.parents[parent]; ok &&
+			strings.HasPrefix(dir, parent+string(os.PathSeparator)) &&
+			strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddPending(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t)
+	defer w.Close()
+
+	path := join(tmp, "run", "app", "app.pid")
+	if err := w.AddPending(path); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != tmp {
+		t.Errorf("wrong WatchList: %q", l)
+	}
+
+	touch(t, tmp, "other")
+	mkdirAll(t, tmp, "run", "app")
+	eventSeparator()
+	touch(t, path)
+
+	have := eventsFor(t, w, 500*time.Millisecond)
+	if len(have) != 1 || have[0].Name != path || have[0].Op&opCreate == 0 {
+		t.Fatalf("want one Create for %q, have %v", path, have)
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != path {
+		t.Errorf("wrong WatchList: %q", l)
+	}
+
+	// It's now a regular watch.
+	cat(t, "data", path)
+	have = eventsFor(t, w, 500*time.Millisecond)
+	if len(have) == 0 || have[0].Op&opWrite == 0 {
+		t.Errorf("no Write: %v", have)
+	}
+}
+
+func TestAddPendingExisting(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t)
+	defer w.Close()
+
+	touch(t, tmp, "file")
+	if err := w.AddPending(join(tmp, "file")); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != join(tmp, "file") {
+		t.Errorf("wrong WatchList: %q", l)
+	}
+}
+
+func TestAddPendingWatchedParent(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t, tmp)
+	defer w.Close()
+
+	path := join(tmp, "file")
+	if err := w.AddPending(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events in the directory are still sent, and the watch isn't removed.
+	touch(t, tmp, "other")
+	touch(t, path)
+	have := eventsFor(t, w, 500*time.Millisecond)
+	if len(have) < 2 || have[0].Name != join(tmp, "other") {
+		t.Errorf("wrong events: %v", have)
+	}
+	if l := w.WatchList(); len(l) != 2 {
+		t.Errorf("wrong WatchList: %q", l)
+	}
+}
+
+func TestAddPendingRemove(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t)
+	defer w.Close()
+
+	path := join(tmp, "dir", "file")
+	if err := w.AddPending(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if l := w.WatchList(); len(l) != 0 {
+		t.Errorf("wrong WatchList: %q", l)
+	}
+
+	mkdir(t, tmp, "dir")
+	touch(t, path)
+	if have := eventsFor(t, w, 200*time.Millisecond); len(have) != 0 {
+		t.Errorf("events after Remove: %v", have)
+	}
+}
+
+func TestWithReArm(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t)
+	defer w.Close()
+
+	file := join(tmp, "file")
+	touch(t, file)
+	if err := w.AddWith(file, WithReArm()); err != nil {
+		t.Fatal(err)
+	}
+
+	rm(t, file)
+	if have := eventsFor(t, w, 200*time.Millisecond); len(have) == 0 || have[len(have)-1].Op&opRemove == 0 {
+		t.Fatalf("no Remove: %v", have)
+	}
+
+	touch(t, tmp, "other")
+	touch(t, file)
+	have := eventsFor(t, w, 200*time.Millisecond)
+	if len(have) != 1 || have[0].Name != file || have[0].Op&opCreate == 0 {
+		t.Fatalf("want one Create for %q, have %v", file, have)
+	}
+
+	cat(t, "data", file)
+	have = eventsFor(t, w, 200*time.Millisecond)
+	if len(have) == 0 || have[0].Name != file || have[0].Op&opWrite == 0 {
+		t.Fatalf("no Write: %v", have)
+	}
+	if l := w.WatchList(); len(l) != 1 || l[0] != file {
+		t.Errorf("wrong WatchList: %q", l)
+	}
+
+	// Removed watches aren't added again.
+	if err := w.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	rm(t, file)
+	touch(t, file)
+	if have := eventsFor(t, w, 200*time.Millisecond); len(have) != 0 {
+		t.Errorf("events after Remove: %v", have)
+	}
+}
@@ -0,0 +1,44 @@
+package fsnotify
+
+// filter events from the backend before sending them.
+//
+// Every event goes through the same steps, in this order: events for paths
+// that are being added (pending), AddFile watches and rotation, unlinked files,
+// IsDir, Xattr, Chmod, checksums, one-shot watches, Remove events for the paths
+// below a removed directory (reconcile), content diffs, and finally the
+// conversion of the name. Events that make it through are counted for Stats;
+// the journal is only written once an event is delivered (see
+// delivery.sent()).
+func (w *Watcher) filter(e Event) (Event, bool) {
+	if !w.pendingEvent(e) {
+		return e, false
+	}
+	e, ok := w.files.filter(e)
+	if !ok || w.unlinked(e) {
+		return e, false
+	}
+	e = w.reconcile.markDir(e)
+	e = w.attrs.filter(e)
+	e, ok = w.chmod.filter(e)
+	if ok {
+		e, ok = w.sums.filter(e)
+	}
+	if ok {
+		ok = w.oneShot(e)
+	}
+	if ok {
+		// Send the Remove events for the paths below a directory before the
+		// event for it.
+		if gone := w.reconcile.seen(e); len(gone) > 0 {
+			w.sendGone(gone)
+		}
+		e = w.diffs.diff(e)
+		e = e.relativeTo(w.with.relativeTo)
+		e = normEvent(w.with.unicodeNorm, e)
+		e = w.with.nameEncoding.event(e)
+	}
+	if ok {
+		w.stats.event(e, len(w.Events))
+	}
+	return e, ok
+}
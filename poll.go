@@ -0,0 +1,86 @@
+package fsnotify
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// With BackendPoll no watches are added to the system: the reconciler (see
+// WithReconcile) scans the added paths every interval, and sends every change
+// since the last scan.
+
+// Default interval for BackendPoll, if it's not set with WithReconcile.
+var pollInterval = time.Second
+
+// withPoll selects BackendPoll; this is set by NewWatcherWithBackend.
+func withPoll() addOpt {
+	return func(opt *withOpts) { opt.poll = true }
+}
+
+// polling reports if the Watcher uses BackendPoll.
+func (r *reconciler) polling() bool { return r != nil && r.poll != nil }
+
+// add a path for BackendPoll, and record the current state so the first scan
+// has something to compare to.
+func (r *reconciler) add(name string, with withOpts) error {
+	switch {
+	case with.retarget:
+		return ErrUnsupported{Feature: "WithRetarget", Backend: string(BackendPoll)}
+	case with.maxDepth > 0:
+		return ErrUnsupported{Feature: "WithMaxDepth", Backend: string(BackendPoll)}
+	case with.ops != 0:
+		return ErrUnsupported{Feature: "WithOps", Backend: string(BackendPoll)}
+	}
+	if err := checkPlatformOpts(with, string(BackendPoll), false); err != nil {
+		return err
+	}
+
+	root, recurse := recursivePath(name)
+	if with.onlyDir {
+		if err := checkDir(root, with.noFollow); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Lstat(root); err != nil {
+		return err
+	}
+	s, err := TakeSnapshot([]string{name})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k, v := range s {
+		if _, ok := r.state[k]; !ok {
+			r.state[k] = v
+			r.know(k, v.IsDir)
+		}
+	}
+	r.roots[root] = r.roots[root] || recurse
+	r.poll[name] = struct{}{}
+	return nil
+}
+
+// remove a path for BackendPoll.
+func (r *reconciler) remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.poll[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNonExistentWatch, name)
+	}
+	delete(r.poll, name)
+	return nil
+}
+
+// list the paths for BackendPoll.
+func (r *reconciler) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := make([]string, 0, len(r.poll))
+	for p := range r.poll {
+		l = append(l, p)
+	}
+	return l
+}
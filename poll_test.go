@@ -0,0 +1,64 @@
+package fsnotify
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackendPoll(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "dir", noWait)
+	touch(t, tmp, "existing", noWait)
+
+	w, err := NewWatcherWithBackend(BackendPoll, WithReconcile(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.Backend() != BackendPoll {
+		t.Errorf("Backend() = %q", w.Backend())
+	}
+	addWatch(t, w, join(tmp, "..."))
+	if have := w.WatchList(); !reflect.DeepEqual(have, []string{join(tmp, "...")}) {
+		t.Errorf("WatchList() = %q", have)
+	}
+
+	// Nothing is sent for the paths that existed before Add.
+	if have := eventsFor(t, w, 400*time.Millisecond); len(have) > 0 {
+		t.Fatalf("events for existing paths: %v", have)
+	}
+
+	touch(t, tmp, "dir", "file", noWait)
+	rm(t, tmp, "existing", noWait)
+	ops := make(map[string]Op)
+	for _, e := range eventsFor(t, w, 400*time.Millisecond) {
+		ops[e.Name] |= e.Op
+	}
+	if ops[join(tmp, "dir", "file")]&opCreate == 0 {
+		t.Errorf("no Create for new file: %v", ops)
+	}
+	if ops[join(tmp, "existing")]&opRemove == 0 {
+		t.Errorf("no Remove for removed file: %v", ops)
+	}
+
+	if err := w.Remove(join(tmp, "...")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Remove(join(tmp, "...")); !errors.Is(err, ErrNonExistentWatch) {
+		t.Errorf("wrong error removing twice: %v", err)
+	}
+	touch(t, tmp, "after", noWait)
+	if have := eventsFor(t, w, 400*time.Millisecond); len(have) > 0 {
+		t.Errorf("events after Remove: %v", have)
+	}
+
+	err = w.AddWith(tmp, WithMaxDepth(1))
+	var unsup ErrUnsupported
+	if !errors.As(err, &unsup) || unsup.Backend != "poll" {
+		t.Errorf("wrong error for WithMaxDepth: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package fsnotify
+
+import (
+	"os"
+	"strings"
+)
+
+// Capabilities describes what can be reported for a path on the current
+// platform; see [Probe].
+type Capabilities struct {
+	Backend    string // "inotify", "kqueue", "windows", or "fen".
+	Filesystem string // Type of the filesystem, e.g. "ext4", "apfs", "nfs", or "NTFS"; empty if it's not known.
+
+	// Changes to the path are reported. This is false for network
+	// filesystems and FUSE, where changes made elsewhere aren't reported, and
+	// for pseudo filesystems such as /proc and /sys; use polling instead.
+	Native bool
+
+	// The path is on a network filesystem, such as NFS or SMB. Only changes
+	// made by this machine are reported.
+	Network bool
+
+	// Directories are watched recursively by the system, rather than with a
+	// watch for every subdirectory; files created in a new directory before
+	// it's watched are never missed. Only on Windows.
+	Recursive bool
+
+	// Both the old and new name of a file that's renamed in a watched
+	// directory are known: a Rename with the old name is followed by a
+	// Create with the new name, with nothing in between. On other platforms
+	// the Create may be sent later or not at all.
+	RenamePairs bool
+
+	// Closing a file after writing to it is reported (with [WithOps] and
+	// Close), so it's known when writing is finished.
+	CloseWrite bool
+}
+
+// Probe reports what can be reported for a path on the current platform, to
+// decide between watching it and polling it.
+//
+// Filesystems that aren't known are assumed to be local, and Native is true
+// for them.
+func Probe(path string) (Capabilities, error) {
+	c := Capabilities{Backend: defaultBackend()}
+	switch c.Backend {
+	case "inotify":
+		c.RenamePairs = true
+	case "windows":
+		c.Recursive, c.RenamePairs = true, true
+	}
+	c.CloseWrite = supportedOps.Has(Close)
+
+	if _, err := os.Stat(path); err != nil {
+		return c, err
+	}
+	fs, network, err := fsType(path)
+	if err != nil {
+		return c, err
+	}
+	c.Filesystem = fs
+	c.Network = network || networkFS[strings.ToLower(fs)]
+	c.Native = !c.Network && !pseudoFS[strings.ToLower(fs)] && !strings.Contains(strings.ToLower(fs), "fuse")
+	return c, nil
+}
+
+// Network filesystems, as named by the various platforms.
+var networkFS = map[string]bool{
+	"nfs": true, "nfs4": true, "smb": true, "smb2": true, "smbfs": true, "cifs": true,
+	"afs": true, "coda": true, "ceph": true, "9p": true, "afpfs": true, "webdav": true,
+}
+
+// Pseudo filesystems, for which changes aren't reported.
+var pseudoFS = map[string]bool{
+	"proc": true, "procfs": true, "sysfs": true, "devfs": true, "devpts": true, "fdescfs": true,
+	"cgroup": true, "cgroup2": true, "debugfs": true, "tracefs": true, "securityfs": true, "bpf": true,
+}
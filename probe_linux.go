@@ -0,0 +1,59 @@
+//go:build linux
+// +build linux
+
+package fsnotify
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// Names of the statfs f_type magic numbers.
+var fsMagic = map[uint32]string{
+	unix.EXT4_SUPER_MAGIC:      "ext4", // Also ext2 and ext3.
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.XFS_SUPER_MAGIC:       "xfs",
+	unix.F2FS_SUPER_MAGIC:      "f2fs",
+	0x2fc12fc1:                 "zfs",
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.RAMFS_MAGIC:           "ramfs",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlay",
+	unix.SQUASHFS_MAGIC:        "squashfs",
+	unix.ISOFS_SUPER_MAGIC:     "iso9660",
+	unix.MSDOS_SUPER_MAGIC:     "vfat",
+	unix.EXFAT_SUPER_MAGIC:     "exfat",
+	0x5346544e:                 "ntfs",
+	unix.FUSE_SUPER_MAGIC:      "fuse",
+	unix.NFS_SUPER_MAGIC:       "nfs",
+	unix.SMB_SUPER_MAGIC:       "smb",
+	0xff534d42:                 "cifs",
+	0xfe534d42:                 "smb2",
+	unix.AFS_SUPER_MAGIC:       "afs",
+	unix.AFS_FS_MAGIC:          "afs",
+	unix.CODA_SUPER_MAGIC:      "coda",
+	unix.CEPH_SUPER_MAGIC:      "ceph",
+	unix.V9FS_MAGIC:            "9p",
+	unix.PROC_SUPER_MAGIC:      "proc",
+	unix.SYSFS_MAGIC:           "sysfs",
+	unix.DEVPTS_SUPER_MAGIC:    "devpts",
+	unix.CGROUP_SUPER_MAGIC:    "cgroup",
+	unix.CGROUP2_SUPER_MAGIC:   "cgroup2",
+	unix.DEBUGFS_MAGIC:         "debugfs",
+	unix.TRACEFS_MAGIC:         "tracefs",
+	unix.SECURITYFS_MAGIC:      "securityfs",
+	unix.BPF_FS_MAGIC:          "bpf",
+}
+
+// fsType gets the type of the filesystem, and if it's a network filesystem.
+func fsType(path string) (string, bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", false, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	if name, ok := fsMagic[uint32(st.Type)]; ok {
+		return name, false, nil
+	}
+	return "0x" + strconv.FormatUint(uint64(uint32(st.Type)), 16), false, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+// +build !linux,!darwin,!freebsd,!dragonfly,!windows
+
+package fsnotify
+
+// fsType gets the type of the filesystem; this isn't supported on this
+// platform.
+func fsType(path string) (string, bool, error) { return "", false, nil }
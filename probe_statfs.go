@@ -0,0 +1,19 @@
+//go:build darwin || freebsd || dragonfly
+// +build darwin freebsd dragonfly
+
+package fsnotify
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fsType gets the type of the filesystem, and if it's a network filesystem.
+func fsType(path string) (string, bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return "", false, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	return unix.ByteSliceToString(st.Fstypename[:]), false, nil
+}
@@ -0,0 +1,37 @@
+package fsnotify
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	c, err := Probe(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Backend != defaultBackend() {
+		t.Errorf("Backend = %q; want %q", c.Backend, defaultBackend())
+	}
+	if c.Recursive != (runtime.GOOS == "windows") {
+		t.Errorf("Recursive = %t on %s", c.Recursive, runtime.GOOS)
+	}
+	if c.CloseWrite != supportedOps.Has(Close) {
+		t.Errorf("CloseWrite = %t; SupportedOps = %s", c.CloseWrite, SupportedOps())
+	}
+
+	if _, err := Probe(join(t.TempDir(), "nonexistent")); !os.IsNotExist(err) {
+		t.Errorf("wrong error for nonexistent path: %v", err)
+	}
+
+	if runtime.GOOS == "linux" {
+		c, err := Probe("/proc/self")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Filesystem != "proc" || c.Native || c.Network {
+			t.Errorf("/proc: %+v", c)
+		}
+	}
+}
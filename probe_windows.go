@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// fsType gets the type of the filesystem of the volume, and if it's a network
+// share.
+func fsType(path string) (string, bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false, err
+	}
+	p, err := windows.UTF16PtrFromString(longPath(abs))
+	if err != nil {
+		return "", false, err
+	}
+	root := make([]uint16, windows.MAX_PATH+1)
+	if err := windows.GetVolumePathName(p, &root[0], uint32(len(root))); err != nil {
+		return "", false, os.NewSyscallError("GetVolumePathName", err)
+	}
+	network := windows.GetDriveType(&root[0]) == windows.DRIVE_REMOTE
+
+	name := make([]uint16, windows.MAX_PATH+1)
+	err = windows.GetVolumeInformation(&root[0], nil, 0, nil, nil, nil, &name[0], uint32(len(name)))
+	if err != nil {
+		return "", network, nil // Not all network shares support this.
+	}
+	return windows.UTF16ToString(name), network, nil
+}
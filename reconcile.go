@@ -0,0 +1,321 @@
+package fsnotify
+
+import (
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithReconcile rescans all watched paths every interval, and sends the
+// events for any changes that the system didn't report: a Create, Write, or
+// Remove for every path that was added, changed, or removed since the last scan
+// without an event for it. This is only used by [NewWatcherWith], and is a
+// no-op for [Watcher.AddWith].
+//
+// This is a safety net for filesystems that don't reliably report changes,
+// such as Docker bind mounts on macOS and Windows, WSL, or SMB shares; on
+// filesystems that never report changes made elsewhere (see [Probe]) it works
+// as polling. Every scan reads all watched directories, so use an interval of
+// a minute or more for large trees.
+//
+// Changes that the system reports within 100ms after a scan aren't sent again,
+// but a change may be sent twice if the system is slower to report it.
+//
+// The paths from the scans are also used for the Remove events that are sent
+// for the paths below a directory that's removed or moved away (see [Remove]),
+// so that this includes paths that existed before the watch was added.
+func WithReconcile(interval time.Duration) addOpt {
+	return func(opt *withOpts) { opt.reconcile = interval }
+}
+
+// How long to wait for events after scanning; a change is only sent if there
+// was no event for it by then.
+var reconcileDelay = 100 * time.Millisecond
+
+// reconciler keeps track of the paths that are known to exist, to send Remove
+// events for the paths below a directory that's removed. For WithReconcile it
+// also keeps a snapshot of the watched paths as of the last event for them, to
+// find changes the backend didn't send.
+type reconciler struct {
+	interval time.Duration
+	mu       sync.Mutex
+	known    map[string]map[string]bool // dir → name → isDir, for every known path.
+	state    Snapshot                   // As of the last scan, updated by events.
+	roots    map[string]bool            // Paths from WatchList at the last scan; true if recursive.
+	touched  map[string]struct{}        // Paths with an event since the current scan started.
+	poll     map[string]struct{}        // Paths added with BackendPoll; nil for other backends.
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+func newReconciler(with withOpts) *reconciler {
+	r := &reconciler{
+		interval: with.reconcile,
+		known:    make(map[string]map[string]bool),
+		state:    make(Snapshot),
+		roots:    make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+	if with.poll {
+		r.poll = make(map[string]struct{})
+		if r.interval <= 0 {
+			r.interval = pollInterval
+		}
+	}
+	return r
+}
+
+// seen updates the known paths for an event that's being sent, returning the
+// paths below a directory that was removed or renamed, for which no Remove was
+// sent.
+func (r *reconciler) seen(e Event) Snapshot {
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.touched != nil {
+		r.touched[cloneString(name)] = struct{}{}
+	}
+	var gone Snapshot
+	if e.Op&(opRemove|opRename) != 0 {
+		gone = r.forget(name)
+	}
+	if e.Op&(opCreate|opWrite|opChmod) != 0 {
+		name = cloneString(name)
+		if r.interval > 0 {
+			delete(r.state, name)
+			r.state.add(name)
+			r.know(name, r.state[name].IsDir)
+		} else {
+			r.know(name, e.Op&IN_ISDIR != 0)
+		}
+	}
+	return gone
+}
+
+// markDir sets IN_ISDIR from the known paths if the backend didn't set it. On
+// Linux inotify always sets it, except for the watched path itself.
+func (r *reconciler) markDir(e Event) Event {
+	if e.Op&IN_ISDIR != 0 || (runtime.GOOS == "linux" && e.Op&(opRemove|opRename) == 0) {
+		return e
+	}
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	r.mu.Lock()
+	isDir := r.known[filepath.Dir(name)][filepath.Base(name)]
+	r.mu.Unlock()
+	if isDir {
+		e.Op |= IN_ISDIR
+	}
+	return e
+}
+
+// know adds path to the known paths, along with its parent directories; r.mu
+// must be held.
+func (r *reconciler) know(path string, isDir bool) {
+	for {
+		dir := filepath.Dir(path)
+		if dir == path {
+			return
+		}
+		c, ok := r.known[dir]
+		if !ok {
+			c = make(map[string]bool)
+			r.known[dir] = c
+		}
+		base := filepath.Base(path)
+		_, existed := c[base]
+		c[base] = c[base] || isDir
+		if existed {
+			return
+		}
+		path, isDir = dir, true
+	}
+}
+
+// forget removes path and all known paths below it, returning the paths below
+// it; r.mu must be held.
+func (r *reconciler) forget(path string) Snapshot {
+	if c, ok := r.known[filepath.Dir(path)]; ok {
+		delete(c, filepath.Base(path))
+		if len(c) == 0 {
+			delete(r.known, filepath.Dir(path))
+		}
+	}
+	delete(r.state, path)
+
+	var (
+		gone Snapshot
+		walk func(dir string)
+	)
+	walk = func(dir string) {
+		for n, isDir := range r.known[dir] {
+			p := filepath.Join(dir, n)
+			if gone == nil {
+				gone = make(Snapshot)
+			}
+			gone[p] = SnapshotEntry{IsDir: isDir}
+			delete(r.state, p)
+			if isDir {
+				walk(p)
+			}
+		}
+		delete(r.known, dir)
+	}
+	walk(path)
+	return gone
+}
+
+// sendGone sends a Remove event for the paths returned by seen, deepest paths
+// first.
+func (w *Watcher) sendGone(gone Snapshot) {
+	paths := make([]string, 0, len(gone))
+	for p := range gone {
+		paths = append(paths, p)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, p := range paths {
+		if !w.sendSynthetic(p, Remove, gone[p].IsDir) {
+			return
+		}
+	}
+}
+
+// run scans the paths returned by list every interval until stopped, sending
+// the changes that weren't seen with send.
+func (r *reconciler) run(list func() []string, send func(name string, op Op, isDir bool) bool) {
+	if r.interval <= 0 {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		t := time.NewTicker(r.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-r.done:
+				return
+			case <-t.C:
+			}
+			if !r.check(list(), send) {
+				return
+			}
+		}
+	}()
+}
+
+// check scans the paths once, returning false if send did or if stopped.
+func (r *reconciler) check(paths []string, send func(name string, op Op, isDir bool) bool) bool {
+	r.mu.Lock()
+	r.touched = make(map[string]struct{})
+	r.mu.Unlock()
+
+	var (
+		cur    = make(Snapshot)
+		roots  = make(map[string]bool, len(paths))
+		failed = make(map[string]bool)
+	)
+	for _, p := range paths {
+		root, recurse := recursivePath(p)
+		roots[root] = recurse
+		s, err := TakeSnapshot([]string{p})
+		if err != nil {
+			failed[root] = recurse // Try again on the next scan.
+			continue
+		}
+		for k, v := range s {
+			cur[k] = v
+		}
+	}
+
+	select {
+	case <-r.done:
+		return false
+	case <-time.After(reconcileDelay):
+	}
+
+	r.mu.Lock()
+	prev := make(Snapshot, len(r.state))
+	for k, v := range r.state {
+		if !inRoots(k, roots) {
+			continue // No longer watched.
+		}
+		prev[k] = v
+		if inRoots(k, failed) {
+			cur[k] = v
+		}
+	}
+	for k, v := range cur {
+		if _, ok := prev[k]; !ok && !inRoots(k, r.roots) {
+			prev[k] = v // Added since the last scan, so there's nothing to compare to.
+		}
+	}
+	for k := range r.touched {
+		// There was an event after the scan started, which is more recent.
+		if v, ok := r.state[k]; ok && inRoots(k, roots) {
+			cur[k] = v
+		} else {
+			delete(cur, k)
+		}
+	}
+	r.state, r.roots, r.touched = cur, roots, nil
+	for k, v := range cur {
+		r.know(k, v.IsDir)
+	}
+	r.mu.Unlock()
+
+	added, changed, removed := prev.Diff(cur)
+	for _, p := range removed {
+		if !send(p, Remove, prev[p].IsDir) {
+			return false
+		}
+	}
+	for _, p := range added {
+		if !send(p, Create, cur[p].IsDir) {
+			return false
+		}
+	}
+	for _, p := range changed {
+		if !send(p, Write, cur[p].IsDir) {
+			return false
+		}
+	}
+	return true
+}
+
+// inRoots reports if the path is one of the roots, an entry in one of them, or
+// below a recursive one.
+func inRoots(path string, roots map[string]bool) bool {
+	if _, ok := roots[path]; ok {
+		return true
+	}
+	if _, ok := roots[filepath.Dir(path)]; ok {
+		return true
+	}
+	for r, recurse := range roots {
+		if recurse && strings.HasPrefix(path, r+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// stop scanning and wait for it to finish; this must be called before closing
+// the Events channel.
+func (r *reconciler) stop() {
+	select {
+	case <-r.done:
+	default:
+		close(r.done)
+	}
+	r.wg.Wait()
+}
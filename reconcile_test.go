@@ -0,0 +1,216 @@
+package fsnotify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	touch(t, tmp, "file", noWait)
+	touch(t, tmp, "gone", noWait)
+
+	r := newReconciler(withOpts{reconcile: time.Hour})
+	check := func() []string {
+		t.Helper()
+		var have []string
+		r.check([]string{tmp}, func(name string, op Op, isDir bool) bool {
+			have = append(have, fmt.Sprintf("%s %s", op, name[len(tmp):]))
+			return true
+		})
+		sort.Strings(have)
+		return have
+	}
+
+	// Nothing to compare with on the first scan.
+	if have := check(); len(have) != 0 {
+		t.Fatalf("events on first scan: %s", have)
+	}
+
+	// Changes without an event.
+	touch(t, tmp, "new", noWait)
+	cat(t, "data", join(tmp, "file"), noWait)
+	rm(t, tmp, "gone", noWait)
+	want := []string{Create.String() + " /new", Remove.String() + " /gone", Write.String() + " /file"}
+	sort.Strings(want)
+	if have := check(); fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+
+	// Changes that were sent aren't sent again.
+	touch(t, tmp, "sent", noWait)
+	r.seen(Event{Name: join(tmp, "sent"), Op: opCreate})
+	rm(t, tmp, "new", noWait)
+	r.seen(Event{Name: join(tmp, "new"), Op: opRemove})
+	if have := check(); len(have) != 0 {
+		t.Errorf("events for changes that were seen: %s", have)
+	}
+
+	// Paths that are no longer watched.
+	r.check(nil, func(string, Op, bool) bool { return true })
+	if len(r.state) != 0 {
+		t.Errorf("state not cleared: %v", r.state)
+	}
+}
+
+func TestWithReconcile(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithReconcile(50 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addWatch(t, w, tmp)
+	time.Sleep(100 * time.Millisecond) // Wait for the first scan.
+
+	// The event from the system is sent, and the scans after it shouldn't send
+	// it again.
+	file := join(tmp, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(500 * time.Millisecond)
+	n := 0
+loop:
+	for {
+		select {
+		case e := <-w.Events:
+			if e.Name == file && e.Op&opCreate != 0 {
+				n++
+			}
+			if n == 2 {
+				break loop
+			}
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			break loop
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d Create events; want 1", n)
+	}
+}
+
+func TestReconcileGone(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		t.Skip("recursion not supported on " + runtime.GOOS)
+	}
+
+	tmp := t.TempDir()
+	out := t.TempDir()
+	mkdirAll(t, tmp, "dir", "sub", noWait)
+	touch(t, tmp, "dir", "file", noWait)
+	touch(t, tmp, "dir", "sub", "file", noWait)
+
+	w, err := NewWatcherWith(WithReconcile(50 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp, "...")
+	time.Sleep(200 * time.Millisecond) // Wait for the first scan.
+
+	mv(t, join(tmp, "dir"), out, "dir")
+
+	// The directory itself may have more than one event (e.g. IN_MOVED_FROM
+	// and IN_MOVE_SELF on Linux).
+	var have []string
+	for _, e := range eventsFor(t, w, 200*time.Millisecond) {
+		n := e.Name[len(tmp):]
+		if e.Op&(opRemove|opRename) != 0 && (len(have) == 0 || have[len(have)-1] != n) {
+			have = append(have, n)
+		}
+	}
+	want := []string{"/dir/sub/file", "/dir/sub", "/dir/file", "/dir"}
+	if fmt.Sprint(filepath.ToSlash(fmt.Sprint(have))) != fmt.Sprint(want) {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+// Remove events for the paths below a directory are sent without WithReconcile
+// for the paths there was an event for.
+func TestRemoveKnownChildren(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		t.Skip("recursion not supported on " + runtime.GOOS)
+	}
+
+	tmp := t.TempDir()
+	out := t.TempDir()
+	w := newWatcher(t)
+	defer w.Close()
+	addWatch(t, w, tmp, "...")
+
+	mkdirAll(t, tmp, "dir", "sub")
+	touch(t, tmp, "dir", "file")
+	touch(t, tmp, "dir", "sub", "file")
+	eventsFor(t, w, 200*time.Millisecond)
+
+	mv(t, join(tmp, "dir"), out, "dir")
+	var have []string
+	for _, e := range eventsFor(t, w, 200*time.Millisecond) {
+		n := e.Name[len(tmp):]
+		if e.Op&(opRemove|opRename) != 0 && (len(have) == 0 || have[len(have)-1] != n) {
+			have = append(have, n)
+		}
+	}
+	want := []string{"/dir/sub/file", "/dir/sub", "/dir/file", "/dir"}
+	if fmt.Sprint(filepath.ToSlash(fmt.Sprint(have))) != fmt.Sprint(want) {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+}
+
+func TestReconcilerForget(t *testing.T) {
+	r := newReconciler(withOpts{})
+	for _, p := range []string{"/a/b/c", "/a/b/d", "/a/e", "/f"} {
+		r.seen(Event{Name: filepath.FromSlash(p), Op: opCreate})
+	}
+	gone := r.seen(Event{Name: filepath.FromSlash("/a"), Op: opRemove})
+	var have []string
+	for p, e := range gone {
+		have = append(have, fmt.Sprintf("%s %t", filepath.ToSlash(p), e.IsDir))
+	}
+	sort.Strings(have)
+	want := []string{"/a/b true", "/a/b/c false", "/a/b/d false", "/a/e false"}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("\nhave: %s\nwant: %s", have, want)
+	}
+	if gone := r.seen(Event{Name: filepath.FromSlash("/a"), Op: opRemove}); len(gone) != 0 {
+		t.Errorf("sent again: %v", gone)
+	}
+	if len(r.known) != 1 {
+		t.Errorf("paths not forgotten: %v", r.known)
+	}
+}
+
+func TestReconcilerMarkDir(t *testing.T) {
+	r := newReconciler(withOpts{})
+	r.seen(Event{Name: filepath.FromSlash("/a/b/c"), Op: opCreate})
+
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"/a/b", true},
+		{"/a/b/c", false},
+		{"/a/x", false},
+	} {
+		e := r.markDir(Event{Name: filepath.FromSlash(tt.name), Op: opRemove})
+		if e.IsDir() != tt.want {
+			t.Errorf("%s: IsDir() = %t; want %t", tt.name, e.IsDir(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// The rpc package is a separate module, so that importing fsnotify doesn't
+// pull in gRPC.
+module github.com/hohodqr/fsnotify/rpc
+
+go 1.17
+
+require (
+	github.com/hohodqr/fsnotify v0.0.0-00010101000000-000000000000
+	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.30.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+)
+
+replace github.com/hohodqr/fsnotify => ../
@@ -0,0 +1,513 @@
+// Package rpc exposes a [fsnotify.Watcher] over the network with gRPC, so an
+// agent on one machine can feed watchers on another.
+//
+// The server side wraps a Watcher:
+//
+//	w, err := fsnotify.NewWatcher()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	l, err := net.Listen("tcp", "localhost:7070")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(rpc.NewServer(w, rpc.WithToken(token)).Serve(l))
+//
+// And the client works much like a Watcher:
+//
+//	c, err := rpc.Dial("agent:7070", rpc.WithToken(token))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+//	if err := c.Add("/var/log"); err != nil {
+//		log.Fatal(err)
+//	}
+//	for e := range c.Events {
+//		log.Println(e)
+//	}
+//
+// The service is defined in rpcpb/fsnotify.proto, with Add, Remove, and
+// WatchList calls, and a server-streaming Events call. The server keeps the
+// last events in memory, and every event has a cursor that a stream can be
+// resumed from: the Client resumes after reconnecting, and misses nothing
+// unless it falls more than that behind, in which case it gets an error
+// wrapping [fsnotify.ErrEventOverflow].
+//
+// Anyone who can connect can watch any path the server can read, so use
+// [WithToken] and [WithTLS] when listening on anything other than localhost.
+//
+// This is a separate module, so that fsnotify itself doesn't depend on gRPC.
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hohodqr/fsnotify"
+	"github.com/hohodqr/fsnotify/rpc/rpcpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultBufferSize is the number of events and errors a Server keeps for
+// clients.
+const DefaultBufferSize = 10000
+
+// Option is an option for [NewServer] and [Dial].
+type Option func(*options)
+
+type options struct {
+	token string
+	tls   *tls.Config
+}
+
+// WithToken requires clients to send the token (for NewServer), or sends it to
+// the server (for Dial). The token is sent as "authorization: Bearer <token>"
+// metadata with every call, so it should be used with WithTLS unless the
+// connection is local.
+func WithToken(token string) Option {
+	return func(o *options) { o.token = token }
+}
+
+// WithTLS uses TLS for the connection: the server's certificate (for
+// NewServer), or the root CAs to verify the server with (for Dial).
+func WithTLS(c *tls.Config) Option {
+	return func(o *options) { o.tls = c }
+}
+
+func getOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// record is an event or an error.
+type record struct {
+	event fsnotify.Event
+	err   string // Error message, if this is an error.
+}
+
+// Server serves the events from a Watcher to clients.
+type Server struct {
+	rpcpb.UnimplementedWatcherServer
+
+	w    *fsnotify.Watcher
+	opts options
+	size int
+
+	mu      sync.Mutex
+	records []record      // Ring buffer with the last size records; cursor n is at n%size.
+	next    uint64        // Cursor of the next record.
+	notify  chan struct{} // Closed and replaced when a record is added.
+	closed  bool          // Watcher was closed.
+	srv     *grpc.Server
+}
+
+// NewServer creates a new Server for the watcher, and starts reading its events
+// and errors. The Watcher shouldn't be read from elsewhere.
+//
+// Close the Watcher to stop sending events; clients will get an error and close
+// their channels.
+func NewServer(w *fsnotify.Watcher, opts ...Option) *Server {
+	s := &Server{w: w, opts: getOptions(opts), size: DefaultBufferSize, notify: make(chan struct{})}
+	go s.read()
+	return s
+}
+
+func (s *Server) read() {
+	events, errs := s.w.Events, s.w.Errors
+	for events != nil || errs != nil {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			s.add(record{event: e})
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			s.add(record{err: err.Error()})
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	close(s.notify)
+}
+
+func (s *Server) add(r record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.records) < s.size {
+		s.records = append(s.records, r)
+	} else {
+		s.records[s.next%uint64(s.size)] = r
+	}
+	s.next++
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Serve accepts connections on l until it's closed or Stop is called.
+func (s *Server) Serve(l net.Listener) error {
+	var opts []grpc.ServerOption
+	if s.opts.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.opts.tls)))
+	}
+	if s.opts.token != "" {
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+				if err := s.auth(ctx); err != nil {
+					return nil, err
+				}
+				return h(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+				if err := s.auth(ss.Context()); err != nil {
+					return err
+				}
+				return h(srv, ss)
+			}))
+	}
+
+	srv := grpc.NewServer(opts...)
+	rpcpb.RegisterWatcherServer(srv, s)
+	s.mu.Lock()
+	s.srv = srv
+	s.mu.Unlock()
+	return srv.Serve(l)
+}
+
+// Stop closes the listener and all connections.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	srv := s.srv
+	s.mu.Unlock()
+	if srv != nil {
+		srv.Stop()
+	}
+}
+
+func (s *Server) auth(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if t := strings.TrimPrefix(v, "Bearer "); subtle.ConstantTimeCompare([]byte(t), []byte(s.opts.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing token")
+}
+
+// Add implements the Add call.
+func (s *Server) Add(_ context.Context, r *rpcpb.AddRequest) (*rpcpb.AddResponse, error) {
+	return &rpcpb.AddResponse{}, s.w.Add(r.Path)
+}
+
+// Remove implements the Remove call.
+func (s *Server) Remove(_ context.Context, r *rpcpb.RemoveRequest) (*rpcpb.RemoveResponse, error) {
+	return &rpcpb.RemoveResponse{}, s.w.Remove(r.Path)
+}
+
+// WatchList implements the WatchList call.
+func (s *Server) WatchList(context.Context, *rpcpb.WatchListRequest) (*rpcpb.WatchListResponse, error) {
+	return &rpcpb.WatchListResponse{Paths: s.w.WatchList()}, nil
+}
+
+var errClosed = status.Error(codes.Aborted, "watcher closed")
+
+// Events implements the Events call.
+func (s *Server) Events(r *rpcpb.EventsRequest, stream rpcpb.Watcher_EventsServer) error {
+	s.mu.Lock()
+	cursor := s.next
+	if r.Cursor != nil {
+		cursor = *r.Cursor
+	}
+	s.mu.Unlock()
+
+	// Send the cursor we start at in the header, so the client can resume from
+	// there even if it didn't get any records.
+	if err := stream.SendHeader(metadata.Pairs("cursor", strconv.FormatUint(cursor, 10))); err != nil {
+		return err
+	}
+	for {
+		s.mu.Lock()
+		var (
+			first   = s.next - uint64(len(s.records))
+			missed  uint64
+			records []record
+		)
+		if cursor > s.next {
+			cursor = s.next
+		}
+		if cursor < first {
+			missed, cursor = first-cursor, first
+		}
+		for c := cursor; c < s.next; c++ {
+			records = append(records, s.records[c%uint64(s.size)])
+		}
+		closed, notify := s.closed, s.notify
+		s.mu.Unlock()
+
+		for _, rec := range records {
+			cursor++
+			if err := stream.Send(toProto(rec, cursor, missed)); err != nil {
+				return err
+			}
+			missed = 0
+		}
+		if closed {
+			return errClosed
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-notify:
+		}
+	}
+}
+
+func toProto(r record, cursor, missed uint64) *rpcpb.Record {
+	p := &rpcpb.Record{Cursor: cursor, Missed: missed}
+	if r.err != "" {
+		p.Record = &rpcpb.Record_Error{Error: r.err}
+		return p
+	}
+	p.Record = &rpcpb.Record_Event{Event: &rpcpb.Event{
+		Name:    r.event.Name,
+		Op:      uint32(r.event.Op),
+		OpNames: strings.Split(r.event.Op.String(), "|"),
+		Root:    r.event.Root,
+	}}
+	return p
+}
+
+// Client is a client for a Server, with the same Events and Errors channels as
+// a Watcher.
+type Client struct {
+	// Events sends the events from the server.
+	//
+	// The Op is the same number as on the server, so it's only meaningful if
+	// the server runs on the same platform; the names of the operations are
+	// in the Op names of the server's platform.
+	Events chan fsnotify.Event
+
+	// Errors sends the errors from the server's Watcher, and errors
+	// communicating with the server. Errors from the server's Watcher are
+	// sent as plain errors with the same message, except for
+	// ErrEventOverflow.
+	Errors chan error
+
+	conn   *grpc.ClientConn
+	c      rpcpb.WatcherClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	exited chan struct{}
+	once   sync.Once
+}
+
+// Dial connects to the server at the TCP address.
+func Dial(addr string, opts ...Option) (*Client, error) {
+	o := getOptions(opts)
+	creds := insecure.NewCredentials()
+	if o.tls != nil {
+		creds = credentials.NewTLS(o.tls)
+	}
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if o.token != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(token{o.token, o.tls != nil}))
+	}
+	conn, err := grpc.Dial(addr, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// NewClient creates a new client on an existing connection, which isn't closed
+// by Close. Only events after the client was created are sent.
+func NewClient(conn grpc.ClientConnInterface) (*Client, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		Events: make(chan fsnotify.Event),
+		Errors: make(chan error),
+		c:      rpcpb.NewWatcherClient(conn),
+		ctx:    ctx,
+		cancel: cancel,
+		exited: make(chan struct{}),
+	}
+
+	// Wait for the stream to be established, so no events are missed after
+	// this returns.
+	stream, cursor, err := c.events(nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go c.stream(stream, cursor)
+	return c, nil
+}
+
+// events starts the Events stream, returning the cursor it starts at.
+func (c *Client) events(cursor *uint64) (rpcpb.Watcher_EventsClient, uint64, error) {
+	stream, err := c.c.Events(c.ctx, &rpcpb.EventsRequest{Cursor: cursor}, grpc.WaitForReady(true))
+	if err != nil {
+		return nil, 0, err
+	}
+	md, err := stream.Header()
+	if err != nil {
+		return nil, 0, err
+	}
+	v := md.Get("cursor")
+	if len(v) == 0 {
+		// The call failed without sending a header, e.g. for a wrong token;
+		// the error is in the trailer.
+		_, err := stream.Recv()
+		if err == nil || err == io.EOF {
+			err = errors.New("rpc: no cursor in Events header")
+		}
+		return nil, 0, err
+	}
+	start, err := strconv.ParseUint(v[0], 10, 64)
+	return stream, start, err
+}
+
+// token is the credentials for WithToken.
+type token struct {
+	token  string
+	secure bool
+}
+
+func (t token) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+func (t token) RequireTransportSecurity() bool { return t.secure }
+
+// Add starts watching the path on the server.
+func (c *Client) Add(path string) error {
+	_, err := c.c.Add(c.ctx, &rpcpb.AddRequest{Path: path})
+	return err
+}
+
+// Remove stops watching the path on the server.
+func (c *Client) Remove(path string) error {
+	_, err := c.c.Remove(c.ctx, &rpcpb.RemoveRequest{Path: path})
+	return err
+}
+
+// WatchList gets all paths the server is watching.
+func (c *Client) WatchList() ([]string, error) {
+	r, err := c.c.WatchList(c.ctx, &rpcpb.WatchListRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return r.Paths, nil
+}
+
+// Close the connection and the Events and Errors channels. The watches on the
+// server aren't removed.
+func (c *Client) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.cancel()
+		<-c.exited
+		if c.conn != nil {
+			err = c.conn.Close()
+		}
+	})
+	return err
+}
+
+// stream sends the events from the Events stream, and resumes it from the last
+// cursor if the connection is lost.
+func (c *Client) stream(stream rpcpb.Watcher_EventsClient, cursor uint64) {
+	defer func() {
+		close(c.Events)
+		close(c.Errors)
+		close(c.exited)
+	}()
+
+	for {
+		r, err := stream.Recv()
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return // Closed by us.
+			}
+			if status.Code(err) != codes.Unavailable {
+				// The server's Watcher was closed, or something we can't
+				// recover from.
+				c.sendError(err)
+				return
+			}
+			// Lost the connection; wait for it to come back.
+			if !c.sendError(err) {
+				return
+			}
+			stream, _, err = c.events(&cursor)
+			if err != nil {
+				if c.ctx.Err() == nil {
+					c.sendError(err)
+				}
+				return
+			}
+			continue
+		}
+		cursor = r.Cursor
+
+		if r.Missed > 0 && !c.sendError(fmt.Errorf("%w: missed %d events", fsnotify.ErrEventOverflow, r.Missed)) {
+			return
+		}
+		switch rec := r.Record.(type) {
+		case *rpcpb.Record_Error:
+			err := errors.New(rec.Error)
+			if rec.Error == fsnotify.ErrEventOverflow.Error() {
+				err = fsnotify.ErrEventOverflow
+			}
+			if !c.sendError(err) {
+				return
+			}
+		case *rpcpb.Record_Event:
+			e := fsnotify.Event{Name: rec.Event.Name, Op: fsnotify.Op(rec.Event.Op), Root: rec.Event.Root}
+			select {
+			case <-c.ctx.Done():
+				return
+			case c.Events <- e:
+			}
+		}
+	}
+}
+
+// sendError returns false if the client was closed.
+func (c *Client) sendError(err error) bool {
+	select {
+	case <-c.ctx.Done():
+		return false
+	case c.Errors <- err:
+		return true
+	}
+}
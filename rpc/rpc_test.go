@@ -0,0 +1,228 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hohodqr/fsnotify"
+	"github.com/hohodqr/fsnotify/rpc/rpcpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func serve(t *testing.T) (*fsnotify.Watcher, *Server, string) {
+	t.Helper()
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := NewServer(w)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return w, s, l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *Client {
+	t.Helper()
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient(t *testing.T) {
+	_, _, addr := serve(t)
+	c := dial(t, addr)
+
+	tmp := t.TempDir()
+	if err := c.Add(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(filepath.Join(tmp, "nonexistent")); err == nil {
+		t.Error("no error adding a nonexistent path")
+	}
+	l, err := c.WatchList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(l, []string{tmp}) {
+		t.Errorf("wrong WatchList: %v", l)
+	}
+
+	file := filepath.Join(tmp, "file")
+	if err := os.WriteFile(file, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-c.Events:
+		if e.Name != file {
+			t.Errorf("wrong event: %s", e)
+		}
+	case err := <-c.Errors:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	if err := c.Remove(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := c.WatchList(); len(l) != 0 {
+		t.Errorf("WatchList not empty after Remove: %v", l)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-c.Events; ok {
+		t.Error("Events not closed")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close: %s", err)
+	}
+}
+
+func TestMissed(t *testing.T) {
+	_, s, addr := serve(t)
+	s.mu.Lock()
+	s.size = 2
+	s.mu.Unlock()
+	// Wraps around the buffer twice.
+	for _, n := range []string{"a", "b", "c", "d", "e"} {
+		s.add(record{event: fsnotify.Event{Name: n}})
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var cursor uint64
+	stream, err := rpcpb.NewWatcherClient(conn).Events(ctx, &rpcpb.EventsRequest{Cursor: &cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []string{"d", "e"} {
+		r, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.GetEvent().GetName() != want || r.Cursor != uint64(i+4) {
+			t.Errorf("have %q at %d; want %q at %d", r.GetEvent().GetName(), r.Cursor, want, i+4)
+		}
+		if wantMissed := uint64(3 * (1 - i)); r.Missed != wantMissed {
+			t.Errorf("Missed = %d; want %d", r.Missed, wantMissed)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	s := NewServer(w)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	c := dial(t, l.Addr().String())
+
+	// Events while the connection is down are sent after it's back.
+	s.Stop()
+	if err := <-c.Errors; status.Code(err) != codes.Unavailable {
+		t.Fatalf("wrong error: %v", err)
+	}
+	s.add(record{event: fsnotify.Event{Name: "a"}})
+	l, err = net.Listen("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Stop()
+
+	select {
+	case e := <-c.Events:
+		if e.Name != "a" {
+			t.Errorf("wrong event: %s", e)
+		}
+	case err := <-c.Errors:
+		t.Fatal(err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestToken(t *testing.T) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(w, WithToken("secret"))
+	go s.Serve(l)
+	defer s.Stop()
+	addr := l.Addr().String()
+
+	for _, tok := range []string{"", "wrong"} {
+		c, err := Dial(addr, WithToken(tok))
+		if err == nil {
+			c.Close()
+		}
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("wrong error for token %q: %v", tok, err)
+		}
+	}
+
+	c, err := Dial(addr, WithToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.WatchList(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerClosed(t *testing.T) {
+	w, _, addr := serve(t)
+	c := dial(t, addr)
+	w.Close()
+
+	select {
+	case err := <-c.Errors:
+		if status.Code(err) != codes.Aborted {
+			t.Errorf("wrong error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if _, ok := <-c.Events; ok {
+		t.Error("Events not closed")
+	}
+}
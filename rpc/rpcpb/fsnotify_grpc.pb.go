@@ -0,0 +1,265 @@
+// The gRPC service for the github.com/hohodqr/fsnotify/rpc package.
+//
+// Regenerate the Go code in this directory after changing it with:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//          --go-grpc_out=. --go-grpc_opt=paths=source_relative fsnotify.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: fsnotify.proto
+
+package rpcpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Watcher_Add_FullMethodName       = "/fsnotify.rpc.v1.Watcher/Add"
+	Watcher_Remove_FullMethodName    = "/fsnotify.rpc.v1.Watcher/Remove"
+	Watcher_WatchList_FullMethodName = "/fsnotify.rpc.v1.Watcher/WatchList"
+	Watcher_Events_FullMethodName    = "/fsnotify.rpc.v1.Watcher/Events"
+)
+
+// WatcherClient is the client API for Watcher service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WatcherClient interface {
+	// Add starts watching a path.
+	Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error)
+	// Remove stops watching a path.
+	Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error)
+	// WatchList gets all watched paths.
+	WatchList(ctx context.Context, in *WatchListRequest, opts ...grpc.CallOption) (*WatchListResponse, error)
+	// Events streams the events and errors from the Watcher, until the client
+	// cancels it or the Watcher is closed.
+	Events(ctx context.Context, in *EventsRequest, opts ...grpc.CallOption) (Watcher_EventsClient, error)
+}
+
+type watcherClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWatcherClient(cc grpc.ClientConnInterface) WatcherClient {
+	return &watcherClient{cc}
+}
+
+func (c *watcherClient) Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error) {
+	out := new(AddResponse)
+	err := c.cc.Invoke(ctx, Watcher_Add_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherClient) Remove(ctx context.Context, in *RemoveRequest, opts ...grpc.CallOption) (*RemoveResponse, error) {
+	out := new(RemoveResponse)
+	err := c.cc.Invoke(ctx, Watcher_Remove_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherClient) WatchList(ctx context.Context, in *WatchListRequest, opts ...grpc.CallOption) (*WatchListResponse, error) {
+	out := new(WatchListResponse)
+	err := c.cc.Invoke(ctx, Watcher_WatchList_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watcherClient) Events(ctx context.Context, in *EventsRequest, opts ...grpc.CallOption) (Watcher_EventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Watcher_ServiceDesc.Streams[0], Watcher_Events_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watcherEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Watcher_EventsClient interface {
+	Recv() (*Record, error)
+	grpc.ClientStream
+}
+
+type watcherEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *watcherEventsClient) Recv() (*Record, error) {
+	m := new(Record)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WatcherServer is the server API for Watcher service.
+// All implementations must embed UnimplementedWatcherServer
+// for forward compatibility
+type WatcherServer interface {
+	// Add starts watching a path.
+	Add(context.Context, *AddRequest) (*AddResponse, error)
+	// Remove stops watching a path.
+	Remove(context.Context, *RemoveRequest) (*RemoveResponse, error)
+	// WatchList gets all watched paths.
+	WatchList(context.Context, *WatchListRequest) (*WatchListResponse, error)
+	// Events streams the events and errors from the Watcher, until the client
+	// cancels it or the Watcher is closed.
+	Events(*EventsRequest, Watcher_EventsServer) error
+	mustEmbedUnimplementedWatcherServer()
+}
+
+// UnimplementedWatcherServer must be embedded to have forward compatible implementations.
+type UnimplementedWatcherServer struct {
+}
+
+func (UnimplementedWatcherServer) Add(context.Context, *AddRequest) (*AddResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Add not implemented")
+}
+func (UnimplementedWatcherServer) Remove(context.Context, *RemoveRequest) (*RemoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
+}
+func (UnimplementedWatcherServer) WatchList(context.Context, *WatchListRequest) (*WatchListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WatchList not implemented")
+}
+func (UnimplementedWatcherServer) Events(*EventsRequest, Watcher_EventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method Events not implemented")
+}
+func (UnimplementedWatcherServer) mustEmbedUnimplementedWatcherServer() {}
+
+// UnsafeWatcherServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WatcherServer will
+// result in compilation errors.
+type UnsafeWatcherServer interface {
+	mustEmbedUnimplementedWatcherServer()
+}
+
+func RegisterWatcherServer(s grpc.ServiceRegistrar, srv WatcherServer) {
+	s.RegisterService(&Watcher_ServiceDesc, srv)
+}
+
+func _Watcher_Add_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).Add(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_Add_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).Add(ctx, req.(*AddRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watcher_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_Remove_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).Remove(ctx, req.(*RemoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watcher_WatchList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WatchListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatcherServer).WatchList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Watcher_WatchList_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatcherServer).WatchList(ctx, req.(*WatchListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watcher_Events_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WatcherServer).Events(m, &watcherEventsServer{stream})
+}
+
+type Watcher_EventsServer interface {
+	Send(*Record) error
+	grpc.ServerStream
+}
+
+type watcherEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *watcherEventsServer) Send(m *Record) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Watcher_ServiceDesc is the grpc.ServiceDesc for Watcher service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Watcher_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fsnotify.rpc.v1.Watcher",
+	HandlerType: (*WatcherServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Add",
+			Handler:    _Watcher_Add_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _Watcher_Remove_Handler,
+		},
+		{
+			MethodName: "WatchList",
+			Handler:    _Watcher_WatchList_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Events",
+			Handler:       _Watcher_Events_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fsnotify.proto",
+}
@@ -0,0 +1,88 @@
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WithInitialScan sends a Create event for every existing file and directory
+// in the path after it's added, so that existing files can be processed with the
+// same code as new ones.
+//
+// For directories this is every entry in the directory, or every entry in all
+// subdirectories for recursive watches (up to [WithMaxDepth]). For files it's
+// just the file itself.
+//
+// The scan runs in the background, after Add returns, and is interleaved with
+// the regular events. A file that's created during the scan may be reported
+// twice.
+func WithInitialScan() addOpt {
+	return func(opt *withOpts) { opt.initialScan = true }
+}
+
+// scanner keeps track of the goroutines that send synthetic events (for
+// WithInitialScan and CatchUp), so that Close can wait for them before closing
+// the Events channel.
+type scanner struct {
+	wg sync.WaitGroup
+}
+
+func newScanner() *scanner { return &scanner{} }
+
+// run f in the background.
+func (s *scanner) run(f func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		f()
+	}()
+}
+
+// wait for all scans to finish.
+func (s *scanner) wait() { s.wg.Wait() }
+
+// scan calls send for every entry in path, until it returns false.
+func scan(path string, with withOpts, send func(name string, isDir bool) bool) {
+	path, recurse := recursivePath(path)
+	dirs := []string{path}
+	if recurse {
+		dirs, _ = GetDirNamesWith(dirs, WithMaxDepth(with.maxDepth), WithContinueOnError())
+		if len(dirs) == 0 {
+			return
+		}
+	} else {
+		st, err := os.Stat(path)
+		if err != nil {
+			return
+		}
+		if !st.IsDir() {
+			send(path, false)
+			return
+		}
+	}
+
+	for _, d := range dirs {
+		ls, err := os.ReadDir(d)
+		if err != nil {
+			continue
+		}
+		for _, f := range ls {
+			if !send(filepath.Join(d, f.Name()), f.IsDir()) {
+				return
+			}
+		}
+	}
+}
+
+// initialScan starts the WithInitialScan for path, if enabled.
+func (w *Watcher) initialScan(path string, with withOpts) {
+	if !with.initialScan {
+		return
+	}
+	w.scan.run(func() {
+		scan(path, with, func(name string, isDir bool) bool {
+			return w.sendSynthetic(name, Create, isDir)
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package fsnotify
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWithInitialScan(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdirAll(t, tmp, "dir", "sub")
+	touch(t, tmp, "file", noWait)
+	touch(t, tmp, "dir", "file", noWait)
+	touch(t, tmp, "dir", "sub", "file", noWait)
+
+	tests := []struct {
+		name string
+		path string
+		opts []addOpt
+		want []string
+	}{
+		{"dir", tmp, nil, []string{"dir", "file"}},
+		{"file", join(tmp, "file"), nil, []string{"file"}},
+		{"recursive", join(tmp, "..."), nil,
+			[]string{"dir", "dir/file", "dir/sub", "dir/sub/file", "file"}},
+		{"max depth", join(tmp, "..."), []addOpt{WithMaxDepth(1)},
+			[]string{"dir", "dir/file", "dir/sub", "file"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if isKqueue() || isSolaris() {
+				if _, recurse := recursivePath(tt.path); recurse {
+					t.Skip("recursive watches not supported")
+				}
+			}
+
+			w := newWatcher(t)
+			defer w.Close()
+			if err := w.AddWith(tt.path, append(tt.opts, WithInitialScan())...); err != nil {
+				t.Fatal(err)
+			}
+
+			var have []string
+			timeout := time.After(2 * time.Second)
+			for len(have) < len(tt.want) {
+				select {
+				case e := <-w.Events:
+					if e.Op&opCreate == 0 {
+						t.Fatalf("not a Create event: %s", e)
+					}
+					have = append(have, filepath.ToSlash(e.Name[len(tmp)+1:]))
+				case err := <-w.Errors:
+					t.Fatal(err)
+				case <-timeout:
+					t.Fatalf("timeout; have: %q", have)
+				}
+			}
+			sort.Strings(have)
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package fsnotify
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SimModel describes the expected load for [Simulate].
+type SimModel struct {
+	// Backend to simulate: "inotify", "kqueue", "windows", or "fen". The
+	// default is the backend for the current platform, and anything else is an
+	// error.
+	Backend string
+
+	// Recursive simulates watching every directory in the tree, rather than
+	// just the root.
+	Recursive bool
+
+	// FilePolling simulates [WithFilePolling] on kqueue, where only
+	// directories need a file descriptor.
+	FilePolling bool
+
+	// EventsPerSecond is the sustained rate of filesystem events.
+	EventsPerSecond float64
+
+	// Every BurstInterval there's a burst of BurstSize events on top of the
+	// sustained rate. Both are optional.
+	BurstSize     int
+	BurstInterval time.Duration
+
+	// ConsumerPerSecond is the number of events the application can process
+	// per second. 0 means the consumer is infinitely fast.
+	ConsumerPerSecond float64
+
+	// Duration to simulate; the default is one minute.
+	Duration time.Duration
+
+	// QueueSize is the number of events that can be queued before they're
+	// dropped: the fs.inotify.max_queued_events sysctl for inotify, or the
+	// number of events that fit in the buffer on Windows. It's read from the
+	// system or set to a reasonable default if 0.
+	QueueSize int
+
+	// BufferSize is the Windows buffer size per watch; the default is the
+	// same as for [WithBufferSize].
+	BufferSize int
+}
+
+// SimReport is the result of [Simulate].
+type SimReport struct {
+	Backend string
+	Dirs    int // Number of directories in the tree.
+	Files   int // Number of non-directories in the tree.
+
+	Watches         int // Number of watches that would be registered.
+	WatchLimit      int // System limit on watches (0 if unknown or unlimited).
+	FileDescriptors int // Number of file descriptors that would be used.
+	FDLimit         int // Limit for open files (0 if unknown).
+	Memory          int // Estimated memory use in bytes, both kernel and Go.
+
+	QueueSize  int     // Queue capacity that was used.
+	PeakQueue  int     // Largest number of queued events seen.
+	Overflows  int     // Number of times the queue overflowed.
+	Dropped    int     // Number of events lost to overflows.
+	QueueRatio float64 // PeakQueue / QueueSize.
+}
+
+// OverflowRisk reports if the simulation overflowed or got close to it.
+func (r SimReport) OverflowRisk() bool { return r.Overflows > 0 || r.QueueRatio > 0.75 }
+
+// Exceeds reports if the watch or file descriptor limits would be exceeded.
+func (r SimReport) Exceeds() bool {
+	return (r.WatchLimit > 0 && r.Watches > r.WatchLimit) ||
+		(r.FDLimit > 0 && r.FileDescriptors > r.FDLimit)
+}
+
+// Simulate projects the resource use and queue behaviour of watching the tree
+// at root with the given load, without actually adding any watches. This can be
+// used for capacity planning before deploying a watcher for a very large tree.
+//
+// The numbers are estimates based on the typical per-watch costs of each
+// backend, and the queue simulation assumes events arrive evenly apart from
+// the bursts.
+func Simulate(root string, m SimModel) (SimReport, error) {
+	if m.Backend == "" {
+		m.Backend = defaultBackend()
+	}
+	switch m.Backend {
+	case "inotify", "kqueue", "fen", "windows":
+	default:
+		return SimReport{}, fmt.Errorf("fsnotify.Simulate: unknown backend %q", m.Backend)
+	}
+	if m.Duration <= 0 {
+		m.Duration = time.Minute
+	}
+	if m.BufferSize <= 0 {
+		m.BufferSize = defaultOpts.bufsize
+	}
+
+	r := SimReport{Backend: m.Backend}
+	var pathBytes int
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories can't be watched either.
+			if d != nil && d.IsDir() && path != root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if !m.Recursive && path != root {
+				return fs.SkipDir
+			}
+			r.Dirs++
+		} else {
+			r.Files++
+		}
+		pathBytes += len(path)
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+
+	const mapEntry = 64 // Rough overhead of a Go map entry plus watch struct.
+	switch m.Backend {
+	case "inotify":
+		// Every directory is a watch; the kernel uses about 1K per watch on
+		// 64-bit systems.
+		r.Watches = r.Dirs
+		r.FileDescriptors = 1
+		r.Memory = r.Watches*(1080+2*mapEntry) + pathBytes
+		r.WatchLimit = readProcInt("/proc/sys/fs/inotify/max_user_watches")
+		if m.QueueSize <= 0 {
+			m.QueueSize = readProcInt("/proc/sys/fs/inotify/max_queued_events")
+		}
+		if m.QueueSize <= 0 {
+			m.QueueSize = 16384
+		}
+	case "kqueue":
+		// kqueue needs a file descriptor for every file and directory, or
+		// only for directories with WithFilePolling.
+		r.Watches = r.Dirs + r.Files
+		if m.FilePolling {
+			r.Watches = r.Dirs
+		}
+		r.FileDescriptors = r.Watches + 3
+		r.Memory = r.Watches*(200+4*mapEntry) + 2*pathBytes
+		if m.QueueSize <= 0 {
+			m.QueueSize = r.Watches // One pending knote per watch.
+		}
+	case "fen":
+		r.Watches = r.Dirs + r.Files
+		r.FileDescriptors = 1
+		r.Memory = r.Watches*(300+2*mapEntry) + 2*pathBytes
+		if m.QueueSize <= 0 {
+			m.QueueSize = r.Watches
+		}
+	case "windows":
+		// One handle and buffer per directory, unless it's a recursive watch
+		// on the root.
+		r.Watches = r.Dirs
+		if m.Recursive {
+			r.Watches = 1
+		}
+		r.FileDescriptors = r.Watches + 1
+		r.Memory = r.Watches*(m.BufferSize+2*mapEntry) + pathBytes
+		if m.QueueSize <= 0 {
+			// A FILE_NOTIFY_INFORMATION is 12 bytes plus the UTF-16 filename.
+			avg := 16
+			if n := r.Dirs + r.Files; n > 0 {
+				avg = 12 + 2*(pathBytes/n) // Overestimates; full paths are longer than names.
+			}
+			m.QueueSize = m.BufferSize / avg
+		}
+	}
+	r.FDLimit = fdLimit()
+	r.QueueSize = m.QueueSize
+
+	simulateQueue(&r, m)
+	return r, nil
+}
+
+// simulateQueue runs the event arrival/consumption model in 1ms steps.
+func simulateQueue(r *SimReport, m SimModel) {
+	const step = time.Millisecond
+	var (
+		queue, arrive, consume float64
+		overflowed             bool
+	)
+	for t := time.Duration(0); t < m.Duration; t += step {
+		arrive = m.EventsPerSecond * step.Seconds()
+		if m.BurstSize > 0 && m.BurstInterval > 0 && t%m.BurstInterval == 0 {
+			arrive += float64(m.BurstSize)
+		}
+		queue += arrive
+
+		if m.ConsumerPerSecond <= 0 {
+			consume = queue
+		} else {
+			consume = m.ConsumerPerSecond * step.Seconds()
+		}
+		if consume > queue {
+			consume = queue
+		}
+
+		if int(queue) > r.PeakQueue {
+			r.PeakQueue = int(queue)
+		}
+		if int(queue) > r.QueueSize {
+			r.Dropped += int(queue) - r.QueueSize
+			queue = float64(r.QueueSize)
+			if !overflowed {
+				r.Overflows++
+			}
+			overflowed = true
+		} else {
+			overflowed = false
+		}
+		queue -= consume
+	}
+	if r.PeakQueue > r.QueueSize {
+		r.PeakQueue = r.QueueSize
+	}
+	if r.QueueSize > 0 {
+		r.QueueRatio = float64(r.PeakQueue) / float64(r.QueueSize)
+	}
+}
+
+func defaultBackend() string {
+	switch runtime.GOOS {
+	case "linux":
+		return "inotify"
+	case "windows":
+		return "windows"
+	case "illumos", "solaris":
+		return "fen"
+	default:
+		return "kqueue"
+	}
+}
+
+func readProcInt(path string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return n
+}
@@ -0,0 +1,64 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	tmp := t.TempDir()
+	mkdirAll(t, tmp, "a", "b", "c")
+	touch(t, tmp, "a", "file")
+	touch(t, tmp, "a", "b", "file")
+
+	tests := []struct {
+		name        string
+		model       SimModel
+		wantWatches int
+		wantRisk    bool
+	}{
+		{"inotify non-recursive", SimModel{Backend: "inotify"}, 1, false},
+		{"inotify recursive", SimModel{Backend: "inotify", Recursive: true}, 4, false},
+		{"kqueue recursive", SimModel{Backend: "kqueue", Recursive: true}, 6, false},
+		{"kqueue file polling", SimModel{Backend: "kqueue", Recursive: true, FilePolling: true}, 4, false},
+		{"windows recursive", SimModel{Backend: "windows", Recursive: true}, 1, false},
+		{"slow consumer", SimModel{
+			Backend:           "inotify",
+			QueueSize:         1000,
+			EventsPerSecond:   1000,
+			ConsumerPerSecond: 500,
+			Duration:          10 * time.Second,
+		}, 1, true},
+		{"bursts", SimModel{
+			Backend:       "inotify",
+			QueueSize:     1000,
+			BurstSize:     2000,
+			BurstInterval: time.Second,
+		}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Simulate(tmp, tt.model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Watches != tt.wantWatches {
+				t.Errorf("Watches = %d; want %d", r.Watches, tt.wantWatches)
+			}
+			if r.OverflowRisk() != tt.wantRisk {
+				t.Errorf("OverflowRisk() = %t; want %t (%+v)", r.OverflowRisk(), tt.wantRisk, r)
+			}
+			if r.Memory <= 0 {
+				t.Errorf("Memory = %d", r.Memory)
+			}
+		})
+	}
+}
+
+func TestSimulateUnknownBackend(t *testing.T) {
+	_, err := Simulate(t.TempDir(), SimModel{Backend: "inotfy"})
+	if err == nil {
+		t.Fatal("no error for unknown backend")
+	}
+}
@@ -0,0 +1,198 @@
+package fsnotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SnapshotEntry describes a single path in a snapshot.
+type SnapshotEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	IsDir   bool      `json:"dir,omitempty"`
+}
+
+// Snapshot is the state of a set of paths at some point in time, so that it
+// can be compared to the current state later on; see [Watcher.SaveSnapshot]
+// and [Watcher.CatchUp].
+type Snapshot map[string]SnapshotEntry
+
+// TakeSnapshot creates a snapshot of paths. For directories this includes all
+// entries in the directory, and paths ending with "/..." include all
+// subdirectories (as with [Watcher.Add]).
+//
+// Paths that don't exist are skipped.
+func TakeSnapshot(paths []string) (Snapshot, error) {
+	s := make(Snapshot)
+	for _, p := range paths {
+		p, recurse := recursivePath(p)
+		if err := s.add(p); err != nil {
+			return nil, err
+		}
+		if e, ok := s[p]; !ok || !e.IsDir {
+			continue
+		}
+
+		dirs := []string{p}
+		if recurse {
+			var err error
+			dirs, err = GetDirs(dirs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, d := range dirs {
+			ls, err := os.ReadDir(d)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+			for _, f := range ls {
+				if err := s.add(filepath.Join(d, f.Name())); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s Snapshot) add(path string) error {
+	st, err := os.Lstat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	s[path] = SnapshotEntry{Size: st.Size(), ModTime: st.ModTime(), IsDir: st.IsDir()}
+	return nil
+}
+
+// ReadSnapshot reads a snapshot written with [Snapshot.Write]. It returns an
+// error wrapping [os.ErrNotExist] if the file doesn't exist.
+func ReadSnapshot(file string) (Snapshot, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := make(Snapshot)
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("fsnotify.ReadSnapshot: %s: %w", file, err)
+	}
+	return s, nil
+}
+
+// Write the snapshot to file. The file is replaced atomically, so a crash never
+// leaves a partial snapshot.
+func (s Snapshot) Write(file string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Diff compares the snapshot with a newer one, returning the paths that are
+// only in other, the paths that have a different size, modification time, or
+// type, and the paths that are only in s.
+//
+// Directories are never reported as changed, as their modification time
+// changes whenever an entry is added or removed.
+func (s Snapshot) Diff(other Snapshot) (added, changed, removed []string) {
+	for path, e := range other {
+		have, ok := s[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case have.IsDir != e.IsDir,
+			!e.IsDir && (have.Size != e.Size || !have.ModTime.Equal(e.ModTime)):
+			changed = append(changed, path)
+		}
+	}
+	for path := range s {
+		if _, ok := other[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	return added, changed, removed
+}
+
+// SaveSnapshot writes a snapshot of all watched paths to file, for use with
+// [Watcher.CatchUp] after the program is restarted.
+//
+// Take care that the snapshot reflects what was processed: save it after
+// handling all events (e.g. on shutdown or periodically), as changes after the
+// snapshot will be sent again on CatchUp.
+func (w *Watcher) SaveSnapshot(file string) error {
+	s, err := TakeSnapshot(w.WatchList())
+	if err != nil {
+		return err
+	}
+	s.remove(file)
+	return s.Write(file)
+}
+
+// CatchUp compares all watched paths with the snapshot in file, sending a
+// Create, Write, or Remove event for every path that changed since the snapshot
+// was saved with [Watcher.SaveSnapshot]. It's a no-op if file doesn't exist
+// (e.g. on the first start).
+//
+// The paths should be added before calling CatchUp, so that no changes are
+// missed in between. The events are sent in the background, after CatchUp
+// returns, and are interleaved with the regular events.
+func (w *Watcher) CatchUp(file string) error {
+	old, err := ReadSnapshot(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	cur, err := TakeSnapshot(w.WatchList())
+	if err != nil {
+		return err
+	}
+	cur.remove(file)
+	old.remove(file)
+
+	added, changed, removed := old.Diff(cur)
+	w.scan.run(func() {
+		for _, p := range removed {
+			if !w.sendSynthetic(p, Remove, old[p].IsDir) {
+				return
+			}
+		}
+		for _, p := range added {
+			if !w.sendSynthetic(p, Create, cur[p].IsDir) {
+				return
+			}
+		}
+		for _, p := range changed {
+			if !w.sendSynthetic(p, Write, cur[p].IsDir) {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// remove the snapshot file itself, in case it's in a watched directory.
+func (s Snapshot) remove(file string) {
+	delete(s, file)
+	delete(s, file+".tmp")
+}
@@ -0,0 +1,58 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCatchUp(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	file := join(t.TempDir(), "snapshot")
+	cat(t, "one", tmp, "one")
+	cat(t, "two", tmp, "two")
+
+	w := newWatcher(t, tmp)
+	if err := w.CatchUp(file); err != nil { // Doesn't exist yet.
+		t.Fatal(err)
+	}
+	if err := w.SaveSnapshot(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes while "not running".
+	cat(t, "changed", tmp, "one")
+	rm(t, tmp, "two")
+	touch(t, tmp, "three")
+
+	w = newWatcher(t, tmp)
+	defer w.Close()
+	if err := w.CatchUp(file); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Op{
+		join(tmp, "one"):   opWrite,
+		join(tmp, "two"):   opRemove,
+		join(tmp, "three"): opCreate,
+	}
+	timeout := time.After(2 * time.Second)
+	for len(want) > 0 {
+		select {
+		case e := <-w.Events:
+			op, ok := want[e.Name]
+			if !ok || e.Op&op == 0 {
+				t.Fatalf("unexpected event: %s", e)
+			}
+			delete(want, e.Name)
+		case err := <-w.Errors:
+			t.Fatal(err)
+		case <-timeout:
+			t.Fatalf("timeout; still waiting for %v", want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package fsnotify
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Spec is a declarative description of what a Watcher watches, for example
+// loaded from a configuration file; see [Watcher.ApplySpec].
+type Spec struct {
+	Watches []WatchSpec `json:"watches"`
+}
+
+// WatchSpec describes a single path in a [Spec], with the options passed to
+// [Watcher.AddWith].
+type WatchSpec struct {
+	Path        string `json:"path"`                   // Path to watch; end with "/..." to watch recursively.
+	BufferSize  int    `json:"buffer_size,omitempty"`  // WithBufferSize
+	Retarget    bool   `json:"retarget,omitempty"`     // WithRetarget
+	NoFollow    bool   `json:"no_follow,omitempty"`    // WithFollowSymlinks(false)
+	MaxDepth    int    `json:"max_depth,omitempty"`    // WithMaxDepth
+	InitialScan bool   `json:"initial_scan,omitempty"` // WithInitialScan
+
+	WindowsFilters uint32 `json:"windows_filters,omitempty"` // WithWindowsFilters
+}
+
+func (s WatchSpec) options() []addOpt {
+	var opts []addOpt
+	if s.BufferSize > 0 {
+		opts = append(opts, WithBufferSize(s.BufferSize))
+	}
+	if s.Retarget {
+		opts = append(opts, WithRetarget())
+	}
+	if s.NoFollow {
+		opts = append(opts, WithFollowSymlinks(false))
+	}
+	if s.MaxDepth > 0 {
+		opts = append(opts, WithMaxDepth(s.MaxDepth))
+	}
+	if s.InitialScan {
+		opts = append(opts, WithInitialScan())
+	}
+	if s.WindowsFilters != 0 {
+		opts = append(opts, WithWindowsFilters(s.WindowsFilters))
+	}
+	return opts
+}
+
+// SpecReport describes the changes made by [Watcher.ApplySpec], or the changes
+// that would be made for [DiffSpec].
+type SpecReport struct {
+	Added   []string         // New paths.
+	Removed []string         // Paths no longer in the spec.
+	Changed []string         // Paths with different options, which were removed and added again.
+	Failed  map[string]error // Paths that couldn't be added, removed, or changed.
+}
+
+// DiffSpec returns the changes needed to go from the old spec to the new one.
+// Paths are compared after [filepath.Clean], and if a path is listed more than
+// once the last one is used.
+func DiffSpec(old, new Spec) SpecReport {
+	var (
+		o, n = old.byPath(), new.byPath()
+		r    SpecReport
+	)
+	for p, want := range n {
+		have, ok := o[p]
+		switch {
+		case !ok:
+			r.Added = append(r.Added, p)
+		case have != want:
+			r.Changed = append(r.Changed, p)
+		}
+	}
+	for p := range o {
+		if _, ok := n[p]; !ok {
+			r.Removed = append(r.Removed, p)
+		}
+	}
+	sort.Strings(r.Added)
+	sort.Strings(r.Removed)
+	sort.Strings(r.Changed)
+	return r
+}
+
+func (s Spec) byPath() map[string]WatchSpec {
+	m := make(map[string]WatchSpec, len(s.Watches))
+	for _, w := range s.Watches {
+		w.Path = filepath.Clean(w.Path)
+		m[w.Path] = w
+	}
+	return m
+}
+
+// appliedSpec is the Spec that's currently applied with ApplySpec, and the
+// paths that were added with Add or AddWith so ApplySpec doesn't remove them
+// and Standby can add them again with the same options.
+type appliedSpec struct {
+	mu      sync.Mutex
+	watches map[string]WatchSpec
+
+	userMu sync.Mutex
+	user   map[string][]addOpt // Path → options passed to AddWith.
+}
+
+func newAppliedSpec() *appliedSpec {
+	return &appliedSpec{watches: make(map[string]WatchSpec), user: make(map[string][]addOpt)}
+}
+
+// addUser records that the path was added with AddWith, if err shows it was
+// added. This is deferred by AddWith, with the path from addPath.
+func (a *appliedSpec) addUser(path string, opts []addOpt, err error) {
+	if _, partial := err.(*WalkError); (err != nil && !partial) || getOptions(opts...).fromSpec {
+		return
+	}
+	a.userMu.Lock()
+	defer a.userMu.Unlock()
+	a.user[filepath.Clean(path)] = opts
+}
+
+func (a *appliedSpec) removeUser(path string) {
+	a.userMu.Lock()
+	defer a.userMu.Unlock()
+	delete(a.user, filepath.Clean(path))
+}
+
+// userAdds returns the paths added with AddWith and their options.
+func (a *appliedSpec) userAdds() map[string][]addOpt {
+	a.userMu.Lock()
+	defer a.userMu.Unlock()
+	m := make(map[string][]addOpt, len(a.user))
+	for p, opts := range a.user {
+		m[p] = opts
+	}
+	return m
+}
+
+func (a *appliedSpec) isUser(path string) bool {
+	a.userMu.Lock()
+	defer a.userMu.Unlock()
+	_, ok := a.user[filepath.Clean(path)]
+	return ok
+}
+
+// withFromSpec marks the AddWith calls from ApplySpec.
+func withFromSpec() addOpt {
+	return func(opt *withOpts) { opt.fromSpec = true }
+}
+
+func (a *appliedSpec) spec() Spec {
+	s := Spec{Watches: make([]WatchSpec, 0, len(a.watches))}
+	for _, w := range a.watches {
+		s.Watches = append(s.Watches, w)
+	}
+	return s
+}
+
+// ApplySpec changes the watched paths to match spec, only adding and removing
+// the paths that changed since the previous ApplySpec (or since the Watcher was
+// created). Paths that were added with Add or AddWith are not affected: if a
+// path is both in the spec and added with Add (before or after ApplySpec), it's
+// not removed when it's removed from the spec, until it's also passed to
+// Remove.
+//
+// A path whose options changed is removed and added again, which may miss
+// events in between. If it was also added with Add it's not removed, and the
+// new options are applied with AddWith.
+//
+// All changes are attempted even if some fail. Failed paths are listed only in
+// Failed in the report, and applying the same spec again will retry them; the
+// returned error is for the first path that failed. A path that couldn't be added (or added
+// again after its options changed) isn't watched.
+func (w *Watcher) ApplySpec(spec Spec) (SpecReport, error) {
+	w.spec.mu.Lock()
+	defer w.spec.mu.Unlock()
+
+	var (
+		diff = DiffSpec(w.spec.spec(), spec)
+		r    SpecReport
+		want = spec.byPath()
+		user = func(p string) bool { return w.spec.isUser(w.addPath(p, []addOpt{withFromSpec()})) }
+		add  = func(p string) error { return w.AddWith(p, append(want[p].options(), withFromSpec())...) }
+		fail = func(p string, err error) {
+			if r.Failed == nil {
+				r.Failed = make(map[string]error)
+			}
+			r.Failed[p] = err
+		}
+	)
+	for _, p := range diff.Removed {
+		if !user(p) {
+			if err := w.Remove(p); err != nil {
+				fail(p, err)
+				continue
+			}
+		}
+		delete(w.spec.watches, p)
+		r.Removed = append(r.Removed, p)
+	}
+	for _, p := range diff.Changed {
+		if !user(p) {
+			if err := w.Remove(p); err != nil {
+				fail(p, err)
+				continue
+			}
+		}
+		delete(w.spec.watches, p)
+		if err := add(p); err != nil {
+			fail(p, err)
+			continue
+		}
+		w.spec.watches[p] = want[p]
+		r.Changed = append(r.Changed, p)
+	}
+	for _, p := range diff.Added {
+		if err := add(p); err != nil {
+			fail(p, err)
+			continue
+		}
+		w.spec.watches[p] = want[p]
+		r.Added = append(r.Added, p)
+	}
+
+	if len(r.Failed) > 0 {
+		failed := make([]string, 0, len(r.Failed))
+		for p := range r.Failed {
+			failed = append(failed, p)
+		}
+		sort.Strings(failed)
+		return r, fmt.Errorf("fsnotify.ApplySpec: %s: %w", failed[0], r.Failed[failed[0]])
+	}
+	return r, nil
+}
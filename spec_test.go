@@ -0,0 +1,135 @@
+package fsnotify
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDiffSpec(t *testing.T) {
+	old := Spec{Watches: []WatchSpec{
+		{Path: "/a"},
+		{Path: "/b/"},
+		{Path: "/c", InitialScan: true},
+	}}
+	new := Spec{Watches: []WatchSpec{
+		{Path: "/b"},
+		{Path: "/c"},
+		{Path: "/d/..."},
+	}}
+
+	have := DiffSpec(old, new)
+	want := SpecReport{
+		Added:   []string{"/d/..."},
+		Removed: []string{"/a"},
+		Changed: []string{"/c"},
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %#v\nwant: %#v", have, want)
+	}
+}
+
+func TestApplySpec(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "a", noWait)
+	mkdir(t, tmp, "b", noWait)
+	mkdir(t, tmp, "c", noWait)
+	var (
+		a = join(tmp, "a")
+		b = join(tmp, "b")
+		c = join(tmp, "c")
+	)
+
+	w := newWatcher(t)
+	defer w.Close()
+	go func() {
+		for range w.Events {
+		}
+	}()
+
+	check := func(want ...string) {
+		t.Helper()
+		have := w.WatchList()
+		sort.Strings(have)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("wrong WatchList\nhave: %q\nwant: %q", have, want)
+		}
+	}
+
+	if _, err := w.ApplySpec(Spec{Watches: []WatchSpec{{Path: a}, {Path: b}}}); err != nil {
+		t.Fatal(err)
+	}
+	check(a, b)
+
+	r, err := w.ApplySpec(Spec{Watches: []WatchSpec{
+		{Path: b, InitialScan: true},
+		{Path: c},
+		{Path: join(tmp, "nonexistent")},
+	}})
+	if err == nil || len(r.Failed) != 1 || r.Failed[join(tmp, "nonexistent")] == nil {
+		t.Fatalf("wrong error: %v (failed: %v)", err, r.Failed)
+	}
+	r.Failed = nil
+	want := SpecReport{Added: []string{c}, Removed: []string{a}, Changed: []string{b}}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("\nhave: %#v\nwant: %#v", r, want)
+	}
+	check(b, c)
+}
+
+func TestApplySpecUserWatch(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "a", noWait)
+	mkdir(t, tmp, "b", noWait)
+	var (
+		a = join(tmp, "a")
+		b = join(tmp, "b")
+	)
+
+	w := newWatcher(t)
+	defer w.Close()
+	go func() {
+		for range w.Events {
+		}
+	}()
+
+	check := func(want ...string) {
+		t.Helper()
+		have := w.WatchList()
+		sort.Strings(have)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("wrong WatchList\nhave: %q\nwant: %q", have, want)
+		}
+	}
+
+	// Added before and after the spec.
+	addWatch(t, w, a)
+	if _, err := w.ApplySpec(Spec{Watches: []WatchSpec{{Path: a}, {Path: b}}}); err != nil {
+		t.Fatal(err)
+	}
+	addWatch(t, w, b)
+	if _, err := w.ApplySpec(Spec{Watches: []WatchSpec{{Path: a, InitialScan: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	check(a, b)
+	if _, err := w.ApplySpec(Spec{}); err != nil {
+		t.Fatal(err)
+	}
+	check(a, b)
+
+	// Removed by the user, so the spec owns it.
+	if err := w.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ApplySpec(Spec{Watches: []WatchSpec{{Path: a}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.ApplySpec(Spec{}); err != nil {
+		t.Fatal(err)
+	}
+	check(b)
+}
@@ -0,0 +1,215 @@
+package fsnotify
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Stabilizer sends a single event for a file once it has stopped changing for
+// a quiet period, which is the most common way to detect that a file is
+// "complete" (e.g. an upload finished) when the writer doesn't signal this in
+// any other way.
+//
+// Every Create or Write event for a path (re)starts the quiet period, and when
+// it expires the file's size and modification time are compared with what they
+// were at the start of the period to catch writes that didn't send an event.
+// The sent event has all the operations seen during the period. A Remove or
+// Rename cancels the pending event.
+type Stabilizer struct {
+	// Events sends a single event per path once it's stable.
+	Events chan Event
+
+	// Errors sends any errors from the Watcher.
+	Errors chan error
+
+	quiet time.Duration
+	mu    sync.Mutex
+	files map[string]*stableFile
+	wg    sync.WaitGroup
+	done  chan struct{}
+}
+
+type stableFile struct {
+	ev    Event
+	timer *time.Timer
+	size  int64
+	mtime time.Time
+}
+
+// NewStabilizer creates a new Stabilizer reading from the watcher. This takes
+// over reading from w.Events and w.Errors; the Stabilizer's channels are
+// closed once the watcher is closed.
+func NewStabilizer(w *Watcher, quiet time.Duration) *Stabilizer {
+	s := &Stabilizer{
+		Events: make(chan Event),
+		Errors: make(chan error),
+		quiet:  quiet,
+		files:  make(map[string]*stableFile),
+		done:   make(chan struct{}),
+	}
+	go s.run(w)
+	return s
+}
+
+func (s *Stabilizer) run(w *Watcher) {
+	defer func() {
+		close(s.done)
+		s.mu.Lock()
+		for name, f := range s.files {
+			if f.timer.Stop() {
+				s.wg.Done()
+			}
+			delete(s.files, name)
+		}
+		s.mu.Unlock()
+		s.wg.Wait()
+		close(s.Events)
+		close(s.Errors)
+	}()
+
+	errs := w.Errors
+	for {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			s.Errors <- err
+		case e, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			s.handle(e)
+		}
+	}
+}
+
+func (s *Stabilizer) handle(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.files[e.Name]
+	if e.Op&(opRemove|opRename) != 0 {
+		if ok {
+			if f.timer.Stop() {
+				s.wg.Done()
+			}
+			delete(s.files, e.Name)
+		}
+		return
+	}
+	if e.Op&(opCreate|opWrite) == 0 {
+		return
+	}
+
+	if !ok {
+		f = &stableFile{ev: e}
+		f.size, f.mtime = statSizeTime(e.Name)
+		s.files[e.Name] = f
+		s.wg.Add(1)
+		f.timer = time.AfterFunc(s.quiet, func() { s.fire(e.Name) })
+		return
+	}
+
+	f.ev.Op |= e.Op
+	if f.timer.Stop() {
+		s.wg.Done()
+	}
+	f.size, f.mtime = statSizeTime(e.Name)
+	s.wg.Add(1)
+	f.timer.Reset(s.quiet)
+}
+
+func (s *Stabilizer) fire(name string) {
+	defer s.wg.Done()
+
+	s.mu.Lock()
+	f, ok := s.files[name]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	size, mtime := statSizeTime(name)
+	if size != f.size || !mtime.Equal(f.mtime) {
+		// Changed without an event; wait some more.
+		f.size, f.mtime = size, mtime
+		s.wg.Add(1)
+		f.timer.Reset(s.quiet)
+		s.mu.Unlock()
+		return
+	}
+	delete(s.files, name)
+	s.mu.Unlock()
+
+	select {
+	case s.Events <- f.ev:
+	case <-s.done:
+	}
+}
+
+func statSizeTime(path string) (int64, time.Time) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return -1, time.Time{}
+	}
+	return st.Size(), st.ModTime()
+}
+
+// WaitStable waits until the file at path exists and hasn't changed for the
+// quiet period. If the file already exists and doesn't change it returns after
+// the quiet period.
+//
+// This watches the parent directory, which must exist. It will wait forever if
+// the file is never created.
+func WaitStable(path string, quiet time.Duration) error {
+	path = filepath.Clean(path)
+
+	w, err := NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	size, mtime := statSizeTime(path)
+	t := time.NewTimer(quiet)
+	defer t.Stop()
+	for {
+		select {
+		case err, ok := <-w.Errors:
+			if !ok {
+				return ErrClosed
+			}
+			return err
+		case e, ok := <-w.Events:
+			if !ok {
+				return ErrClosed
+			}
+			if e.Name != path {
+				continue
+			}
+			if !t.Stop() {
+				<-t.C
+			}
+			t.Reset(quiet)
+		case <-t.C:
+			s, m := statSizeTime(path)
+			if s >= 0 && s == size && m.Equal(mtime) {
+				return nil
+			}
+			if s < 0 {
+				if _, err := os.Stat(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+			size, mtime = s, m
+			t.Reset(quiet)
+		}
+	}
+}
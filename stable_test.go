@@ -0,0 +1,75 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStabilizer(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t, tmp)
+	s := NewStabilizer(w, 200*time.Millisecond)
+
+	file := join(tmp, "file")
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		cat(t, "data", file)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case e := <-s.Events:
+		if e.Name != file {
+			t.Errorf("wrong name: %q", e.Name)
+		}
+		if time.Since(start) < 400*time.Millisecond {
+			t.Errorf("sent before the file was stable: %s", time.Since(start))
+		}
+	case err := <-s.Errors:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	select {
+	case e := <-s.Events:
+		t.Errorf("more than one event: %s", e)
+	case <-time.After(400 * time.Millisecond):
+	}
+
+	w.Close()
+	if _, ok := <-s.Events; ok {
+		t.Error("Events not closed")
+	}
+}
+
+func TestWaitStable(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	file := join(tmp, "file")
+
+	done := make(chan error)
+	go func() { done <- WaitStable(file, 200*time.Millisecond) }()
+
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		cat(t, "data", file)
+		time.Sleep(50 * time.Millisecond)
+	}
+	wrote := time.Now()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(wrote); d < 150*time.Millisecond {
+			t.Errorf("returned too soon after last write: %s", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,84 @@
+package fsnotify
+
+import "sort"
+
+// Standby creates a new watcher that watches the same paths as w, for replacing
+// w with [Watcher.Handover] without missing any events; for example to change
+// the options passed to [NewWatcherWith]. The calls to AddWith (and Add),
+// [Watcher.ApplySpec], and [Watcher.AddMount] are repeated on the new watcher
+// with the same options, so a recursive watch is still recursive.
+//
+// The new watcher is started right away, but it holds all events until
+// Handover is called instead of sending them on the Events channel. Errors are
+// still sent on the Errors channel, and [WithCallback] is never held.
+//
+// The held events are kept in memory without limit, and nothing is merged or
+// dropped (regardless of [WithBackpressure]), so Handover should be called soon
+// after Standby; if the switch is cancelled, close the new watcher instead.
+func (w *Watcher) Standby(opts ...addOpt) (*Watcher, error) {
+	next, err := NewWatcherWith(opts...)
+	if err != nil {
+		return nil, err
+	}
+	next.delivery.hold()
+	if err := w.replay(next); err != nil {
+		next.Close()
+		return nil, err
+	}
+	return next, nil
+}
+
+// replay the watches added to w on next. The spec is applied first, so that
+// AddWith for the same path overrides its options as it did on w.
+func (w *Watcher) replay(next *Watcher) error {
+	w.spec.mu.Lock()
+	spec := w.spec.spec()
+	w.spec.mu.Unlock()
+	if _, err := next.ApplySpec(spec); err != nil {
+		return err
+	}
+
+	adds := w.spec.userAdds()
+	paths := make([]string, 0, len(adds))
+	for p := range adds {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		err := next.AddWith(p, adds[p]...)
+		if _, partial := err.(*WalkError); err != nil && !partial {
+			return err
+		}
+	}
+
+	for _, p := range w.mountPoints() {
+		if err := next.AddMount(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handover replaces w with next, which was created with [Watcher.Standby]: a
+// [Handover] event is sent on w.Events (or to the [WithCallback] function),
+// after which w is closed and next sends all events it held since it was
+// created, followed by new events.
+//
+// Consumers should read from w.Events until the Handover event, and then from
+// next.Events. No events are lost in the switch, but events that happened
+// after Standby was called may be sent by both watchers.
+//
+// Handover blocks until the Handover event is read, or until w is closed. It
+// returns [ErrClosed] if w was already closed, or is closed before the Handover
+// event is read; next still sends the held events in that case.
+func (w *Watcher) Handover(next *Watcher) error {
+	next.delivery.release(next.Events)
+
+	e := Event{Op: Handover}
+	if w.callback != nil {
+		w.callback.call(e)
+	} else if !w.delivery.sendWait(w.Events, e) {
+		return ErrClosed
+	}
+	return w.Close()
+}
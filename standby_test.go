@@ -0,0 +1,160 @@
+package fsnotify
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHandover(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t, tmp)
+	next, err := w.Standby()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+
+	wait := func(ch chan Event, name string, op Op) {
+		t.Helper()
+		for {
+			select {
+			case e := <-ch:
+				if e.Name == name && e.Op&op != 0 {
+					return
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timeout waiting for %s %q", op, name)
+			}
+		}
+	}
+
+	touch(t, tmp, "a", noWait)
+	wait(w.Events, join(tmp, "a"), opCreate)
+
+	errc := make(chan error, 1)
+	go func() { errc <- w.Handover(next) }()
+	wait(w.Events, "", Handover)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// Held events are sent first.
+	touch(t, tmp, "b", noWait)
+	select {
+	case e := <-next.Events:
+		if e.Name != join(tmp, "a") {
+			t.Fatalf("wrong first event: %s", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout")
+	}
+	wait(next.Events, join(tmp, "b"), opCreate)
+}
+
+func TestHandoverClosed(t *testing.T) {
+	t.Parallel()
+
+	handover := func(t *testing.T, w, next *Watcher) error {
+		t.Helper()
+		errc := make(chan error, 1)
+		go func() { errc <- w.Handover(next) }()
+		select {
+		case err := <-errc:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Handover blocked")
+			return nil
+		}
+	}
+
+	t.Run("already closed", func(t *testing.T) {
+		t.Parallel()
+		w := newWatcher(t, t.TempDir())
+		next, err := w.Standby()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer next.Close()
+		w.Close()
+		if err := handover(t, w, next); err != ErrClosed {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+
+	t.Run("closed while waiting", func(t *testing.T) {
+		t.Parallel()
+		w := newWatcher(t, t.TempDir())
+		next, err := w.Standby()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer next.Close()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			w.Close()
+		}()
+		if err := handover(t, w, next); err != ErrClosed {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+}
+
+func TestStandbyAddWith(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "sub", noWait)
+	touch(t, tmp, "sub", "file", noWait)
+	w := newWatcher(t)
+	var opts []addOpt
+	if w.Supports(Open) {
+		opts = append(opts, WithOps(Open))
+	}
+	if err := w.AddWith(join(tmp, "..."), opts...); err != nil {
+		t.Fatal(err)
+	}
+	next, err := w.Standby()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+
+	go func() {
+		for range w.Events {
+		}
+	}()
+	if err := w.Handover(next); err != nil {
+		t.Fatal(err)
+	}
+
+	// The options should be the same.
+	if w.Supports(Open) {
+		fp, err := os.Open(join(tmp, "sub", "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp.Close()
+	wait:
+		for {
+			select {
+			case e := <-next.Events:
+				if e.Name == join(tmp, "sub", "file") && e.Has(Open) {
+					break wait
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no Open event; options weren't copied")
+			}
+		}
+	}
+
+	// And it should still be a single recursive watch.
+	if err := next.Remove(join(tmp, "...")); err != nil {
+		t.Fatal(err)
+	}
+	if l := next.WatchList(); len(l) > 0 {
+		t.Errorf("still watching after Remove: %v", l)
+	}
+}
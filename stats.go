@@ -0,0 +1,123 @@
+package fsnotify
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are counters for a Watcher since it was created; see [Watcher.Stats].
+type Stats struct {
+	Since   time.Time // When the Watcher was created.
+	Watches int       // Number of watched paths, as in [Watcher.WatchList].
+
+	// Events sent, by operation. An event with more than one operation is
+	// counted once for every operation, and Other counts events that have none
+	// of these (e.g. [Open] or [Retargeted]). Events dropped because of
+	// [WithBackpressure] are included in this.
+	Events  uint64 // Total number of events.
+	Creates uint64
+	Writes  uint64
+	Removes uint64
+	Renames uint64
+	Chmods  uint64
+	Other   uint64
+
+	Errors  uint64 // Errors sent on the Errors channel, including overflows.
+	Dropped uint64 // Events dropped because of WithBackpressure; see [Watcher.DroppedEvents].
+
+	// Number of times the kernel reported that events were lost, as an
+	// ErrEventOverflow error: IN_Q_OVERFLOW on Linux, or a buffer overrun on
+	// Windows. Unlike Dropped it's not known how many events were lost.
+	Overflows uint64
+
+	// Events waiting to be read from the Events channel right now, and the
+	// capacity of the channel as set with [WithEventChannelSize].
+	QueueDepth    int
+	QueueCapacity int
+
+	// Events waiting to be sent on the Events channel once there is room, for
+	// BackpressureCoalesce, or until Handover for a [Watcher.Standby] watcher.
+	Queued int
+
+	// Most events that were waiting to be read from the Events channel when
+	// an event was sent; this is never more than QueueCapacity.
+	QueueHighWater int
+}
+
+// Stats returns counters for the Watcher since it was created, so that
+// applications can report the health of the Watcher without counting every
+// event themselves.
+func (w *Watcher) Stats() Stats {
+	s := w.stats.get()
+	s.Watches = len(w.WatchList())
+	s.Dropped = w.DroppedEvents()
+	s.QueueDepth, s.QueueCapacity = len(w.Events), cap(w.Events)
+	s.Queued = w.delivery.queued()
+	return s
+}
+
+// stats keeps the counters for Stats(); all fields are accessed atomically.
+type stats struct {
+	events, creates, writes, removes, renames, chmods, other uint64
+	errors, overflows                                        uint64
+	highWater                                                uint64
+	since                                                    time.Time
+
+	overflow overflowFunc // See OnOverflow.
+}
+
+func newStats() *stats { return &stats{since: time.Now()} }
+
+// event counts an event that's sent, with queued events already waiting in
+// the Events channel.
+func (s *stats) event(e Event, queued int) {
+	atomic.AddUint64(&s.events, 1)
+	count := func(op Op, n *uint64) bool {
+		if e.Op&op == 0 {
+			return false
+		}
+		atomic.AddUint64(n, 1)
+		return true
+	}
+	c := count(opCreate, &s.creates)
+	c = count(opWrite, &s.writes) || c
+	c = count(opRemove, &s.removes) || c
+	c = count(opRename, &s.renames) || c
+	c = count(opChmod, &s.chmods) || c
+	if !c {
+		atomic.AddUint64(&s.other, 1)
+	}
+
+	for {
+		h := atomic.LoadUint64(&s.highWater)
+		if uint64(queued) <= h || atomic.CompareAndSwapUint64(&s.highWater, h, uint64(queued)) {
+			break
+		}
+	}
+}
+
+// error counts an error that's sent.
+func (s *stats) error(err error) {
+	atomic.AddUint64(&s.errors, 1)
+	if errors.Is(err, ErrEventOverflow) {
+		atomic.AddUint64(&s.overflows, 1)
+		s.overflow.call(0)
+	}
+}
+
+func (s *stats) get() Stats {
+	return Stats{
+		Since:          s.since,
+		Events:         atomic.LoadUint64(&s.events),
+		Creates:        atomic.LoadUint64(&s.creates),
+		Writes:         atomic.LoadUint64(&s.writes),
+		Removes:        atomic.LoadUint64(&s.removes),
+		Renames:        atomic.LoadUint64(&s.renames),
+		Chmods:         atomic.LoadUint64(&s.chmods),
+		Other:          atomic.LoadUint64(&s.other),
+		Errors:         atomic.LoadUint64(&s.errors),
+		Overflows:      atomic.LoadUint64(&s.overflows),
+		QueueHighWater: int(atomic.LoadUint64(&s.highWater)),
+	}
+}
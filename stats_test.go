@@ -0,0 +1,75 @@
+package fsnotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w, err := NewWatcherWith(WithEventChannelSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	addWatch(t, w, tmp)
+
+	start := time.Now()
+	touch(t, tmp, "a")
+	cat(t, "data", tmp, "a")
+	rm(t, tmp, "a")
+	waitForEvents()
+
+	s := w.Stats()
+	if s.Watches != 1 {
+		t.Errorf("Watches = %d; want 1", s.Watches)
+	}
+	if s.Creates != 1 || s.Writes == 0 || s.Removes != 1 || s.Renames != 0 {
+		t.Errorf("wrong counts: %+v", s)
+	}
+	if s.Events < 3 {
+		t.Errorf("Events = %d; want at least 3", s.Events)
+	}
+	if s.QueueHighWater == 0 || s.QueueHighWater >= 10 {
+		t.Errorf("QueueHighWater = %d", s.QueueHighWater)
+	}
+	if s.QueueDepth != int(s.Events) || s.QueueCapacity != 10 || s.Queued != 0 {
+		t.Errorf("wrong queue: %+v", s)
+	}
+	if s.Since.After(start) {
+		t.Errorf("Since = %s, after %s", s.Since, start)
+	}
+	if s.Errors != 0 || s.Overflows != 0 || s.Dropped != 0 {
+		t.Errorf("wrong error counts: %+v", s)
+	}
+
+	w.stats.error(ErrEventOverflow)
+	if s := w.Stats(); s.Errors != 1 || s.Overflows != 1 {
+		t.Errorf("wrong error counts: %+v", s)
+	}
+}
+
+func TestStatsQueued(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t)
+	defer w.Close()
+
+	next, err := w.Standby()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+	addWatch(t, next, tmp)
+
+	touch(t, tmp, "a")
+	touch(t, tmp, "b")
+	waitForEvents()
+
+	if s := next.Stats(); s.Queued < 2 || s.QueueDepth != 0 {
+		t.Errorf("wrong queue: %+v", s)
+	}
+}
@@ -0,0 +1,118 @@
+package fsnotify
+
+import (
+	"context"
+	"sync"
+)
+
+// SubscribeCtx returns a channel that receives a copy of all events for which
+// filter returns true (or all events if filter is nil), until ctx is cancelled
+// or the watcher is closed; the channel is closed when that happens.
+//
+// This is useful for temporary consumers, such as watching the output of a job
+// while it runs; there's no need to unsubscribe, and nothing is leaked if the
+// consumer goes away as long as the context is cancelled.
+//
+// Subscriptions receive events in addition to the Events channel, which must
+// still be read as usual. The channel has the same buffer size as Events (see
+// [WithEventChannelSize]); delivery blocks if it's full, until ctx is
+// cancelled.
+func (w *Watcher) SubscribeCtx(ctx context.Context, filter func(Event) bool) <-chan Event {
+	return w.subs.subscribe(ctx, filter, false)
+}
+
+type subscription struct {
+	ctx    context.Context
+	filter func(Event) bool
+	ch     chan Event
+	own    bool // Not sent on the Events channel (see AddWatch).
+}
+
+type subscriptions struct {
+	mu     sync.RWMutex
+	subs   map[*subscription]struct{}
+	size   uint
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newSubscriptions(with withOpts) *subscriptions {
+	return &subscriptions{
+		subs: make(map[*subscription]struct{}),
+		size: with.eventsSize,
+		done: make(chan struct{}),
+	}
+}
+
+func (s *subscriptions) subscribe(ctx context.Context, filter func(Event) bool, own bool) <-chan Event {
+	sub := &subscription{ctx: ctx, filter: filter, ch: make(chan Event, s.size), own: own}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(sub.ch)
+		return sub.ch
+	}
+	s.subs[sub] = struct{}{}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+			return // close() takes care of it.
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subs[sub]; ok {
+			delete(s.subs, sub)
+			close(sub.ch)
+		}
+	}()
+	return sub.ch
+}
+
+// send the event to all matching subscriptions. The event is cloned if clone
+// is set, for events with a reused Name (see WithCallback).
+//
+// Returns true if a subscription for a Watch received it, in which case it
+// shouldn't be sent on the Events channel.
+func (s *subscriptions) send(e Event, clone bool) (owned bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.subs) == 0 {
+		return false
+	}
+	if clone {
+		e = e.Clone()
+	}
+	for sub := range s.subs {
+		if sub.filter != nil && !sub.filter(e) {
+			continue
+		}
+		owned = owned || sub.own
+		select {
+		case sub.ch <- e:
+		case <-sub.ctx.Done():
+		case <-s.done:
+			return owned
+		}
+	}
+	return owned
+}
+
+// close all subscriptions; this must be called when the watcher is closed.
+func (s *subscriptions) close() {
+	s.once.Do(func() { close(s.done) }) // Unblock send() first.
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for sub := range s.subs {
+		delete(s.subs, sub)
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,61 @@
+package fsnotify
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubscribeCtx(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	w := newWatcher(t, tmp)
+	go func() {
+		for range w.Events {
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	txt := w.SubscribeCtx(ctx, func(e Event) bool { return filepath.Ext(e.Name) == ".txt" })
+	all := w.SubscribeCtx(context.Background(), nil)
+
+	touch(t, tmp, "file.go", noWait)
+	touch(t, tmp, "file.txt", noWait)
+
+	var (
+		timeout = time.After(2 * time.Second)
+		haveTxt []string
+		haveAll = make(map[string]bool)
+	)
+	for len(haveTxt) == 0 || len(haveAll) < 2 {
+		select {
+		case e := <-txt:
+			haveTxt = append(haveTxt, e.Name)
+		case e := <-all:
+			haveAll[e.Name] = true
+		case <-timeout:
+			t.Fatalf("timeout: txt=%v all=%v", haveTxt, haveAll)
+		}
+	}
+	if haveTxt[0] != join(tmp, "file.txt") {
+		t.Errorf("wrong event: %s", haveTxt[0])
+	}
+
+	// Don't block delivery for the rest of the test.
+	go func() {
+		for range all {
+		}
+	}()
+
+	cancel()
+	for range txt { // Must be closed once cancelled.
+	}
+
+	w.Close()
+	if _, ok := <-w.SubscribeCtx(context.Background(), nil); ok {
+		t.Error("channel from closed watcher not closed")
+	}
+}
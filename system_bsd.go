@@ -6,3 +6,7 @@ package fsnotify
 import "golang.org/x/sys/unix"
 
 const openMode = unix.O_NONBLOCK | unix.O_RDONLY | unix.O_CLOEXEC
+
+// openSymlink opens a symlink itself rather than the target; this is 0 as the
+// BSDs don't have a way to do this.
+const openSymlink = 0
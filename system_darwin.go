@@ -7,3 +7,6 @@ import "golang.org/x/sys/unix"
 
 // note: this constant is not defined on BSD
 const openMode = unix.O_EVTONLY | unix.O_CLOEXEC
+
+// openSymlink opens a symlink itself rather than the target.
+const openSymlink = unix.O_SYMLINK
@@ -0,0 +1,16 @@
+//go:build plan9 || js || wasip1
+// +build plan9 js wasip1
+
+package fsnotify
+
+import "os"
+
+// fdLimit gets the soft limit for the number of open files, or 0 if it's
+// unknown.
+func fdLimit() int { return 0 }
+
+// fileID gets the device and inode number, if the system has them.
+func fileID(path string, fi os.FileInfo) (dev, ino uint64, err error) { return 0, 0, nil }
+
+// fileOwner gets the user and group ID, if the system has them.
+func fileOwner(fi os.FileInfo) (uid, gid uint32) { return 0, 0 }
@@ -0,0 +1,37 @@
+//go:build !windows && !plan9 && !js && !wasip1
+// +build !windows,!plan9,!js,!wasip1
+
+package fsnotify
+
+import (
+	"os"
+	"syscall"
+)
+
+// fdLimit gets the soft limit for the number of open files, or 0 if it's
+// unknown.
+func fdLimit() int {
+	var l syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &l); err != nil {
+		return 0
+	}
+	return int(l.Cur)
+}
+
+// fileID gets the device and inode number.
+func fileID(path string, fi os.FileInfo) (dev, ino uint64, err error) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, nil
+	}
+	return uint64(st.Dev), uint64(st.Ino), nil
+}
+
+// fileOwner gets the user and group ID.
+func fileOwner(fi os.FileInfo) (uid, gid uint32) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return st.Uid, st.Gid
+}
@@ -0,0 +1,53 @@
+//go:build windows
+// +build windows
+
+package fsnotify
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// fdLimit gets the soft limit for the number of open files, or 0 if it's
+// unknown.
+func fdLimit() int { return 0 }
+
+// longPath gets the path to use for Win32 API calls, adding the \\?\ prefix if
+// it's longer than MAX_PATH.
+func longPath(path string) string {
+	if len(path) < windowsMaxPath {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return windowsLongPath(abs)
+}
+
+// fileID gets the volume serial number and file index.
+func fileID(path string, fi os.FileInfo) (dev, ino uint64, err error) {
+	p, err := windows.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return 0, 0, err
+	}
+	h, err := windows.CreateFile(p, 0,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, 0, os.NewSyscallError("CreateFile", err)
+	}
+	defer windows.CloseHandle(h)
+
+	var info windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(h, &info); err != nil {
+		return 0, 0, os.NewSyscallError("GetFileInformationByHandle", err)
+	}
+	return uint64(info.VolumeSerialNumber), uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow), nil
+}
+
+// fileOwner gets the user and group ID; Windows doesn't have these, so this
+// always returns 0.
+func fileOwner(fi os.FileInfo) (uid, gid uint32) { return 0, 0 }
@@ -0,0 +1,39 @@
+package fsnotify
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// WithExcludeUnlinked drops events for files that were already removed, but
+// are still open in some process. This is only used by [NewWatcherWith], and is
+// a no-op for [Watcher.AddWith].
+//
+// A program writing to a temporary file it removed right after opening it would
+// otherwise send Write events for a name that no longer exists. The Remove for
+// the file is still sent.
+//
+// This sets IN_EXCL_UNLINK on Linux, so these events are never read from the
+// kernel. Other platforms, and Linux with [WithSharedInstance], drop Write and Chmod events if the file doesn't exist
+// when the event is sent, which means a Write shortly before the file was
+// removed may be dropped as well.
+func WithExcludeUnlinked() addOpt {
+	return func(opt *withOpts) { opt.excludeUnlinked = true }
+}
+
+// unlinked reports if e should be dropped for WithExcludeUnlinked.
+func (w *Watcher) unlinked(e Event) bool {
+	if !w.with.excludeUnlinked || (runtime.GOOS == "linux" && !w.with.shared) ||
+		e.Op&(opWrite|opChmod) == 0 || e.Op&(opCreate|opRemove|opRename) != 0 {
+		return false
+	}
+	name := e.Name
+	if e.Root != "" {
+		name = filepath.Join(e.Root, e.Name)
+	}
+	_, err := os.Lstat(name)
+	return errors.Is(err, fs.ErrNotExist)
+}
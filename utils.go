@@ -1,32 +1,13 @@
 package fsnotify
 
-import (
-	"os"
-	"path/filepath"
-)
-
-func visit(files *[]string) filepath.WalkFunc {
-	return func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			*files = append(*files, path)
-		}
-		return nil
-	}
-}
-
+// GetDirNames gets all directories in names and their subdirectories,
+// recursively. Symlinks to directories are followed, and every directory is
+// only returned once; see [GetDirNamesWith] for how loops are handled.
+//
+// This is the same as [GetDirs] without options. Only directories are returned,
+// so the result can be passed to [Watcher.Add] without adding a watch for every
+// file; files (and symlinks to files) are skipped, including files in names.
+// Use [GetDirNamesWith] with [WithFileTypes] to get other paths as well.
 func GetDirNames(names []string) ([]string, error) {
-	var files []string
-
-	for _, v := range names {
-		err := filepath.Walk(v, visit(&files))
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return files, nil
-
+	return GetDirs(names)
 }
@@ -0,0 +1,443 @@
+package fsnotify
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrLoop is returned by [GetDirNamesWith] with [LoopError] if a directory
+// contains itself through a symlink (or bind mount).
+var ErrLoop = errors.New("fsnotify: directory loop")
+
+// LoopPolicy describes what to do if a directory loop is found while walking a
+// tree; see [WithLoopPolicy].
+type LoopPolicy uint8
+
+const (
+	// Skip the directory that loops back; this is the default.
+	LoopSkip LoopPolicy = iota
+
+	// Return an error wrapping [ErrLoop].
+	LoopError
+)
+
+// WalkError is returned by [GetDirNamesWith], [GetDirs], and [Watcher.AddWith] with
+// [WithContinueOnError] if some directories couldn't be read or watched.
+//
+// errors.Is and errors.As only check the first error; use Errs to check all of
+// them.
+type WalkError struct {
+	Errs []error // Usually an *fs.PathError for every directory.
+}
+
+func (e *WalkError) Error() string {
+	if len(e.Errs) == 1 {
+		return e.Errs[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e.Errs[0], len(e.Errs)-1)
+}
+
+func (e *WalkError) Unwrap() error { return e.Errs[0] }
+
+// walkError returns a *WalkError for the errors, or nil if there are none.
+func walkError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &WalkError{Errs: errs}
+}
+
+// WithContinueOnError skips directories that can't be read (e.g. because of
+// permissions) and directory loops with [LoopError] when walking a tree, rather
+// than stopping at the first error. The directories that could be read are
+// returned or watched, together with a [*WalkError] for the others.
+//
+// This applies to [GetDirNamesWith] and recursive watches with
+// [Watcher.AddWith] (e.g. "dir/..."); it's a no-op for [NewWatcherWith].
+// Errors for the paths that are passed are still returned right away.
+func WithContinueOnError() addOpt {
+	return func(opt *withOpts) { opt.continueOnError = true }
+}
+
+// WithWalkWorkers sets how many directories are read at the same time when
+// walking a tree, for [GetDirNamesWith] and recursive watches with
+// [Watcher.AddWith]. The default is 8; n<1 reads one directory at a time. It's
+// a no-op for [NewWatcherWith].
+//
+// Every directory is still only read once, and the result is the same as
+// reading them one at a time.
+func WithWalkWorkers(n int) addOpt {
+	return func(opt *withOpts) { opt.walkWorkers = n }
+}
+
+// WithProgress calls fn while walking a tree for [GetDirNamesWith] or a
+// recursive watch with [Watcher.AddWith], with the number of directories that
+// were read and the number that were watched so far (always 0 for
+// GetDirNamesWith). It's called for about every 1,000 directories, and once
+// when done. It's a no-op for [NewWatcherWith].
+func WithProgress(fn func(dirs, watched int)) addOpt {
+	return func(opt *withOpts) { opt.progress = fn }
+}
+
+// How often WithProgress is called.
+const progressEvery = 1000
+
+// FileType is a set of file types for [WithFileTypes].
+type FileType uint8
+
+const (
+	TypeDir     FileType = 1 << iota // Directory, or symlink to a directory.
+	TypeFile                         // Regular file.
+	TypeSymlink                      // Symlink to something other than a directory, or a broken symlink.
+	TypeOther                        // Anything else: sockets, named pipes, devices.
+
+	TypeAll = TypeDir | TypeFile | TypeSymlink | TypeOther
+)
+
+// WithFileTypes sets which types of paths [GetDirNamesWith] returns; the
+// default is TypeDir. Symlinks to directories are always followed and returned
+// as a directory. It's a no-op for [GetDirs], [NewWatcherWith], and
+// [Watcher.AddWith].
+func WithFileTypes(t FileType) addOpt {
+	return func(opt *withOpts) { opt.fileTypes = t }
+}
+
+// WithLoopPolicy sets what [GetDirNamesWith] does when it finds a directory
+// loop. It's a no-op for [NewWatcherWith] and [Watcher.AddWith].
+func WithLoopPolicy(p LoopPolicy) addOpt {
+	return func(opt *withOpts) { opt.loopPolicy = p }
+}
+
+// GetDirs gets all directories in names and their subdirectories, recursively,
+// in the same way as [GetDirNamesWith]. Only directories are ever returned, no
+// matter which [WithFileTypes] is used.
+//
+// This is what recursive watches with [Watcher.AddWith] use, so that only the
+// directories get a watch.
+func GetDirs(names []string, opts ...addOpt) ([]string, error) {
+	return getDirs(names, getOptions(opts...))
+}
+
+// GetDirNamesWith is like [GetDirNames], but allows passing options.
+//
+// Directories are identified by their device and inode number (volume and file
+// index on Windows), so a directory that can be reached through several paths
+// is only returned once, for the first path it was found at. A directory that
+// contains itself (e.g. "a/link" pointing to "a") is a loop and is handled
+// according to [WithLoopPolicy].
+//
+// [WithMaxDepth] limits how many levels of subdirectories are returned. The
+// first error stops the walk, unless [WithContinueOnError] is used.
+//
+// Directories are read concurrently (see [WithWalkWorkers]), and
+// [WithProgress] reports how far along it is.
+//
+// Only directories are returned by default; use [WithFileTypes] to also return
+// files, symlinks, and other paths. These are returned right after the
+// directory they're in, and before its subdirectories.
+func GetDirNamesWith(names []string, opts ...addOpt) ([]string, error) {
+	return getDirNames(names, getOptions(opts...))
+}
+
+func getDirs(names []string, with withOpts) ([]string, error) {
+	with.fileTypes = TypeDir
+	return getDirNames(names, with)
+}
+
+func getDirNames(names []string, with withOpts) ([]string, error) {
+	if with.fileTypes == 0 {
+		with.fileTypes = TypeDir
+	}
+	roots := make([]string, len(names))
+	stats := make([]dirStat, len(names))
+	for i, n := range names {
+		roots[i] = filepath.Clean(n)
+		stats[i] = statDirID(roots[i])
+		if !stats[i].dir && stats[i].err == nil && with.fileTypes&^TypeDir != 0 {
+			stats[i].typ = lstatType(roots[i])
+		}
+	}
+
+	dw := dirWalker{with: with, seen: make(map[[2]uint64]struct{}), lists: readTree(roots, stats, with)}
+	for i, r := range roots {
+		if err := dw.walk(r, stats[i], make(map[[2]uint64]struct{}), 0); err != nil {
+			return nil, err
+		}
+	}
+	return dw.paths, walkError(dw.errs)
+}
+
+// dirStat is what's known about a path that may be a directory.
+type dirStat struct {
+	dir   bool
+	id    [2]uint64
+	err   error    // From stat().
+	idErr error    // From fileID().
+	typ   FileType // Only set if it's not a directory, and only for roots.
+}
+
+func statDirID(path string) dirStat {
+	st, err := os.Stat(path)
+	if err != nil {
+		return dirStat{err: err}
+	}
+	if !st.IsDir() {
+		return dirStat{}
+	}
+	dev, ino, err := fileID(path, st)
+	return dirStat{dir: true, id: [2]uint64{dev, ino}, idErr: err}
+}
+
+// lstatType gets the FileType of path without following symlinks.
+func lstatType(path string) FileType {
+	st, err := os.Lstat(path)
+	if err != nil {
+		return 0
+	}
+	return modeType(st.Mode())
+}
+
+func modeType(m fs.FileMode) FileType {
+	switch {
+	case m.IsDir():
+		return TypeDir
+	case m.IsRegular():
+		return TypeFile
+	case m&fs.ModeSymlink != 0:
+		return TypeSymlink
+	}
+	return TypeOther
+}
+
+// dirRead is a directory that was read: the entries that may be directories
+// (directories and symlinks) and their dirStat, and the other entries if they
+// were asked for with WithFileTypes.
+type dirRead struct {
+	names []string
+	stats []dirStat
+	files []string // Entries that are not directories, in the order they're returned.
+	err   error    // From ReadDir(); the entries that were read before are still set.
+}
+
+func readSubdirs(path string, types FileType) *dirRead {
+	ls, err := os.ReadDir(path)
+	l := &dirRead{err: err}
+	for _, f := range ls {
+		if !f.IsDir() && f.Type()&os.ModeSymlink == 0 {
+			if types&modeType(f.Type()) != 0 {
+				l.files = append(l.files, f.Name())
+			}
+			continue
+		}
+		s := statDirID(filepath.Join(path, f.Name()))
+		if f.Type()&os.ModeSymlink != 0 && !s.dir && (s.err == nil || errors.Is(s.err, os.ErrNotExist)) {
+			if types&TypeSymlink != 0 {
+				l.files = append(l.files, f.Name())
+			}
+			continue
+		}
+		l.names = append(l.names, f.Name())
+		l.stats = append(l.stats, s)
+	}
+	return l
+}
+
+// dirKey identifies a directory; the path is only used if the ID isn't known on
+// this platform.
+type dirKey struct {
+	id   [2]uint64
+	path string
+}
+
+func keyOf(path string, s dirStat) dirKey {
+	if s.id == ([2]uint64{}) {
+		return dirKey{path: path}
+	}
+	return dirKey{id: s.id}
+}
+
+// readTree reads all directories below the roots with WithWalkWorkers
+// goroutines, up to WithMaxDepth levels deep. Every directory is only read
+// once, no matter how many paths it can be reached through.
+func readTree(roots []string, stats []dirStat, with withOpts) map[dirKey]*dirRead {
+	type job struct {
+		key  dirKey
+		path string
+	}
+	type result struct {
+		job
+		list *dirRead
+	}
+	var (
+		lists   = make(map[dirKey]*dirRead)
+		depth   = make(map[dirKey]int) // Fewest levels below a root it was found at.
+		queue   []job
+		busy    int
+		jobs    = make(chan job)
+		results = make(chan result)
+		workers = with.walkWorkers
+	)
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				results <- result{job: j, list: readSubdirs(j.path, with.fileTypes)}
+			}
+		}()
+	}
+	defer close(jobs)
+
+	var reach func(path string, s dirStat, d int)
+	reach = func(path string, s dirStat, d int) {
+		if !s.dir || s.idErr != nil {
+			return
+		}
+		k := keyOf(path, s)
+		if old, ok := depth[k]; ok && old <= d {
+			return
+		}
+		depth[k] = d
+		if with.maxDepth > 0 && d >= with.maxDepth {
+			return
+		}
+		l, ok := lists[k]
+		if !ok {
+			lists[k] = nil // Being read.
+			queue = append(queue, job{key: k, path: path})
+			return
+		}
+		if l != nil { // Found closer to the root than before.
+			for i, n := range l.names {
+				reach(filepath.Join(path, n), l.stats[i], d+1)
+			}
+		}
+	}
+	for i, r := range roots {
+		reach(r, stats[i], 0)
+	}
+
+	read := 0
+	for len(queue) > 0 || busy > 0 {
+		var (
+			send chan job
+			next job
+		)
+		if len(queue) > 0 {
+			send, next = jobs, queue[len(queue)-1]
+		}
+		select {
+		case send <- next:
+			queue = queue[:len(queue)-1]
+			busy++
+		case r := <-results:
+			busy--
+			lists[r.key] = r.list
+			for i, n := range r.list.names {
+				reach(filepath.Join(r.path, n), r.list.stats[i], depth[r.key]+1)
+			}
+			if read++; with.progress != nil && read%progressEvery == 0 {
+				with.progress(read, 0)
+			}
+		}
+	}
+	if with.progress != nil && read%progressEvery != 0 {
+		with.progress(read, 0)
+	}
+	return lists
+}
+
+type dirWalker struct {
+	with  withOpts
+	seen  map[[2]uint64]struct{}
+	lists map[dirKey]*dirRead
+	paths []string
+	errs  []error // With WithContinueOnError.
+}
+
+// fail returns the error if the walk should stop, or else records it.
+func (dw *dirWalker) fail(err error) error {
+	if !dw.with.continueOnError {
+		return err
+	}
+	dw.errs = append(dw.errs, err)
+	return nil
+}
+
+// walk the directory at path, depth levels below the root, using the
+// directories from readTree(); parents has the IDs of the directories that are
+// being walked above it.
+func (dw *dirWalker) walk(path string, s dirStat, parents map[[2]uint64]struct{}, depth int) error {
+	if s.err != nil {
+		if depth > 0 && errors.Is(s.err, os.ErrNotExist) {
+			return nil // Broken symlink, or removed while walking.
+		}
+		if depth == 0 {
+			return s.err
+		}
+		return dw.fail(atPath(s.err, path))
+	}
+	if !s.dir {
+		if depth == 0 && dw.with.fileTypes&s.typ != 0 {
+			dw.paths = append(dw.paths, path)
+		}
+		return nil
+	}
+	if s.idErr != nil {
+		return dw.fail(s.idErr)
+	}
+
+	id := s.id
+	if id != [2]uint64{} {
+		if _, ok := parents[id]; ok {
+			if dw.with.loopPolicy == LoopError {
+				return dw.fail(fmt.Errorf("%w: %s", ErrLoop, path))
+			}
+			return nil
+		}
+		if _, ok := dw.seen[id]; ok {
+			return nil
+		}
+		dw.seen[id] = struct{}{}
+		parents[id] = struct{}{}
+		defer delete(parents, id)
+	}
+	if dw.with.fileTypes&TypeDir != 0 {
+		dw.paths = append(dw.paths, path)
+	}
+	if dw.with.maxDepth > 0 && depth >= dw.with.maxDepth {
+		return nil
+	}
+
+	l := dw.lists[keyOf(path, s)]
+	if l == nil {
+		return nil
+	}
+	if l.err != nil {
+		if err := dw.fail(atPath(l.err, path)); err != nil {
+			return err
+		}
+	}
+	for _, n := range l.files {
+		dw.paths = append(dw.paths, filepath.Join(path, n))
+	}
+	for i, n := range l.names {
+		if err := dw.walk(filepath.Join(path, n), l.stats[i], parents, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// atPath sets the path in a *fs.PathError to path; a directory that can be
+// reached through several paths is only read once, through any of them.
+func atPath(err error, path string) error {
+	if pe, ok := err.(*fs.PathError); ok && pe.Path != path {
+		return &fs.PathError{Op: pe.Op, Path: path, Err: pe.Err}
+	}
+	return err
+}
@@ -0,0 +1,175 @@
+package fsnotify
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/hohodqr/fsnotify/internal"
+)
+
+func TestGetDirNamesWith(t *testing.T) {
+	t.Parallel()
+	if !internal.HasPrivilegesForSymlink() {
+		t.Skip("does not have privileges for symlink on this OS")
+	}
+
+	tmp := t.TempDir()
+	a := join(tmp, "a")
+	mkdirAll(t, a, "b", "c")
+	symlink(t, a, a, "b", "loop")      // Loop back to a.
+	symlink(t, join(a, "b"), a, "dup") // Second path to b.
+	symlink(t, join(tmp, "nonexistent"), a, "broken")
+	touch(t, a, "file")
+	touch(t, a, "b", "file")
+	symlink(t, join(a, "file"), a, "b", "link")
+
+	want := []string{a, join(a, "b"), join(a, "b", "c")}
+	have, err := GetDirNames([]string{a, join(a, "b"), join(a, "file")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+
+	_, err = GetDirNamesWith([]string{a}, WithLoopPolicy(LoopError))
+	if !errors.Is(err, ErrLoop) {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	have, err = GetDirNamesWith([]string{a}, WithMaxDepth(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := want[:2]; !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestWithFileTypes(t *testing.T) {
+	t.Parallel()
+	if !internal.HasPrivilegesForSymlink() {
+		t.Skip("does not have privileges for symlink on this OS")
+	}
+
+	tmp := t.TempDir()
+	a := join(tmp, "a")
+	mkdirAll(t, a, "b")
+	touch(t, a, "file")
+	touch(t, a, "b", "file")
+	symlink(t, join(a, "file"), a, "link")
+	symlink(t, join(a, "b"), a, "dirlink")
+	symlink(t, join(tmp, "nonexistent"), a, "b", "broken")
+
+	tests := []struct {
+		types FileType
+		want  []string
+	}{
+		{0, []string{a, join(a, "b")}},
+		{TypeDir, []string{a, join(a, "b")}},
+		{TypeDir | TypeFile, []string{a, join(a, "file"), join(a, "b"), join(a, "b", "file")}},
+		{TypeSymlink, []string{join(a, "link"), join(a, "b", "broken")}},
+		{TypeAll, []string{a, join(a, "file"), join(a, "link"), join(a, "b"),
+			join(a, "b", "broken"), join(a, "b", "file")}},
+	}
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			have, err := GetDirNamesWith([]string{a}, WithFileTypes(tt.types))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(have, tt.want) {
+				t.Errorf("\nhave: %q\nwant: %q", have, tt.want)
+			}
+		})
+	}
+
+	have, err := GetDirNamesWith([]string{join(a, "file")}, WithFileTypes(TypeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{join(a, "file")}; !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+
+	have, err = GetDirs([]string{a}, WithFileTypes(TypeAll))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{a, join(a, "b")}; !reflect.DeepEqual(have, want) {
+		t.Errorf("GetDirs\nhave: %q\nwant: %q", have, want)
+	}
+}
+
+func TestGetDirNamesWithContinueOnError(t *testing.T) {
+	t.Parallel()
+	if !internal.HasPrivilegesForSymlink() {
+		t.Skip("does not have privileges for symlink on this OS")
+	}
+
+	tmp := t.TempDir()
+	a := join(tmp, "a")
+	mkdirAll(t, a, "b", "c")
+	mkdir(t, a, "d")
+	symlink(t, a, a, "b", "loop")
+
+	have, err := GetDirNamesWith([]string{a}, WithLoopPolicy(LoopError), WithContinueOnError())
+	var werr *WalkError
+	if !errors.As(err, &werr) || len(werr.Errs) != 1 || !errors.Is(err, ErrLoop) {
+		t.Fatalf("wrong error: %#v", err)
+	}
+	want := []string{a, join(a, "b"), join(a, "b", "c"), join(a, "d")}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+
+	_, err = GetDirNamesWith([]string{join(tmp, "nonexistent")}, WithContinueOnError())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("wrong error for nonexistent path: %v", err)
+	}
+}
+
+func TestWithWalkWorkers(t *testing.T) {
+	t.Parallel()
+	if !internal.HasPrivilegesForSymlink() {
+		t.Skip("does not have privileges for symlink on this OS")
+	}
+
+	tmp := t.TempDir()
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			mkdirAll(t, tmp, fmt.Sprintf("d%d", i), fmt.Sprintf("e%d", j), "f", noWait)
+		}
+	}
+	symlink(t, join(tmp, "d5"), tmp, "d0", "a-dup") // Found before d5 itself.
+	symlink(t, tmp, tmp, "d9", "e9", "f", "loop")
+
+	want, err := GetDirNamesWith([]string{tmp}, WithWalkWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 1+10+100+100 {
+		t.Fatalf("wrong number of directories: %d", len(want))
+	}
+	for _, d := range want {
+		if d == join(tmp, "d5") {
+			t.Fatalf("d5 returned; should be d0/a-dup: %q", want)
+		}
+	}
+	var progress [2]int
+	have, err := GetDirNamesWith([]string{tmp}, WithWalkWorkers(16), WithProgress(func(dirs, watched int) {
+		progress = [2]int{dirs, watched}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("\nhave: %q\nwant: %q", have, want)
+	}
+	if progress != [2]int{len(want), 0} {
+		t.Errorf("progress = %v; want %v", progress, [2]int{len(want), 0})
+	}
+}
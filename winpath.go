@@ -0,0 +1,39 @@
+package fsnotify
+
+import "strings"
+
+// The conversion to and from extended-length paths is kept separate from the
+// Windows backend and doesn't depend on the platform, so it can be tested
+// everywhere.
+
+// windowsMaxPath is the length from which a path needs the \\?\ prefix. This is
+// 248 rather than MAX_PATH (260), as CreateDirectory needs room for an 8.3
+// filename.
+const windowsMaxPath = 248
+
+// windowsLongPath converts an absolute and clean Windows path to an
+// extended-length path if it's too long for the Win32 API without the \\?\
+// prefix; other paths are returned unchanged.
+func windowsLongPath(abs string) string {
+	switch {
+	case len(abs) < windowsMaxPath, strings.HasPrefix(abs, `\\?\`), strings.HasPrefix(abs, `\\.\`):
+		return abs
+	case strings.HasPrefix(abs, `\\`): // \\server\share\path
+		return `\\?\UNC\` + abs[2:]
+	case len(abs) >= 3 && abs[1] == ':' && abs[2] == '\\': // C:\path
+		return `\\?\` + abs
+	}
+	return abs
+}
+
+// windowsShortPath removes the \\?\ prefix from an extended-length path for a
+// drive letter or UNC path; other paths are returned unchanged.
+func windowsShortPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, `\\?\UNC\`):
+		return `\\` + path[8:]
+	case strings.HasPrefix(path, `\\?\`) && len(path) >= 6 && path[5] == ':':
+		return path[4:]
+	}
+	return path
+}
@@ -0,0 +1,34 @@
+package fsnotify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWindowsLongPath(t *testing.T) {
+	long := strings.Repeat(`\node_modules\pkg`, 20)
+	tests := []struct {
+		in, long, short string
+	}{
+		{`C:\dir`, `C:\dir`, `C:\dir`},
+		{`C:` + long, `\\?\C:` + long, `C:` + long},
+		{`\\server\share` + long, `\\?\UNC\server\share` + long, `\\server\share` + long},
+		{`\\?\C:` + long, `\\?\C:` + long, `C:` + long},
+		{`\\?\C:\dir`, `\\?\C:\dir`, `C:\dir`},
+		{`\\?\UNC\server\share\dir`, `\\?\UNC\server\share\dir`, `\\server\share\dir`},
+		{`\\.\pipe` + long, `\\.\pipe` + long, `\\.\pipe` + long},
+		{`\\?\Volume{b75e2c83-0000-0000-0000-602f00000000}\dir`, `\\?\Volume{b75e2c83-0000-0000-0000-602f00000000}\dir`, `\\?\Volume{b75e2c83-0000-0000-0000-602f00000000}\dir`},
+		{`relative` + long, `relative` + long, `relative` + long},
+	}
+
+	for _, tt := range tests {
+		t.Run("", func(t *testing.T) {
+			if have := windowsLongPath(tt.in); have != tt.long {
+				t.Errorf("windowsLongPath(%q)\nhave: %q\nwant: %q", tt.in, have, tt.long)
+			}
+			if have := windowsShortPath(tt.in); have != tt.short {
+				t.Errorf("windowsShortPath(%q)\nhave: %q\nwant: %q", tt.in, have, tt.short)
+			}
+		})
+	}
+}